@@ -0,0 +1,29 @@
+package magitrickle
+
+import (
+	"magitrickle/constant"
+	"magitrickle/utils/netfilterTools"
+
+	"github.com/rs/zerolog/log"
+)
+
+const allocationsFileName = "/allocations.json"
+
+// loadAllocations подключает закрепление меток и таблиц за группами и забывает
+// закрепления удалённых групп
+func (a *App) loadAllocations() {
+	path := constant.AppStateDir + allocationsFileName
+	allocations, err := netfilterTools.LoadAllocations(path)
+	if err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("mark and table allocations discarded")
+	}
+	a.nfHelper.Allocations = allocations
+
+	var keys []string
+	for _, group := range a.ruleSetSnapshot() {
+		keys = append(keys, group.RuntimeKey())
+	}
+	if err := allocations.Prune(keys); err != nil {
+		log.Warn().Err(err).Msg("failed to prune mark and table allocations")
+	}
+}
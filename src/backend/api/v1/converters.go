@@ -5,8 +5,10 @@ import (
 	"strings"
 
 	"magitrickle/api/v1/types"
+	"magitrickle/app"
 	"magitrickle/models"
 	"magitrickle/utils/intID"
+	"magitrickle/utils/netfilterTools"
 
 	"github.com/dlclark/regexp2"
 )
@@ -14,6 +16,73 @@ import (
 var colorRegExp = regexp2.MustCompile(`^#[0-9a-f]{6}$`, regexp2.IgnoreCase)
 
 func GroupFromReq(req types.GroupReq, existing *models.Group) (*models.Group, error) {
+	protocols := make([]string, 0, len(req.Protocols))
+	for _, protocol := range req.Protocols {
+		protocols = append(protocols, strings.ToLower(strings.TrimSpace(protocol)))
+	}
+	if err := models.ValidateProtocols(protocols, len(req.DstPorts) > 0); err != nil {
+		return nil, err
+	}
+	if err := models.ValidatePorts(req.DstPorts); err != nil {
+		return nil, err
+	}
+	srcAddresses := trimList(req.SrcAddresses)
+	srcMACs := trimList(req.SrcMACs)
+	srcInterfaces := trimList(req.SrcInterfaces)
+	if err := models.ValidateSources(srcAddresses, srcMACs, srcInterfaces); err != nil {
+		return nil, err
+	}
+	for i, mac := range srcMACs {
+		srcMACs[i] = strings.ToLower(mac)
+	}
+	failoverInterfaces := trimList(req.FailoverInterfaces)
+	healthCheck := HealthCheckFromReq(req.HealthCheck)
+	if err := models.ValidateFailover(req.Interface, failoverInterfaces, healthCheck); err != nil {
+		return nil, err
+	}
+	mode := strings.ToLower(strings.TrimSpace(req.Mode))
+	if mode == models.GroupModeFailover {
+		mode = ""
+	}
+	if err := models.ValidateBalance(mode, append([]string{req.Interface}, failoverInterfaces...), req.InterfaceWeights); err != nil {
+		return nil, err
+	}
+	gateways := trimList(req.Gateways)
+	if err := models.ValidateGateways(req.Interface, gateways, mode, healthCheck); err != nil {
+		return nil, err
+	}
+	if err := models.ValidateProxy(req.Interface, protocols, failoverInterfaces, gateways, healthCheck, req.RouteOutput); err != nil {
+		return nil, err
+	}
+	if err := models.ValidateTag(req.Interface, failoverInterfaces, gateways, healthCheck); err != nil {
+		return nil, err
+	}
+	if err := models.ValidateReject(req.Interface, failoverInterfaces, healthCheck); err != nil {
+		return nil, err
+	}
+	mssClamp := strings.ToLower(strings.TrimSpace(req.MSSClamp))
+	if err := models.ValidateMSSClamp(mssClamp, req.Interface, protocols); err != nil {
+		return nil, err
+	}
+	nat := strings.ToLower(strings.TrimSpace(req.NAT))
+	if nat == models.NATMasquerade {
+		nat = ""
+	}
+	if err := models.ValidateNAT(nat, req.Interface); err != nil {
+		return nil, err
+	}
+	var ipset *models.IPSetSizing
+	if req.IPSet != nil {
+		ipset = &models.IPSetSizing{
+			MaxElem:  req.IPSet.MaxElem,
+			HashSize: req.IPSet.HashSize,
+			Timeout:  req.IPSet.Timeout,
+		}
+		if err := ipset.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	var group *models.Group
 	if existing == nil {
 		group = &models.Group{ID: intID.RandomID()}
@@ -34,6 +103,31 @@ func GroupFromReq(req types.GroupReq, existing *models.Group) (*models.Group, er
 		group.Color = strings.ToLower(req.Color)
 	}
 	group.Interface = req.Interface
+	group.Protocols = nil
+	if len(protocols) > 0 {
+		group.Protocols = protocols
+	}
+	group.DstPorts = nil
+	if len(req.DstPorts) > 0 {
+		group.DstPorts = req.DstPorts
+	}
+	group.SrcAddresses = srcAddresses
+	group.SrcMACs = srcMACs
+	group.SrcInterfaces = srcInterfaces
+	group.RouteOutput = req.RouteOutput
+	group.FailoverInterfaces = failoverInterfaces
+	group.HealthCheck = healthCheck
+	group.Mode = mode
+	group.InterfaceWeights = nil
+	if len(req.InterfaceWeights) > 0 {
+		group.InterfaceWeights = req.InterfaceWeights
+	}
+	group.Gateways = gateways
+	group.Onlink = req.Onlink
+	group.DisableConntrackFlush = req.DisableConntrackFlush
+	group.IPSet = ipset
+	group.MSSClamp = mssClamp
+	group.NAT = nat
 	group.Enable = true
 	if req.Enable != nil {
 		group.Enable = *req.Enable
@@ -53,6 +147,33 @@ func GroupFromReq(req types.GroupReq, existing *models.Group) (*models.Group, er
 	return group, nil
 }
 
+// HealthCheckFromReq переводит проверку работоспособности из запроса; тип приводится к нижнему регистру
+func HealthCheckFromReq(req *types.HealthCheckReq) *models.HealthCheck {
+	if req == nil {
+		return nil
+	}
+	return &models.HealthCheck{
+		Type:          strings.ToLower(strings.TrimSpace(req.Type)),
+		Target:        strings.TrimSpace(req.Target),
+		Interval:      req.Interval,
+		Timeout:       req.Timeout,
+		FailThreshold: req.FailThreshold,
+		RiseThreshold: req.RiseThreshold,
+	}
+}
+
+// trimList обрезает пробелы у элементов списка; пустой список превращается в nil
+func trimList(list []string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+	out := make([]string, len(list))
+	for i, v := range list {
+		out[i] = strings.TrimSpace(v)
+	}
+	return out
+}
+
 func RuleFromReq(ruleReq types.RuleReq, existingRules []*models.Rule) (*models.Rule, error) {
 	var rule *models.Rule
 	if ruleReq.ID != nil {
@@ -90,6 +211,41 @@ func RespFromGroup(group *models.Group, withRules bool) types.GroupRes {
 		Color:     group.Color,
 		Interface: group.Interface,
 		Enable:    group.Enable,
+		Protocols: group.Protocols,
+		DstPorts:  group.DstPorts,
+
+		SrcAddresses:  group.SrcAddresses,
+		SrcMACs:       group.SrcMACs,
+		SrcInterfaces: group.SrcInterfaces,
+		RouteOutput:   group.RouteOutput,
+
+		FailoverInterfaces: group.FailoverInterfaces,
+		Mode:               group.Mode,
+		InterfaceWeights:   group.InterfaceWeights,
+		Gateways:           group.Gateways,
+		Onlink:             group.Onlink,
+
+		DisableConntrackFlush: group.DisableConntrackFlush,
+		MSSClamp:              group.MSSClamp,
+		NAT:                   group.NAT,
+	}
+	if group.HealthCheck != nil {
+		check := group.HealthCheck.WithDefaults()
+		groupRes.HealthCheck = &types.HealthCheckRes{
+			Type:          check.Type,
+			Target:        check.Target,
+			Interval:      check.Interval,
+			Timeout:       check.Timeout,
+			FailThreshold: check.FailThreshold,
+			RiseThreshold: check.RiseThreshold,
+		}
+	}
+	if group.IPSet != nil {
+		groupRes.IPSet = &types.IPSetSizing{
+			MaxElem:  group.IPSet.MaxElem,
+			HashSize: group.IPSet.HashSize,
+			Timeout:  group.IPSet.Timeout,
+		}
 	}
 	if withRules {
 		groupRes.RulesRes = RespFromRules(group.Rules)
@@ -114,3 +270,194 @@ func RespFromRule(rule *models.Rule) types.RuleRes {
 		Enable: rule.Enable,
 	}
 }
+
+func RespFromSyncReport(report app.RuleSetSyncReport) types.GroupReportRes {
+	res := types.GroupReportRes{
+		ASN:         make([]types.ASNReportRes, len(report.ASN)),
+		Aggregation: make([]types.SubnetAggregationRes, len(report.Aggregation)),
+		IPSet:       make([]types.IPSetUsageRes, len(report.IPSet)),
+		Warnings:    report.Warnings,
+	}
+	for i, aggregation := range report.Aggregation {
+		res.Aggregation[i] = types.SubnetAggregationRes{
+			Family: aggregation.Family,
+			Before: aggregation.Before,
+			After:  aggregation.After,
+		}
+	}
+	for i, usage := range report.IPSet {
+		res.IPSet[i] = types.IPSetUsageRes{
+			Family:  usage.Family,
+			Entries: usage.Entries,
+			MaxElem: usage.MaxElem,
+			Resized: usage.Resized,
+		}
+	}
+	if !report.Time.IsZero() {
+		res.SyncedAt = report.Time.Unix()
+	}
+	if report.Progress != nil {
+		res.Progress = &types.SyncProgressRes{
+			StartedAt: report.Progress.Started.Unix(),
+			Done:      report.Progress.Done,
+			Total:     report.Progress.Total,
+		}
+	}
+	for i, asn := range report.ASN {
+		res.ASN[i] = types.ASNReportRes{
+			ASN:   asn.ASN,
+			IPv4:  asn.IPv4,
+			IPv6:  asn.IPv6,
+			Error: asn.Error,
+		}
+	}
+	return res
+}
+
+func RespFromFailoverStatus(status app.FailoverStatus) types.GroupFailoverRes {
+	res := types.GroupFailoverRes{
+		Active:     status.Active,
+		Interfaces: make([]types.InterfaceHealthRes, len(status.Interfaces)),
+		Events:     make([]types.FailoverEventRes, len(status.Events)),
+	}
+	for i, iface := range status.Interfaces {
+		res.Interfaces[i] = types.InterfaceHealthRes{
+			Interface: iface.Interface,
+			Healthy:   iface.Healthy,
+			Error:     iface.LastError,
+		}
+		if !iface.LastCheck.IsZero() {
+			res.Interfaces[i].LastCheck = iface.LastCheck.Unix()
+		}
+	}
+	for i, event := range status.Events {
+		res.Events[i] = types.FailoverEventRes{
+			Time:   event.Time.Unix(),
+			From:   event.From,
+			To:     event.To,
+			Reason: event.Reason,
+			Error:  event.Error,
+		}
+	}
+	return res
+}
+
+func RespFromGroupAllocation(allocation app.GroupAllocation) types.GroupAllocationRes {
+	res := types.GroupAllocationRes{
+		Mask:       allocation.Mask,
+		Interfaces: make([]types.InterfaceAllocationRes, len(allocation.Interfaces)),
+	}
+	for i, iface := range allocation.Interfaces {
+		res.Interfaces[i] = types.InterfaceAllocationRes{
+			Interface: iface.Interface,
+			Mark:      iface.Mark,
+			Table:     iface.Table,
+			Collision: iface.Collision,
+		}
+	}
+	return res
+}
+
+func RespFromGroupStats(stats app.GroupStats) types.GroupStatsRes {
+	res := types.GroupStatsRes{
+		Time:     stats.Time.Unix(),
+		Interval: stats.Interval.Seconds(),
+		Families: make([]types.TrafficStatsRes, len(stats.Families)),
+	}
+	for i, family := range stats.Families {
+		res.Families[i] = types.TrafficStatsRes{
+			Family:  family.Family,
+			Tx:      respFromTraffic(family.Tx),
+			Rx:      respFromTraffic(family.Rx),
+			TxDelta: respFromTraffic(family.TxDelta),
+			RxDelta: respFromTraffic(family.RxDelta),
+		}
+	}
+	return res
+}
+
+func respFromTraffic(traffic netfilterTools.Traffic) types.TrafficRes {
+	return types.TrafficRes{Packets: traffic.Packets, Bytes: traffic.Bytes}
+}
+
+func RespFromConnections(connections []app.Connection) types.ConnectionsRes {
+	res := types.ConnectionsRes{Connections: make([]types.ConnectionRes, len(connections))}
+	for i, connection := range connections {
+		item := types.ConnectionRes{
+			Family:          connection.Family,
+			Protocol:        connection.Protocol,
+			Client:          connection.Src.Addr().String(),
+			ClientPort:      connection.Src.Port(),
+			Destination:     connection.Dst.Addr().String(),
+			DestinationPort: connection.Dst.Port(),
+			Tx:              respFromTraffic(connection.Tx),
+			Rx:              respFromTraffic(connection.Rx),
+			Mark:            connection.Mark,
+			GroupName:       connection.GroupName,
+			Interface:       connection.Interface,
+			Domains:         connection.Domains,
+		}
+		if !connection.GroupID.IsZero() {
+			group := connection.GroupID
+			item.Group = &group
+		}
+		res.Connections[i] = item
+	}
+	return res
+}
+
+func RespFromGroupsPlan(plan app.GroupsPlan) types.GroupsPlanRes {
+	res := types.GroupsPlanRes{
+		IPTables4: plan.IPTables4,
+		IPTables6: plan.IPTables6,
+		Groups:    make([]types.GroupPlanRes, len(plan.Groups)),
+		Warnings:  plan.Warnings,
+	}
+	for i, group := range plan.Groups {
+		res.Groups[i] = types.GroupPlanRes{
+			ID:            group.ID,
+			Name:          group.Name,
+			Removed:       group.Removed,
+			IPSetAdd:      group.IPSetAdd,
+			IPSetDelete:   group.IPSetDelete,
+			RoutingAdd:    group.RoutingAdd,
+			RoutingDelete: group.RoutingDelete,
+		}
+	}
+	return res
+}
+
+func RespFromCapabilities(caps netfilterTools.Capabilities) types.CapabilitiesRes {
+	res := types.CapabilitiesRes{
+		Backend:      caps.Backend,
+		Capabilities: make([]types.CapabilityRes, len(caps.List)),
+		Degraded:     caps.Degraded,
+	}
+	for i, capability := range caps.List {
+		res.Capabilities[i] = types.CapabilityRes{
+			Name:      capability.Name,
+			Family:    capability.Family,
+			Available: capability.Available,
+			Error:     capability.Error,
+		}
+	}
+	return res
+}
+
+func RespFromReconcileStatus(status app.ReconcileStatus) types.ReconcileStatusRes {
+	res := types.ReconcileStatusRes{
+		LastError: status.LastError,
+		Repairs:   make([]types.ReconcileRepairRes, len(status.Repairs)),
+	}
+	if !status.LastRun.IsZero() {
+		res.LastRun = status.LastRun.Unix()
+	}
+	for i, repair := range status.Repairs {
+		res.Repairs[i] = types.ReconcileRepairRes{
+			Object:   repair.Object,
+			Count:    repair.Count,
+			LastTime: repair.LastTime.Unix(),
+		}
+	}
+	return res
+}
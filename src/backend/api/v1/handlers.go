@@ -1,8 +1,10 @@
 package v1
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"strconv"
 
 	"magitrickle/api/utils"
@@ -10,6 +12,7 @@ import (
 	"magitrickle/app"
 	"magitrickle/models"
 	"magitrickle/utils/intID"
+	"magitrickle/utils/netfilterTools"
 
 	"github.com/rs/zerolog/log"
 )
@@ -72,10 +75,11 @@ func (h *Handler) ListInterfaces(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res := make([]types.InterfaceRes, len(interfaces)+1)
-	res[0] = types.InterfaceRes{ID: "blackhole"}
+	res := make([]types.InterfaceRes, len(interfaces)+2)
+	res[0] = types.InterfaceRes{ID: models.InterfaceBlackhole}
+	res[1] = types.InterfaceRes{ID: models.InterfaceReject}
 	for i, iface := range interfaces {
-		res[i+1] = types.InterfaceRes{
+		res[i+2] = types.InterfaceRes{
 			ID:   iface.ID,
 			Name: iface.Name,
 		}
@@ -99,6 +103,81 @@ func (h *Handler) SaveConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetReconcileStatus
+//
+//	@Summary		Получить результаты сверки правил
+//	@Description	Возвращает время последней сверки правил netfilter, наборов и маршрутов и объекты, которые пришлось восстанавливать
+//	@Tags			config
+//	@Produce		json
+//	@Success		200	{object}	types.ReconcileStatusRes
+//	@Failure		500	{object}	types.ErrorRes
+//	@Router			/api/v1/system/reconcile [get]
+func (h *Handler) GetReconcileStatus(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJson(w, http.StatusOK, RespFromReconcileStatus(h.app.ReconcileStatus()))
+}
+
+// GetCapabilities
+//
+//	@Summary		Получить возможности ядра
+//	@Description	Возвращает результаты проверки модулей ядра и утилит, выполненной при запуске, и отключённые из-за них функции
+//	@Tags			config
+//	@Produce		json
+//	@Success		200	{object}	types.CapabilitiesRes
+//	@Failure		503	{object}	types.ErrorRes
+//	@Router			/api/v1/system/capabilities [get]
+func (h *Handler) GetCapabilities(w http.ResponseWriter, r *http.Request) {
+	caps := h.app.Capabilities()
+	if caps == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "kernel capabilities are not checked yet")
+		return
+	}
+	utils.WriteJson(w, http.StatusOK, RespFromCapabilities(*caps))
+}
+
+// GetConnections
+//
+//	@Summary		Получить список соединений
+//	@Description	Возвращает соединения из conntrack с группой, чьей меткой помечено соединение, и доменами адреса назначения из кэша DNS
+//	@Tags			connections
+//	@Produce		json
+//	@Param			client	query		string	false	"Адрес клиента"
+//	@Param			group	query		string	false	"ID группы"
+//	@Success		200		{object}	types.ConnectionsRes
+//	@Failure		400		{object}	types.ErrorRes
+//	@Failure		500		{object}	types.ErrorRes
+//	@Failure		503		{object}	types.ErrorRes
+//	@Router			/api/v1/connections [get]
+func (h *Handler) GetConnections(w http.ResponseWriter, r *http.Request) {
+	var filter app.ConnectionFilter
+	if client := r.URL.Query().Get("client"); client != "" {
+		addr, err := netip.ParseAddr(client)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "invalid client address")
+			return
+		}
+		filter.Client = addr
+	}
+	if group := r.URL.Query().Get("group"); group != "" {
+		id, err := intID.ParseID(group)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "invalid group id")
+			return
+		}
+		filter.Group = id
+	}
+
+	connections, err := h.app.Connections(filter)
+	if err != nil {
+		if errors.Is(err, netfilterTools.ErrCapabilityMissing) {
+			utils.WriteError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to list connections: %w", err).Error())
+		return
+	}
+	utils.WriteJson(w, http.StatusOK, RespFromConnections(connections))
+}
+
 // GetGroups
 //
 //	@Summary		Получить список групп
@@ -179,6 +258,45 @@ func (h *Handler) PutGroups(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// PlanGroups
+//
+//	@Summary		Спланировать обновление списка групп
+//	@Description	Возвращает скрипты iptables-restore, изменения ipset и маршрутизации, которые внесло бы обновление списка групп, ничего не применяя
+//	@Tags			groups
+//	@Accept			json
+//	@Produce		json
+//	@Param			json	body		types.GroupsReq	true	"Тело запроса"
+//	@Success		200		{object}	types.GroupsPlanRes
+//	@Failure		400		{object}	types.ErrorRes
+//	@Failure		500		{object}	types.ErrorRes
+//	@Router			/api/v1/groups/plan [post]
+func (h *Handler) PlanGroups(w http.ResponseWriter, r *http.Request) {
+	req, err := utils.ReadJson[types.GroupsReq](r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Groups == nil {
+		utils.WriteError(w, http.StatusBadRequest, "no groups in request")
+		return
+	}
+	// Текущие группы не изменяются, поэтому модели строятся заново
+	newGroups := make([]*models.Group, len(*req.Groups))
+	for i, gReq := range *req.Groups {
+		newGroups[i], err = GroupFromReq(gReq, nil)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	plan, err := h.app.PlanGroups(newGroups)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	utils.WriteJson(w, http.StatusOK, RespFromGroupsPlan(plan))
+}
+
 // CreateGroup
 //
 //	@Summary		Создать группу
@@ -319,6 +437,78 @@ func (h *Handler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetGroupReport
+//
+//	@Summary		Получить отчёт синхронизации группы
+//	@Description	Возвращает отчёт о последней синхронизации группы, в том числе количество префиксов от каждого ASN
+//	@Tags			groups
+//	@Produce		json
+//	@Param			groupID	path		string	true	"ID группы"
+//	@Success		200		{object}	types.GroupReportRes
+//	@Failure		404		{object}	types.ErrorRes
+//	@Failure		500		{object}	types.ErrorRes
+//	@Router			/api/v1/groups/{groupID}/report [get]
+func (h *Handler) GetGroupReport(w http.ResponseWriter, r *http.Request) {
+	groupIdx, _ := strconv.Atoi(r.Header.Get("groupIdx"))
+	report := h.userGroups()[groupIdx].SyncReport()
+	utils.WriteJson(w, http.StatusOK, RespFromSyncReport(report))
+}
+
+// GetGroupFailover
+//
+//	@Summary		Получить состояние резервирования интерфейсов группы
+//	@Description	Возвращает активный интерфейс, результаты проверок работоспособности и историю переключений
+//	@Tags			groups
+//	@Produce		json
+//	@Param			groupID	path		string	true	"ID группы"
+//	@Success		200		{object}	types.GroupFailoverRes
+//	@Failure		404		{object}	types.ErrorRes
+//	@Failure		500		{object}	types.ErrorRes
+//	@Router			/api/v1/groups/{groupID}/failover [get]
+func (h *Handler) GetGroupFailover(w http.ResponseWriter, r *http.Request) {
+	groupIdx, _ := strconv.Atoi(r.Header.Get("groupIdx"))
+	status := h.userGroups()[groupIdx].FailoverStatus()
+	utils.WriteJson(w, http.StatusOK, RespFromFailoverStatus(status))
+}
+
+// GetGroupAllocation
+//
+//	@Summary		Получить метки и таблицы группы
+//	@Description	Возвращает метки пакетов и таблицы маршрутизации, закреплённые за интерфейсами группы
+//	@Tags			groups
+//	@Produce		json
+//	@Param			groupID	path		string	true	"ID группы"
+//	@Success		200		{object}	types.GroupAllocationRes
+//	@Failure		404		{object}	types.ErrorRes
+//	@Failure		500		{object}	types.ErrorRes
+//	@Router			/api/v1/groups/{groupID}/allocation [get]
+func (h *Handler) GetGroupAllocation(w http.ResponseWriter, r *http.Request) {
+	groupIdx, _ := strconv.Atoi(r.Header.Get("groupIdx"))
+	allocation := h.userGroups()[groupIdx].Allocation()
+	utils.WriteJson(w, http.StatusOK, RespFromGroupAllocation(allocation))
+}
+
+// GetGroupStats
+//
+//	@Summary		Получить счётчики трафика группы
+//	@Description	Возвращает пакеты и байты группы по семействам адресов и их прирост с предыдущего запроса
+//	@Tags			groups
+//	@Produce		json
+//	@Param			groupID	path		string	true	"ID группы"
+//	@Success		200		{object}	types.GroupStatsRes
+//	@Failure		404		{object}	types.ErrorRes
+//	@Failure		500		{object}	types.ErrorRes
+//	@Router			/api/v1/groups/{groupID}/stats [get]
+func (h *Handler) GetGroupStats(w http.ResponseWriter, r *http.Request) {
+	groupIdx, _ := strconv.Atoi(r.Header.Get("groupIdx"))
+	stats, err := h.userGroups()[groupIdx].Stats()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	utils.WriteJson(w, http.StatusOK, RespFromGroupStats(stats))
+}
+
 // GetRules
 //
 //	@Summary		Получить список правил
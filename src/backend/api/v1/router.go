@@ -22,6 +22,7 @@ func NewRouter(a app.Main) chi.Router {
 		r.Get("/", h.GetGroups)
 		r.Put("/", h.PutGroups)
 		r.Post("/", h.CreateGroup)
+		r.Post("/plan", h.PlanGroups)
 		r.Route("/{groupID}", func(r chi.Router) {
 			r.Use(func(next http.Handler) http.Handler {
 				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -44,6 +45,10 @@ func NewRouter(a app.Main) chi.Router {
 			r.Get("/", h.GetGroup)
 			r.Put("/", h.PutGroup)
 			r.Delete("/", h.DeleteGroup)
+			r.Get("/report", h.GetGroupReport)
+			r.Get("/failover", h.GetGroupFailover)
+			r.Get("/allocation", h.GetGroupAllocation)
+			r.Get("/stats", h.GetGroupStats)
 			r.Route("/rules", func(r chi.Router) {
 				r.Get("/", h.GetRules)
 				r.Put("/", h.PutRules)
@@ -75,6 +80,7 @@ func NewRouter(a app.Main) chi.Router {
 			})
 		})
 	})
+	r.Get("/connections", h.GetConnections)
 	r.Route("/subscriptions", func(r chi.Router) {
 		r.Get("/", h.GetSubscriptions)
 		r.Put("/", h.PutSubscriptions)
@@ -87,6 +93,8 @@ func NewRouter(a app.Main) chi.Router {
 	})
 	r.Route("/system", func(r chi.Router) {
 		r.Get("/interfaces", h.ListInterfaces)
+		r.Get("/reconcile", h.GetReconcileStatus)
+		r.Get("/capabilities", h.GetCapabilities)
 		r.Route("/config", func(r chi.Router) {
 			r.Post("/save", h.SaveConfig)
 		})
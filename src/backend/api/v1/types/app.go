@@ -4,3 +4,30 @@ type NetfilterDHookReq struct {
 	Type  string `json:"type" example:"iptables"`
 	Table string `json:"table" example:"nat"`
 }
+
+type ReconcileStatusRes struct {
+	LastRun   int64                `json:"lastRun,omitempty" example:"1700000000"`
+	LastError string               `json:"lastError,omitempty" example:"group 0a1b2c3d: error while adding route: network is unreachable"`
+	Repairs   []ReconcileRepairRes `json:"repairs"`
+}
+
+type ReconcileRepairRes struct {
+	Object   string `json:"object" example:"iptables mangle/PREROUTING"`
+	Count    int    `json:"count" example:"3"`
+	LastTime int64  `json:"lastTime" example:"1700000000"`
+}
+
+type CapabilitiesRes struct {
+	Backend      string          `json:"backend" example:"iptables"`
+	Capabilities []CapabilityRes `json:"capabilities"`
+	// Degraded – отключённые из-за отсутствующих возможностей семейства и функции
+	Degraded []string `json:"degraded,omitempty" example:"ipv6 disabled: missing policy_routing"`
+}
+
+type CapabilityRes struct {
+	Name string `json:"name" example:"set_match"`
+	// Family – 4 или 6; отсутствует, если возможность не зависит от семейства
+	Family    int    `json:"family,omitempty" example:"4"`
+	Available bool   `json:"available" example:"false"`
+	Error     string `json:"error,omitempty" example:"iptables-restore failed: exit status 2: Couldn't load match 'set'"`
+}
@@ -0,0 +1,26 @@
+package types
+
+import "magitrickle/utils/intID"
+
+type ConnectionsRes struct {
+	Connections []ConnectionRes `json:"connections"`
+}
+
+// ConnectionRes – соединение conntrack: tx – трафик от клиента, rx – ответы
+type ConnectionRes struct {
+	Family          int        `json:"family" example:"4"`
+	Protocol        string     `json:"protocol" example:"tcp"`
+	Client          string     `json:"client" example:"192.168.1.10"`
+	ClientPort      uint16     `json:"clientPort" example:"51234"`
+	Destination     string     `json:"destination" example:"93.184.216.34"`
+	DestinationPort uint16     `json:"destinationPort" example:"443"`
+	Tx              TrafficRes `json:"tx"`
+	Rx              TrafficRes `json:"rx"`
+	Mark            uint32     `json:"mark" example:"1298229097"`
+	// Group – группа, чьей меткой помечено соединение; отсутствует, если такой нет
+	Group     *intID.ID `json:"group,omitempty" example:"0a1b2c3d" swaggertype:"string"`
+	GroupName string    `json:"groupName,omitempty" example:"Example"`
+	Interface string    `json:"interface,omitempty" example:"nwg0"`
+	// Domains – домены адреса назначения из кэша DNS
+	Domains []string `json:"domains,omitempty" example:"example.com"`
+}
@@ -18,6 +18,30 @@ type GroupReq struct {
 	Color     string    `json:"color" example:"#ffffff"`
 	Interface string    `json:"interface" example:"nwg0"`
 	Enable    *bool     `json:"enable" example:"true" TODO:"Make required after 1.0.0"`
+	Protocols []string  `json:"protocols,omitempty" example:"tcp,udp"`
+	DstPorts  []string  `json:"dstPorts,omitempty" example:"443,8000-8080"`
+	// Селекторы источника
+	SrcAddresses  []string `json:"srcAddresses,omitempty" example:"192.168.1.10,192.168.2.0/24"`
+	SrcMACs       []string `json:"srcMacs,omitempty" example:"aa:bb:cc:dd:ee:ff"`
+	SrcInterfaces []string `json:"srcInterfaces,omitempty" example:"br0"`
+	RouteOutput   bool     `json:"routeOutput" example:"false"`
+	// Резервирование интерфейсов
+	FailoverInterfaces []string        `json:"failoverInterfaces,omitempty" example:"nwg1,wg0"`
+	HealthCheck        *HealthCheckReq `json:"healthCheck,omitempty"`
+	// Режим: failover (по умолчанию) или balance
+	Mode             string            `json:"mode,omitempty" example:"balance"`
+	InterfaceWeights map[string]uint32 `json:"interfaceWeights,omitempty"`
+	// Шлюзы основного интерфейса (не более одного IPv4 и одного IPv6)
+	Gateways []string `json:"gateways,omitempty" example:"192.168.1.2"`
+	Onlink   bool     `json:"onlink" example:"false"`
+	// Не удалять записи conntrack при изменении маршрутизации группы
+	DisableConntrackFlush bool `json:"disableConntrackFlush" example:"false"`
+	// Размеры набора адресов группы
+	IPSet *IPSetSizing `json:"ipset,omitempty"`
+	// Ограничение MSS: pmtu или размер в байтах
+	MSSClamp string `json:"mssClamp,omitempty" example:"pmtu"`
+	// Трансляция адреса источника: masquerade, none или snat:<адрес>[,<адрес>]
+	NAT string `json:"nat,omitempty" example:"masquerade"`
 	RulesReq
 }
 
@@ -27,5 +51,54 @@ type GroupRes struct {
 	Color     string   `json:"color" example:"#ffffff"`
 	Interface string   `json:"interface" example:"nwg0"`
 	Enable    bool     `json:"enable" example:"true"`
+	Protocols []string `json:"protocols,omitempty" example:"tcp,udp"`
+	DstPorts  []string `json:"dstPorts,omitempty" example:"443,8000-8080"`
+	// Селекторы источника
+	SrcAddresses  []string `json:"srcAddresses,omitempty" example:"192.168.1.10,192.168.2.0/24"`
+	SrcMACs       []string `json:"srcMacs,omitempty" example:"aa:bb:cc:dd:ee:ff"`
+	SrcInterfaces []string `json:"srcInterfaces,omitempty" example:"br0"`
+	RouteOutput   bool     `json:"routeOutput" example:"false"`
+	// Резервирование интерфейсов
+	FailoverInterfaces []string        `json:"failoverInterfaces,omitempty" example:"nwg1,wg0"`
+	HealthCheck        *HealthCheckRes `json:"healthCheck,omitempty"`
+	// Режим: failover (по умолчанию) или balance
+	Mode             string            `json:"mode,omitempty" example:"balance"`
+	InterfaceWeights map[string]uint32 `json:"interfaceWeights,omitempty"`
+	// Шлюзы основного интерфейса (не более одного IPv4 и одного IPv6)
+	Gateways []string `json:"gateways,omitempty" example:"192.168.1.2"`
+	Onlink   bool     `json:"onlink" example:"false"`
+	// Не удалять записи conntrack при изменении маршрутизации группы
+	DisableConntrackFlush bool `json:"disableConntrackFlush" example:"false"`
+	// Размеры набора адресов группы
+	IPSet *IPSetSizing `json:"ipset,omitempty"`
+	// Ограничение MSS: pmtu или размер в байтах
+	MSSClamp string `json:"mssClamp,omitempty" example:"pmtu"`
+	// Трансляция адреса источника: masquerade, none или snat:<адрес>[,<адрес>]
+	NAT string `json:"nat,omitempty" example:"masquerade"`
 	RulesRes
 }
+
+type HealthCheckReq struct {
+	Type          string `json:"type" example:"icmp"`
+	Target        string `json:"target,omitempty" example:"1.1.1.1"`
+	Interval      uint32 `json:"interval,omitempty" example:"10"`
+	Timeout       uint32 `json:"timeout,omitempty" example:"3"`
+	FailThreshold int    `json:"failThreshold,omitempty" example:"3"`
+	RiseThreshold int    `json:"riseThreshold,omitempty" example:"2"`
+}
+
+type HealthCheckRes struct {
+	Type          string `json:"type" example:"icmp"`
+	Target        string `json:"target,omitempty" example:"1.1.1.1"`
+	Interval      uint32 `json:"interval" example:"10"`
+	Timeout       uint32 `json:"timeout" example:"3"`
+	FailThreshold int    `json:"failThreshold" example:"3"`
+	RiseThreshold int    `json:"riseThreshold" example:"2"`
+}
+
+// IPSetSizing – размеры набора адресов; 0 – значение по умолчанию
+type IPSetSizing struct {
+	MaxElem  uint32 `json:"maxElem,omitempty" example:"262144"`
+	HashSize uint32 `json:"hashSize,omitempty" example:"4096"`
+	Timeout  uint32 `json:"timeout,omitempty" example:"300"`
+}
@@ -0,0 +1,116 @@
+package types
+
+import "magitrickle/utils/intID"
+
+type GroupReportRes struct {
+	SyncedAt    int64                  `json:"syncedAt" example:"1700000000"`
+	ASN         []ASNReportRes         `json:"asn"`
+	Aggregation []SubnetAggregationRes `json:"aggregation"`
+	IPSet       []IPSetUsageRes        `json:"ipset"`
+	Warnings    []string               `json:"warnings,omitempty" example:"ipv4 set is nearly full: 60000 of 65536 elements"`
+	// Progress – ход текущей синхронизации; отсутствует, если она не выполняется
+	Progress *SyncProgressRes `json:"progress,omitempty"`
+}
+
+type SyncProgressRes struct {
+	StartedAt int64 `json:"startedAt" example:"1700000000"`
+	Done      int   `json:"done" example:"20480"`
+	Total     int   `json:"total" example:"100000"`
+}
+
+// SubnetAggregationRes – число записей набора до и после объединения подсетей
+type SubnetAggregationRes struct {
+	Family int `json:"family" example:"4"`
+	Before int `json:"before" example:"4096"`
+	After  int `json:"after" example:"37"`
+}
+
+type IPSetUsageRes struct {
+	Family  int    `json:"family" example:"4"`
+	Entries uint32 `json:"entries" example:"1200"`
+	// MaxElem – ёмкость набора; 0 – не ограничена
+	MaxElem uint32 `json:"maxElem" example:"65536"`
+	Resized bool   `json:"resized" example:"false"`
+}
+
+type ASNReportRes struct {
+	ASN   uint32 `json:"asn" example:"32934"`
+	IPv4  int    `json:"ipv4" example:"120"`
+	IPv6  int    `json:"ipv6" example:"40"`
+	Error string `json:"error,omitempty" example:"asn dataset is not loaded"`
+}
+
+type GroupFailoverRes struct {
+	Active     string               `json:"active" example:"nwg0"`
+	Interfaces []InterfaceHealthRes `json:"interfaces"`
+	Events     []FailoverEventRes   `json:"events"`
+}
+
+type InterfaceHealthRes struct {
+	Interface string `json:"interface" example:"nwg0"`
+	Healthy   bool   `json:"healthy" example:"true"`
+	LastCheck int64  `json:"lastCheck,omitempty" example:"1700000000"`
+	Error     string `json:"error,omitempty" example:"no echo reply"`
+}
+
+type FailoverEventRes struct {
+	Time   int64  `json:"time" example:"1700000000"`
+	From   string `json:"from" example:"nwg0"`
+	To     string `json:"to" example:"nwg1"`
+	Reason string `json:"reason" example:"nwg0 unhealthy: no echo reply"`
+	Error  string `json:"error,omitempty" example:"error adding iface route"`
+}
+
+type GroupAllocationRes struct {
+	// Mask – биты метки, которые использует приложение
+	Mask       uint32                   `json:"mask" example:"4294967295"`
+	Interfaces []InterfaceAllocationRes `json:"interfaces"`
+}
+
+type InterfaceAllocationRes struct {
+	Interface string `json:"interface" example:"nwg0"`
+	Mark      uint32 `json:"mark" example:"1298229097"`
+	Table     int    `json:"table" example:"1298229097"`
+	// Collision – почему закреплённые значения были заменены
+	Collision string `json:"collision,omitempty" example:"table 1298229097 already has routes"`
+}
+
+type GroupStatsRes struct {
+	Time int64 `json:"time" example:"1700000000"`
+	// Interval – секунды с предыдущего запроса; 0 – запрос первый, приращения равны счётчикам
+	Interval float64           `json:"interval" example:"60"`
+	Families []TrafficStatsRes `json:"families"`
+}
+
+// TrafficStatsRes – счётчики семейства: tx – трафик клиентов к адресам группы, rx – ответы
+type TrafficStatsRes struct {
+	Family  int        `json:"family" example:"4"`
+	Tx      TrafficRes `json:"tx"`
+	Rx      TrafficRes `json:"rx"`
+	TxDelta TrafficRes `json:"txDelta"`
+	RxDelta TrafficRes `json:"rxDelta"`
+}
+
+type TrafficRes struct {
+	Packets uint64 `json:"packets" example:"1200"`
+	Bytes   uint64 `json:"bytes" example:"1048576"`
+}
+
+type GroupsPlanRes struct {
+	// IPTables4, IPTables6 – скрипты iptables-restore; пустой скрипт – без изменений
+	IPTables4 string         `json:"iptables4" example:"*mangle\n:MT_0a1b2c3d - [0:0]\n-A MT_0a1b2c3d -m set --match-set mt_0a1b2c3d_4 dst -j MARK --set-mark 1298229097\nCOMMIT\n"`
+	IPTables6 string         `json:"iptables6"`
+	Groups    []GroupPlanRes `json:"groups"`
+	Warnings  []string       `json:"warnings,omitempty" example:"netfilter rules are not planned for nftables backend"`
+}
+
+type GroupPlanRes struct {
+	ID   intID.ID `json:"id" example:"0a1b2c3d" swaggertype:"string"`
+	Name string   `json:"name" example:"Routing"`
+	// Removed – группы нет в новом списке
+	Removed       bool     `json:"removed,omitempty" example:"false"`
+	IPSetAdd      []string `json:"ipsetAdd,omitempty" example:"203.0.113.0/24"`
+	IPSetDelete   []string `json:"ipsetDelete,omitempty" example:"198.51.100.7/32"`
+	RoutingAdd    []string `json:"routingAdd,omitempty" example:"rule -4 fwmark 0x4d617469 lookup 1298229097"`
+	RoutingDelete []string `json:"routingDelete,omitempty" example:"route -4 default dev nwg0 metric 10 table 1298229097"`
+}
@@ -3,6 +3,7 @@ package magitrickle
 import (
 	"errors"
 	"fmt"
+	"net/netip"
 	"sync"
 	"sync/atomic"
 
@@ -11,6 +12,7 @@ import (
 	groupruntime "magitrickle/groups"
 	"magitrickle/internal/interfaces"
 	"magitrickle/models"
+	"magitrickle/utils/asnDataset"
 	"magitrickle/utils/dnsMITMProxy"
 	"magitrickle/utils/intID"
 	"magitrickle/utils/netfilterTools"
@@ -21,6 +23,7 @@ import (
 
 var (
 	ErrAlreadyRunning           = errors.New("already running")
+	ErrNotRunning               = errors.New("not running")
 	ErrGroupIDConflict          = errors.New("group id conflict")
 	ErrRuleIDConflict           = errors.New("rule id conflict")
 	ErrConfigUnsupportedVersion = errors.New("config unsupported version")
@@ -38,10 +41,14 @@ type App struct {
 	dnsMITM              *dnsMITMProxy.DNSMITMProxy
 	nfHelper             *netfilterTools.Helper
 	recordsCache         *recordsCache.Records
+	asnDataset           *asnDataset.Dataset
 	userRuleSets         []*RuleSet
 	subscriptionRuleSets []*RuleSet
 	dnsOverrider         *netfilterTools.PortRemap
 	subscriptions        []*models.Subscription
+
+	reconcileMu     sync.Mutex
+	reconcileStatus reconcileStatus
 }
 
 // New создаёт новый экземпляр App
@@ -94,13 +101,8 @@ func (a *App) addGroupLocked(groupModel *models.Group) error {
 			return ErrGroupIDConflict
 		}
 	}
-	// Проверка уникальности rule.ID внутри группы.
-	dup := make(map[[4]byte]struct{})
-	for _, rule := range groupModel.Rules {
-		if _, exists := dup[rule.ID]; exists {
-			return ErrRuleIDConflict
-		}
-		dup[rule.ID] = struct{}{}
+	if err := validateGroup(groupModel); err != nil {
+		return err
 	}
 
 	grp, err := NewRuleSet(groupruntime.BuildRuntimeRuleSet(groupModel), a)
@@ -132,6 +134,21 @@ func (a *App) addGroupLocked(groupModel *models.Group) error {
 	return nil
 }
 
+// validateGroup проверяет группу и уникальность rule.ID внутри неё
+func validateGroup(groupModel *models.Group) error {
+	if err := groupModel.Validate(); err != nil {
+		return fmt.Errorf("invalid group %s: %w", groupModel.ID.String(), err)
+	}
+	dup := make(map[[4]byte]struct{})
+	for _, rule := range groupModel.Rules {
+		if _, exists := dup[rule.ID]; exists {
+			return ErrRuleIDConflict
+		}
+		dup[rule.ID] = struct{}{}
+	}
+	return nil
+}
+
 // RemoveGroupByIndex удаляет группу по индексу
 func (a *App) RemoveGroupByIndex(idx int) {
 	a.stateMu.Lock()
@@ -241,6 +258,14 @@ func (a *App) DnsOverrider() *netfilterTools.PortRemap {
 	return a.dnsOverrider
 }
 
+// lookupASN возвращает префиксы ASN из локальной базы
+func (a *App) lookupASN(asn uint32) ([]netip.Prefix, error) {
+	if a.asnDataset == nil {
+		return nil, errors.New("asn dataset is not loaded")
+	}
+	return a.asnDataset.Lookup(asn)
+}
+
 func (a *App) ruleSetSnapshot() []*RuleSet {
 	a.stateMu.RLock()
 	defer a.stateMu.RUnlock()
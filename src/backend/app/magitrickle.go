@@ -3,6 +3,7 @@ package app
 import (
 	"context"
 	"errors"
+	"net/netip"
 	"time"
 
 	"magitrickle/models"
@@ -25,6 +26,146 @@ type SubscriptionSyncResult struct {
 	Rules      []*models.SubscriptionRule
 }
 
+// RuleSetSyncReport описывает результат последней синхронизации набора правил
+type RuleSetSyncReport struct {
+	Time time.Time
+	ASN  []ASNSyncReport
+	// Aggregation – число записей наборов до и после объединения подсетей
+	Aggregation []SubnetAggregationReport
+	// IPSet – заполненность наборов адресов после синхронизации
+	IPSet []netfilterTools.IPSetUsage
+	// Warnings – предупреждения о почти заполненных наборах
+	Warnings []string
+	// Progress – ход загрузки изменений в наборы; nil, если синхронизация не выполняется
+	Progress *SyncProgress
+}
+
+// SyncProgress – число применённых изменений наборов из общего числа Total
+type SyncProgress struct {
+	Started time.Time
+	Done    int
+	Total   int
+}
+
+// SubnetAggregationReport – число записей набора семейства Family (4 или 6)
+// до и после объединения подсетей в минимальное покрытие
+type SubnetAggregationReport struct {
+	Family int
+	Before int
+	After  int
+}
+
+// ASNSyncReport – вклад одного ASN в набор правил
+type ASNSyncReport struct {
+	ASN   uint32
+	IPv4  int
+	IPv6  int
+	Error string
+}
+
+// FailoverEvent – смена активного интерфейса группы
+type FailoverEvent struct {
+	Time   time.Time
+	From   string
+	To     string
+	Reason string
+	// Error – ошибка применения маршрутов нового интерфейса
+	Error string
+}
+
+// InterfaceHealth – результат проверок работоспособности интерфейса
+type InterfaceHealth struct {
+	Interface string
+	Healthy   bool
+	LastCheck time.Time
+	LastError string
+}
+
+// FailoverStatus описывает резервирование интерфейсов группы
+type FailoverStatus struct {
+	Active     string
+	Interfaces []InterfaceHealth
+	Events     []FailoverEvent
+}
+
+// GroupAllocation – метки и таблицы маршрутизации, закреплённые за интерфейсами группы
+type GroupAllocation struct {
+	Mask       uint32
+	Interfaces []netfilterTools.Allocation
+}
+
+// GroupStats – счётчики трафика группы и их приращения с предыдущего запроса
+type GroupStats struct {
+	Time time.Time
+	// Interval – время с предыдущего запроса; 0 – запрос первый, приращения равны счётчикам
+	Interval time.Duration
+	Families []TrafficStats
+}
+
+// TrafficStats – счётчики семейства Family (4 или 6): Tx – трафик клиентов к адресам
+// группы, Rx – ответы на него
+type TrafficStats struct {
+	Family  int
+	Tx      netfilterTools.Traffic
+	Rx      netfilterTools.Traffic
+	TxDelta netfilterTools.Traffic
+	RxDelta netfilterTools.Traffic
+}
+
+// Connection – соединение из conntrack с группой, чьей меткой оно помечено,
+// и доменами адреса назначения из кэша DNS
+type Connection struct {
+	netfilterTools.Connection
+	// GroupID – нулевой, если соединение не помечено ни одной группой
+	GroupID   intID.ID
+	GroupName string
+	// Interface – интерфейс группы, через который идёт соединение
+	Interface string
+	Domains   []string
+}
+
+// ConnectionFilter – отбор соединений; нулевые поля не ограничивают выборку
+type ConnectionFilter struct {
+	Client netip.Addr
+	Group  intID.ID
+}
+
+// GroupsPlan – изменения, которые внесла бы замена списка пользовательских групп
+type GroupsPlan struct {
+	// IPTables4, IPTables6 – скрипты iptables-restore; пустой скрипт – без изменений
+	IPTables4 string
+	IPTables6 string
+	Groups    []GroupPlan
+	Warnings  []string
+}
+
+// GroupPlan – изменения наборов адресов и маршрутизации одной группы
+type GroupPlan struct {
+	ID   intID.ID
+	Name string
+	// Removed – группы нет в новом списке
+	Removed       bool
+	IPSetAdd      []string
+	IPSetDelete   []string
+	RoutingAdd    []string
+	RoutingDelete []string
+}
+
+// ReconcileStatus – результаты сверки правил netfilter и маршрутизации с желаемым состоянием
+type ReconcileStatus struct {
+	LastRun   time.Time
+	LastError string
+	// Repairs – исправленные объекты с начала работы
+	Repairs []ReconcileRepair
+}
+
+// ReconcileRepair – сколько раз объект пришлось восстанавливать
+type ReconcileRepair struct {
+	Object   string
+	Count    int
+	LastTime time.Time
+}
+
 type Main interface {
 	Config() models.AppConfig
 	UserGroups() []RuleSet
@@ -32,6 +173,7 @@ type Main interface {
 	AddGroup(groupModel *models.Group) error
 	RemoveGroupByIndex(idx int)
 	RemoveGroupByID(id intID.ID) bool
+	PlanGroups(groups []*models.Group) (GroupsPlan, error)
 	SyncSubscriptionRuleSets() error
 	WithSubscriptions(fn func([]*models.Subscription))
 	ReplaceSubscriptions(subscriptions []*models.Subscription) error
@@ -44,6 +186,9 @@ type Main interface {
 	LoadConfig() error
 	SaveConfig() error
 	ForceCommitIPTables() error
+	ReconcileStatus() ReconcileStatus
+	Capabilities() *netfilterTools.Capabilities
+	Connections(filter ConnectionFilter) ([]Connection, error)
 	Start(ctx context.Context) (err error)
 }
 
@@ -59,6 +204,11 @@ type RuleSet interface {
 	Enable() error
 	Disable() error
 	Sync() error
+	SyncReport() RuleSetSyncReport
+	FailoverStatus() FailoverStatus
+	Allocation() GroupAllocation
+	Stats() (GroupStats, error)
 	LinkUpHook(event netlink.LinkUpdate) error
+	LinkDownHook(event netlink.LinkUpdate) error
 	AddrChangeHook(event netlink.AddrUpdate) error
 }
@@ -0,0 +1,34 @@
+package magitrickle
+
+import (
+	"magitrickle/utils/netfilterTools"
+
+	"github.com/rs/zerolog/log"
+)
+
+// logCapabilities сообщает о недоступных возможностях ядра и отключённых из-за них функциях
+func logCapabilities(caps netfilterTools.Capabilities) {
+	missing := caps.Missing()
+	for _, capability := range missing {
+		event := log.Warn().Str("capability", capability.Name).Str("error", capability.Error)
+		if capability.Family != 0 {
+			event = event.Int("family", capability.Family)
+		}
+		event.Msg("kernel capability is missing")
+	}
+	for _, degraded := range caps.Degraded {
+		log.Warn().Str("reason", degraded).Msg("netfilter degraded")
+	}
+	log.Info().
+		Int("checked", len(caps.List)).
+		Int("missing", len(missing)).
+		Msg("kernel capabilities checked")
+}
+
+// Capabilities возвращает результаты проверки возможностей ядра; nil, если ядро не запущено
+func (a *App) Capabilities() *netfilterTools.Capabilities {
+	if a.nfHelper == nil {
+		return nil
+	}
+	return a.nfHelper.Capabilities()
+}
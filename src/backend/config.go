@@ -82,6 +82,7 @@ func (a *App) LoadConfig() error {
 		}
 
 		if cfg.App.Netfilter != nil {
+			applyIfSet(&a.config.Netfilter.Backend, cfg.App.Netfilter.Backend)
 			if cfg.App.Netfilter.IPTables != nil {
 				applyIfSet(&a.config.Netfilter.IPTables.ChainPrefix, cfg.App.Netfilter.IPTables.ChainPrefix)
 			}
@@ -99,6 +100,18 @@ func (a *App) LoadConfig() error {
 			applyIfSet(&a.config.Netfilter.DisableIPv4, cfg.App.Netfilter.DisableIPv4)
 			applyIfSet(&a.config.Netfilter.DisableIPv6, cfg.App.Netfilter.DisableIPv6)
 			applyIfSet(&a.config.Netfilter.StartMarkTableIndex, cfg.App.Netfilter.StartMarkTableIndex)
+			applyIfSet(&a.config.Netfilter.FwmarkMask, cfg.App.Netfilter.FwmarkMask)
+			applyIfSet(&a.config.Netfilter.OutputBypass, cfg.App.Netfilter.OutputBypass)
+			applyIfSet(&a.config.Netfilter.ReconcileInterval, cfg.App.Netfilter.ReconcileInterval)
+		}
+
+		if cfg.App.ASN != nil {
+			applyIfSet(&a.config.ASN.DatasetPath, cfg.App.ASN.DatasetPath)
+		}
+
+		if cfg.App.RecordsCache != nil {
+			applyIfSet(&a.config.RecordsCache.SnapshotPath, cfg.App.RecordsCache.SnapshotPath)
+			applyIfSet(&a.config.RecordsCache.SnapshotInterval, cfg.App.RecordsCache.SnapshotInterval)
 		}
 
 		applyIfSet(&a.config.Link, cfg.App.Link)
@@ -182,6 +195,7 @@ func (a *App) SaveConfig() error {
 				Timeout:         &a.config.DNSProxy.Timeout,
 			},
 			Netfilter: &config.Netfilter{
+				Backend: &a.config.Netfilter.Backend,
 				IPTables: &config.IPTables{
 					ChainPrefix: &a.config.Netfilter.IPTables.ChainPrefix,
 				},
@@ -192,6 +206,16 @@ func (a *App) SaveConfig() error {
 				DisableIPv4:         &a.config.Netfilter.DisableIPv4,
 				DisableIPv6:         &a.config.Netfilter.DisableIPv6,
 				StartMarkTableIndex: &a.config.Netfilter.StartMarkTableIndex,
+				FwmarkMask:          &a.config.Netfilter.FwmarkMask,
+				OutputBypass:        &a.config.Netfilter.OutputBypass,
+				ReconcileInterval:   &a.config.Netfilter.ReconcileInterval,
+			},
+			ASN: &config.ASN{
+				DatasetPath: &a.config.ASN.DatasetPath,
+			},
+			RecordsCache: &config.RecordsCache{
+				SnapshotPath:     &a.config.RecordsCache.SnapshotPath,
+				SnapshotInterval: &a.config.RecordsCache.SnapshotInterval,
 			},
 			Link:              &a.config.Link,
 			ShowAllInterfaces: &a.config.ShowAllInterfaces,
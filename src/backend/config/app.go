@@ -3,12 +3,14 @@ package config
 import "time"
 
 type App struct {
-	HTTPWeb           *HTTPWeb   `yaml:"httpWeb"`
-	DNSProxy          *DNSProxy  `yaml:"dnsProxy"`
-	Netfilter         *Netfilter `yaml:"netfilter"`
-	Link              *[]string  `yaml:"link"`
-	ShowAllInterfaces *bool      `yaml:"showAllInterfaces"`
-	LogLevel          *string    `yaml:"logLevel"`
+	HTTPWeb           *HTTPWeb      `yaml:"httpWeb"`
+	DNSProxy          *DNSProxy     `yaml:"dnsProxy"`
+	Netfilter         *Netfilter    `yaml:"netfilter"`
+	ASN               *ASN          `yaml:"asn"`
+	RecordsCache      *RecordsCache `yaml:"recordsCache"`
+	Link              *[]string     `yaml:"link"`
+	ShowAllInterfaces *bool         `yaml:"showAllInterfaces"`
+	LogLevel          *string       `yaml:"logLevel"`
 }
 
 type HTTPWeb struct {
@@ -44,11 +46,15 @@ type DNSProxyServer struct {
 }
 
 type Netfilter struct {
-	IPTables            *IPTables `yaml:"iptables"`
-	IPSet               *IPSet    `yaml:"ipset"`
-	DisableIPv4         *bool     `yaml:"disableIPv4"`
-	DisableIPv6         *bool     `yaml:"disableIPv6"`
-	StartMarkTableIndex *uint32   `yaml:"startMarkTableIndex"`
+	Backend             *string        `yaml:"backend"`
+	IPTables            *IPTables      `yaml:"iptables"`
+	IPSet               *IPSet         `yaml:"ipset"`
+	DisableIPv4         *bool          `yaml:"disableIPv4"`
+	DisableIPv6         *bool          `yaml:"disableIPv6"`
+	StartMarkTableIndex *uint32        `yaml:"startMarkTableIndex"`
+	FwmarkMask          *uint32        `yaml:"fwmarkMask"`
+	OutputBypass        *[]string      `yaml:"outputBypass"`
+	ReconcileInterval   *time.Duration `yaml:"reconcileInterval"`
 }
 
 type IPTables struct {
@@ -59,3 +65,12 @@ type IPSet struct {
 	TablePrefix   *string        `yaml:"tablePrefix"`
 	AdditionalTTL *time.Duration `yaml:"additionalTTL"`
 }
+
+type ASN struct {
+	DatasetPath *string `yaml:"datasetPath"`
+}
+
+type RecordsCache struct {
+	SnapshotPath     *string        `yaml:"snapshotPath"`
+	SnapshotInterval *time.Duration `yaml:"snapshotInterval"`
+}
@@ -0,0 +1,66 @@
+package magitrickle
+
+import (
+	"magitrickle/app"
+	"magitrickle/utils/intID"
+	"magitrickle/utils/netfilterTools"
+)
+
+// groupMarks – метки conntrack одной группы
+type groupMarks struct {
+	id    intID.ID
+	name  string
+	marks []netfilterTools.ConnMark
+}
+
+// Connections возвращает соединения conntrack с группой по метке соединения
+// и доменами адреса назначения
+func (a *App) Connections(filter app.ConnectionFilter) ([]app.Connection, error) {
+	if a.nfHelper == nil {
+		return nil, ErrNotRunning
+	}
+
+	var groups []groupMarks
+	for _, g := range a.ruleSetSnapshot() {
+		g.locker.Lock()
+		if g.ipsetToLink != nil {
+			if marks := g.ipsetToLink.ConnMarks(); len(marks) != 0 {
+				groups = append(groups, groupMarks{id: g.IDValue(), name: g.DisplayName(), marks: marks})
+			}
+		}
+		g.locker.Unlock()
+	}
+
+	entries, err := a.nfHelper.Connections()
+	if err != nil {
+		return nil, err
+	}
+	domains := a.recordsCache.AddressDomains()
+
+	connections := make([]app.Connection, 0, len(entries))
+	for _, entry := range entries {
+		if filter.Client.IsValid() && entry.Src.Addr() != filter.Client.Unmap() {
+			continue
+		}
+		connection := app.Connection{Connection: entry}
+		connection.GroupID, connection.GroupName, connection.Interface = matchGroup(groups, entry.Mark)
+		if !filter.Group.IsZero() && connection.GroupID != filter.Group {
+			continue
+		}
+		connection.Domains = domains[entry.Dst.Addr()]
+		connections = append(connections, connection)
+	}
+	return connections, nil
+}
+
+// matchGroup ищет группу, меткой которой помечено соединение
+func matchGroup(groups []groupMarks, mark uint32) (intID.ID, string, string) {
+	for _, group := range groups {
+		for _, m := range group.marks {
+			if m.Match(mark) {
+				return group.id, group.name, m.Interface
+			}
+		}
+	}
+	return intID.ID{}, "", ""
+}
@@ -29,6 +29,7 @@ var DefaultAppConfig = models.AppConfig{
 		Skin: "default",
 	},
 	Netfilter: models.AppConfigNetfilter{
+		Backend: "auto",
 		IPTables: models.AppConfigIPTables{
 			ChainPrefix: "MT_",
 		},
@@ -39,6 +40,16 @@ var DefaultAppConfig = models.AppConfig{
 		DisableIPv4:         false,
 		DisableIPv6:         false,
 		StartMarkTableIndex: 0x4D616769, // Magi
+		FwmarkMask:          0xffffffff,
+		OutputBypass:        []string{},
+		ReconcileInterval:   time.Minute,
+	},
+	ASN: models.AppConfigASN{
+		DatasetPath: AppStateDir + "/asn-prefixes.txt",
+	},
+	RecordsCache: models.AppConfigRecordsCache{
+		SnapshotPath:     AppStateDir + "/records-cache.json",
+		SnapshotInterval: 5 * time.Minute,
 	},
 	Link:              []string{"br0"},
 	ShowAllInterfaces: false,
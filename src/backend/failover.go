@@ -0,0 +1,218 @@
+package magitrickle
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"magitrickle/app"
+	"magitrickle/models"
+	"magitrickle/utils/healthCheck"
+
+	"github.com/rs/zerolog/log"
+)
+
+// failoverEventsLimit – сколько последних переключений хранится у группы
+const failoverEventsLimit = 50
+
+// failoverMonitor периодически проверяет интерфейсы группы и переключает маршрутизацию
+// на первый работоспособный, а при балансировке исключает неработоспособные из выбора
+type failoverMonitor struct {
+	cancel  context.CancelFunc
+	balance bool
+
+	locker  sync.Mutex
+	tracker *healthCheck.Tracker
+}
+
+func (g *RuleSet) startFailover() {
+	model := g.spec.Model
+	// С одним интерфейсом проверки только информируют о его состоянии
+	if model == nil || (len(model.FailoverInterfaces) == 0 && model.HealthCheck == nil) {
+		return
+	}
+
+	check := model.HealthCheck.WithDefaults()
+	if check.Type == models.HealthCheckNeighbor && check.Target == "" && len(model.Gateways) > 0 {
+		check.Target = model.Gateways[0]
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &failoverMonitor{
+		cancel:  cancel,
+		balance: model.Balancing(),
+		tracker: healthCheck.NewTracker(model.Interfaces(), check.FailThreshold, check.RiseThreshold),
+	}
+	g.failover = m
+
+	go g.runFailover(ctx, m, model.Interfaces(), healthCheck.Check{
+		Type:    check.Type,
+		Target:  check.Target,
+		Timeout: time.Duration(check.Timeout) * time.Second,
+	}, time.Duration(check.Interval)*time.Second)
+}
+
+// stopFailover останавливает проверки; вызывается под g.locker, поэтому не ждёт завершения
+func (g *RuleSet) stopFailover() {
+	if g.failover == nil {
+		return
+	}
+	g.failover.cancel()
+	g.failover = nil
+}
+
+func (g *RuleSet) runFailover(ctx context.Context, m *failoverMonitor, ifaces []string, check healthCheck.Check, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		results := make([]error, len(ifaces))
+		var wg sync.WaitGroup
+		for i, iface := range ifaces {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = healthCheck.Probe(ctx, iface, check)
+			}()
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.locker.Lock()
+		now := time.Now()
+		sw, changed := m.tracker.Update(now, results)
+		states := m.tracker.States()
+		m.locker.Unlock()
+		if m.balance {
+			g.updateBalance(ctx, now, states)
+		} else if changed {
+			g.switchInterface(ctx, sw)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *RuleSet) switchInterface(ctx context.Context, sw healthCheck.Switch) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	// Группа могла быть выключена или пересоздана, пока шли проверки
+	if ctx.Err() != nil || g.ipsetToLink == nil {
+		return
+	}
+
+	event := app.FailoverEvent{
+		Time:   sw.Time,
+		From:   sw.From,
+		To:     sw.To,
+		Reason: sw.Reason,
+	}
+	if err := g.ipsetToLink.SetInterface(sw.To); err != nil {
+		event.Error = err.Error()
+		log.Error().
+			Err(err).
+			Str("group", g.IDValue().String()).
+			Str("iface", sw.To).
+			Msg("failed to switch group interface")
+	}
+	g.flushInterfaceConntrack()
+	log.Info().
+		Str("group", g.IDValue().String()).
+		Str("from", sw.From).
+		Str("to", sw.To).
+		Str("reason", sw.Reason).
+		Msg("group interface switched")
+
+	g.recordFailoverEvent(event)
+}
+
+// updateBalance исключает неработоспособные интерфейсы из балансировки
+func (g *RuleSet) updateBalance(ctx context.Context, now time.Time, states []healthCheck.State) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	if ctx.Err() != nil || g.ipsetToLink == nil {
+		return
+	}
+
+	var inactive, reasons []string
+	for _, state := range states {
+		if !state.Healthy {
+			inactive = append(inactive, state.Interface)
+			reasons = append(reasons, fmt.Sprintf("%s unhealthy: %s", state.Interface, state.LastError))
+		}
+	}
+	from := strings.Join(g.ipsetToLink.ActiveInterfaces(), ",")
+	if err := g.ipsetToLink.SetInactive(inactive); err != nil {
+		g.recordFailoverEvent(app.FailoverEvent{Time: now, From: from, To: from, Reason: "balance update failed", Error: err.Error()})
+		log.Error().
+			Err(err).
+			Str("group", g.IDValue().String()).
+			Msg("failed to update group balance")
+		return
+	}
+	to := strings.Join(g.ipsetToLink.ActiveInterfaces(), ",")
+	if from == to {
+		return
+	}
+
+	g.flushInterfaceConntrack(inactive...)
+
+	reason := "all interfaces healthy"
+	if len(reasons) > 0 {
+		reason = strings.Join(reasons, "; ")
+	}
+	log.Info().
+		Str("group", g.IDValue().String()).
+		Str("from", from).
+		Str("to", to).
+		Str("reason", reason).
+		Msg("group balance interfaces changed")
+	g.recordFailoverEvent(app.FailoverEvent{Time: now, From: from, To: to, Reason: reason})
+}
+
+func (g *RuleSet) recordFailoverEvent(event app.FailoverEvent) {
+	g.failoverEvents = append(g.failoverEvents, event)
+	if len(g.failoverEvents) > failoverEventsLimit {
+		g.failoverEvents = g.failoverEvents[len(g.failoverEvents)-failoverEventsLimit:]
+	}
+}
+
+// FailoverStatus возвращает активный интерфейс, состояние проверок и историю переключений
+func (g *RuleSet) FailoverStatus() app.FailoverStatus {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	status := app.FailoverStatus{
+		Active: g.RouteInterface(),
+		Events: append([]app.FailoverEvent(nil), g.failoverEvents...),
+	}
+	if g.ipsetToLink != nil {
+		status.Active = g.ipsetToLink.Interface()
+		if g.failover != nil && g.failover.balance {
+			status.Active = strings.Join(g.ipsetToLink.ActiveInterfaces(), ",")
+		}
+	}
+	if g.failover != nil {
+		g.failover.locker.Lock()
+		states := g.failover.tracker.States()
+		g.failover.locker.Unlock()
+		for _, state := range states {
+			status.Interfaces = append(status.Interfaces, app.InterfaceHealth{
+				Interface: state.Interface,
+				Healthy:   state.Healthy,
+				LastCheck: state.LastCheck,
+				LastError: state.LastError,
+			})
+		}
+	}
+	return status
+}
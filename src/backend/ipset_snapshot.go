@@ -0,0 +1,204 @@
+package magitrickle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"magitrickle/utils/intID"
+	"magitrickle/utils/netfilterTools"
+
+	"github.com/rs/zerolog/log"
+)
+
+const ipsetSnapshotVersion = 1
+
+// ipsetSnapshot – адреса из DNS в наборах групп. Сроки хранятся абсолютными: таймаут
+// элемента уже включает AdditionalTTL и может быть длиннее записи кэша, поэтому
+// элементы восстанавливаются со своим оставшимся сроком, а не выводятся из кэша.
+type ipsetSnapshot struct {
+	Version int                  `json:"version"`
+	SavedAt time.Time            `json:"savedAt"`
+	Groups  []ipsetSnapshotGroup `json:"groups"`
+}
+
+type ipsetSnapshotGroup struct {
+	ID      intID.ID             `json:"id"`
+	Entries []ipsetSnapshotEntry `json:"entries"`
+}
+
+type ipsetSnapshotEntry struct {
+	Address  netip.Addr `json:"address"`
+	Deadline time.Time  `json:"deadline"`
+}
+
+// ipsetSnapshotPath возвращает путь снимка наборов рядом со снимком кэша; пусто – не сохраняется
+func (a *App) ipsetSnapshotPath() string {
+	path := a.config.RecordsCache.SnapshotPath
+	if path == "" {
+		return ""
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "-ipsets.json"
+}
+
+// saveIPSets сохраняет адреса из DNS в наборах включённых групп
+func (a *App) saveIPSets() {
+	path := a.ipsetSnapshotPath()
+	if path == "" {
+		return
+	}
+
+	now := time.Now()
+	snap := ipsetSnapshot{Version: ipsetSnapshotVersion, SavedAt: now}
+	for _, g := range a.ruleSetSnapshot() {
+		entries, err := g.snapshotEntries(now)
+		if err != nil {
+			log.Error().Err(err).Str("group", g.IDValue().String()).Msg("failed to list group ipset for snapshot")
+			continue
+		}
+		if len(entries) != 0 {
+			snap.Groups = append(snap.Groups, ipsetSnapshotGroup{ID: g.IDValue(), Entries: entries})
+		}
+	}
+	if err := writeIPSetSnapshot(path, snap); err != nil {
+		log.Error().Err(err).Str("path", path).Msg("failed to save ipset snapshot")
+	}
+}
+
+// loadIPSets читает снимок наборов; испорченный или устаревший снимок удаляется
+func (a *App) loadIPSets() map[intID.ID][]ipsetSnapshotEntry {
+	path := a.ipsetSnapshotPath()
+	if path == "" {
+		return nil
+	}
+
+	groups, err := readIPSetSnapshot(path, time.Now())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		log.Warn().Err(err).Str("path", path).Msg("ipset snapshot discarded")
+		_ = os.Remove(path)
+	default:
+		log.Info().Int("groups", len(groups)).Msg("ipset snapshot loaded")
+	}
+	return groups
+}
+
+func writeIPSetSnapshot(path string, snap ipsetSnapshot) error {
+	out, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create snapshot folder: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0600); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// readIPSetSnapshot возвращает элементы групп из снимка. Снимок другой версии или
+// сделанный «в будущем» отбрасывается: оставшийся срок элементов по нему не вычислить.
+func readIPSetSnapshot(path string, now time.Time) (map[intID.ID][]ipsetSnapshotEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap ipsetSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	if snap.Version != ipsetSnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	if snap.SavedAt.After(now) {
+		return nil, fmt.Errorf("snapshot saved at %s, clock is %s", snap.SavedAt.Format(time.RFC3339), now.Format(time.RFC3339))
+	}
+
+	groups := make(map[intID.ID][]ipsetSnapshotEntry, len(snap.Groups))
+	for _, group := range snap.Groups {
+		groups[group.ID] = append(groups[group.ID], group.Entries...)
+	}
+	return groups, nil
+}
+
+// snapshotEntries возвращает адреса из DNS в наборе группы со сроками их таймаутов
+func (g *RuleSet) snapshotEntries(now time.Time) ([]ipsetSnapshotEntry, error) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	if !g.Enabled() || !g.ConfiguredEnabled() || g.ipset == nil {
+		return nil, nil
+	}
+
+	list4, err := g.listIPv4Subnets()
+	if err != nil {
+		return nil, err
+	}
+	list6, err := g.listIPv6Subnets()
+	if err != nil {
+		return nil, err
+	}
+	return append(dynamicEntries(list4, now), dynamicEntries(list6, now)...), nil
+}
+
+// dynamicEntries отбирает узлы с таймаутом: постоянные записи выводятся из правил группы
+func dynamicEntries[S interface {
+	comparable
+	Prefix() netip.Prefix
+}](list map[S]netfilterTools.IPSetTimeout, now time.Time) []ipsetSnapshotEntry {
+	var entries []ipsetSnapshotEntry
+	for subnet, timeout := range list {
+		prefix := subnet.Prefix()
+		if timeout == nil || *timeout == 0 || !prefix.IsSingleIP() {
+			continue
+		}
+		entries = append(entries, ipsetSnapshotEntry{
+			Address:  prefix.Addr(),
+			Deadline: now.Add(time.Duration(*timeout) * time.Second),
+		})
+	}
+	return entries
+}
+
+// restoreEntries добавляет в набор группы элементы из снимка с оставшимся сроком
+func (g *RuleSet) restoreEntries(entries []ipsetSnapshotEntry, now time.Time) error {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	if !g.Enabled() || !g.ConfiguredEnabled() {
+		return nil
+	}
+
+	ipv4, ipv6 := restoredSubnets(entries, now)
+	return errors.Join(g.ipset.AddIPv4Subnets(ipv4, nil), g.ipset.AddIPv6Subnets(ipv6, nil))
+}
+
+// restoredSubnets переводит неистёкшие элементы снимка в записи наборов
+func restoredSubnets(entries []ipsetSnapshotEntry, now time.Time) (map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout, map[netfilterTools.IPv6Subnet]netfilterTools.IPSetTimeout) {
+	ipv4 := make(map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout)
+	ipv6 := make(map[netfilterTools.IPv6Subnet]netfilterTools.IPSetTimeout)
+	for _, entry := range entries {
+		remaining := entry.Deadline.Sub(now).Seconds()
+		if remaining < 1 || !entry.Address.IsValid() {
+			continue
+		}
+		ttl := uint32(remaining)
+		addr := entry.Address.Unmap()
+		if addr.Is4() {
+			ipv4[netfilterTools.IPv4Subnet{Address: addr.As4()}] = &ttl
+		} else {
+			ipv6[netfilterTools.IPv6Subnet{Address: addr.As16()}] = &ttl
+		}
+	}
+	return ipv4, ipv6
+}
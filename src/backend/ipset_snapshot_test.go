@@ -0,0 +1,61 @@
+package magitrickle
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"magitrickle/utils/intID"
+	"magitrickle/utils/netfilterTools"
+)
+
+func TestIPSetSnapshotRoundTrip(t *testing.T) {
+	ttl := func(v uint32) netfilterTools.IPSetTimeout { return &v }
+	host4 := netfilterTools.IPv4Subnet{Address: [4]byte{10, 0, 0, 1}}
+	host6 := netfilterTools.IPv6Subnet{Address: netip.MustParseAddr("2001:db8::1").As16()}
+	saved := time.Now()
+
+	// Таймаут элемента включает AdditionalTTL и длиннее записи кэша
+	list4 := map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout{
+		host4:                           ttl(3600),
+		{Address: [4]byte{10, 0, 0, 2}}: nil,
+		{Address: [4]byte{10, 1, 0, 0}, CIDR: 16}: nil,
+	}
+	list6 := map[netfilterTools.IPv6Subnet]netfilterTools.IPSetTimeout{host6: ttl(600)}
+
+	id := intID.ID{1, 2, 3, 4}
+	path := filepath.Join(t.TempDir(), "records-cache-ipsets.json")
+	snap := ipsetSnapshot{Version: ipsetSnapshotVersion, SavedAt: saved, Groups: []ipsetSnapshotGroup{
+		{ID: id, Entries: append(dynamicEntries(list4, saved), dynamicEntries(list6, saved)...)},
+	}}
+	if err := writeIPSetSnapshot(path, snap); err != nil {
+		t.Fatalf("writeIPSetSnapshot failed: %v", err)
+	}
+
+	// Перезапуск через 100 секунд
+	restart := saved.Add(100 * time.Second)
+	groups, err := readIPSetSnapshot(path, restart)
+	if err != nil {
+		t.Fatalf("readIPSetSnapshot failed: %v", err)
+	}
+	ipv4, ipv6 := restoredSubnets(groups[id], restart)
+	if len(ipv4) != 1 || len(ipv6) != 1 {
+		t.Fatalf("restored %v and %v, want one dynamic host per family", ipv4, ipv6)
+	}
+	if timeout := ipv4[host4]; timeout == nil || *timeout != 3500 {
+		t.Errorf("ipv4 timeout = %v, want 3500", timeout)
+	}
+	if timeout := ipv6[host6]; timeout == nil || *timeout != 500 {
+		t.Errorf("ipv6 timeout = %v, want 500", timeout)
+	}
+
+	// Истёкшие элементы не восстанавливаются
+	if ipv4, ipv6 := restoredSubnets(groups[id], saved.Add(time.Hour)); len(ipv4) != 0 || len(ipv6) != 0 {
+		t.Errorf("expired entries restored: %v %v", ipv4, ipv6)
+	}
+	// Снимок «из будущего» отбрасывается
+	if _, err := readIPSetSnapshot(path, saved.Add(-time.Minute)); err == nil {
+		t.Error("snapshot from the future must be discarded")
+	}
+}
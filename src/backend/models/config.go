@@ -6,6 +6,8 @@ type AppConfig struct {
 	HTTPWeb           AppConfigHTTPWeb
 	DNSProxy          AppConfigDNSProxy
 	Netfilter         AppConfigNetfilter
+	ASN               AppConfigASN
+	RecordsCache      AppConfigRecordsCache
 	Link              []string
 	ShowAllInterfaces bool
 	LogLevel          string
@@ -44,11 +46,20 @@ type AppConfigDNSProxyServer struct {
 }
 
 type AppConfigNetfilter struct {
+	Backend             string
 	IPTables            AppConfigIPTables
 	IPSet               AppConfigIPSet
 	DisableIPv4         bool
 	DisableIPv6         bool
 	StartMarkTableIndex uint32
+	FwmarkMask          uint32
+	// OutputBypass – адреса, трафик роутера к которым не маркируется группами с RouteOutput.
+	// Транспорт туннелей с явным remote и пиров WireGuard в ядре пропускается автоматически;
+	// здесь перечисляются серверы OpenVPN, WireGuard в пространстве пользователя и прочих
+	// tun-интерфейсов, если их сокеты не помечены fwmark.
+	OutputBypass []string
+	// ReconcileInterval – период сверки правил и маршрутов с желаемым состоянием; 0 – выключена
+	ReconcileInterval time.Duration
 }
 
 type AppConfigIPTables struct {
@@ -59,3 +70,13 @@ type AppConfigIPSet struct {
 	TablePrefix   string
 	AdditionalTTL time.Duration
 }
+
+type AppConfigASN struct {
+	DatasetPath string
+}
+
+type AppConfigRecordsCache struct {
+	// SnapshotPath – файл снимка кэша DNS-записей; пусто – кэш не сохраняется
+	SnapshotPath     string
+	SnapshotInterval time.Duration
+}
@@ -1,14 +1,672 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
 	"magitrickle/utils/intID"
 )
 
+var (
+	ErrInvalidProtocol = errors.New("invalid protocol")
+	ErrInvalidPort     = errors.New("invalid port")
+	ErrInvalidSource   = errors.New("invalid source selector")
+	ErrInvalidFailover = errors.New("invalid failover settings")
+	ErrInvalidBalance  = errors.New("invalid balance settings")
+	ErrInvalidGateway  = errors.New("invalid gateway")
+	ErrInvalidIPSet    = errors.New("invalid ipset settings")
+	ErrInvalidProxy    = errors.New("invalid proxy target")
+	ErrInvalidMSSClamp = errors.New("invalid mss clamp")
+	ErrInvalidNAT      = errors.New("invalid nat mode")
+	ErrInvalidTag      = errors.New("invalid tag target")
+	ErrInvalidReject   = errors.New("invalid reject target")
+)
+
+// PortProtocols – протоколы, для которых допустимо указание порта назначения
+var PortProtocols = []string{"tcp", "udp", "sctp", "dccp"}
+
+// Protocols – все протоколы, допустимые в квалификаторах группы
+var Protocols = append([]string{"icmp"}, PortProtocols...)
+
 type Group struct {
 	ID        intID.ID `yaml:"id"`
 	Name      string   `yaml:"name"`
 	Color     string   `yaml:"color"`
 	Interface string   `yaml:"interface"`
 	Enable    bool     `yaml:"enable"`
-	Rules     []*Rule  `yaml:"rules"`
+	Protocols []string `yaml:"protocols,omitempty"`
+	DstPorts  []string `yaml:"dst_ports,omitempty"`
+	// Селекторы источника: группа применяется только к трафику подходящих клиентов
+	SrcAddresses  []string `yaml:"src_addresses,omitempty"`
+	SrcMACs       []string `yaml:"src_macs,omitempty"`
+	SrcInterfaces []string `yaml:"src_interfaces,omitempty"`
+	// Маршрутизировать также трафик самого роутера
+	RouteOutput bool `yaml:"route_output,omitempty"`
+	// Резервные интерфейсы в порядке приоритета; активный выбирается проверками работоспособности
+	FailoverInterfaces []string     `yaml:"failover_interfaces,omitempty"`
+	HealthCheck        *HealthCheck `yaml:"health_check,omitempty"`
+	// Режим использования дополнительных интерфейсов: резервирование или балансировка
+	Mode string `yaml:"mode,omitempty"`
+	// Веса интерфейсов при балансировке (по умолчанию 1)
+	InterfaceWeights map[string]uint32 `yaml:"interface_weights,omitempty"`
+	// Шлюзы (next-hop) основного интерфейса: не более одного IPv4 и одного IPv6.
+	// Без шлюза маршрут группы указывает только на интерфейс.
+	Gateways []string `yaml:"gateways,omitempty"`
+	// Onlink – шлюз доступен напрямую через интерфейс, даже если не входит в его подсети
+	Onlink bool `yaml:"onlink,omitempty"`
+	// Не удалять записи conntrack при изменении маршрутизации группы
+	DisableConntrackFlush bool `yaml:"disable_conntrack_flush,omitempty"`
+	// Размеры набора адресов группы (по умолчанию – значения ipset)
+	IPSet *IPSetSizing `yaml:"ipset,omitempty"`
+	// Ограничение MSS TCP-соединений: "pmtu" (по MTU маршрута) или размер в байтах
+	MSSClamp string `yaml:"mss_clamp,omitempty"`
+	// Трансляция адреса источника: "masquerade" (по умолчанию), "none"
+	// или "snat:<адрес>[,<адрес>]" – не более одного адреса на семейство
+	NAT   string  `yaml:"nat,omitempty"`
+	Rules []*Rule `yaml:"rules"`
+}
+
+// Цели-прокси: вместо интерфейса группа указывает "tproxy:<port>" или "redirect:<port>",
+// и трафик передаётся локальному прозрачному прокси
+const (
+	ProxyTProxy   = "tproxy"
+	ProxyRedirect = "redirect"
+)
+
+// Цели-метки: "mark:<значение>[/<маска>]" или "dscp:<класс>" только помечают трафик
+// для внешнего QoS/SQM, не меняя маршрутизацию
+const (
+	TagMark = "mark"
+	TagDSCP = "dscp"
+)
+
+// Псевдоинтерфейсы: blackhole молча отбрасывает трафик группы маршрутом blackhole,
+// reject отклоняет его, отвечая клиенту TCP RST или ICMP port unreachable
+const (
+	InterfaceBlackhole = "blackhole"
+	InterfaceReject    = "reject"
+)
+
+// dscpClasses – именованные классы DSCP (RFC 2474, RFC 2597, RFC 3246, RFC 5865)
+var dscpClasses = map[string]uint8{
+	"cs0": 0, "cs1": 8, "cs2": 16, "cs3": 24, "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+	"af11": 10, "af12": 12, "af13": 14,
+	"af21": 18, "af22": 20, "af23": 22,
+	"af31": 26, "af32": 28, "af33": 30,
+	"af41": 34, "af42": 36, "af43": 38,
+	"ef": 46, "va": 44, "le": 1,
+}
+
+// MSSClampPMTU – ограничение MSS по MTU маршрута
+const MSSClampPMTU = "pmtu"
+
+// Режимы трансляции адреса источника
+const (
+	NATMasquerade = "masquerade"
+	NATNone       = "none"
+	NATSNAT       = "snat"
+)
+
+// Пределы фиксированного MSS
+const (
+	MinMSS = 536
+	MaxMSS = 65495
+)
+
+// Пределы размеров набора адресов
+const (
+	MinIPSetMaxElem  = 64
+	MaxIPSetMaxElem  = 1 << 24
+	MinIPSetHashSize = 64
+	MaxIPSetHashSize = 1 << 24
+	// MaxIPSetTimeout – наибольший таймаут, принимаемый ядром (в секундах)
+	MaxIPSetTimeout = 2147483
+)
+
+// IPSetSizing – размеры набора адресов группы; нулевые значения – значения по умолчанию.
+// Переполненный набор увеличивается автоматически.
+type IPSetSizing struct {
+	MaxElem  uint32 `yaml:"max_elem,omitempty"`
+	HashSize uint32 `yaml:"hash_size,omitempty"`
+	// Timeout – время жизни элемента по умолчанию в секундах
+	Timeout uint32 `yaml:"timeout,omitempty"`
+}
+
+// Validate проверяет размеры набора: hashsize должен быть степенью двойки
+func (s *IPSetSizing) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.MaxElem != 0 && (s.MaxElem < MinIPSetMaxElem || s.MaxElem > MaxIPSetMaxElem) {
+		return fmt.Errorf("%w: max_elem must be in %d..%d", ErrInvalidIPSet, MinIPSetMaxElem, MaxIPSetMaxElem)
+	}
+	if s.HashSize != 0 && (s.HashSize < MinIPSetHashSize || s.HashSize > MaxIPSetHashSize || s.HashSize&(s.HashSize-1) != 0) {
+		return fmt.Errorf("%w: hash_size must be a power of two in %d..%d", ErrInvalidIPSet, MinIPSetHashSize, MaxIPSetHashSize)
+	}
+	if s.Timeout > MaxIPSetTimeout {
+		return fmt.Errorf("%w: timeout must not exceed %d", ErrInvalidIPSet, MaxIPSetTimeout)
+	}
+	return nil
+}
+
+// Режимы группы с несколькими интерфейсами
+const (
+	GroupModeFailover = "failover"
+	GroupModeBalance  = "balance"
+)
+
+// MaxInterfaceWeight – максимальный вес интерфейса при балансировке
+const MaxInterfaceWeight = 100
+
+// Типы проверок работоспособности интерфейса
+const (
+	HealthCheckLink     = "link"
+	HealthCheckICMP     = "icmp"
+	HealthCheckTCP      = "tcp"
+	HealthCheckHTTP     = "http"
+	HealthCheckNeighbor = "neighbor"
+)
+
+// HealthCheck – проверка работоспособности интерфейсов группы.
+// Target: адрес для icmp и neighbor, host:port для tcp, URL для http; для link не используется.
+// Для neighbor без Target проверяется шлюз группы.
+type HealthCheck struct {
+	Type   string `yaml:"type"`
+	Target string `yaml:"target,omitempty"`
+	// Interval и Timeout в секундах
+	Interval uint32 `yaml:"interval,omitempty"`
+	Timeout  uint32 `yaml:"timeout,omitempty"`
+	// Число неудачных (успешных) проверок подряд для смены состояния интерфейса
+	FailThreshold int `yaml:"fail_threshold,omitempty"`
+	RiseThreshold int `yaml:"rise_threshold,omitempty"`
+}
+
+// WithDefaults возвращает копию проверки с заполненными значениями по умолчанию;
+// без проверки интерфейсы оцениваются только по состоянию линка
+func (c *HealthCheck) WithDefaults() HealthCheck {
+	var out HealthCheck
+	if c != nil {
+		out = *c
+	}
+	if out.Type == "" {
+		out.Type = HealthCheckLink
+	}
+	if out.Interval == 0 {
+		out.Interval = 10
+	}
+	if out.Timeout == 0 {
+		out.Timeout = 3
+	}
+	if out.FailThreshold == 0 {
+		out.FailThreshold = 3
+	}
+	if out.RiseThreshold == 0 {
+		out.RiseThreshold = 2
+	}
+	return out
+}
+
+// Validate проверяет параметры проверки работоспособности
+func (c *HealthCheck) Validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Type {
+	case "", HealthCheckLink:
+	case HealthCheckICMP:
+		if _, err := netip.ParseAddr(c.Target); err != nil {
+			return fmt.Errorf("%w: icmp target %q must be an ip address", ErrInvalidFailover, c.Target)
+		}
+	case HealthCheckNeighbor:
+		if _, err := netip.ParseAddr(c.Target); c.Target != "" && err != nil {
+			return fmt.Errorf("%w: neighbor target %q must be an ip address", ErrInvalidFailover, c.Target)
+		}
+	case HealthCheckTCP:
+		if _, port, err := net.SplitHostPort(c.Target); err != nil || port == "" {
+			return fmt.Errorf("%w: tcp target %q must be host:port", ErrInvalidFailover, c.Target)
+		}
+	case HealthCheckHTTP:
+		u, err := url.Parse(c.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: http target %q must be an http(s) url", ErrInvalidFailover, c.Target)
+		}
+	default:
+		return fmt.Errorf("%w: unknown health check type %q", ErrInvalidFailover, c.Type)
+	}
+	if c.FailThreshold < 0 || c.RiseThreshold < 0 {
+		return fmt.Errorf("%w: thresholds must not be negative", ErrInvalidFailover)
+	}
+	return nil
+}
+
+// Interfaces возвращает основной и резервные интерфейсы в порядке приоритета
+func (g *Group) Interfaces() []string {
+	return append([]string{g.Interface}, g.FailoverInterfaces...)
+}
+
+// Balancing сообщает, распределяет ли группа новые соединения между интерфейсами
+func (g *Group) Balancing() bool {
+	return g.Mode == GroupModeBalance
+}
+
+// Validate проверяет дополнительные параметры группы
+func (g *Group) Validate() error {
+	if err := ValidateProtocols(g.Protocols, len(g.DstPorts) > 0); err != nil {
+		return err
+	}
+	if err := ValidatePorts(g.DstPorts); err != nil {
+		return err
+	}
+	if err := ValidateSources(g.SrcAddresses, g.SrcMACs, g.SrcInterfaces); err != nil {
+		return err
+	}
+	if err := ValidateFailover(g.Interface, g.FailoverInterfaces, g.HealthCheck); err != nil {
+		return err
+	}
+	if err := ValidateBalance(g.Mode, g.Interfaces(), g.InterfaceWeights); err != nil {
+		return err
+	}
+	if err := ValidateGateways(g.Interface, g.Gateways, g.Mode, g.HealthCheck); err != nil {
+		return err
+	}
+	if err := g.IPSet.Validate(); err != nil {
+		return err
+	}
+	if err := ValidateProxy(g.Interface, g.Protocols, g.FailoverInterfaces, g.Gateways, g.HealthCheck, g.RouteOutput); err != nil {
+		return err
+	}
+	if err := ValidateMSSClamp(g.MSSClamp, g.Interface, g.Protocols); err != nil {
+		return err
+	}
+	if err := ValidateNAT(g.NAT, g.Interface); err != nil {
+		return err
+	}
+	if err := ValidateTag(g.Interface, g.FailoverInterfaces, g.Gateways, g.HealthCheck); err != nil {
+		return err
+	}
+	if err := ValidateReject(g.Interface, g.FailoverInterfaces, g.HealthCheck); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseNAT разбирает режим трансляции адреса источника; пустая строка – маскарадинг.
+// Для "snat" возвращаются адреса IPv4 и IPv6; семейство без адреса маскарадится.
+func ParseNAT(value string) (mode string, snat4, snat6 netip.Addr, err error) {
+	switch value {
+	case "", NATMasquerade:
+		return NATMasquerade, netip.Addr{}, netip.Addr{}, nil
+	case NATNone:
+		return NATNone, netip.Addr{}, netip.Addr{}, nil
+	}
+	mode, list, ok := strings.Cut(value, ":")
+	if !ok || mode != NATSNAT {
+		return "", netip.Addr{}, netip.Addr{}, fmt.Errorf("%w: %q is not one of %s, %s, %s:<address>", ErrInvalidNAT, value, NATMasquerade, NATNone, NATSNAT)
+	}
+	for _, item := range strings.Split(list, ",") {
+		addr, err := netip.ParseAddr(strings.TrimSpace(item))
+		if err != nil || addr.Zone() != "" || addr.IsUnspecified() {
+			return "", netip.Addr{}, netip.Addr{}, fmt.Errorf("%w: snat address %q", ErrInvalidNAT, item)
+		}
+		addr = addr.Unmap()
+		target := &snat6
+		if addr.Is4() {
+			target = &snat4
+		}
+		if target.IsValid() {
+			return "", netip.Addr{}, netip.Addr{}, fmt.Errorf("%w: more than one snat address per family", ErrInvalidNAT)
+		}
+		*target = addr
+	}
+	return NATSNAT, snat4, snat6, nil
+}
+
+// ValidateNAT проверяет режим трансляции адреса: прокси не проходит через nat POSTROUTING,
+// поэтому для него допустим только режим по умолчанию
+func ValidateNAT(value, iface string) error {
+	mode, _, _, err := ParseNAT(value)
+	if err != nil {
+		return err
+	}
+	if target := targetMode(iface); target != "" && mode != NATMasquerade {
+		return fmt.Errorf("%w: not supported by %s", ErrInvalidNAT, target)
+	}
+	return nil
+}
+
+// ParseMSSClamp разбирает ограничение MSS: "pmtu" или размер в байтах.
+// Для пустой строки enabled ложно; для "pmtu" size равен 0.
+func ParseMSSClamp(value string) (enabled bool, size uint16, err error) {
+	switch value {
+	case "":
+		return false, 0, nil
+	case MSSClampPMTU:
+		return true, 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 16)
+	if err != nil || n < MinMSS || n > MaxMSS {
+		return false, 0, fmt.Errorf("%w: %q is neither %q nor a size in %d..%d", ErrInvalidMSSClamp, value, MSSClampPMTU, MinMSS, MaxMSS)
+	}
+	return true, uint16(n), nil
+}
+
+// ValidateMSSClamp проверяет ограничение MSS: группа должна пропускать TCP
+// и направлять трафик в интерфейс, а не прокси
+func ValidateMSSClamp(value, iface string, protocols []string) error {
+	enabled, _, err := ParseMSSClamp(value)
+	if err != nil || !enabled {
+		return err
+	}
+	if mode := targetMode(iface); mode != "" {
+		return fmt.Errorf("%w: not supported by %s", ErrInvalidMSSClamp, mode)
+	}
+	if len(protocols) > 0 && !slices.Contains(protocols, "tcp") {
+		return fmt.Errorf("%w: group does not route tcp", ErrInvalidMSSClamp)
+	}
+	return nil
+}
+
+// ParseProxyTarget разбирает интерфейс вида "tproxy:<port>" или "redirect:<port>";
+// для обычного интерфейса возвращает пустой режим
+func ParseProxyTarget(iface string) (mode string, port uint16, err error) {
+	mode, portStr, ok := strings.Cut(iface, ":")
+	if !ok || (mode != ProxyTProxy && mode != ProxyRedirect) {
+		return "", 0, nil
+	}
+	p, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || p == 0 {
+		return "", 0, fmt.Errorf("%w: port %q", ErrInvalidProxy, portStr)
+	}
+	return mode, uint16(p), nil
+}
+
+// ParseTagTarget разбирает интерфейс вида "mark:<значение>[/<маска>]" или "dscp:<класс>";
+// для обычного интерфейса возвращает пустой режим. Без маски метка заменяется целиком,
+// класс DSCP задаётся именем (ef, af41, cs1) или числом 0..63.
+func ParseTagTarget(iface string) (mode string, mark, mask uint32, dscp uint8, err error) {
+	mode, value, ok := strings.Cut(iface, ":")
+	switch {
+	case !ok:
+		return "", 0, 0, 0, nil
+	case mode == TagMark:
+		markStr, maskStr, hasMask := strings.Cut(value, "/")
+		m, err := strconv.ParseUint(markStr, 0, 32)
+		if err != nil {
+			return "", 0, 0, 0, fmt.Errorf("%w: mark %q", ErrInvalidTag, markStr)
+		}
+		mask := uint64(0xffffffff)
+		if hasMask {
+			mask, err = strconv.ParseUint(maskStr, 0, 32)
+			if err != nil || mask == 0 {
+				return "", 0, 0, 0, fmt.Errorf("%w: mask %q", ErrInvalidTag, maskStr)
+			}
+		}
+		if m&^mask != 0 {
+			return "", 0, 0, 0, fmt.Errorf("%w: mark %#x is outside of mask %#x", ErrInvalidTag, m, mask)
+		}
+		return TagMark, uint32(m), uint32(mask), 0, nil
+	case mode == TagDSCP:
+		if class, ok := dscpClasses[strings.ToLower(value)]; ok {
+			return TagDSCP, 0, 0, class, nil
+		}
+		d, err := strconv.ParseUint(value, 0, 8)
+		if err != nil || d > 63 {
+			return "", 0, 0, 0, fmt.Errorf("%w: dscp class %q", ErrInvalidTag, value)
+		}
+		return TagDSCP, 0, 0, uint8(d), nil
+	}
+	return "", 0, 0, 0, nil
+}
+
+// ValidateTag проверяет цель-метку: маршрутизация не меняется,
+// поэтому резервные интерфейсы, шлюзы и проверки работоспособности неприменимы
+func ValidateTag(iface string, failover, gateways []string, check *HealthCheck) error {
+	mode, _, _, _, err := ParseTagTarget(iface)
+	if err != nil || mode == "" {
+		return err
+	}
+	switch {
+	case len(failover) > 0:
+		return fmt.Errorf("%w: failover interfaces are not supported", ErrInvalidTag)
+	case len(gateways) > 0:
+		return fmt.Errorf("%w: gateways are not supported", ErrInvalidTag)
+	case check != nil:
+		return fmt.Errorf("%w: health checks are not supported", ErrInvalidTag)
+	}
+	return nil
+}
+
+// ValidateReject проверяет цель reject: трафик отклоняется, а не маршрутизируется,
+// поэтому резервные интерфейсы и проверки работоспособности неприменимы
+func ValidateReject(iface string, failover []string, check *HealthCheck) error {
+	if iface != InterfaceReject {
+		return nil
+	}
+	switch {
+	case len(failover) > 0:
+		return fmt.Errorf("%w: failover interfaces are not supported", ErrInvalidReject)
+	case check != nil:
+		return fmt.Errorf("%w: health checks are not supported", ErrInvalidReject)
+	}
+	return nil
+}
+
+// isPseudoIface сообщает, что интерфейс не является одним сетевым устройством:
+// псевдоинтерфейс blackhole/reject или шаблон с суффиксом "+"
+func isPseudoIface(iface string) bool {
+	return iface == InterfaceBlackhole || iface == InterfaceReject || strings.HasSuffix(iface, "+")
+}
+
+// targetMode возвращает режим цели-прокси, цели-метки или reject; пусто для обычного интерфейса
+func targetMode(iface string) string {
+	if iface == InterfaceReject {
+		return InterfaceReject
+	}
+	if mode, _, _ := ParseProxyTarget(iface); mode != "" {
+		return mode
+	}
+	mode, _, _, _, _ := ParseTagTarget(iface)
+	return mode
+}
+
+// ValidateProxy проверяет цель-прокси: прокси принимает только tcp и udp, а резервные
+// интерфейсы, шлюзы, проверки работоспособности и трафик роутера к нему неприменимы
+func ValidateProxy(iface string, protocols, failover, gateways []string, check *HealthCheck, routeOutput bool) error {
+	mode, _, err := ParseProxyTarget(iface)
+	if err != nil || mode == "" {
+		return err
+	}
+	for _, protocol := range protocols {
+		if protocol != "tcp" && protocol != "udp" {
+			return fmt.Errorf("%w: protocol %q is not supported by %s", ErrInvalidProxy, protocol, mode)
+		}
+	}
+	switch {
+	case len(failover) > 0:
+		return fmt.Errorf("%w: failover interfaces are not supported", ErrInvalidProxy)
+	case len(gateways) > 0:
+		return fmt.Errorf("%w: gateways are not supported", ErrInvalidProxy)
+	case check != nil:
+		return fmt.Errorf("%w: health checks are not supported", ErrInvalidProxy)
+	case routeOutput:
+		return fmt.Errorf("%w: routing router traffic is not supported", ErrInvalidProxy)
+	}
+	return nil
+}
+
+// ValidateGateways проверяет шлюзы группы: адреса, не более одного на семейство,
+// и совместимость с интерфейсом, режимом и проверкой работоспособности
+func ValidateGateways(iface string, gateways []string, mode string, check *HealthCheck) error {
+	if len(gateways) == 0 {
+		if check != nil && check.Type == HealthCheckNeighbor && check.Target == "" {
+			return fmt.Errorf("%w: neighbor check requires a target or a gateway", ErrInvalidFailover)
+		}
+		return nil
+	}
+	if isPseudoIface(iface) {
+		return fmt.Errorf("%w: interface %q can not have a gateway", ErrInvalidGateway, iface)
+	}
+	if mode == GroupModeBalance {
+		return fmt.Errorf("%w: gateways are not supported in %q mode", ErrInvalidGateway, GroupModeBalance)
+	}
+	var has4, has6 bool
+	for _, gateway := range gateways {
+		addr, err := netip.ParseAddr(gateway)
+		if err != nil || addr.Zone() != "" || addr.IsUnspecified() || addr.IsLoopback() || addr.IsMulticast() {
+			return fmt.Errorf("%w: %q", ErrInvalidGateway, gateway)
+		}
+		family := &has6
+		if addr.Unmap().Is4() {
+			family = &has4
+		}
+		if *family {
+			return fmt.Errorf("%w: more than one gateway of the same family", ErrInvalidGateway)
+		}
+		*family = true
+	}
+	return nil
+}
+
+// ValidateBalance проверяет режим группы и веса интерфейсов (ifaces – основной и дополнительные)
+func ValidateBalance(mode string, ifaces []string, weights map[string]uint32) error {
+	switch mode {
+	case "", GroupModeFailover:
+		if len(weights) > 0 {
+			return fmt.Errorf("%w: weights require %q mode", ErrInvalidBalance, GroupModeBalance)
+		}
+		return nil
+	case GroupModeBalance:
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidBalance, mode)
+	}
+	if len(ifaces) < 2 {
+		return fmt.Errorf("%w: at least two interfaces are required", ErrInvalidBalance)
+	}
+	if isPseudoIface(ifaces[0]) {
+		return fmt.Errorf("%w: interface %q", ErrInvalidBalance, ifaces[0])
+	}
+	for iface, weight := range weights {
+		if !slices.Contains(ifaces, iface) {
+			return fmt.Errorf("%w: weight for unknown interface %q", ErrInvalidBalance, iface)
+		}
+		if weight == 0 || weight > MaxInterfaceWeight {
+			return fmt.Errorf("%w: weight of %q must be in 1..%d", ErrInvalidBalance, iface, MaxInterfaceWeight)
+		}
+	}
+	return nil
+}
+
+// ValidateFailover проверяет список резервных интерфейсов и проверку их работоспособности
+func ValidateFailover(primary string, failover []string, check *HealthCheck) error {
+	seen := map[string]struct{}{primary: {}}
+	for _, iface := range failover {
+		if isPseudoIface(iface) || !isValidIfaceName(iface) {
+			return fmt.Errorf("%w: interface %q", ErrInvalidFailover, iface)
+		}
+		if _, exists := seen[iface]; exists {
+			return fmt.Errorf("%w: duplicate interface %q", ErrInvalidFailover, iface)
+		}
+		seen[iface] = struct{}{}
+	}
+	return check.Validate()
+}
+
+// ValidateSources проверяет селекторы источника: адреса/подсети, MAC-адреса и имена входящих интерфейсов
+func ValidateSources(addresses, macs, ifaces []string) error {
+	for _, address := range addresses {
+		if _, err := netip.ParsePrefix(address); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(address); err != nil {
+			return fmt.Errorf("%w: address %q", ErrInvalidSource, address)
+		}
+	}
+	for _, mac := range macs {
+		hw, err := net.ParseMAC(mac)
+		if err != nil || len(hw) != 6 {
+			return fmt.Errorf("%w: mac %q", ErrInvalidSource, mac)
+		}
+	}
+	for _, iface := range ifaces {
+		if !isValidIfaceName(iface) {
+			return fmt.Errorf("%w: interface %q", ErrInvalidSource, iface)
+		}
+	}
+	return nil
+}
+
+// isValidIfaceName проверяет имя интерфейса в формате iptables (допускается суффикс "+")
+func isValidIfaceName(name string) bool {
+	base := strings.TrimSuffix(name, "+")
+	if base == "" || len(name) > 15 || base == "." || base == ".." {
+		return false
+	}
+	for _, r := range base {
+		if r <= ' ' || r == '/' || r == '+' || r == '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateProtocols проверяет список протоколов. Если withPorts – разрешены только протоколы с портами.
+func ValidateProtocols(protocols []string, withPorts bool) error {
+	allowed := Protocols
+	if withPorts {
+		allowed = PortProtocols
+	}
+	seen := make(map[string]struct{}, len(protocols))
+	for _, protocol := range protocols {
+		found := false
+		for _, p := range allowed {
+			if p == protocol {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if withPorts {
+				return fmt.Errorf("%w: %q (destination ports require one of %s)", ErrInvalidProtocol, protocol, strings.Join(PortProtocols, ", "))
+			}
+			return fmt.Errorf("%w: %q", ErrInvalidProtocol, protocol)
+		}
+		if _, exists := seen[protocol]; exists {
+			return fmt.Errorf("%w: duplicate %q", ErrInvalidProtocol, protocol)
+		}
+		seen[protocol] = struct{}{}
+	}
+	return nil
+}
+
+// ValidatePorts проверяет список портов назначения: "443" или диапазон "8000-8080"
+func ValidatePorts(ports []string) error {
+	for _, port := range ports {
+		if _, _, err := ParsePortRange(port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParsePortRange разбирает порт или диапазон портов
+func ParsePortRange(s string) (from, to uint16, err error) {
+	fromStr, toStr, isRange := strings.Cut(s, "-")
+	fromVal, err := strconv.ParseUint(fromStr, 10, 16)
+	if err != nil || fromVal == 0 {
+		return 0, 0, fmt.Errorf("%w: %q", ErrInvalidPort, s)
+	}
+	if !isRange {
+		return uint16(fromVal), uint16(fromVal), nil
+	}
+	toVal, err := strconv.ParseUint(toStr, 10, 16)
+	if err != nil || toVal < fromVal {
+		return 0, 0, fmt.Errorf("%w: %q", ErrInvalidPort, s)
+	}
+	return uint16(fromVal), uint16(toVal), nil
 }
@@ -0,0 +1,388 @@
+package models
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		input    string
+		from, to uint16
+		wantErr  bool
+	}{
+		{"443", 443, 443, false},
+		{"8000-8080", 8000, 8080, false},
+		{"1-65535", 1, 65535, false},
+		{"0", 0, 0, true},
+		{"65536", 0, 0, true},
+		{"8080-8000", 0, 0, true},
+		{"80-", 0, 0, true},
+		{"http", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, tt := range tests {
+		from, to, err := ParsePortRange(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePortRange(%q) err = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if from != tt.from || to != tt.to {
+			t.Errorf("ParsePortRange(%q) = %d-%d, want %d-%d", tt.input, from, to, tt.from, tt.to)
+		}
+	}
+}
+
+func TestGroupValidateQualifiers(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols []string
+		ports     []string
+		wantErr   error
+	}{
+		{"empty", nil, nil, nil},
+		{"ports only", nil, []string{"443"}, nil},
+		{"tcp udp 443", []string{"tcp", "udp"}, []string{"443", "8443-8444"}, nil},
+		{"icmp without ports", []string{"icmp"}, nil, nil},
+		{"icmp with ports", []string{"icmp"}, []string{"443"}, ErrInvalidProtocol},
+		{"unknown protocol", []string{"ssh"}, nil, ErrInvalidProtocol},
+		{"duplicate protocol", []string{"tcp", "tcp"}, nil, ErrInvalidProtocol},
+		{"bad port", []string{"tcp"}, []string{"99999"}, ErrInvalidPort},
+	}
+	for _, tt := range tests {
+		g := &Group{Protocols: tt.protocols, DstPorts: tt.ports}
+		err := g.Validate()
+		if tt.wantErr == nil && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGroupValidateSources(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses []string
+		macs      []string
+		ifaces    []string
+		wantErr   bool
+	}{
+		{"empty", nil, nil, nil, false},
+		{"valid", []string{"192.168.1.10", "192.168.2.0/24", "fd00::/64"}, []string{"aa:bb:cc:dd:ee:ff"}, []string{"br0", "ppp+"}, false},
+		{"bad address", []string{"192.168.1.300"}, nil, nil, true},
+		{"bad cidr", []string{"10.0.0.0/33"}, nil, nil, true},
+		{"bad mac", nil, []string{"aa:bb:cc"}, nil, true},
+		{"eui64 mac", nil, []string{"00:00:00:00:fe:80:00:00"}, nil, true},
+		{"empty iface", nil, nil, []string{""}, true},
+		{"long iface", nil, nil, []string{"averyveryverylongname"}, true},
+		{"iface with space", nil, nil, []string{"br 0"}, true},
+		{"only plus", nil, nil, []string{"+"}, true},
+	}
+	for _, tt := range tests {
+		g := &Group{SrcAddresses: tt.addresses, SrcMACs: tt.macs, SrcInterfaces: tt.ifaces}
+		err := g.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidSource) {
+			t.Errorf("%s: error = %v, want ErrInvalidSource", tt.name, err)
+		}
+	}
+}
+
+func TestGroupValidateFailover(t *testing.T) {
+	tests := []struct {
+		name     string
+		failover []string
+		check    *HealthCheck
+		wantErr  bool
+	}{
+		{"empty", nil, nil, false},
+		{"link only", []string{"nwg1"}, nil, false},
+		{"icmp", []string{"nwg1", "wg0"}, &HealthCheck{Type: HealthCheckICMP, Target: "1.1.1.1"}, false},
+		{"tcp", []string{"nwg1"}, &HealthCheck{Type: HealthCheckTCP, Target: "example.com:443"}, false},
+		{"http", []string{"nwg1"}, &HealthCheck{Type: HealthCheckHTTP, Target: "https://example.com/generate_204"}, false},
+		{"duplicate primary", []string{"nwg0"}, nil, true},
+		{"duplicate failover", []string{"nwg1", "nwg1"}, nil, true},
+		{"blackhole", []string{"blackhole"}, nil, true},
+		{"wildcard", []string{"wg+"}, nil, true},
+		{"icmp hostname", []string{"nwg1"}, &HealthCheck{Type: HealthCheckICMP, Target: "example.com"}, true},
+		{"tcp without port", []string{"nwg1"}, &HealthCheck{Type: HealthCheckTCP, Target: "example.com"}, true},
+		{"http without scheme", []string{"nwg1"}, &HealthCheck{Type: HealthCheckHTTP, Target: "example.com"}, true},
+		{"unknown type", []string{"nwg1"}, &HealthCheck{Type: "dns"}, true},
+	}
+	for _, tt := range tests {
+		g := &Group{Interface: "nwg0", FailoverInterfaces: tt.failover, HealthCheck: tt.check}
+		err := g.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidFailover) {
+			t.Errorf("%s: error = %v, want ErrInvalidFailover", tt.name, err)
+		}
+	}
+}
+
+func TestGroupValidateBalance(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		extra   []string
+		weights map[string]uint32
+		wantErr bool
+	}{
+		{"failover", GroupModeFailover, []string{"nwg1"}, nil, false},
+		{"balance", GroupModeBalance, []string{"nwg1"}, nil, false},
+		{"weights", GroupModeBalance, []string{"nwg1"}, map[string]uint32{"nwg0": 3, "nwg1": 1}, false},
+		{"single interface", GroupModeBalance, nil, nil, true},
+		{"unknown mode", "random", []string{"nwg1"}, nil, true},
+		{"weights without balance", "", []string{"nwg1"}, map[string]uint32{"nwg0": 2}, true},
+		{"weight of unknown interface", GroupModeBalance, []string{"nwg1"}, map[string]uint32{"nwg2": 2}, true},
+		{"zero weight", GroupModeBalance, []string{"nwg1"}, map[string]uint32{"nwg1": 0}, true},
+		{"too large weight", GroupModeBalance, []string{"nwg1"}, map[string]uint32{"nwg1": MaxInterfaceWeight + 1}, true},
+	}
+	for _, tt := range tests {
+		g := &Group{Interface: "nwg0", FailoverInterfaces: tt.extra, Mode: tt.mode, InterfaceWeights: tt.weights}
+		err := g.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidBalance) {
+			t.Errorf("%s: error = %v, want ErrInvalidBalance", tt.name, err)
+		}
+	}
+
+	g := &Group{Interface: "blackhole", FailoverInterfaces: []string{"nwg1"}, Mode: GroupModeBalance}
+	if err := g.Validate(); !errors.Is(err, ErrInvalidBalance) {
+		t.Errorf("blackhole: error = %v, want ErrInvalidBalance", err)
+	}
+}
+
+func TestGroupValidateGateways(t *testing.T) {
+	tests := []struct {
+		name     string
+		iface    string
+		gateways []string
+		mode     string
+		check    *HealthCheck
+		wantErr  error
+	}{
+		{"none", "br0", nil, "", nil, nil},
+		{"ipv4", "br0", []string{"192.168.1.2"}, "", nil, nil},
+		{"both families", "br0", []string{"192.168.1.2", "fe80::2"}, "", nil, nil},
+		{"neighbor check of gateway", "br0", []string{"192.168.1.2"}, "", &HealthCheck{Type: HealthCheckNeighbor}, nil},
+		{"two ipv4", "br0", []string{"192.168.1.2", "192.168.1.3"}, "", nil, ErrInvalidGateway},
+		{"hostname", "br0", []string{"router.lan"}, "", nil, ErrInvalidGateway},
+		{"zone", "br0", []string{"fe80::2%br0"}, "", nil, ErrInvalidGateway},
+		{"unspecified", "br0", []string{"0.0.0.0"}, "", nil, ErrInvalidGateway},
+		{"blackhole", "blackhole", []string{"192.168.1.2"}, "", nil, ErrInvalidGateway},
+		{"neighbor check without target", "br0", nil, "", &HealthCheck{Type: HealthCheckNeighbor}, ErrInvalidFailover},
+	}
+	for _, tt := range tests {
+		g := &Group{Interface: tt.iface, Gateways: tt.gateways, Mode: tt.mode, HealthCheck: tt.check}
+		err := g.Validate()
+		if (err != nil) != (tt.wantErr != nil) || (err != nil && !errors.Is(err, tt.wantErr)) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	g := &Group{Interface: "br0", FailoverInterfaces: []string{"nwg0"}, Gateways: []string{"192.168.1.2"}, Mode: GroupModeBalance}
+	if err := g.Validate(); !errors.Is(err, ErrInvalidGateway) {
+		t.Errorf("balance: error = %v, want ErrInvalidGateway", err)
+	}
+}
+
+func TestIPSetSizingValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		sizing  *IPSetSizing
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"defaults", &IPSetSizing{}, false},
+		{"valid", &IPSetSizing{MaxElem: 262144, HashSize: 4096, Timeout: 600}, false},
+		{"small maxelem", &IPSetSizing{MaxElem: 10}, true},
+		{"huge maxelem", &IPSetSizing{MaxElem: MaxIPSetMaxElem + 1}, true},
+		{"hashsize not power of two", &IPSetSizing{HashSize: 1000}, true},
+		{"small hashsize", &IPSetSizing{HashSize: 32}, true},
+		{"huge timeout", &IPSetSizing{Timeout: MaxIPSetTimeout + 1}, true},
+	}
+	for _, tt := range tests {
+		g := &Group{IPSet: tt.sizing}
+		err := g.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidIPSet) {
+			t.Errorf("%s: error = %v, want ErrInvalidIPSet", tt.name, err)
+		}
+	}
+}
+
+func TestGroupValidateProxy(t *testing.T) {
+	tests := []struct {
+		name  string
+		group Group
+		mode  string
+		port  uint16
+		err   bool
+	}{
+		{"interface", Group{Interface: "nwg0"}, "", 0, false},
+		{"tproxy", Group{Interface: "tproxy:12345"}, ProxyTProxy, 12345, false},
+		{"redirect tcp", Group{Interface: "redirect:1080", Protocols: []string{"tcp"}}, ProxyRedirect, 1080, false},
+		{"zero port", Group{Interface: "tproxy:0"}, "", 0, true},
+		{"bad port", Group{Interface: "redirect:http"}, "", 0, true},
+		{"icmp", Group{Interface: "tproxy:12345", Protocols: []string{"icmp"}}, ProxyTProxy, 12345, true},
+		{"failover", Group{Interface: "tproxy:12345", FailoverInterfaces: []string{"nwg1"}}, ProxyTProxy, 12345, true},
+		{"gateway", Group{Interface: "tproxy:12345", Gateways: []string{"192.168.1.2"}}, ProxyTProxy, 12345, true},
+		{"route output", Group{Interface: "redirect:1080", RouteOutput: true}, ProxyRedirect, 1080, true},
+	}
+	for _, tt := range tests {
+		mode, port, _ := ParseProxyTarget(tt.group.Interface)
+		if mode != tt.mode || port != tt.port {
+			t.Errorf("%s: ParseProxyTarget() = %q, %d, want %q, %d", tt.name, mode, port, tt.mode, tt.port)
+		}
+		err := tt.group.Validate()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidProxy) {
+			t.Errorf("%s: error = %v, want ErrInvalidProxy", tt.name, err)
+		}
+	}
+}
+
+func TestGroupValidateMSSClamp(t *testing.T) {
+	tests := []struct {
+		name  string
+		group Group
+		size  uint16
+		err   bool
+	}{
+		{"none", Group{Interface: "nwg0"}, 0, false},
+		{"pmtu", Group{Interface: "nwg0", MSSClamp: "pmtu"}, 0, false},
+		{"fixed", Group{Interface: "nwg0", MSSClamp: "1360"}, 1360, false},
+		{"too small", Group{Interface: "nwg0", MSSClamp: "100"}, 0, true},
+		{"garbage", Group{Interface: "nwg0", MSSClamp: "auto"}, 0, true},
+		{"udp only", Group{Interface: "nwg0", MSSClamp: "pmtu", Protocols: []string{"udp"}}, 0, true},
+		{"proxy", Group{Interface: "tproxy:12345", MSSClamp: "pmtu"}, 0, true},
+	}
+	for _, tt := range tests {
+		if _, size, _ := ParseMSSClamp(tt.group.MSSClamp); size != tt.size {
+			t.Errorf("%s: ParseMSSClamp() size = %d, want %d", tt.name, size, tt.size)
+		}
+		err := tt.group.Validate()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidMSSClamp) {
+			t.Errorf("%s: error = %v, want ErrInvalidMSSClamp", tt.name, err)
+		}
+	}
+}
+
+func TestGroupValidateNAT(t *testing.T) {
+	tests := []struct {
+		name  string
+		group Group
+		mode  string
+		snat4 string
+		snat6 string
+		err   bool
+	}{
+		{"default", Group{Interface: "nwg0"}, NATMasquerade, "", "", false},
+		{"none", Group{Interface: "nwg0", NAT: "none"}, NATNone, "", "", false},
+		{"snat4", Group{Interface: "nwg0", NAT: "snat:10.0.0.1"}, NATSNAT, "10.0.0.1", "", false},
+		{"snat both", Group{Interface: "nwg0", NAT: "snat:2001:db8::1, 10.0.0.1"}, NATSNAT, "10.0.0.1", "2001:db8::1", false},
+		{"snat twice", Group{Interface: "nwg0", NAT: "snat:10.0.0.1,10.0.0.2"}, "", "", "", true},
+		{"snat garbage", Group{Interface: "nwg0", NAT: "snat:gateway"}, "", "", "", true},
+		{"unknown", Group{Interface: "nwg0", NAT: "dnat"}, "", "", "", true},
+		{"proxy default", Group{Interface: "redirect:12345", NAT: "masquerade"}, NATMasquerade, "", "", false},
+		{"proxy none", Group{Interface: "redirect:12345", NAT: "none"}, NATNone, "", "", true},
+	}
+	for _, tt := range tests {
+		mode, snat4, snat6, _ := ParseNAT(tt.group.NAT)
+		if mode != tt.mode {
+			t.Errorf("%s: ParseNAT() mode = %q, want %q", tt.name, mode, tt.mode)
+		}
+		if got := addrString(snat4); got != tt.snat4 {
+			t.Errorf("%s: ParseNAT() snat4 = %q, want %q", tt.name, got, tt.snat4)
+		}
+		if got := addrString(snat6); got != tt.snat6 {
+			t.Errorf("%s: ParseNAT() snat6 = %q, want %q", tt.name, got, tt.snat6)
+		}
+		err := tt.group.Validate()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidNAT) {
+			t.Errorf("%s: error = %v, want ErrInvalidNAT", tt.name, err)
+		}
+	}
+}
+
+func addrString(addr netip.Addr) string {
+	if !addr.IsValid() {
+		return ""
+	}
+	return addr.String()
+}
+
+func TestGroupValidateTag(t *testing.T) {
+	tests := []struct {
+		name  string
+		group Group
+		mode  string
+		mark  uint32
+		mask  uint32
+		dscp  uint8
+		err   bool
+	}{
+		{"interface", Group{Interface: "nwg0"}, "", 0, 0, 0, false},
+		{"mark", Group{Interface: "mark:0x10/0xf0"}, TagMark, 0x10, 0xf0, 0, false},
+		{"mark without mask", Group{Interface: "mark:5"}, TagMark, 5, 0xffffffff, 0, false},
+		{"mark outside mask", Group{Interface: "mark:0x100/0xff"}, "", 0, 0, 0, true},
+		{"mark zero mask", Group{Interface: "mark:0/0"}, "", 0, 0, 0, true},
+		{"dscp class", Group{Interface: "dscp:EF"}, TagDSCP, 0, 0, 46, false},
+		{"dscp number", Group{Interface: "dscp:10"}, TagDSCP, 0, 0, 10, false},
+		{"dscp out of range", Group{Interface: "dscp:64"}, "", 0, 0, 0, true},
+		{"failover", Group{Interface: "dscp:cs1", FailoverInterfaces: []string{"nwg1"}}, TagDSCP, 0, 0, 8, true},
+		{"mss clamp", Group{Interface: "dscp:cs1", MSSClamp: "pmtu"}, TagDSCP, 0, 0, 8, true},
+		{"nat", Group{Interface: "mark:1", NAT: "none"}, TagMark, 1, 0xffffffff, 0, true},
+	}
+	for _, tt := range tests {
+		mode, mark, mask, dscp, _ := ParseTagTarget(tt.group.Interface)
+		if mode != tt.mode || mark != tt.mark || mask != tt.mask || dscp != tt.dscp {
+			t.Errorf("%s: ParseTagTarget() = %q, %#x, %#x, %d; want %q, %#x, %#x, %d", tt.name, mode, mark, mask, dscp, tt.mode, tt.mark, tt.mask, tt.dscp)
+		}
+		err := tt.group.Validate()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestGroupValidateReject(t *testing.T) {
+	tests := []struct {
+		name  string
+		group Group
+		err   bool
+	}{
+		{"reject", Group{Interface: "reject"}, false},
+		{"route output", Group{Interface: "reject", RouteOutput: true, Protocols: []string{"tcp"}}, false},
+		{"failover", Group{Interface: "reject", FailoverInterfaces: []string{"nwg1"}}, true},
+		{"failover to reject", Group{Interface: "nwg0", FailoverInterfaces: []string{"reject"}}, true},
+		{"health check", Group{Interface: "reject", HealthCheck: &HealthCheck{Type: HealthCheckLink}}, true},
+		{"gateway", Group{Interface: "reject", Gateways: []string{"10.0.0.1"}}, true},
+		{"mss clamp", Group{Interface: "reject", MSSClamp: "pmtu"}, true},
+	}
+	for _, tt := range tests {
+		err := tt.group.Validate()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.err)
+		}
+	}
+}
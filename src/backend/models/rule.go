@@ -16,6 +16,7 @@ const (
 	RuleTypeRegEx     string = "regex"
 	RuleTypeSubnet    string = "subnet"
 	RuleTypeSubnet6   string = "subnet6"
+	RuleTypeASN       string = "asn"
 )
 
 type Rule struct {
@@ -85,7 +86,7 @@ func (d *Rule) IsMatch(domainName string) bool {
 		}
 		return d.compiled(domainName)
 
-	case RuleTypeSubnet, RuleTypeSubnet6:
+	case RuleTypeSubnet, RuleTypeSubnet6, RuleTypeASN:
 		return false
 	}
 	return false
@@ -30,16 +30,14 @@ func subscribeAddrUpdates() (chan netlink.AddrUpdate, chan struct{}, error) {
 	return addrUpdateChannel, done, nil
 }
 
-// handleLink обрабатывает события изменения состояния сетевых интерфейсов
+// handleLink обрабатывает события изменения состояния сетевых интерфейсов.
+// Группы сами сверяют имя интерфейса с основным, резервными и балансируемыми.
 func (a *App) handleLink(event netlink.LinkUpdate) {
-	switch event.Header.Type {
-	case unix.RTM_NEWLINK:
-		linkAttrs := event.Link.Attrs()
-		if linkAttrs.Flags&net.FlagUp == 0 {
-			break
-		}
+	linkAttrs := event.Link.Attrs()
+	ifaceName := linkAttrs.Name
 
-		ifaceName := linkAttrs.Name
+	switch {
+	case event.Header.Type == unix.RTM_NEWLINK && linkAttrs.Flags&net.FlagUp != 0:
 		if !slices.Contains(constant.IgnoredInterfaces, ifaceName) {
 			log.Debug().
 				Str("interface", ifaceName).
@@ -47,10 +45,6 @@ func (a *App) handleLink(event netlink.LinkUpdate) {
 				Msg("interface up")
 		}
 		for _, group := range a.ruleSetSnapshot() {
-			if group.RouteInterface() != ifaceName {
-				continue
-			}
-
 			if err := group.LinkUpHook(event); err != nil {
 				log.Error().
 					Err(err).
@@ -59,11 +53,22 @@ func (a *App) handleLink(event netlink.LinkUpdate) {
 			}
 		}
 
-	case unix.RTM_DELLINK:
-		log.Debug().
-			Str("interface", event.Link.Attrs().Name).
-			Int("type", int(event.Header.Type)).
-			Msg("interface del")
+	case event.Header.Type == unix.RTM_NEWLINK, event.Header.Type == unix.RTM_DELLINK:
+		if !slices.Contains(constant.IgnoredInterfaces, ifaceName) {
+			log.Debug().
+				Str("interface", ifaceName).
+				Int("index", linkAttrs.Index).
+				Int("type", int(event.Header.Type)).
+				Msg("interface down")
+		}
+		for _, group := range a.ruleSetSnapshot() {
+			if err := group.LinkDownHook(event); err != nil {
+				log.Error().
+					Err(err).
+					Str("group", group.IDValue().String()).
+					Msg("error while handling interface down")
+			}
+		}
 	}
 }
 
@@ -0,0 +1,172 @@
+package magitrickle
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"magitrickle/app"
+	groupruntime "magitrickle/groups"
+	"magitrickle/models"
+	"magitrickle/utils/intID"
+	"magitrickle/utils/netfilterTools"
+)
+
+// ruleSetState – записи ipset и маршрутизация набора правил
+type ruleSetState struct {
+	ipv4    map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout
+	ipv6    map[netfilterTools.IPv6Subnet]netfilterTools.IPSetTimeout
+	routing []string
+}
+
+// PlanGroups строит план замены пользовательских групп на groups, как при PUT /groups:
+// скрипты iptables-restore, изменения ipset и ip rule/route. Система не изменяется.
+func (a *App) PlanGroups(groups []*models.Group) (app.GroupsPlan, error) {
+	if !a.enabled.Load() || a.nfHelper == nil {
+		return app.GroupsPlan{}, ErrNotRunning
+	}
+
+	var plan app.GroupsPlan
+	if backend := a.nfHelper.Backend(); backend != netfilterTools.BackendIPTables {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("netfilter rules are not planned for %s backend", backend))
+	}
+	nh := a.nfHelper.DryRun()
+	registerChainPatches(nh)
+
+	a.stateMu.RLock()
+	current := slices.Clone(a.userRuleSets)
+	a.stateMu.RUnlock()
+
+	// Как и PUT /groups, сначала отключаются все текущие группы
+	for _, g := range current {
+		if err := g.planDisable(nh); err != nil {
+			return app.GroupsPlan{}, fmt.Errorf("failed to plan group %s: %w", g.IDValue().String(), err)
+		}
+	}
+
+	now := time.Now()
+	planned := make(map[intID.ID]struct{}, len(groups))
+	for _, model := range groups {
+		if _, ok := planned[model.ID]; ok {
+			return app.GroupsPlan{}, ErrGroupIDConflict
+		}
+		planned[model.ID] = struct{}{}
+		if err := validateGroup(model); err != nil {
+			return app.GroupsPlan{}, err
+		}
+
+		var oldState ruleSetState
+		for _, g := range current {
+			if g.IDValue() == model.ID {
+				oldState = g.planState()
+				break
+			}
+		}
+
+		grp, err := newRuleSet(groupruntime.BuildRuntimeRuleSet(model), a)
+		if err != nil {
+			return app.GroupsPlan{}, fmt.Errorf("failed to create group: %w", err)
+		}
+		var newState ruleSetState
+		if grp.ConfiguredEnabled() {
+			newState, err = grp.planEnable(nh, now)
+			if err != nil {
+				return app.GroupsPlan{}, fmt.Errorf("failed to plan group %s: %w", model.ID.String(), err)
+			}
+		}
+		plan.Groups = append(plan.Groups, groupPlan(model.ID, model.Name, oldState, newState))
+	}
+
+	for _, g := range current {
+		if _, ok := planned[g.IDValue()]; ok {
+			continue
+		}
+		removed := groupPlan(g.IDValue(), g.DisplayName(), g.planState(), ruleSetState{})
+		removed.Removed = true
+		plan.Groups = append(plan.Groups, removed)
+	}
+
+	if nh.IPTables4 != nil {
+		script, err := nh.IPTables4.Script()
+		if err != nil {
+			return app.GroupsPlan{}, fmt.Errorf("failed to plan iptables rules: %w", err)
+		}
+		plan.IPTables4 = string(script)
+	}
+	if nh.IPTables6 != nil {
+		script, err := nh.IPTables6.Script()
+		if err != nil {
+			return app.GroupsPlan{}, fmt.Errorf("failed to plan ip6tables rules: %w", err)
+		}
+		plan.IPTables6 = string(script)
+	}
+	return plan, nil
+}
+
+// planDisable регистрирует в помощнике из DryRun удаление правил работающей группы
+func (g *RuleSet) planDisable(nh *netfilterTools.Helper) error {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	if g.ipsetToLink == nil {
+		return nil
+	}
+	return nh.IPSetToLink(g.RuntimeKey(), g.RouteInterface(), nh.IPSet(g.RuntimeKey(), g.ipsetOptions()), g.linkOptions()).PlanDisable()
+}
+
+// planEnable строит в помощнике из DryRun правила группы и возвращает её будущее состояние
+func (g *RuleSet) planEnable(nh *netfilterTools.Helper, now time.Time) (ruleSetState, error) {
+	link := nh.IPSetToLink(g.RuntimeKey(), g.RouteInterface(), nh.IPSet(g.RuntimeKey(), g.ipsetOptions()), g.linkOptions())
+	routing, err := link.PlanEnable()
+	if err != nil {
+		return ruleSetState{}, err
+	}
+	ipv4, ipv6, _ := g.desiredSubnets(now)
+	return ruleSetState{ipv4: ipv4, ipv6: ipv6, routing: routing}, nil
+}
+
+// planState возвращает текущее состояние работающей группы
+func (g *RuleSet) planState() ruleSetState {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	var state ruleSetState
+	if g.ipset != nil {
+		state.ipv4, _ = g.listIPv4Subnets()
+		state.ipv6, _ = g.listIPv6Subnets()
+	}
+	if g.ipsetToLink != nil {
+		state.routing = g.ipsetToLink.Routing()
+	}
+	return state
+}
+
+func groupPlan(id intID.ID, name string, oldState, newState ruleSetState) app.GroupPlan {
+	plan := app.GroupPlan{ID: id, Name: name}
+
+	add4, del4 := subnetChanges(oldState.ipv4, newState.ipv4)
+	add6, del6 := subnetChanges(oldState.ipv6, newState.ipv6)
+	plan.IPSetAdd = append(subnetStrings(add4), subnetStrings(add6)...)
+	plan.IPSetDelete = append(subnetStrings(del4), subnetStrings(del6)...)
+
+	for _, entry := range newState.routing {
+		if !slices.Contains(oldState.routing, entry) {
+			plan.RoutingAdd = append(plan.RoutingAdd, entry)
+		}
+	}
+	for _, entry := range oldState.routing {
+		if !slices.Contains(newState.routing, entry) {
+			plan.RoutingDelete = append(plan.RoutingDelete, entry)
+		}
+	}
+	return plan
+}
+
+func subnetStrings[S fmt.Stringer](subnets []S) []string {
+	out := make([]string, len(subnets))
+	for i, subnet := range subnets {
+		out[i] = subnet.String()
+	}
+	slices.Sort(out)
+	return out
+}
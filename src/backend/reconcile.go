@@ -0,0 +1,194 @@
+package magitrickle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"magitrickle/app"
+	"magitrickle/utils/netfilterTools"
+
+	"github.com/rs/zerolog/log"
+)
+
+// reconcileStatus накапливает результаты сверки; защищён App.reconcileMu
+type reconcileStatus struct {
+	lastRun   time.Time
+	lastError string
+	repairs   map[string]*app.ReconcileRepair
+}
+
+// startReconciler периодически сверяет правила netfilter, наборы и маршрутизацию
+// с желаемым состоянием. Только Keenetic сообщает о сбросе правил через netfilterd,
+// поэтому на остальных прошивках расхождения находятся сверкой.
+func (a *App) startReconciler(ctx context.Context) {
+	interval := a.config.Netfilter.ReconcileInterval
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				a.reconcile()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// reconcile выполняет одну сверку. Наборы проверяются первыми: правила iptables
+// ссылаются на них, и без наборов iptables-restore завершится ошибкой.
+func (a *App) reconcile() {
+	a.reconcileMu.Lock()
+	defer a.reconcileMu.Unlock()
+
+	var repaired []string
+	var errs []error
+	ruleSets := a.ruleSetSnapshot()
+
+	var resync []*RuleSet
+	var setErrs []error
+	for _, g := range ruleSets {
+		name, recreated, err := g.reconcileIPSet()
+		if err != nil {
+			setErrs = append(setErrs, fmt.Errorf("group %s: %w", g.IDValue().String(), err))
+			continue
+		}
+		if recreated {
+			repaired = append(repaired, "ipset "+name)
+			resync = append(resync, g)
+		}
+	}
+
+	fixed, err := a.nfHelper.ReconcileNetfilter()
+	if errors.Is(err, netfilterTools.ErrRulesLost) {
+		// Наборы nftables удаляются вместе с таблицей, и пересоздать их до её
+		// восстановления нельзя; повторно включённые группы уже синхронизированы
+		err = a.rebuildNetfilter(ruleSets)
+		resync, setErrs = nil, nil
+	}
+	errs = append(errs, setErrs...)
+	repaired = append(repaired, fixed...)
+	errs = append(errs, err)
+
+	for _, g := range ruleSets {
+		fixed, err := g.reconcileRouting()
+		repaired = append(repaired, fixed...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("group %s: %w", g.IDValue().String(), err))
+		}
+	}
+
+	for _, g := range resync {
+		if err := g.Sync(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to sync group %s: %w", g.IDValue().String(), err))
+		}
+	}
+
+	now := time.Now()
+	a.reconcileStatus.lastRun = now
+	a.reconcileStatus.lastError = ""
+	if err := errors.Join(errs...); err != nil {
+		a.reconcileStatus.lastError = err.Error()
+		log.Error().Err(err).Msg("failed to reconcile netfilter state")
+	}
+	if a.reconcileStatus.repairs == nil {
+		a.reconcileStatus.repairs = make(map[string]*app.ReconcileRepair)
+	}
+	for _, object := range repaired {
+		repair, ok := a.reconcileStatus.repairs[object]
+		if !ok {
+			repair = &app.ReconcileRepair{Object: object}
+			a.reconcileStatus.repairs[object] = repair
+		}
+		repair.Count++
+		repair.LastTime = now
+		log.Warn().
+			Str("object", object).
+			Int("count", repair.Count).
+			Msg("repaired netfilter state drift")
+	}
+}
+
+// rebuildNetfilter пересоздаёт правила после их утраты: очищает остатки
+// и заново включает группы и перенаправление DNS
+func (a *App) rebuildNetfilter(ruleSets []*RuleSet) error {
+	if err := a.nfHelper.Clean(); err != nil {
+		return fmt.Errorf("failed to clear netfilter rules: %w", err)
+	}
+
+	var errs []error
+	if a.dnsOverrider != nil {
+		_ = a.dnsOverrider.Disable()
+		if err := a.dnsOverrider.Enable(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to override DNS: %w", err))
+		}
+	}
+	for _, g := range ruleSets {
+		if !g.Enabled() {
+			continue
+		}
+		_ = g.Disable()
+		if err := g.Enable(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to enable group %s: %w", g.IDValue().String(), err))
+			continue
+		}
+		if err := g.Sync(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to sync group %s: %w", g.IDValue().String(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReconcileStatus возвращает результаты сверки; объекты отсортированы по числу исправлений
+func (a *App) ReconcileStatus() app.ReconcileStatus {
+	a.reconcileMu.Lock()
+	defer a.reconcileMu.Unlock()
+
+	status := app.ReconcileStatus{
+		LastRun:   a.reconcileStatus.lastRun,
+		LastError: a.reconcileStatus.lastError,
+	}
+	for _, repair := range a.reconcileStatus.repairs {
+		status.Repairs = append(status.Repairs, *repair)
+	}
+	slices.SortFunc(status.Repairs, func(x, y app.ReconcileRepair) int {
+		if x.Count != y.Count {
+			return y.Count - x.Count
+		}
+		return strings.Compare(x.Object, y.Object)
+	})
+	return status
+}
+
+// reconcileIPSet пересоздаёт пропавшие наборы группы
+func (g *RuleSet) reconcileIPSet() (string, bool, error) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	if g.ipset == nil {
+		return "", false, nil
+	}
+	recreated, err := g.ipset.Reconcile()
+	return g.ipset.Name(), recreated, err
+}
+
+// reconcileRouting восстанавливает пропавшие ip rule и маршруты группы
+func (g *RuleSet) reconcileRouting() ([]string, error) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	if g.ipsetToLink == nil {
+		return nil, nil
+	}
+	return g.ipsetToLink.Reconcile()
+}
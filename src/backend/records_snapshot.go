@@ -0,0 +1,63 @@
+package magitrickle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// restoreRecordsCache восстанавливает кэш DNS-записей из снимка. Адреса в наборах групп
+// восстанавливаются из своего снимка (ipset_snapshot.go) при включении групп.
+func (a *App) restoreRecordsCache() {
+	path := a.config.RecordsCache.SnapshotPath
+	if path == "" {
+		return
+	}
+
+	restored, err := a.recordsCache.Load(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		log.Warn().Err(err).Str("path", path).Msg("records cache snapshot discarded")
+		_ = os.Remove(path)
+	default:
+		log.Info().Int("records", restored).Msg("records cache restored")
+	}
+}
+
+func (a *App) saveRecordsCache() {
+	path := a.config.RecordsCache.SnapshotPath
+	if path == "" {
+		return
+	}
+
+	if err := a.recordsCache.Save(path); err != nil {
+		log.Error().Err(err).Str("path", path).Msg("failed to save records cache snapshot")
+	}
+}
+
+// startRecordsSnapshot периодически сохраняет снимки кэша и наборов групп, чтобы они пережили и аварийное завершение
+func (a *App) startRecordsSnapshot(ctx context.Context) {
+	interval := a.config.RecordsCache.SnapshotInterval
+	if a.config.RecordsCache.SnapshotPath == "" || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				a.saveRecordsCache()
+				a.saveIPSets()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -3,18 +3,25 @@ package magitrickle
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"net"
+	"net/netip"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"magitrickle/app"
 	"magitrickle/models"
 	"magitrickle/rulesets"
+	"magitrickle/utils/asnDataset"
+	"magitrickle/utils/cidrTools"
 	"magitrickle/utils/intID"
 	"magitrickle/utils/netfilterTools"
 
 	"github.com/rs/zerolog/log"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 type RuleSet struct {
@@ -26,6 +33,17 @@ type RuleSet struct {
 	app         *App
 	ipset       *netfilterTools.IPSet
 	ipsetToLink *netfilterTools.IPSetToLink
+	syncReport  app.RuleSetSyncReport
+	// syncLocker упорядочивает синхронизации; syncProgress – ход текущей загрузки наборов
+	syncLocker   sync.Mutex
+	syncProgress atomic.Pointer[app.SyncProgress]
+
+	failover       *failoverMonitor
+	failoverEvents []app.FailoverEvent
+
+	// lastCounters, lastCountersTime – счётчики трафика на момент предыдущего запроса
+	lastCounters     []netfilterTools.TrafficCounter
+	lastCountersTime time.Time
 }
 
 func (g *RuleSet) Enabled() bool {
@@ -79,6 +97,105 @@ func (g *RuleSet) RuleModels() []*models.Rule {
 	return g.spec.Rules
 }
 
+func (g *RuleSet) linkOptions() netfilterTools.IPSetToLinkOptions {
+	var opts netfilterTools.IPSetToLinkOptions
+	if g.spec.Model != nil {
+		opts = netfilterTools.IPSetToLinkOptions{
+			Protocols:     g.spec.Model.Protocols,
+			DstPorts:      g.spec.Model.DstPorts,
+			SrcAddresses:  g.spec.Model.SrcAddresses,
+			SrcMACs:       g.spec.Model.SrcMACs,
+			SrcInterfaces: g.spec.Model.SrcInterfaces,
+			RouteOutput:   g.spec.Model.RouteOutput,
+			Gateways:      g.spec.Model.Gateways,
+			Onlink:        g.spec.Model.Onlink,
+		}
+		if g.spec.Model.Balancing() {
+			opts.Balance = g.spec.Model.FailoverInterfaces
+			opts.Weights = g.spec.Model.InterfaceWeights
+		}
+		if enabled, size, _ := models.ParseMSSClamp(g.spec.Model.MSSClamp); enabled {
+			opts.MSSClamp = &netfilterTools.MSSClamp{Size: size}
+		}
+		if mode, snat4, snat6, _ := models.ParseNAT(g.spec.Model.NAT); mode == models.NATNone {
+			opts.NAT.Disabled = true
+		} else {
+			opts.NAT.SNAT4, opts.NAT.SNAT6 = snat4, snat6
+		}
+	}
+	// Интерфейс вида "tproxy:<port>" передаёт трафик локальному прокси
+	if mode, port, _ := models.ParseProxyTarget(g.RouteInterface()); mode != "" {
+		opts.Proxy = &netfilterTools.ProxyTarget{Mode: mode, Port: port}
+	}
+	// Интерфейс вида "mark:<значение>/<маска>" или "dscp:<класс>" только помечает трафик
+	if mode, mark, mask, dscp, _ := models.ParseTagTarget(g.RouteInterface()); mode != "" {
+		opts.Tag = &netfilterTools.TagTarget{Mode: mode, Mark: mark, Mask: mask, DSCP: dscp}
+	}
+	return opts
+}
+
+func (g *RuleSet) ipsetOptions() netfilterTools.IPSetOptions {
+	if g.spec.Model == nil || g.spec.Model.IPSet == nil {
+		return netfilterTools.IPSetOptions{}
+	}
+	return netfilterTools.IPSetOptions{
+		MaxElem:  g.spec.Model.IPSet.MaxElem,
+		HashSize: g.spec.Model.IPSet.HashSize,
+		Timeout:  g.spec.Model.IPSet.Timeout,
+	}
+}
+
+// checkIPSetUsage проверяет заполненность наборов и добавляет предупреждения в отчёт синхронизации
+func (g *RuleSet) checkIPSetUsage() {
+	if g.ipset == nil {
+		return
+	}
+	usage, err := g.ipset.CheckUsage()
+	g.syncReport.IPSet = usage
+	g.syncReport.Warnings = nil
+	for _, u := range usage {
+		switch {
+		case u.Resized:
+			g.syncReport.Warnings = append(g.syncReport.Warnings, fmt.Sprintf("ipv%d set was nearly full (%d entries) and was resized to %d elements", u.Family, u.Entries, u.MaxElem))
+		case u.NearFull():
+			g.syncReport.Warnings = append(g.syncReport.Warnings, fmt.Sprintf("ipv%d set is nearly full: %d of %d elements", u.Family, u.Entries, u.MaxElem))
+		}
+	}
+	if err != nil {
+		g.syncReport.Warnings = append(g.syncReport.Warnings, err.Error())
+		log.Error().
+			Err(err).
+			Str("group", g.IDValue().String()).
+			Msg("failed to check ipset usage")
+	}
+}
+
+// conntrack возвращает механизм удаления записей conntrack; nil, если группа от него отказалась
+func (g *RuleSet) conntrack() *netfilterTools.ConntrackFlusher {
+	if g.spec.Model != nil && g.spec.Model.DisableConntrackFlush {
+		return nil
+	}
+	return g.app.nfHelper.Conntrack()
+}
+
+// flushInterfaceConntrack удаляет соединения, направленные через интерфейсы ifaces
+// (все интерфейсы группы, если ifaces не указаны)
+func (g *RuleSet) flushInterfaceConntrack(ifaces ...string) {
+	if g.ipsetToLink == nil {
+		return
+	}
+	marks := g.ipsetToLink.Marks()
+	if len(ifaces) == 0 {
+		g.conntrack().FlushMarks(slices.Collect(maps.Values(marks))...)
+		return
+	}
+	for _, iface := range ifaces {
+		if mark, ok := marks[iface]; ok {
+			g.conntrack().FlushMarks(mark)
+		}
+	}
+}
+
 func (g *RuleSet) ConfiguredEnabled() bool {
 	if g.spec.Model != nil {
 		return g.spec.Model.Enable
@@ -203,8 +320,8 @@ func (g *RuleSet) enable() error {
 		return nil
 	}
 
-	ipset := g.app.nfHelper.IPSet(g.RuntimeKey())
-	ipsetToLink := g.app.nfHelper.IPSetToLink(g.RuntimeKey(), g.RouteInterface(), ipset)
+	ipset := g.app.nfHelper.IPSet(g.RuntimeKey(), g.ipsetOptions())
+	ipsetToLink := g.app.nfHelper.IPSetToLink(g.RuntimeKey(), g.RouteInterface(), ipset, g.linkOptions())
 	if err := ipsetToLink.ClearIfDisabled(); err != nil {
 		return fmt.Errorf("failed to clear iptables: %w", err)
 	}
@@ -218,6 +335,7 @@ func (g *RuleSet) enable() error {
 		return fmt.Errorf("failed to link ipset to interface: %w", err)
 	}
 	g.ipsetToLink = ipsetToLink
+	g.startFailover()
 
 	return nil
 }
@@ -237,11 +355,15 @@ func (g *RuleSet) disable() error {
 		return nil
 	}
 	defer g.enabled.Store(false)
+	g.stopFailover()
 
 	if !g.ConfiguredEnabled() {
 		return nil
 	}
 
+	// Соединения группы должны вернуться к основной маршрутизации
+	g.flushInterfaceConntrack()
+
 	var errs []error
 	errs = append(errs, func() error {
 		if g.ipsetToLink == nil {
@@ -272,11 +394,13 @@ func (g *RuleSet) Disable() error {
 	return g.disable()
 }
 
-func (g *RuleSet) sync() error {
-	now := time.Now()
+// desiredSubnets строит записи ipset, которые должны быть у группы на момент now
+func (g *RuleSet) desiredSubnets(now time.Time) (map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout, map[netfilterTools.IPv6Subnet]netfilterTools.IPSetTimeout, app.RuleSetSyncReport) {
 	newIPv4SubnetList := make(map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout)
 	newIPv6SubnetList := make(map[netfilterTools.IPv6Subnet]netfilterTools.IPSetTimeout)
 	knownDomains := g.app.recordsCache.ListKnownDomains()
+	report := app.RuleSetSyncReport{Time: now}
+	seenASN := make(map[uint32]struct{})
 	for _, domain := range g.RuleModels() {
 		if !domain.IsEnabled() {
 			continue
@@ -371,6 +495,45 @@ func (g *RuleSet) sync() error {
 				}] = nil
 			}
 
+		case models.RuleTypeASN:
+			asn, err := asnDataset.ParseASN(domain.Rule)
+			if err != nil {
+				log.Warn().
+					Str("group", g.IDValue().String()).
+					Str("rule", domain.Rule).
+					Msg("invalid asn rule")
+				continue
+			}
+			if _, ok := seenASN[asn]; ok {
+				continue
+			}
+			seenASN[asn] = struct{}{}
+
+			asnReport := app.ASNSyncReport{ASN: asn}
+			prefixes, err := g.app.lookupASN(asn)
+			if err != nil {
+				asnReport.Error = err.Error()
+				log.Error().
+					Err(err).
+					Str("group", g.IDValue().String()).
+					Uint32("asn", asn).
+					Msg("failed to expand asn")
+			}
+			for _, prefix := range prefixes {
+				if prefix.Addr().Is4() {
+					for _, subnet := range ipv4SubnetsFromPrefix(prefix) {
+						newIPv4SubnetList[subnet] = nil
+					}
+					asnReport.IPv4++
+				} else {
+					for _, subnet := range ipv6SubnetsFromPrefix(prefix) {
+						newIPv6SubnetList[subnet] = nil
+					}
+					asnReport.IPv6++
+				}
+			}
+			report.ASN = append(report.ASN, asnReport)
+
 		default:
 			for _, domainName := range knownDomains {
 				if !domain.IsMatch(domainName) {
@@ -399,88 +562,378 @@ func (g *RuleSet) sync() error {
 		}
 	}
 
-	oldIPv4SubnetList, err := g.listIPv4Subnets()
-	if err != nil {
-		return fmt.Errorf("failed to get old ipset list: %w", err)
+	ipv4, ipv6 := aggregateSubnets(newIPv4SubnetList, ipv4SubnetsFromPrefix), aggregateSubnets(newIPv6SubnetList, ipv6SubnetsFromPrefix)
+	report.Aggregation = []app.SubnetAggregationReport{
+		{Family: 4, Before: len(newIPv4SubnetList), After: len(ipv4)},
+		{Family: 6, Before: len(newIPv6SubnetList), After: len(ipv6)},
+	}
+	return ipv4, ipv6, report
+}
+
+// aggregateSubnets объединяет постоянные записи (подсети и ASN) в минимальное покрытие
+// и отбрасывает записи с таймаутом, которые уже покрыты постоянными
+func aggregateSubnets[S interface {
+	comparable
+	Prefix() netip.Prefix
+}](list map[S]netfilterTools.IPSetTimeout, fromPrefix func(netip.Prefix) []S) map[S]netfilterTools.IPSetTimeout {
+	var permanent []netip.Prefix
+	for subnet, ttl := range list {
+		if ttl == nil {
+			permanent = append(permanent, subnet.Prefix())
+		}
+	}
+	// Покрытие отсортировано и не содержит пересечений
+	cover := cidrTools.Aggregate(permanent)
+
+	out := make(map[S]netfilterTools.IPSetTimeout, len(list))
+	for _, prefix := range cover {
+		for _, subnet := range fromPrefix(prefix) {
+			out[subnet] = nil
+		}
+	}
+	for subnet, ttl := range list {
+		if ttl == nil {
+			continue
+		}
+		addr := subnet.Prefix().Addr()
+		idx, found := slices.BinarySearchFunc(cover, addr, func(prefix netip.Prefix, addr netip.Addr) int {
+			return prefix.Addr().Compare(addr)
+		})
+		if !found {
+			idx--
+		}
+		if idx >= 0 && cover[idx].Contains(addr) {
+			continue
+		}
+		out[subnet] = ttl
 	}
-	for subnet, newTTL := range newIPv4SubnetList {
-		if oldTTL, ok := oldIPv4SubnetList[subnet]; ok {
+	return out
+}
+
+// subnetChanges возвращает записи, которые нужно добавить и удалить, чтобы привести
+// current к desired. Запись добавляется заново, если у неё появился или вырос таймаут.
+func subnetChanges[S comparable](current, desired map[S]netfilterTools.IPSetTimeout) (add []S, del []S) {
+	for subnet, newTTL := range desired {
+		oldTTL, existed := current[subnet]
+		if existed {
 			if oldTTL == nil || (newTTL != nil && *newTTL < *oldTTL) {
 				continue
 			}
 		}
+		add = append(add, subnet)
+	}
+	for subnet := range current {
+		if _, ok := desired[subnet]; !ok {
+			del = append(del, subnet)
+		}
+	}
+	return add, del
+}
 
-		if err := g.addIPv4Subnet(subnet, newTTL); err != nil {
-			log.Error().
-				Err(err).
-				Str("subnet", subnet.String()).
-				Msg("failed to add subnet")
-		} else {
-			log.Debug().
-				Str("subnet", subnet.String()).
-				Msg("added subnet")
+// planSubnetChanges рассчитывает изменения одного семейства. В changed попадают только
+// появившиеся и удалённые подсети: продление таймаута существующей записи не меняет
+// маркировку соединений, и сбрасывать их не нужно.
+func planSubnetChanges[S interface {
+	comparable
+	Prefix() netip.Prefix
+}](current, desired map[S]netfilterTools.IPSetTimeout) (add map[S]netfilterTools.IPSetTimeout, del []S, changed []netip.Prefix) {
+	var addList []S
+	addList, del = subnetChanges(current, desired)
+	add = make(map[S]netfilterTools.IPSetTimeout, len(addList))
+	for _, subnet := range addList {
+		add[subnet] = desired[subnet]
+		if _, existed := current[subnet]; !existed {
+			changed = append(changed, subnet.Prefix())
 		}
 	}
-	for subnet := range oldIPv4SubnetList {
-		if _, ok := newIPv4SubnetList[subnet]; ok {
+	for _, subnet := range del {
+		changed = append(changed, subnet.Prefix())
+	}
+	return add, del, changed
+}
+
+// subnetSync – изменения наборов группы, рассчитанные под блокировкой группы
+type subnetSync struct {
+	ipset   *netfilterTools.IPSet
+	addIPv4 map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout
+	delIPv4 []netfilterTools.IPv4Subnet
+	addIPv6 map[netfilterTools.IPv6Subnet]netfilterTools.IPSetTimeout
+	delIPv6 []netfilterTools.IPv6Subnet
+	// changed – добавленные и удалённые подсети; соединения к ним заново пройдут маркировку
+	changed []netip.Prefix
+}
+
+func (s subnetSync) total() int {
+	return len(s.addIPv4) + len(s.delIPv4) + len(s.addIPv6) + len(s.delIPv6)
+}
+
+// planSync рассчитывает изменения наборов; вызывается под блокировкой группы
+func (g *RuleSet) planSync() (subnetSync, error) {
+	newIPv4SubnetList, newIPv6SubnetList, report := g.desiredSubnets(time.Now())
+	g.syncReport = report
+
+	plan := subnetSync{ipset: g.ipset}
+	oldIPv4SubnetList, err := g.listIPv4Subnets()
+	if err != nil {
+		return plan, fmt.Errorf("failed to get old ipset list: %w", err)
+	}
+	oldIPv6SubnetList, err := g.listIPv6Subnets()
+	if err != nil {
+		return plan, fmt.Errorf("failed to get old ipset list: %w", err)
+	}
+
+	var changed4, changed6 []netip.Prefix
+	plan.addIPv4, plan.delIPv4, changed4 = planSubnetChanges(oldIPv4SubnetList, newIPv4SubnetList)
+	plan.addIPv6, plan.delIPv6, changed6 = planSubnetChanges(oldIPv6SubnetList, newIPv6SubnetList)
+	plan.changed = append(changed4, changed6...)
+	return plan, nil
+}
+
+// applySync загружает изменения в наборы пачками, блокируя группу только на время
+// пачки, поэтому адреса из DNS-ответов добавляются и во время загрузки. Ход загрузки
+// доступен в SyncReport.
+func (g *RuleSet) applySync(plan subnetSync) {
+	total := plan.total()
+	if total == 0 {
+		return
+	}
+
+	started := time.Now()
+	g.syncProgress.Store(&app.SyncProgress{Started: started, Total: total})
+	defer g.syncProgress.Store(nil)
+	log.Info().
+		Str("group", g.IDValue().String()).
+		Int("add", len(plan.addIPv4)+len(plan.addIPv6)).
+		Int("delete", len(plan.delIPv4)+len(plan.delIPv6)).
+		Msg("loading ipset changes")
+
+	base := 0
+	step := func(done int) {
+		g.syncProgress.Store(&app.SyncProgress{Started: started, Done: base + done, Total: total})
+		log.Debug().
+			Str("group", g.IDValue().String()).
+			Int("done", base+done).
+			Int("total", total).
+			Msg("ipset sync progress")
+	}
+	for _, op := range []struct {
+		msg string
+		n   int
+		run func() error
+	}{
+		{"failed to add subnets", len(plan.addIPv4), func() error { return addSubnets(g, plan.ipset, plan.addIPv4, plan.ipset.AddIPv4Subnets, step) }},
+		{"failed to delete subnets", len(plan.delIPv4), func() error { return delStaleSubnets(g, plan.ipset, plan.delIPv4, plan.ipset.DelIPv4Subnets, step) }},
+		{"failed to add subnets", len(plan.addIPv6), func() error { return addSubnets(g, plan.ipset, plan.addIPv6, plan.ipset.AddIPv6Subnets, step) }},
+		{"failed to delete subnets", len(plan.delIPv6), func() error { return delStaleSubnets(g, plan.ipset, plan.delIPv6, plan.ipset.DelIPv6Subnets, step) }},
+	} {
+		if op.n == 0 {
 			continue
 		}
-
-		if err := g.delIPv4Subnet(subnet); err != nil {
+		err := op.run()
+		if errors.Is(err, errIPSetReplaced) {
+			log.Info().
+				Str("group", g.IDValue().String()).
+				Msg("ipset was recreated while loading changes, dropping the rest of the plan")
+			return
+		}
+		if err != nil {
 			log.Error().
 				Err(err).
-				Str("subnet", subnet.String()).
-				Msg("failed to delete subnet")
-		} else {
-			log.Debug().
-				Str("subnet", subnet.String()).
-				Msg("deleted subnet")
+				Str("group", g.IDValue().String()).
+				Msg(op.msg)
 		}
+		base += op.n
 	}
 
-	oldIPv6SubnetList, err := g.listIPv6Subnets()
-	if err != nil {
-		return fmt.Errorf("failed to get old ipset list: %w", err)
+	log.Info().
+		Str("group", g.IDValue().String()).
+		Int("changes", total).
+		Dur("elapsed", time.Since(started)).
+		Msg("ipset changes loaded")
+}
+
+// errIPSetReplaced – набор группы пересоздан после расчёта плана; остаток плана
+// отбрасывается, новый набор заполнит его собственная синхронизация
+var errIPSetReplaced = errors.New("group ipset was replaced")
+
+// syncBatches применяет изменения пачками по IPSetBatchSize под блокировкой группы.
+// Перед каждой пачкой проверяется, что у группы всё ещё набор ipset, для которого
+// рассчитан план: Disable и Enable между пачками пересоздают набор.
+func syncBatches[S any](g *RuleSet, ipset *netfilterTools.IPSet, subnets []S, apply func([]S) error, progress netfilterTools.BulkProgress) error {
+	var errs []error
+	for start := 0; start < len(subnets); start += netfilterTools.IPSetBatchSize {
+		batch := subnets[start:min(start+netfilterTools.IPSetBatchSize, len(subnets))]
+
+		g.locker.Lock()
+		if g.ipset != ipset {
+			g.locker.Unlock()
+			return errIPSetReplaced
+		}
+		if err := apply(batch); err != nil {
+			errs = append(errs, err)
+		}
+		g.locker.Unlock()
+
+		if progress != nil {
+			progress(start + len(batch))
+		}
 	}
-	for subnet, newTTL := range newIPv6SubnetList {
-		if oldTTL, ok := oldIPv6SubnetList[subnet]; ok {
-			if oldTTL == nil || (newTTL != nil && *newTTL < *oldTTL) {
+	return errors.Join(errs...)
+}
+
+// addSubnets добавляет подсети с их таймаутами пачками через syncBatches
+func addSubnets[S comparable](g *RuleSet, ipset *netfilterTools.IPSet, subnets map[S]netfilterTools.IPSetTimeout, add func(map[S]netfilterTools.IPSetTimeout, netfilterTools.BulkProgress) error, progress netfilterTools.BulkProgress) error {
+	keys := make([]S, 0, len(subnets))
+	for subnet := range subnets {
+		keys = append(keys, subnet)
+	}
+	return syncBatches(g, ipset, keys, func(batch []S) error {
+		entries := make(map[S]netfilterTools.IPSetTimeout, len(batch))
+		for _, subnet := range batch {
+			entries[subnet] = subnets[subnet]
+		}
+		return add(entries, nil)
+	}, progress)
+}
+
+// delStaleSubnets удаляет подсети пачками через syncBatches и перед каждой пачкой
+// оставляет в наборе адреса, которые DNS сопоставил группе после расчёта плана. DNS
+// записывает адрес в кэш раньше, чем добавляет его в набор под той же блокировкой,
+// поэтому такой адрес либо виден в кэше, либо будет добавлен уже после удаления.
+func delStaleSubnets[S interface {
+	comparable
+	Prefix() netip.Prefix
+}](g *RuleSet, ipset *netfilterTools.IPSet, subnets []S, del func([]S, netfilterTools.BulkProgress) error, progress netfilterTools.BulkProgress) error {
+	return syncBatches(g, ipset, subnets, func(batch []S) error {
+		live := g.liveDNSAddresses()
+		stale := make([]S, 0, len(batch))
+		for _, subnet := range batch {
+			prefix := subnet.Prefix()
+			if _, ok := live[prefix.Addr()]; ok && prefix.IsSingleIP() {
 				continue
 			}
+			stale = append(stale, subnet)
 		}
+		if len(stale) == 0 {
+			return nil
+		}
+		return del(stale, nil)
+	}, progress)
+}
 
-		if err := g.addIPv6Subnet(subnet, newTTL); err != nil {
-			log.Error().
-				Err(err).
-				Str("subnet", subnet.String()).
-				Msg("failed to add subnet")
-		} else {
-			log.Debug().
-				Str("subnet", subnet.String()).
-				Msg("added subnet")
+// liveDNSAddresses возвращает адреса из кэша DNS, домены которых подходят под правила группы
+func (g *RuleSet) liveDNSAddresses() map[netip.Addr]struct{} {
+	live := make(map[netip.Addr]struct{})
+	for addr, names := range g.app.recordsCache.AddressDomains() {
+		if g.matchesDomain(names) {
+			live[addr] = struct{}{}
 		}
 	}
-	for subnet := range oldIPv6SubnetList {
-		if _, ok := newIPv6SubnetList[subnet]; ok {
+	return live
+}
+
+// matchesDomain сообщает, что одно из имён подходит под включённое правило группы
+func (g *RuleSet) matchesDomain(names []string) bool {
+	for _, rule := range g.RuleModels() {
+		if !rule.IsEnabled() {
 			continue
 		}
+		for _, name := range names {
+			if rule.IsMatch(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-		if err := g.delIPv6Subnet(subnet); err != nil {
-			log.Error().
-				Err(err).
-				Str("subnet", subnet.String()).
-				Msg("failed to delete subnet")
-		} else {
-			log.Debug().
-				Str("subnet", subnet.String()).
-				Msg("deleted subnet")
+func (g *RuleSet) sync() error {
+	g.locker.Lock()
+	if !g.Enabled() || !g.ConfiguredEnabled() {
+		g.locker.Unlock()
+		return nil
+	}
+	plan, err := g.planSync()
+	g.locker.Unlock()
+
+	if err == nil {
+		g.applySync(plan)
+	}
+
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	if err == nil && g.Enabled() {
+		g.conntrack().FlushDestinations(plan.changed...)
+	}
+	g.checkIPSetUsage()
+	return err
+}
+
+// ipv4SubnetsFromPrefix переводит префикс в записи ipset
+func ipv4SubnetsFromPrefix(prefix netip.Prefix) []netfilterTools.IPv4Subnet {
+	if prefix.Bits() == 0 {
+		// TODO: Fix (remove dirty hack) after resolving https://github.com/vishvananda/netlink/issues/1091
+		return []netfilterTools.IPv4Subnet{
+			{Address: [4]byte{0x00}, CIDR: 1},
+			{Address: [4]byte{0x80}, CIDR: 1},
 		}
 	}
+	if prefix.IsSingleIP() {
+		// Узлы записываются с CIDR 0, как их добавляет DNS и возвращает список набора
+		return []netfilterTools.IPv4Subnet{{Address: prefix.Addr().As4()}}
+	}
+	return []netfilterTools.IPv4Subnet{{Address: prefix.Addr().As4(), CIDR: uint8(prefix.Bits())}}
+}
 
-	return nil
+// ipv6SubnetsFromPrefix переводит префикс в записи ipset
+func ipv6SubnetsFromPrefix(prefix netip.Prefix) []netfilterTools.IPv6Subnet {
+	if prefix.Bits() == 0 {
+		return []netfilterTools.IPv6Subnet{
+			{Address: [16]byte{0x00}, CIDR: 1},
+			{Address: [16]byte{0x80}, CIDR: 1},
+		}
+	}
+	if prefix.IsSingleIP() {
+		return []netfilterTools.IPv6Subnet{{Address: prefix.Addr().As16()}}
+	}
+	return []netfilterTools.IPv6Subnet{{Address: prefix.Addr().As16(), CIDR: uint8(prefix.Bits())}}
 }
 
+// Sync приводит наборы адресов к правилам группы. Синхронизации выполняются по очереди,
+// а блокировка группы не удерживается во время загрузки изменений.
 func (g *RuleSet) Sync() error {
+	g.syncLocker.Lock()
+	defer g.syncLocker.Unlock()
+
+	return g.sync()
+}
+
+// SyncReport возвращает отчёт о последней синхронизации
+func (g *RuleSet) SyncReport() app.RuleSetSyncReport {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	report := g.syncReport
+	if progress := g.syncProgress.Load(); progress != nil {
+		p := *progress
+		report.Progress = &p
+	}
+	return report
+}
+
+// Allocation возвращает метки и таблицы интерфейсов группы; пусто, пока группа выключена
+func (g *RuleSet) Allocation() app.GroupAllocation {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	allocation := app.GroupAllocation{Mask: g.app.config.Netfilter.FwmarkMask}
+	if g.ipsetToLink != nil {
+		allocation.Interfaces = g.ipsetToLink.Allocations()
+	}
+	return allocation
+}
+
+func (g *RuleSet) LinkUpHook(event netlink.LinkUpdate) error {
 	g.locker.Lock()
 	defer g.locker.Unlock()
 
@@ -492,10 +945,16 @@ func (g *RuleSet) Sync() error {
 		return nil
 	}
 
-	return g.sync()
+	restored, err := g.ipsetToLink.LinkUpHook(event)
+	for _, iface := range restored {
+		g.recordLinkEvent(netfilterTools.Blackhole, iface, fmt.Sprintf("interface %s is up (index %d)", iface, event.Link.Attrs().Index))
+	}
+	return err
 }
 
-func (g *RuleSet) LinkUpHook(event netlink.LinkUpdate) error {
+// LinkDownHook убирает маршруты через выключенный или удалённый интерфейс;
+// до его возвращения трафик группы отбрасывается blackhole-маршрутом
+func (g *RuleSet) LinkDownHook(event netlink.LinkUpdate) error {
 	g.locker.Lock()
 	defer g.locker.Unlock()
 
@@ -507,7 +966,26 @@ func (g *RuleSet) LinkUpHook(event netlink.LinkUpdate) error {
 		return nil
 	}
 
-	return g.ipsetToLink.LinkUpHook(event)
+	dropped, err := g.ipsetToLink.LinkDownHook(event)
+	state := "down"
+	if event.Header.Type == unix.RTM_DELLINK {
+		state = "removed"
+	}
+	for _, iface := range dropped {
+		g.recordLinkEvent(iface, netfilterTools.Blackhole, fmt.Sprintf("interface %s is %s", iface, state))
+	}
+	return err
+}
+
+// recordLinkEvent записывает в историю переключений смену маршрута из-за состояния интерфейса
+func (g *RuleSet) recordLinkEvent(from, to, reason string) {
+	log.Info().
+		Str("group", g.IDValue().String()).
+		Str("from", from).
+		Str("to", to).
+		Str("reason", reason).
+		Msg("group route changed")
+	g.recordFailoverEvent(app.FailoverEvent{Time: time.Now(), From: from, To: to, Reason: reason})
 }
 
 func (g *RuleSet) AddrChangeHook(event netlink.AddrUpdate) error {
@@ -0,0 +1,186 @@
+package magitrickle
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"slices"
+	"testing"
+
+	"magitrickle/models"
+	"magitrickle/rulesets"
+	"magitrickle/utils/netfilterTools"
+	"magitrickle/utils/recordsCache"
+)
+
+func TestSubnetChanges(t *testing.T) {
+	ttl := func(v uint32) netfilterTools.IPSetTimeout { return &v }
+	current := map[string]netfilterTools.IPSetTimeout{
+		"kept":      nil,
+		"longer":    ttl(10),
+		"shorter":   ttl(100),
+		"permanent": ttl(10),
+		"removed":   nil,
+	}
+	desired := map[string]netfilterTools.IPSetTimeout{
+		"kept":      nil,
+		"longer":    ttl(60),
+		"shorter":   ttl(50),
+		"permanent": nil,
+		"added":     ttl(30),
+	}
+
+	add, del := subnetChanges(current, desired)
+	slices.Sort(add)
+	if expected := []string{"added", "longer", "permanent"}; !reflect.DeepEqual(add, expected) {
+		t.Errorf("add mismatch.\nExpected: %v\nGot: %v", expected, add)
+	}
+	if expected := []string{"removed"}; !reflect.DeepEqual(del, expected) {
+		t.Errorf("del mismatch.\nExpected: %v\nGot: %v", expected, del)
+	}
+}
+
+func TestAggregateSubnets(t *testing.T) {
+	ttl := func(v uint32) netfilterTools.IPSetTimeout { return &v }
+	subnet := func(s string) netfilterTools.IPv4Subnet {
+		prefix := netip.MustParsePrefix(s)
+		return netfilterTools.IPv4Subnet{Address: prefix.Addr().As4(), CIDR: uint8(prefix.Bits())}
+	}
+	host := func(s string) netfilterTools.IPv4Subnet {
+		return netfilterTools.IPv4Subnet{Address: netip.MustParseAddr(s).As4()}
+	}
+	list := map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout{
+		subnet("10.0.0.0/24"):   nil,
+		subnet("10.0.1.0/24"):   nil,
+		subnet("10.0.1.128/25"): nil,
+		subnet("10.0.3.0/24"):   nil,
+		host("10.0.1.7"):        ttl(60),
+		host("10.0.2.7"):        ttl(60),
+	}
+
+	got := aggregateSubnets(list, ipv4SubnetsFromPrefix)
+	expected := map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout{
+		subnet("10.0.0.0/23"): nil,
+		subnet("10.0.3.0/24"): nil,
+		host("10.0.2.7"):      list[host("10.0.2.7")],
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("aggregateSubnets() mismatch.\nExpected: %v\nGot: %v", expected, got)
+	}
+
+	// Покрытие всего пространства по-прежнему записывается двумя половинами
+	whole := map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout{
+		subnet("0.0.0.0/1"):   nil,
+		subnet("128.0.0.0/1"): nil,
+		subnet("10.0.0.0/8"):  nil,
+	}
+	got = aggregateSubnets(whole, ipv4SubnetsFromPrefix)
+	expected = map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout{
+		subnet("0.0.0.0/1"):   nil,
+		subnet("128.0.0.0/1"): nil,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("aggregateSubnets() of whole space mismatch.\nExpected: %v\nGot: %v", expected, got)
+	}
+}
+
+func TestSubnetsFromHostPrefix(t *testing.T) {
+	// Постоянный узел из правила записывается так же, как адрес из DNS и из списка набора
+	if got := ipv4SubnetsFromPrefix(netip.MustParsePrefix("10.0.0.1/32")); !reflect.DeepEqual(got, []netfilterTools.IPv4Subnet{{Address: [4]byte{10, 0, 0, 1}}}) {
+		t.Errorf("ipv4SubnetsFromPrefix(/32) = %v", got)
+	}
+	if got := ipv6SubnetsFromPrefix(netip.MustParsePrefix("2001:db8::1/128")); !reflect.DeepEqual(got, []netfilterTools.IPv6Subnet{{Address: netip.MustParseAddr("2001:db8::1").As16()}}) {
+		t.Errorf("ipv6SubnetsFromPrefix(/128) = %v", got)
+	}
+}
+
+func TestPlanSubnetChangesFlushesOnlyChanges(t *testing.T) {
+	ttl := func(v uint32) netfilterTools.IPSetTimeout { return &v }
+	host := func(s string) netfilterTools.IPv4Subnet {
+		return netfilterTools.IPv4Subnet{Address: netip.MustParseAddr(s).As4()}
+	}
+	subnet := netfilterTools.IPv4Subnet{Address: [4]byte{10, 1, 0, 0}, CIDR: 16}
+
+	// Набор уже синхронизирован: адреса из DNS в списке набора записаны с CIDR 0
+	current := map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout{
+		host("10.0.0.1"): ttl(40),
+		host("10.0.0.2"): nil,
+		subnet:           nil,
+	}
+	desired := map[netfilterTools.IPv4Subnet]netfilterTools.IPSetTimeout{
+		host("10.0.0.1"): ttl(30),
+		host("10.0.0.2"): nil,
+		subnet:           nil,
+	}
+	add, del, changed := planSubnetChanges(current, desired)
+	if len(add) != 0 || len(del) != 0 || len(changed) != 0 {
+		t.Errorf("unchanged resync: add %v, del %v, flush %v; want nothing", add, del, changed)
+	}
+
+	// Продление таймаута перезаписывает элемент, но соединения не сбрасывает
+	desired[host("10.0.0.1")] = ttl(60)
+	desired[host("10.0.0.3")] = ttl(60)
+	delete(desired, host("10.0.0.2"))
+	add, del, changed = planSubnetChanges(current, desired)
+	if len(add) != 2 || len(del) != 1 {
+		t.Errorf("add %v, del %v; want 2 additions and 1 deletion", add, del)
+	}
+	slices.SortFunc(changed, func(a, b netip.Prefix) int { return a.Addr().Compare(b.Addr()) })
+	expected := []netip.Prefix{netip.MustParsePrefix("10.0.0.2/32"), netip.MustParsePrefix("10.0.0.3/32")}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("flush mismatch.\nExpected: %v\nGot: %v", expected, changed)
+	}
+}
+
+func TestDelStaleSubnetsKeepsLiveDNSAddresses(t *testing.T) {
+	g := &RuleSet{
+		app: &App{recordsCache: recordsCache.New()},
+		spec: rulesets.Spec{Rules: []*models.Rule{
+			{Type: models.RuleTypeDomain, Rule: "example.com", Enable: true},
+		}},
+	}
+	host := func(s string) netfilterTools.IPv4Subnet {
+		return netfilterTools.IPv4Subnet{Address: netip.MustParseAddr(s).As4()}
+	}
+	// План рассчитан до того, как DNS снова вернул 10.0.0.1 для домена группы
+	planned := []netfilterTools.IPv4Subnet{host("10.0.0.1"), host("10.0.0.2"), {Address: [4]byte{10, 0, 0, 0}, CIDR: 8}}
+	g.app.recordsCache.AddAddress("example.com", net.IPv4(10, 0, 0, 1).To4(), 60)
+	g.app.recordsCache.AddAddress("other.com", net.IPv4(10, 0, 0, 2).To4(), 60)
+
+	var deleted []netfilterTools.IPv4Subnet
+	del := func(subnets []netfilterTools.IPv4Subnet, _ netfilterTools.BulkProgress) error {
+		deleted = append(deleted, subnets...)
+		return nil
+	}
+	var done int
+	if err := delStaleSubnets(g, g.ipset, planned, del, func(n int) { done = n }); err != nil {
+		t.Fatalf("delStaleSubnets failed: %v", err)
+	}
+	if expected := planned[1:]; !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("deleted mismatch.\nExpected: %v\nGot: %v", expected, deleted)
+	}
+	if done != len(planned) {
+		t.Errorf("progress = %d, want %d", done, len(planned))
+	}
+}
+
+func TestSyncBatchesDropsPlanForReplacedIPSet(t *testing.T) {
+	planned := &netfilterTools.IPSet{}
+	g := &RuleSet{ipset: planned}
+	subnets := make([]int, netfilterTools.IPSetBatchSize*3)
+
+	var applied int
+	apply := func(batch []int) error {
+		applied += len(batch)
+		// Группу выключили и включили заново между пачками
+		g.ipset = &netfilterTools.IPSet{}
+		return nil
+	}
+	if err := syncBatches(g, planned, subnets, apply, nil); !errors.Is(err, errIPSetReplaced) {
+		t.Errorf("syncBatches() = %v, want errIPSetReplaced", err)
+	}
+	if applied != netfilterTools.IPSetBatchSize {
+		t.Errorf("applied %d subnets, want only the first batch of %d", applied, netfilterTools.IPSetBatchSize)
+	}
+}
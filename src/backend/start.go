@@ -5,18 +5,21 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
 	"runtime/debug"
 	"strconv"
 	"time"
 
 	"magitrickle/api"
+	"magitrickle/utils/asnDataset"
 	"magitrickle/utils/dnsMITMProxy"
 	"magitrickle/utils/iptables"
 	"magitrickle/utils/netfilterTools"
 	"magitrickle/utils/recordsCache"
 
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netlink/nl"
 )
@@ -53,25 +56,31 @@ func (a *App) Start(ctx context.Context) (err error) {
 
 	a.recordsCache = recordsCache.New()
 	a.recordsCache.StartCleanup(ctx, 30*time.Second)
+	a.restoreRecordsCache()
+	a.startRecordsSnapshot(ctx)
+	defer a.saveRecordsCache()
 
-	nfh, err := netfilterTools.New(a.config.Netfilter.IPTables.ChainPrefix, a.config.Netfilter.IPSet.TablePrefix, a.config.Netfilter.DisableIPv4, a.config.Netfilter.DisableIPv6, a.config.Netfilter.StartMarkTableIndex)
+	a.asnDataset = asnDataset.New(a.config.ASN.DatasetPath)
+
+	nfh, err := netfilterTools.New(a.config.Netfilter.Backend, a.config.Netfilter.IPTables.ChainPrefix, a.config.Netfilter.IPSet.TablePrefix, a.config.Netfilter.DisableIPv4, a.config.Netfilter.DisableIPv6, a.config.Netfilter.StartMarkTableIndex, a.config.Netfilter.FwmarkMask)
 	if err != nil {
 		return fmt.Errorf("netfilter helper init fail: %w", err)
 	}
 	a.nfHelper = nfh
+	a.loadAllocations()
+	defer func() {
+		_ = a.nfHelper.Close()
+	}()
+	log.Info().Str("backend", a.nfHelper.Backend()).Msg("netfilter backend selected")
+	a.nfHelper.OutputBypass = a.outputBypass()
 
-	for _, ipt := range []*iptables.IPTables{a.nfHelper.IPTables4, a.nfHelper.IPTables6} {
-		if ipt == nil {
-			continue
-		}
-		ipt.RegisterChainPatch("filter", "FORWARD")
-		ipt.RegisterChainPatch("mangle", "PREROUTING")
-		ipt.RegisterChainPatch("nat", "PREROUTING")
-		ipt.RegisterChainPatch("nat", "POSTROUTING")
-	}
+	// Проверка до очистки: отсутствующий ip6tables иначе прервёт запуск на Clean
+	logCapabilities(a.nfHelper.Preflight())
 
-	if err := a.nfHelper.CleanIPTables(); err != nil {
-		return fmt.Errorf("failed to clear iptables: %w", err)
+	registerChainPatches(a.nfHelper)
+
+	if err := a.nfHelper.Clean(); err != nil {
+		return fmt.Errorf("failed to clear netfilter rules: %w", err)
 	}
 
 	linkUpdateChannel, linkUpdateDone, err := subscribeLinkUpdates()
@@ -119,18 +128,33 @@ func (a *App) Start(ctx context.Context) (err error) {
 
 	if !a.config.DNSProxy.DisableRemap53 {
 		a.dnsOverrider = a.nfHelper.PortRemap("DNSOR", 53, a.config.DNSProxy.Host.Port, interfaceAddrs)
-		if err := a.dnsOverrider.Enable(); err != nil {
+		if err := a.dnsOverrider.Enable(); errors.Is(err, netfilterTools.ErrCapabilityMissing) {
+			log.Error().Err(err).Msg("DNS override is not enabled")
+			a.dnsOverrider = nil
+		} else if err != nil {
 			return fmt.Errorf("failed to override DNS: %v", err)
 		}
 		defer func() {
-			_ = a.dnsOverrider.Disable()
+			if a.dnsOverrider != nil {
+				_ = a.dnsOverrider.Disable()
+			}
 		}()
 	}
 
+	restoredSets := a.loadIPSets()
 	for _, group := range a.ruleSetSnapshot() {
 		if err := group.Enable(); err != nil {
+			if errors.Is(err, netfilterTools.ErrCapabilityMissing) {
+				log.Error().Err(err).Str("group", group.IDValue().String()).Msg("group is not enabled")
+				continue
+			}
 			return fmt.Errorf("failed to enable group: %w", err)
 		}
+		if entries := restoredSets[group.IDValue()]; entries != nil {
+			if err := group.restoreEntries(entries, time.Now()); err != nil {
+				log.Warn().Err(err).Str("group", group.IDValue().String()).Msg("failed to restore group ipset")
+			}
+		}
 		if err := group.Sync(); err != nil {
 			return fmt.Errorf("failed to sync group: %w", err)
 		}
@@ -140,8 +164,11 @@ func (a *App) Start(ctx context.Context) (err error) {
 			_ = group.Disable()
 		}
 	}()
+	// Наборы сохраняются до выключения групп, которое их удаляет
+	defer a.saveIPSets()
 
 	go a.StartSubscriptionAutoUpdate(newCtx)
+	a.startReconciler(newCtx)
 
 	for {
 		select {
@@ -157,6 +184,21 @@ func (a *App) Start(ctx context.Context) (err error) {
 	}
 }
 
+// registerChainPatches регистрирует встроенные цепочки, в которые добавляются переходы в цепочки групп
+func registerChainPatches(nh *netfilterTools.Helper) {
+	for _, ipt := range []*iptables.IPTables{nh.IPTables4, nh.IPTables6} {
+		if ipt == nil {
+			continue
+		}
+		ipt.RegisterChainPatch("filter", "FORWARD")
+		ipt.RegisterChainPatch("filter", "OUTPUT")
+		ipt.RegisterChainPatch("mangle", "PREROUTING")
+		ipt.RegisterChainPatch("mangle", "OUTPUT")
+		ipt.RegisterChainPatch("nat", "PREROUTING")
+		ipt.RegisterChainPatch("nat", "POSTROUTING")
+	}
+}
+
 func (a *App) ForceCommitIPTables() error {
 	if a.nfHelper == nil {
 		return nil
@@ -179,6 +221,37 @@ func (a *App) ForceCommitIPTables() error {
 	return nil
 }
 
+// outputBypass собирает исключения для трафика роутера: upstream DNS-прокси
+// и адреса из netfilter.outputBypass (например, серверы VPN-туннелей)
+func (a *App) outputBypass() []netfilterTools.OutputBypass {
+	var bypass []netfilterTools.OutputBypass
+
+	upstream := a.config.DNSProxy.Upstream
+	if addr, err := netip.ParseAddr(upstream.Address); err == nil {
+		addr = addr.Unmap()
+		prefix := netip.PrefixFrom(addr, addr.BitLen())
+		for _, protocol := range []string{"udp", "tcp"} {
+			bypass = append(bypass, netfilterTools.OutputBypass{Prefix: prefix, Protocol: protocol, Port: upstream.Port})
+		}
+	}
+
+	for _, entry := range a.config.Netfilter.OutputBypass {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				log.Warn().Str("entry", entry).Msg("invalid output bypass entry, skipping")
+				continue
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		bypass = append(bypass, netfilterTools.OutputBypass{Prefix: prefix.Masked()})
+	}
+
+	return bypass
+}
+
 func (a *App) setupLogging() {
 	switch a.config.LogLevel {
 	case "trace":
@@ -0,0 +1,61 @@
+package magitrickle
+
+import (
+	"fmt"
+	"time"
+
+	"magitrickle/app"
+	"magitrickle/utils/netfilterTools"
+)
+
+// Stats возвращает счётчики трафика группы и приращения с предыдущего запроса;
+// у выключенной группы счётчиков нет
+func (g *RuleSet) Stats() (app.GroupStats, error) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	stats := app.GroupStats{Time: time.Now()}
+	if !g.Enabled() || g.ipsetToLink == nil {
+		g.lastCounters, g.lastCountersTime = nil, time.Time{}
+		return stats, nil
+	}
+
+	counters, err := g.ipsetToLink.Counters()
+	if err != nil {
+		return stats, fmt.Errorf("failed to read group counters: %w", err)
+	}
+
+	if !g.lastCountersTime.IsZero() {
+		stats.Interval = stats.Time.Sub(g.lastCountersTime)
+	}
+	for _, counter := range counters {
+		family := app.TrafficStats{
+			Family:  counter.Family,
+			Tx:      counter.Tx,
+			Rx:      counter.Rx,
+			TxDelta: counter.Tx,
+			RxDelta: counter.Rx,
+		}
+		for _, last := range g.lastCounters {
+			if last.Family == counter.Family {
+				family.TxDelta = trafficDelta(last.Tx, counter.Tx)
+				family.RxDelta = trafficDelta(last.Rx, counter.Rx)
+			}
+		}
+		stats.Families = append(stats.Families, family)
+	}
+	g.lastCounters, g.lastCountersTime = counters, stats.Time
+	return stats, nil
+}
+
+// trafficDelta возвращает прирост счётчиков; уменьшение означает, что правила группы
+// пересоздавались и счётчики начались с нуля
+func trafficDelta(last, current netfilterTools.Traffic) netfilterTools.Traffic {
+	if current.Packets < last.Packets || current.Bytes < last.Bytes {
+		return current
+	}
+	return netfilterTools.Traffic{
+		Packets: current.Packets - last.Packets,
+		Bytes:   current.Bytes - last.Bytes,
+	}
+}
@@ -160,6 +160,9 @@ func detectSubscriptionRuleType(pattern string) string {
 	if isValidSubnet(p) {
 		return "subnet"
 	}
+	if isValidASN(p) {
+		return "asn"
+	}
 	if isValidNamespace(p) {
 		return "namespace"
 	}
@@ -66,3 +66,16 @@ func TestSameRulesIgnoresOrder(t *testing.T) {
 		t.Fatal("expected rule comparison to ignore order for equal content")
 	}
 }
+
+func TestParseRulesDetectsASN(t *testing.T) {
+	rules := ParseRules("AS32934\nas13335\nexample.com\nAS99999999999\n")
+	want := []string{models.RuleTypeASN, models.RuleTypeASN, models.RuleTypeNamespace, models.RuleTypeNamespace}
+	if len(rules) != len(want) {
+		t.Fatalf("expected %d rules, got %d", len(want), len(rules))
+	}
+	for i, rule := range rules {
+		if rule.Type != want[i] {
+			t.Errorf("rule %q type = %q, want %q", rule.Rule, rule.Type, want[i])
+		}
+	}
+}
@@ -3,6 +3,8 @@ package subscriptions
 import (
 	"strings"
 
+	"magitrickle/utils/asnDataset"
+
 	"github.com/dlclark/regexp2"
 )
 
@@ -10,6 +12,7 @@ var (
 	domainCharRe   = regexp2.MustCompile(`^[a-zA-Z0-9-.]+$`, 0)
 	wildcardCharRe = regexp2.MustCompile(`^[a-zA-Z0-9\-.*?]+$`, 0)
 	subnetRe       = regexp2.MustCompile(`^(\d{1,3})\.(\d{1,3})\.(\d{1,3})\.(\d{1,3})(?:\/(\d{1,2}))?$`, 0)
+	asnRe          = regexp2.MustCompile(`^AS\d{1,10}$`, regexp2.IgnoreCase)
 )
 
 func isValidWildcard(pattern string) bool {
@@ -95,6 +98,15 @@ func isValidIPv6(ip string) bool {
 	return true
 }
 
+func isValidASN(pattern string) bool {
+	ok, _ := asnRe.MatchString(pattern)
+	if !ok {
+		return false
+	}
+	_, err := asnDataset.ParseASN(pattern)
+	return err == nil
+}
+
 func isValidRegex(pattern string) bool {
 	re, err := regexp2.Compile(pattern, 0)
 	return err == nil && re != nil
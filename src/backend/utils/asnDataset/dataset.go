@@ -0,0 +1,157 @@
+package asnDataset
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"magitrickle/utils/cidrTools"
+)
+
+var ErrInvalidASN = errors.New("invalid asn")
+
+// ParseASN разбирает номер автономной системы в форматах "AS32934", "as32934" и "32934"
+func ParseASN(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && strings.EqualFold(s[:2], "AS") {
+		s = s[2:]
+	}
+	if s == "" {
+		return 0, ErrInvalidASN
+	}
+	asn, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, ErrInvalidASN
+	}
+	return uint32(asn), nil
+}
+
+// Dataset – локальная база соответствия ASN → анонсируемые префиксы.
+// Файл перечитывается автоматически при изменении его размера или времени модификации.
+//
+// Поддерживаемые форматы строк:
+//
+//	1.0.0.0/24 13335
+//	1.0.0.0/24,AS13335
+//	1.0.0.0	24	13335          (формат pfx2as от CAIDA/RouteViews)
+//	1.0.0.0	24	13335_4826     (MOAS – префикс относится к нескольким ASN)
+type Dataset struct {
+	locker sync.Mutex
+
+	path     string
+	modTime  time.Time
+	size     int64
+	loaded   bool
+	prefixes map[uint32][]netip.Prefix
+}
+
+func New(path string) *Dataset {
+	return &Dataset{path: path}
+}
+
+// Path возвращает путь к файлу базы
+func (d *Dataset) Path() string {
+	return d.path
+}
+
+// Lookup возвращает агрегированный список префиксов, анонсируемых ASN
+func (d *Dataset) Lookup(asn uint32) ([]netip.Prefix, error) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+
+	if err := d.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return d.prefixes[asn], nil
+}
+
+func (d *Dataset) reloadIfChanged() error {
+	stat, err := os.Stat(d.path)
+	if err != nil {
+		d.prefixes = nil
+		d.loaded = false
+		return fmt.Errorf("failed to stat asn dataset: %w", err)
+	}
+	if d.loaded && stat.ModTime().Equal(d.modTime) && stat.Size() == d.size {
+		return nil
+	}
+
+	file, err := os.Open(d.path)
+	if err != nil {
+		return fmt.Errorf("failed to open asn dataset: %w", err)
+	}
+	defer file.Close()
+
+	prefixes, err := parse(file)
+	if err != nil {
+		return fmt.Errorf("failed to parse asn dataset: %w", err)
+	}
+
+	d.prefixes = prefixes
+	d.modTime = stat.ModTime()
+	d.size = stat.Size()
+	d.loaded = true
+	return nil
+}
+
+func parse(r io.Reader) (map[uint32][]netip.Prefix, error) {
+	raw := make(map[uint32][]netip.Prefix)
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ','
+		})
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: not enough fields", lineNum)
+		}
+
+		var prefix netip.Prefix
+		var err error
+		asnFields := fields[1:]
+		if strings.Contains(fields[0], "/") {
+			prefix, err = netip.ParsePrefix(fields[0])
+		} else {
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("line %d: not enough fields", lineNum)
+			}
+			prefix, err = netip.ParsePrefix(fields[0] + "/" + fields[1])
+			asnFields = fields[2:]
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		for _, field := range asnFields {
+			for _, asnStr := range strings.Split(field, "_") {
+				asn, err := ParseASN(asnStr)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w: %q", lineNum, err, asnStr)
+				}
+				raw[asn] = append(raw[asn], prefix)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for asn, list := range raw {
+		raw[asn] = cidrTools.Aggregate(list)
+	}
+	return raw, nil
+}
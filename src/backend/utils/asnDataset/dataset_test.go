@@ -0,0 +1,95 @@
+package asnDataset
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseASN(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint32
+		wantErr bool
+	}{
+		{"AS32934", 32934, false},
+		{"as13335", 13335, false},
+		{"15169", 15169, false},
+		{" AS1 ", 1, false},
+		{"AS", 0, true},
+		{"ASX1", 0, true},
+		{"-1", 0, true},
+		{"4294967296", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseASN(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseASN(%q) err = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseASN(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDatasetLookupAggregatesAndReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.txt")
+	content := "# comment\n" +
+		"157.240.0.0/17 32934\n" +
+		"157.240.128.0/17,AS32934\n" +
+		"31.13.24.0\t21\t32934\n" +
+		"2a03:2880::/32 AS32934\n" +
+		"1.1.1.0\t24\t13335_32934\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(path)
+	got, err := d.Lookup(32934)
+	if err != nil {
+		t.Fatalf("Lookup() error: %v", err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("1.1.1.0/24"),
+		netip.MustParsePrefix("31.13.24.0/21"),
+		netip.MustParsePrefix("157.240.0.0/16"),
+		netip.MustParsePrefix("2a03:2880::/32"),
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Lookup(32934) = %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte("8.8.8.0/24 15169\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = d.Lookup(32934)
+	if err != nil {
+		t.Fatalf("Lookup() after reload error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected no prefixes after reload, got %v", got)
+	}
+	got, _ = d.Lookup(15169)
+	if !slices.Equal(got, []netip.Prefix{netip.MustParsePrefix("8.8.8.0/24")}) {
+		t.Fatalf("Lookup(15169) = %v", got)
+	}
+}
+
+func TestDatasetRejectsMalformedLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.txt")
+	if err := os.WriteFile(path, []byte("10.0.0.0/8 notanasn\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(path).Lookup(1); err == nil {
+		t.Fatal("expected error for malformed dataset")
+	}
+}
@@ -0,0 +1,69 @@
+package cidrTools
+
+import (
+	"net/netip"
+	"slices"
+)
+
+// Aggregate возвращает минимальное покрытие переданных префиксов:
+// вложенные префиксы и дубликаты отбрасываются, соседние половины
+// объединяются в родительский префикс. IPv4 и IPv6 обрабатываются раздельно,
+// результат отсортирован (сначала IPv4, затем IPv6).
+func Aggregate(prefixes []netip.Prefix) []netip.Prefix {
+	normalized := make([]netip.Prefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if !prefix.IsValid() {
+			continue
+		}
+		addr := prefix.Addr()
+		bits := prefix.Bits()
+		if addr.Is4In6() {
+			if bits < 96 {
+				continue
+			}
+			addr = addr.Unmap()
+			bits -= 96
+		}
+		normalized = append(normalized, netip.PrefixFrom(addr, bits).Masked())
+	}
+
+	slices.SortFunc(normalized, comparePrefix)
+
+	out := make([]netip.Prefix, 0, len(normalized))
+	for _, prefix := range normalized {
+		if len(out) > 0 && out[len(out)-1].Overlaps(prefix) {
+			// После сортировки перекрывающийся префикс всегда вложен в предыдущий
+			continue
+		}
+		out = append(out, prefix)
+
+		for len(out) >= 2 {
+			parent, ok := mergeSiblings(out[len(out)-2], out[len(out)-1])
+			if !ok {
+				break
+			}
+			out = append(out[:len(out)-2], parent)
+		}
+	}
+	return out
+}
+
+func comparePrefix(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
+
+// mergeSiblings объединяет два префикса, если они являются половинами одного родителя
+func mergeSiblings(lower, upper netip.Prefix) (netip.Prefix, bool) {
+	bits := lower.Bits()
+	if bits == 0 || bits != upper.Bits() || lower.Addr().BitLen() != upper.Addr().BitLen() {
+		return netip.Prefix{}, false
+	}
+	parent := netip.PrefixFrom(lower.Addr(), bits-1).Masked()
+	if parent.Addr() != lower.Addr() || !parent.Contains(upper.Addr()) {
+		return netip.Prefix{}, false
+	}
+	return parent, true
+}
@@ -0,0 +1,67 @@
+package cidrTools
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func parsePrefixes(t *testing.T, list ...string) []netip.Prefix {
+	t.Helper()
+	out := make([]netip.Prefix, len(list))
+	for i, s := range list {
+		out[i] = netip.MustParsePrefix(s)
+	}
+	return out
+}
+
+func TestAggregateMergesAdjacent(t *testing.T) {
+	got := Aggregate(parsePrefixes(t,
+		"10.0.1.0/24",
+		"10.0.0.0/24",
+		"10.0.2.0/24",
+		"10.0.3.0/24",
+		"10.0.5.0/24",
+	))
+	want := parsePrefixes(t, "10.0.0.0/22", "10.0.5.0/24")
+	if !slices.Equal(got, want) {
+		t.Fatalf("Aggregate() = %v, want %v", got, want)
+	}
+}
+
+func TestAggregateDropsCoveredAndDuplicates(t *testing.T) {
+	got := Aggregate(parsePrefixes(t,
+		"192.168.0.0/16",
+		"192.168.10.0/24",
+		"192.168.10.7/32",
+		"192.168.0.0/16",
+		"172.16.0.1/32",
+		"172.16.0.1/32",
+	))
+	want := parsePrefixes(t, "172.16.0.1/32", "192.168.0.0/16")
+	if !slices.Equal(got, want) {
+		t.Fatalf("Aggregate() = %v, want %v", got, want)
+	}
+}
+
+func TestAggregateDoesNotMergeUnalignedNeighbours(t *testing.T) {
+	got := Aggregate(parsePrefixes(t, "10.0.1.0/24", "10.0.2.0/24"))
+	want := parsePrefixes(t, "10.0.1.0/24", "10.0.2.0/24")
+	if !slices.Equal(got, want) {
+		t.Fatalf("Aggregate() = %v, want %v", got, want)
+	}
+}
+
+func TestAggregateKeepsFamiliesApart(t *testing.T) {
+	got := Aggregate(parsePrefixes(t,
+		"2001:db8::/33",
+		"2001:db8:8000::/33",
+		"0.0.0.0/1",
+		"128.0.0.0/1",
+		"::ffff:10.0.0.0/120",
+	))
+	want := parsePrefixes(t, "0.0.0.0/0", "2001:db8::/32")
+	if !slices.Equal(got, want) {
+		t.Fatalf("Aggregate() = %v, want %v", got, want)
+	}
+}
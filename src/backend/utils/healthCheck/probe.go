@@ -0,0 +1,246 @@
+package healthCheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// TypeLink – интерфейс считается работоспособным, если он существует и поднят
+	TypeLink = "link"
+	// TypeICMP – эхо-запрос на адрес Target
+	TypeICMP = "icmp"
+	// TypeTCP – установка TCP-соединения с Target (host:port)
+	TypeTCP = "tcp"
+	// TypeHTTP – HTTP-запрос GET на Target (URL); подходит любой ответ сервера
+	TypeHTTP = "http"
+	// TypeNeighbor – доступность соседа Target (ARP для IPv4, NDP для IPv6) на интерфейсе
+	TypeNeighbor = "neighbor"
+)
+
+var (
+	ErrLinkDown        = errors.New("interface is down")
+	ErrUnknownType     = errors.New("unknown health check type")
+	ErrNoReply         = errors.New("no echo reply")
+	ErrInvalidICMPAddr = errors.New("invalid icmp target")
+	ErrNoNeighbor      = errors.New("neighbor unreachable")
+)
+
+// Check описывает проверку работоспособности интерфейса
+type Check struct {
+	Type    string
+	Target  string
+	Timeout time.Duration
+}
+
+// Probe проверяет интерфейс iface: сначала его состояние, затем, в зависимости от типа,
+// доступность Target через этот интерфейс (сокет привязывается к нему через SO_BINDTODEVICE)
+func Probe(ctx context.Context, iface string, check Check) error {
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return fmt.Errorf("failed to get interface: %w", err)
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		return ErrLinkDown
+	}
+
+	if check.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, check.Timeout)
+		defer cancel()
+	}
+
+	switch check.Type {
+	case "", TypeLink:
+		return nil
+	case TypeICMP:
+		return probeICMP(ctx, iface, check.Target)
+	case TypeTCP:
+		return probeTCP(ctx, iface, check.Target)
+	case TypeHTTP:
+		return probeHTTP(ctx, iface, check.Target)
+	case TypeNeighbor:
+		return probeNeighbor(ctx, link, check.Target)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownType, check.Type)
+	}
+}
+
+// bindToDevice возвращает функцию Control, привязывающую сокет к интерфейсу
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		err := c.Control(func(fd uintptr) {
+			bindErr = unix.BindToDevice(int(fd), iface)
+		})
+		if err != nil {
+			return err
+		}
+		return bindErr
+	}
+}
+
+func probeTCP(ctx context.Context, iface, target string) error {
+	dialer := net.Dialer{Control: bindToDevice(iface)}
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func probeHTTP(ctx context.Context, iface, target string) error {
+	dialer := net.Dialer{Control: bindToDevice(iface)}
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext:       dialer.DialContext,
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func probeICMP(ctx context.Context, iface, target string) error {
+	addr, err := net.ResolveIPAddr("ip", target)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidICMPAddr, err)
+	}
+
+	network, proto := "ip4:icmp", ipv4.ICMPTypeEcho.Protocol()
+	var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if addr.IP.To4() == nil {
+		network, proto = "ip6:ipv6-icmp", ipv6.ICMPTypeEchoRequest.Protocol()
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	lc := net.ListenConfig{Control: bindToDevice(iface)}
+	conn, err := lc.ListenPacket(ctx, network, "")
+	if err != nil {
+		return fmt.Errorf("failed to open icmp socket: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	id, seq := rand.IntN(0xffff), rand.IntN(0xffff)
+	request, err := (&icmp.Message{
+		Type: echoType,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("magitrickle")},
+	}).Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(request, addr); err != nil {
+		return fmt.Errorf("failed to send echo request: %w", err)
+	}
+
+	// Сырой сокет получает все входящие ICMP-сообщения, поэтому чужие ответы пропускаются
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.EAGAIN) || isTimeout(err) {
+				return ErrNoReply
+			}
+			return err
+		}
+		if ip, ok := from.(*net.IPAddr); !ok || !ip.IP.Equal(addr.IP) {
+			continue
+		}
+		reply, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.ID == id && echo.Seq == seq {
+			return nil
+		}
+	}
+}
+
+// probeNeighbor запускает разрешение адреса target ядром и ждёт подтверждения доступности.
+// Если к концу проверки сосед не подтверждён, но и не признан ядром недоступным
+// (STALE, DELAY, PROBE), он считается доступным: ядро само переведёт его в FAILED.
+func probeNeighbor(ctx context.Context, link netlink.Link, target string) error {
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return fmt.Errorf("%w: invalid address %q", ErrNoNeighbor, target)
+	}
+	family := netlink.FAMILY_V4
+	if ip.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	// NTF_USE не меняет запись, а только инициирует ARP/NDP-запрос
+	_ = netlink.NeighSet(&netlink.Neigh{
+		LinkIndex: link.Attrs().Index,
+		Family:    family,
+		IP:        ip,
+		Flags:     netlink.NTF_USE,
+	})
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		state, err := neighborState(link.Attrs().Index, family, ip)
+		if err != nil {
+			return err
+		}
+		switch {
+		case state&(netlink.NUD_REACHABLE|netlink.NUD_PERMANENT|netlink.NUD_NOARP) != 0:
+			return nil
+		case state&netlink.NUD_FAILED != 0:
+			return ErrNoNeighbor
+		}
+
+		select {
+		case <-ctx.Done():
+			if state&(netlink.NUD_STALE|netlink.NUD_DELAY|netlink.NUD_PROBE) != 0 {
+				return nil
+			}
+			return ErrNoNeighbor
+		case <-ticker.C:
+		}
+	}
+}
+
+func neighborState(linkIndex, family int, ip net.IP) (int, error) {
+	neighs, err := netlink.NeighList(linkIndex, family)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list neighbors: %w", err)
+	}
+	for _, neigh := range neighs {
+		if neigh.IP.Equal(ip) {
+			return neigh.State, nil
+		}
+	}
+	return netlink.NUD_NONE, nil
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,108 @@
+package healthCheck
+
+import (
+	"fmt"
+	"time"
+)
+
+// State – состояние интерфейса по результатам проверок
+type State struct {
+	Interface string
+	Healthy   bool
+	LastCheck time.Time
+	LastError string
+
+	checked bool
+	fails   int
+	rises   int
+}
+
+// Switch описывает смену активного интерфейса
+type Switch struct {
+	Time   time.Time
+	From   string
+	To     string
+	Reason string
+}
+
+// Tracker выбирает активный интерфейс из упорядоченного списка: первый работоспособный.
+// Интерфейс признаётся неработоспособным после failThreshold неудачных проверок подряд
+// и возвращается после riseThreshold успешных; первая проверка определяет состояние сразу.
+// Если неработоспособны все интерфейсы, активный не меняется. Tracker не потокобезопасен.
+type Tracker struct {
+	failThreshold int
+	riseThreshold int
+	states        []State
+	active        int
+}
+
+func NewTracker(ifaces []string, failThreshold, riseThreshold int) *Tracker {
+	states := make([]State, len(ifaces))
+	for i, iface := range ifaces {
+		states[i] = State{Interface: iface, Healthy: true}
+	}
+	return &Tracker{
+		failThreshold: max(failThreshold, 1),
+		riseThreshold: max(riseThreshold, 1),
+		states:        states,
+	}
+}
+
+// Active возвращает имя активного интерфейса
+func (t *Tracker) Active() string {
+	return t.states[t.active].Interface
+}
+
+// States возвращает копию состояний интерфейсов в порядке приоритета
+func (t *Tracker) States() []State {
+	return append([]State(nil), t.states...)
+}
+
+// Update учитывает результаты проверок (по одному на интерфейс, nil – успех)
+// и сообщает о смене активного интерфейса
+func (t *Tracker) Update(now time.Time, results []error) (Switch, bool) {
+	for i := range t.states {
+		if i >= len(results) {
+			break
+		}
+		state := &t.states[i]
+		state.LastCheck = now
+		if err := results[i]; err != nil {
+			state.LastError = err.Error()
+			state.fails++
+			state.rises = 0
+			if !state.checked || state.fails >= t.failThreshold {
+				state.Healthy = false
+			}
+		} else {
+			state.LastError = ""
+			state.rises++
+			state.fails = 0
+			if !state.checked || state.rises >= t.riseThreshold {
+				state.Healthy = true
+			}
+		}
+		state.checked = true
+	}
+
+	next := t.active
+	for i, state := range t.states {
+		if state.Healthy {
+			next = i
+			break
+		}
+	}
+	if next == t.active {
+		return Switch{}, false
+	}
+
+	prev := t.states[t.active]
+	sw := Switch{Time: now, From: prev.Interface, To: t.states[next].Interface}
+	if next < t.active {
+		sw.Reason = fmt.Sprintf("%s recovered", sw.To)
+	} else {
+		sw.Reason = fmt.Sprintf("%s unhealthy: %s", prev.Interface, prev.LastError)
+	}
+	t.active = next
+	return sw, true
+}
@@ -0,0 +1,57 @@
+package healthCheck
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTrackerFailover(t *testing.T) {
+	tracker := NewTracker([]string{"wg0", "wg1"}, 2, 2)
+	now := time.Unix(1700000000, 0)
+	down := errors.New("timeout")
+
+	// Первая проверка сразу определяет состояние
+	sw, ok := tracker.Update(now, []error{down, nil})
+	if !ok || sw.From != "wg0" || sw.To != "wg1" {
+		t.Fatalf("expected switch wg0 -> wg1, got %+v (%v)", sw, ok)
+	}
+	if sw.Reason != "wg0 unhealthy: timeout" {
+		t.Errorf("unexpected reason %q", sw.Reason)
+	}
+
+	// Одной успешной проверки недостаточно для возврата
+	if _, ok := tracker.Update(now, []error{nil, nil}); ok {
+		t.Fatal("unexpected switch after single success")
+	}
+	sw, ok = tracker.Update(now, []error{nil, nil})
+	if !ok || sw.To != "wg0" || sw.Reason != "wg0 recovered" {
+		t.Fatalf("expected switch back to wg0, got %+v (%v)", sw, ok)
+	}
+
+	// Одна неудача не приводит к переключению
+	if _, ok := tracker.Update(now, []error{down, nil}); ok {
+		t.Fatal("unexpected switch after single failure")
+	}
+	if !tracker.States()[0].Healthy {
+		t.Error("wg0 must stay healthy below threshold")
+	}
+	if _, ok := tracker.Update(now, []error{down, nil}); !ok || tracker.Active() != "wg1" {
+		t.Fatalf("expected failover to wg1, active %s", tracker.Active())
+	}
+}
+
+func TestTrackerAllDown(t *testing.T) {
+	tracker := NewTracker([]string{"wg0", "wg1"}, 1, 1)
+	down := errors.New("down")
+
+	if _, ok := tracker.Update(time.Now(), []error{down, down}); ok {
+		t.Fatal("active interface must not change when all interfaces are down")
+	}
+	if tracker.Active() != "wg0" {
+		t.Errorf("active = %s, want wg0", tracker.Active())
+	}
+	if sw, ok := tracker.Update(time.Now(), []error{down, nil}); !ok || sw.To != "wg1" {
+		t.Fatalf("expected switch to wg1, got %+v (%v)", sw, ok)
+	}
+}
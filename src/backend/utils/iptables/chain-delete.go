@@ -15,6 +15,10 @@ func (c *chainDelete) Compile(chainName []byte, existedRules []Rule) ([]command,
 	}, 127, nil
 }
 
+func (c *chainDelete) Drifted(existedRules []Rule) bool {
+	return existedRules != nil
+}
+
 // No rules operations for removed chain
 func (c *chainDelete) Append(rule Rule) error {
 	return nil
@@ -35,6 +35,15 @@ func (c *chainOverride) Compile(chainName []byte, existedRules []Rule) ([]comman
 	return out, -128, nil
 }
 
+// Drifted сравнивает только число правил: iptables-save записывает правила
+// в собственной форме, и текстового совпадения может не быть
+func (c *chainOverride) Drifted(existedRules []Rule) bool {
+	c.sync.RLock()
+	defer c.sync.RUnlock()
+
+	return existedRules == nil || len(existedRules) != len(c.rules)
+}
+
 func (c *chainOverride) Append(r Rule) error {
 	c.sync.Lock()
 	defer c.sync.Unlock()
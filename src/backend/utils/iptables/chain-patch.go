@@ -1,6 +1,7 @@
 package iptables
 
 import (
+	"slices"
 	"sync"
 )
 
@@ -80,6 +81,21 @@ func (c *chainPatch) Compile(chainName []byte, existedRules []Rule) ([]command,
 	return out, 0, nil
 }
 
+func (c *chainPatch) Drifted(existedRules []Rule) bool {
+	c.sync.RLock()
+	defer c.sync.RUnlock()
+
+	for _, r := range c.orderedRules {
+		exists := slices.ContainsFunc(existedRules, func(existed Rule) bool {
+			return ruleEqual(existed, r.Rule)
+		})
+		if exists == (r.Option == optionDelete) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *chainPatch) Append(rule Rule) error {
 	return c.addRule(chainPatchRule{
 		Rule:   rule,
@@ -4,6 +4,9 @@ type priority int8
 
 type chain interface {
 	Compile(chainName []byte, existedRules []Rule) ([]command, priority, error)
+	// Drifted сообщает, отличается ли цепочка от зарегистрированного состояния.
+	// existedRules == nil – цепочки нет.
+	Drifted(existedRules []Rule) bool
 	Append(rule Rule) error
 	Insert(ruleNum int, rule Rule) error
 	Delete(rule Rule) error
@@ -0,0 +1,82 @@
+package iptables
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Counter – счётчики пакетов и байт правила
+type Counter struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// RuleCounter – правило цепочки (без оператора -A) и его счётчики
+type RuleCounter struct {
+	Rule Rule
+	Counter
+}
+
+// Counters возвращает счётчики правил цепочки chainName таблицы table в порядке правил.
+// Вывод iptables-save -c разбирается отдельно от GetCurrentRules: счётчики меняются
+// постоянно и не должны влиять на сравнение правил в Commit.
+func (ipt *IPTables) Counters(table, chainName string) ([]RuleCounter, error) {
+	data, err := ipt.executable.SaveCounters(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var counters []RuleCounter
+	var currentTable string
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		switch line[0] {
+		case '*':
+			currentTable = string(line[1:])
+
+		case '[':
+			// Формат: "[packets:bytes] -A CHAIN правило"
+			if currentTable != table {
+				continue
+			}
+			end := bytes.IndexByte(line, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid rule counters: %q", line)
+			}
+			counter, err := parseCounter(line[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid rule counters: %q: %w", line, err)
+			}
+			fields := splitFields(line[end+1:])
+			if len(fields) < 2 || string(fields[0]) != "-A" || string(fields[1]) != chainName {
+				continue
+			}
+			counters = append(counters, RuleCounter{Rule: fields[2:], Counter: counter})
+
+		case 'C':
+			currentTable = ""
+		}
+	}
+	return counters, nil
+}
+
+// parseCounter разбирает "packets:bytes"
+func parseCounter(data []byte) (Counter, error) {
+	packets, bytesCount, ok := bytes.Cut(data, []byte(":"))
+	if !ok {
+		return Counter{}, fmt.Errorf("missing separator")
+	}
+	p, err := strconv.ParseUint(string(packets), 10, 64)
+	if err != nil {
+		return Counter{}, err
+	}
+	b, err := strconv.ParseUint(string(bytesCount), 10, 64)
+	if err != nil {
+		return Counter{}, err
+	}
+	return Counter{Packets: p, Bytes: b}, nil
+}
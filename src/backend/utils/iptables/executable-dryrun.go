@@ -0,0 +1,39 @@
+package iptables
+
+import (
+	"bytes"
+	"errors"
+)
+
+var ErrNotDryRun = errors.New("iptables is not a dry run")
+
+// dryRun читает текущие правила, а скрипт iptables-restore запоминает вместо применения
+type dryRun struct {
+	Executable
+	script []byte
+}
+
+func (d *dryRun) Restore(data []byte) error {
+	d.script = bytes.Clone(data)
+	return nil
+}
+
+// DryRun возвращает пустой IPTables поверх того же iptables: Commit сравнивает
+// зарегистрированные цепочки с текущими правилами, но ничего не применяет
+func (ipt *IPTables) DryRun() *IPTables {
+	return NewIPTables(&dryRun{Executable: ipt.executable})
+}
+
+// Script возвращает скрипт iptables-restore, который применил бы Commit;
+// пустой скрипт – изменений нет. Доступен только для IPTables из DryRun.
+func (ipt *IPTables) Script() ([]byte, error) {
+	d, ok := ipt.executable.(*dryRun)
+	if !ok {
+		return nil, ErrNotDryRun
+	}
+	d.script = nil
+	if err := ipt.Commit(); err != nil {
+		return nil, err
+	}
+	return d.script, nil
+}
@@ -13,13 +13,16 @@ import (
 
 type FakeIPTables struct {
 	rules map[string]map[string][]Rule
-	proto Protocol
+	// counters – счётчики правил по ключу "table/chain/правило"
+	counters map[string]Counter
+	proto    Protocol
 }
 
 func NewFakeIPTables(proto Protocol) *FakeIPTables {
 	return &FakeIPTables{
-		rules: make(map[string]map[string][]Rule),
-		proto: proto,
+		rules:    make(map[string]map[string][]Rule),
+		counters: make(map[string]Counter),
+		proto:    proto,
 	}
 }
 
@@ -47,6 +50,11 @@ func (ipt *FakeIPTables) GetRules(table, chain string) [][]string {
 	return result
 }
 
+// SetCounter задаёт счётчики правила rule цепочки chain, которые вернёт SaveCounters
+func (ipt *FakeIPTables) SetCounter(table, chain string, rule []string, counter Counter) {
+	ipt.counters[table+"/"+chain+"/"+ruleFromStrings(rule).String()] = counter
+}
+
 // ChainExists проверяет существование chain
 func (ipt *FakeIPTables) ChainExists(table, chain string) bool {
 	if ipt.rules[table] == nil {
@@ -61,10 +69,22 @@ func (ipt *FakeIPTables) Proto() Protocol {
 }
 
 func (ipt *FakeIPTables) Save() ([]byte, error) {
+	return ipt.save("", false), nil
+}
+
+func (ipt *FakeIPTables) SaveCounters(table string) ([]byte, error) {
+	return ipt.save(table, true), nil
+}
+
+// save выводит правила таблицы table (пусто – всех таблиц), при withCounters – со счётчиками
+func (ipt *FakeIPTables) save(table string, withCounters bool) []byte {
 	buf := new(bytes.Buffer)
 
 	tableNames := make([]string, 0, len(ipt.rules))
 	for tableName := range ipt.rules {
+		if table != "" && tableName != table {
+			continue
+		}
 		tableNames = append(tableNames, tableName)
 	}
 	slices.Sort(tableNames)
@@ -89,6 +109,10 @@ func (ipt *FakeIPTables) Save() ([]byte, error) {
 		for _, chainName := range chainNames {
 			chainRules := table[chainName]
 			for _, r := range chainRules {
+				if withCounters {
+					counter := ipt.counters[tableName+"/"+chainName+"/"+r.String()]
+					fmt.Fprintf(buf, "[%d:%d] ", counter.Packets, counter.Bytes)
+				}
 				buf.WriteString("-A ")
 				buf.WriteString(chainName)
 				if len(r) > 0 {
@@ -100,7 +124,7 @@ func (ipt *FakeIPTables) Save() ([]byte, error) {
 		}
 		buf.WriteString("COMMIT\n")
 	}
-	return buf.Bytes(), nil
+	return buf.Bytes()
 }
 
 func (ipt *FakeIPTables) Restore(data []byte) error {
@@ -33,7 +33,15 @@ func (ipt *realIPTables) Proto() Protocol {
 }
 
 func (ipt *realIPTables) Save() ([]byte, error) {
-	cmd := exec.Command(ipt.saveCmd)
+	return ipt.save()
+}
+
+func (ipt *realIPTables) SaveCounters(table string) ([]byte, error) {
+	return ipt.save("-c", "-t", table)
+}
+
+func (ipt *realIPTables) save(args ...string) ([]byte, error) {
+	cmd := exec.Command(ipt.saveCmd, args...)
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
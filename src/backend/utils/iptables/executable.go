@@ -2,6 +2,8 @@ package iptables
 
 type Executable interface {
 	Save() ([]byte, error)
+	// SaveCounters возвращает правила таблицы table со счётчиками ("[packets:bytes] -A ...")
+	SaveCounters(table string) ([]byte, error)
 	Restore([]byte) error
 	Proto() Protocol
 }
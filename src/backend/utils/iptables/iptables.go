@@ -205,6 +205,29 @@ func splitFields(data []byte) [][]byte {
 	return fields
 }
 
+// Drift возвращает зарегистрированные цепочки (в виде table/chain), которые разошлись
+// с текущими правилами: удалены, изменены или лишились переходов. Commit их исправляет.
+func (ipt *IPTables) Drift() ([]string, error) {
+	ipt.sync.RLock()
+	defer ipt.sync.RUnlock()
+
+	curRules, err := ipt.GetCurrentRules()
+	if err != nil {
+		return nil, err
+	}
+
+	var drifted []string
+	for tableName, table := range ipt.rules {
+		for chainName, chain := range table {
+			if chain.Drifted(curRules[tableName][chainName]) {
+				drifted = append(drifted, tableName+"/"+chainName)
+			}
+		}
+	}
+	slices.Sort(drifted)
+	return drifted, nil
+}
+
 func (ipt *IPTables) Commit() (err error) {
 	ipt.sync.RLock()
 	defer ipt.sync.RUnlock()
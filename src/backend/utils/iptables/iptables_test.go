@@ -3,7 +3,9 @@
 package iptables
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -579,3 +581,230 @@ func TestPatchRemovesDuplicates(t *testing.T) {
 		t.Errorf("Patch duplicate removal failed.\nExpected: %v\nGot: %v", expected, rules)
 	}
 }
+
+// TestDryRun проверяет, что DryRun строит скрипт относительно текущих правил, не применяя его
+func TestDryRun(t *testing.T) {
+	fake := NewFakeIPTables(ProtocolIPv4)
+	fake.SetInitialRules("filter", "OLD_CHAIN", [][]string{{"-j", "ACCEPT"}})
+
+	ipt := NewIPTables(fake).DryRun()
+	if err := ipt.RegisterChainDelete("filter", "OLD_CHAIN"); err != nil {
+		t.Fatalf("RegisterChainDelete failed: %v", err)
+	}
+	if err := ipt.RegisterChainOverride("filter", "NEW_CHAIN"); err != nil {
+		t.Fatalf("RegisterChainOverride failed: %v", err)
+	}
+	if err := ipt.Append("filter", "NEW_CHAIN", "-j", "DROP"); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := ipt.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	script, err := ipt.Script()
+	if err != nil {
+		t.Fatalf("Script failed: %v", err)
+	}
+	for _, line := range []string{"-F NEW_CHAIN", "-A NEW_CHAIN -j DROP", "-X OLD_CHAIN"} {
+		if !strings.Contains(string(script), line+"\n") {
+			t.Errorf("script does not contain %q:\n%s", line, script)
+		}
+	}
+	if !fake.ChainExists("filter", "OLD_CHAIN") || fake.ChainExists("filter", "NEW_CHAIN") {
+		t.Error("dry run changed rules")
+	}
+
+	if _, err := NewIPTables(fake).Script(); !errors.Is(err, ErrNotDryRun) {
+		t.Errorf("Script on real iptables: err = %v, want ErrNotDryRun", err)
+	}
+}
+
+// TestDrift проверяет обнаружение удалённых цепочек и переходов и их восстановление
+func TestDrift(t *testing.T) {
+	fake := NewFakeIPTables(ProtocolIPv4)
+	fake.SetInitialRules("filter", "FORWARD", [][]string{{"-j", "ACCEPT"}})
+
+	ipt := NewIPTables(fake)
+	if err := ipt.RegisterChainPatch("filter", "FORWARD"); err != nil {
+		t.Fatalf("RegisterChainPatch failed: %v", err)
+	}
+	if err := ipt.RegisterChainOverride("filter", "MY_CHAIN"); err != nil {
+		t.Fatalf("RegisterChainOverride failed: %v", err)
+	}
+	if err := ipt.RegisterChainDelete("filter", "OLD_CHAIN"); err != nil {
+		t.Fatalf("RegisterChainDelete failed: %v", err)
+	}
+	if err := ipt.Append("filter", "MY_CHAIN", "-j", "DROP"); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := ipt.Insert("filter", "FORWARD", 1, "-j", "MY_CHAIN"); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := ipt.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	drifted, err := ipt.Drift()
+	if err != nil {
+		t.Fatalf("Drift failed: %v", err)
+	}
+	if len(drifted) != 0 {
+		t.Errorf("unexpected drift after commit: %v", drifted)
+	}
+
+	// Сторонний скрипт сбросил цепочку, переход и вернул удалённую цепочку
+	delete(fake.rules["filter"], "MY_CHAIN")
+	fake.SetInitialRules("filter", "FORWARD", [][]string{{"-j", "ACCEPT"}})
+	fake.SetInitialRules("filter", "OLD_CHAIN", nil)
+
+	drifted, err = ipt.Drift()
+	if err != nil {
+		t.Fatalf("Drift failed: %v", err)
+	}
+	expected := []string{"filter/FORWARD", "filter/MY_CHAIN", "filter/OLD_CHAIN"}
+	if !reflect.DeepEqual(drifted, expected) {
+		t.Errorf("Drift mismatch.\nExpected: %v\nGot: %v", expected, drifted)
+	}
+
+	if err := ipt.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if drifted, _ = ipt.Drift(); len(drifted) != 0 {
+		t.Errorf("drift not repaired: %v", drifted)
+	}
+	if got := fake.GetRules("filter", "MY_CHAIN"); !reflect.DeepEqual(got, [][]string{{"-j", "DROP"}}) {
+		t.Errorf("MY_CHAIN not restored: %v", got)
+	}
+}
+
+// failingRestore отклоняет скрипты, содержащие reject, как iptables без нужного модуля
+type failingRestore struct {
+	*FakeIPTables
+	reject string
+}
+
+func (f *failingRestore) Restore(data []byte) error {
+	if strings.Contains(string(data), f.reject) {
+		return errors.New("iptables-restore failed: exit status 2: Couldn't load match `set'")
+	}
+	return f.FakeIPTables.Restore(data)
+}
+
+// TestProbe проверяет, что проверочная цепочка удаляется, а ошибка iptables-restore возвращается
+func TestProbe(t *testing.T) {
+	fake := NewFakeIPTables(ProtocolIPv4)
+	fake.SetInitialRules("filter", "FORWARD", [][]string{{"-j", "ACCEPT"}})
+	ipt := NewIPTables(&failingRestore{FakeIPTables: fake, reject: "-m set"})
+
+	if err := ipt.Available(); err != nil {
+		t.Fatalf("Available failed: %v", err)
+	}
+	if err := ipt.Probe("mangle", "MT_PROBE", []string{"-m", "conntrack", "--ctdir", "REPLY", "-j", "RETURN"}, []string{"-j", "MARK", "--set-mark", "1"}); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if fake.ChainExists("mangle", "MT_PROBE") {
+		t.Error("probe chain was not deleted")
+	}
+	if err := ipt.Probe("filter", "MT_PROBE", []string{"-m", "set", "--match-set", "mt_probe_4", "dst", "-j", "RETURN"}); err == nil {
+		t.Error("Probe succeeded for rejected rule")
+	}
+	if fake.ChainExists("filter", "MT_PROBE") {
+		t.Error("probe chain was created for rejected rule")
+	}
+	if got := fake.GetRules("filter", "FORWARD"); !reflect.DeepEqual(got, [][]string{{"-j", "ACCEPT"}}) {
+		t.Errorf("FORWARD changed: %v", got)
+	}
+}
+
+// TestCounters проверяет разбор счётчиков цепочки и то, что они не влияют на Commit
+func TestCounters(t *testing.T) {
+	fake := NewFakeIPTables(ProtocolIPv4)
+	ipt := NewIPTables(fake)
+	if err := ipt.RegisterChainOverride("mangle", "MT_CNT"); err != nil {
+		t.Fatalf("RegisterChainOverride failed: %v", err)
+	}
+	rules := [][]string{
+		{"-m", "conntrack", "--ctdir", "ORIGINAL", "-m", "set", "--match-set", "mt_4", "dst"},
+		{"-m", "conntrack", "--ctdir", "REPLY", "-m", "set", "--match-set", "mt_4", "src"},
+	}
+	for _, rule := range rules {
+		if err := ipt.Append("mangle", "MT_CNT", rule...); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if err := ipt.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	fake.SetCounter("mangle", "MT_CNT", rules[0], Counter{Packets: 10, Bytes: 1500})
+	fake.SetCounter("mangle", "MT_CNT", rules[1], Counter{Packets: 20, Bytes: 30000})
+	fake.SetInitialRules("mangle", "OTHER", [][]string{{"-j", "RETURN"}})
+	fake.SetCounter("mangle", "OTHER", []string{"-j", "RETURN"}, Counter{Packets: 1, Bytes: 1})
+
+	counters, err := ipt.Counters("mangle", "MT_CNT")
+	if err != nil {
+		t.Fatalf("Counters failed: %v", err)
+	}
+	if len(counters) != 2 {
+		t.Fatalf("got %d counters, want 2", len(counters))
+	}
+	if !counters[1].Rule.Contains("--ctdir REPLY") || counters[1].Counter != (Counter{Packets: 20, Bytes: 30000}) {
+		t.Errorf("unexpected reply counter: %v %+v", counters[1].Rule, counters[1].Counter)
+	}
+	if counters[0].Counter != (Counter{Packets: 10, Bytes: 1500}) {
+		t.Errorf("unexpected original counter: %+v", counters[0].Counter)
+	}
+
+	// Commit без изменений не должен ничего применять
+	restored := &failingRestore{FakeIPTables: fake, reject: "MT_CNT"}
+	ipt.executable = restored
+	if err := ipt.Commit(); err != nil {
+		t.Errorf("Commit rewrote unchanged chain: %v", err)
+	}
+}
+
+// savedCounters возвращает заданный вывод iptables-save -c
+type savedCounters struct {
+	*FakeIPTables
+	data string
+}
+
+func (s *savedCounters) SaveCounters(string) ([]byte, error) {
+	return []byte(s.data), nil
+}
+
+// TestCountersFormat проверяет разбор вывода iptables-save -c с политиками и другими таблицами
+func TestCountersFormat(t *testing.T) {
+	ipt := NewIPTables(&savedCounters{FakeIPTables: NewFakeIPTables(ProtocolIPv4), data: "" +
+		"# Generated by iptables-save v1.8.7\n" +
+		"*filter\n" +
+		":MT_CNT - [0:0]\n" +
+		"[7:7] -A MT_CNT -j RETURN\n" +
+		"COMMIT\n" +
+		"*mangle\n" +
+		":PREROUTING ACCEPT [512:65536]\n" +
+		":MT_CNT - [0:0]\n" +
+		"[3:180] -A PREROUTING -j MT_CNT\n" +
+		"[18446744073709551615:42] -A MT_CNT -m set --match-set mt_4 dst\n" +
+		"COMMIT\n",
+	})
+
+	counters, err := ipt.Counters("mangle", "MT_CNT")
+	if err != nil {
+		t.Fatalf("Counters failed: %v", err)
+	}
+	if len(counters) != 1 {
+		t.Fatalf("got %d counters, want 1", len(counters))
+	}
+	if got := counters[0].Rule.String(); got != "-m set --match-set mt_4 dst" {
+		t.Errorf("unexpected rule %q", got)
+	}
+	if counters[0].Counter != (Counter{Packets: 18446744073709551615, Bytes: 42}) {
+		t.Errorf("unexpected counter: %+v", counters[0].Counter)
+	}
+
+	ipt = NewIPTables(&savedCounters{FakeIPTables: NewFakeIPTables(ProtocolIPv4), data: "*mangle\n[1-A MT_CNT -j RETURN\nCOMMIT\n"})
+	if _, err := ipt.Counters("mangle", "MT_CNT"); err == nil {
+		t.Error("Counters accepted malformed counters")
+	}
+}
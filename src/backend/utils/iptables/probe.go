@@ -0,0 +1,37 @@
+package iptables
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Probe проверяет, что iptables и ядро принимают правила rules в таблице table:
+// правила добавляются во временную цепочку chainName, которая затем удаляется.
+// Зарегистрированные цепочки не затрагиваются. Удаление – отдельный вызов
+// iptables-restore: правило, удалённое в той же транзакции, ядро не проверяет.
+func (ipt *IPTables) Probe(table, chainName string, rules ...[]string) error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "*%s\n:%s - [0:0]\n", table, chainName)
+	for _, rule := range rules {
+		fmt.Fprintf(buf, "-A %s ", chainName)
+		ruleWriteTo(buf, ruleFromStrings(rule))
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("COMMIT\n")
+	if err := ipt.executable.Restore(buf.Bytes()); err != nil {
+		return err
+	}
+
+	buf.Reset()
+	fmt.Fprintf(buf, "*%s\n-F %s\n-X %s\nCOMMIT\n", table, chainName, chainName)
+	if err := ipt.executable.Restore(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to delete probe chain: %w", err)
+	}
+	return nil
+}
+
+// Available проверяет, что iptables-save выполняется
+func (ipt *IPTables) Available() error {
+	_, err := ipt.executable.Save()
+	return err
+}
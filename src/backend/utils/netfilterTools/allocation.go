@@ -0,0 +1,340 @@
+package netfilterTools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// FullMarkMask – маска, при которой метке доступны все биты
+const FullMarkMask = 0xffffffff
+
+// Allocation – метка и таблица маршрутизации интерфейса группы
+type Allocation struct {
+	Interface string `json:"-"`
+	Mark      uint32 `json:"mark"`
+	Table     int    `json:"table"`
+	// Collision – почему закреплённые за группой значения пришлось заменить
+	Collision string `json:"-"`
+}
+
+// Allocations закрепляет метки и таблицы за группами (по RuntimeKey), чтобы они не менялись
+// между перезапусками и при изменении порядка групп. Методы безопасно вызывать у nil:
+// тогда значения выбираются заново при каждом включении.
+type Allocations struct {
+	locker sync.Mutex
+	path   string
+	items  map[string]Allocation
+}
+
+// LoadAllocations читает закрепления из файла path. Отсутствующий файл – пустой список;
+// при ошибке чтения также возвращается пустой список, сохраняемый в тот же файл.
+func LoadAllocations(path string) (*Allocations, error) {
+	a := &Allocations{path: path, items: make(map[string]Allocation)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return a, fmt.Errorf("failed to read allocations: %w", err)
+	}
+	if err := json.Unmarshal(data, &a.items); err != nil {
+		a.items = make(map[string]Allocation)
+		return a, fmt.Errorf("failed to parse allocations: %w", err)
+	}
+	return a, nil
+}
+
+func (a *Allocations) get(key string) (Allocation, bool) {
+	if a == nil {
+		return Allocation{}, false
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+
+	alloc, ok := a.items[key]
+	return alloc, ok
+}
+
+// reserved возвращает метки и таблицы, закреплённые за другими ключами
+func (a *Allocations) reserved(key string) (map[uint32]struct{}, map[int]struct{}) {
+	marks := make(map[uint32]struct{})
+	tables := make(map[int]struct{})
+	if a == nil {
+		return marks, tables
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+
+	for k, alloc := range a.items {
+		if k == key {
+			continue
+		}
+		marks[alloc.Mark] = struct{}{}
+		tables[alloc.Table] = struct{}{}
+	}
+	return marks, tables
+}
+
+func (a *Allocations) set(key string, alloc Allocation) error {
+	if a == nil {
+		return nil
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+
+	if current, ok := a.items[key]; ok && current.Mark == alloc.Mark && current.Table == alloc.Table {
+		return nil
+	}
+	a.items[key] = Allocation{Mark: alloc.Mark, Table: alloc.Table}
+	return a.save()
+}
+
+// Prune удаляет закрепления групп, которых нет среди keys
+func (a *Allocations) Prune(keys []string) error {
+	if a == nil {
+		return nil
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+
+	known := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		known[key] = struct{}{}
+	}
+	changed := false
+	for key := range a.items {
+		group, _, _ := strings.Cut(key, "/")
+		if _, ok := known[group]; !ok {
+			delete(a.items, key)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return a.save()
+}
+
+// save атомарно записывает закрепления; вызывается под a.locker. Копия из snapshot
+// не имеет файла и не сохраняется.
+func (a *Allocations) save() error {
+	if a.path == "" {
+		return nil
+	}
+	out, err := json.MarshalIndent(a.items, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal allocations: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(a.path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create allocations folder: %w", err)
+	}
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0600); err != nil {
+		return fmt.Errorf("failed to write allocations: %w", err)
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write allocations: %w", err)
+	}
+	return nil
+}
+
+// depositBits раскладывает младшие биты n по установленным битам mask (аналог PDEP)
+func depositBits(n, mask uint32) uint32 {
+	var out uint32
+	for mask != 0 && n != 0 {
+		bit := mask & -mask
+		if n&1 != 0 {
+			out |= bit
+		}
+		n >>= 1
+		mask &^= bit
+	}
+	return out
+}
+
+// systemUsage – метки и таблицы, занятые правилами и маршрутами системы
+type systemUsage struct {
+	rules       []netlink.Rule
+	marks       map[uint32]struct{}
+	tables      map[int]struct{}
+	routeTables map[int]struct{}
+}
+
+func listSystemUsage() (systemUsage, error) {
+	usage := systemUsage{
+		marks:       make(map[uint32]struct{}),
+		tables:      map[int]struct{}{0: {}, 253: {}, 254: {}, 255: {}},
+		routeTables: make(map[int]struct{}),
+	}
+
+	rules, err := netlink.RuleList(nl.FAMILY_ALL)
+	if err != nil {
+		return usage, fmt.Errorf("error while getting rules: %w", err)
+	}
+	usage.rules = rules
+	for _, rule := range rules {
+		usage.marks[rule.Mark] = struct{}{}
+		usage.tables[rule.Table] = struct{}{}
+	}
+
+	routes, err := netlink.RouteListFiltered(nl.FAMILY_ALL, &netlink.Route{}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return usage, fmt.Errorf("error while getting routes: %w", err)
+	}
+	for _, route := range routes {
+		usage.tables[route.Table] = struct{}{}
+		usage.routeTables[route.Table] = struct{}{}
+	}
+	return usage, nil
+}
+
+// collision проверяет, можно ли использовать закреплённые значения. Правило с той же
+// парой метки и таблицы считается оставшимся от предыдущего запуска, а маршруты
+// таблицы без такого правила – чужими.
+func (u systemUsage) collision(alloc Allocation, mask uint32) string {
+	if alloc.Mark == 0 || alloc.Mark&^mask != 0 {
+		return fmt.Sprintf("mark %#x does not fit fwmark mask %#x", alloc.Mark, mask)
+	}
+	switch alloc.Table {
+	case 0, 253, 254, 255:
+		return fmt.Sprintf("table %d is reserved", alloc.Table)
+	}
+
+	own := false
+	for _, rule := range u.rules {
+		switch {
+		case rule.Mark == alloc.Mark && rule.Table == alloc.Table:
+			own = true
+		case rule.Mark == alloc.Mark:
+			return fmt.Sprintf("mark %#x is used by a rule for table %d", alloc.Mark, rule.Table)
+		case rule.Table == alloc.Table:
+			return fmt.Sprintf("table %d is used by a rule with mark %#x", alloc.Table, rule.Mark)
+		}
+	}
+	if _, ok := u.routeTables[alloc.Table]; ok && !own {
+		return fmt.Sprintf("table %d already has routes", alloc.Table)
+	}
+	return ""
+}
+
+// allocate возвращает метку и таблицу для ключа key: закреплённые, если они свободны,
+// иначе первые свободные, не закреплённые за другими группами. При маске из всех битов
+// метка совпадает с номером таблицы.
+func (nh *Helper) allocate(key string, startIdx uint32) (Allocation, error) {
+	usage, err := listSystemUsage()
+	if err != nil {
+		return Allocation{}, err
+	}
+	return nh.allocateIn(usage, key, startIdx)
+}
+
+// allocateIn выбирает метку и таблицу с учётом занятых в системе usage. Номера
+// выделяются ниже probeTable; если свободных не осталось, возвращается ошибка.
+func (nh *Helper) allocateIn(usage systemUsage, key string, startIdx uint32) (Allocation, error) {
+	mask := nh.markMask()
+
+	var collision string
+	if stored, ok := nh.Allocations.get(key); ok {
+		collision = usage.collision(stored, mask)
+		if collision == "" {
+			return stored, nil
+		}
+	}
+
+	reservedMarks, reservedTables := nh.Allocations.reserved(key)
+	markFree := func(mark uint32) bool {
+		_, used := usage.marks[mark]
+		_, reserved := reservedMarks[mark]
+		return !used && !reserved
+	}
+	tableFree := func(table int) bool {
+		_, used := usage.tables[table]
+		_, reserved := reservedTables[table]
+		return !used && !reserved
+	}
+
+	alloc := Allocation{Collision: collision}
+	if mask == FullMarkMask {
+		idx := startIdx
+		for ; idx < probeTable; idx++ {
+			if markFree(idx) && tableFree(int(idx)) {
+				break
+			}
+		}
+		if idx >= probeTable {
+			return Allocation{}, fmt.Errorf("no free mark and table from %d", startIdx)
+		}
+		alloc.Mark, alloc.Table = idx, int(idx)
+	} else {
+		limit := uint32(1)<<bits.OnesCount32(mask) - 1
+		for n := uint32(1); n <= limit; n++ {
+			if mark := depositBits(n, mask); markFree(mark) {
+				alloc.Mark = mark
+				break
+			}
+		}
+		if alloc.Mark == 0 {
+			return Allocation{}, fmt.Errorf("no free mark in fwmark mask %#x", mask)
+		}
+		idx := startIdx
+		for idx < probeTable && !tableFree(int(idx)) {
+			idx++
+		}
+		if idx >= probeTable {
+			return Allocation{}, fmt.Errorf("no free table from %d", startIdx)
+		}
+		alloc.Table = int(idx)
+	}
+
+	if err := nh.Allocations.set(key, alloc); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("failed to persist mark and table allocation")
+	}
+	return alloc, nil
+}
+
+func (nh *Helper) markMask() uint32 {
+	if nh.MarkMask == 0 {
+		return FullMarkMask
+	}
+	return nh.MarkMask
+}
+
+// ruleMask возвращает маску для ip rule; nil – сравнение метки целиком
+func (nh *Helper) ruleMask() *uint32 {
+	mask := nh.markMask()
+	if mask == FullMarkMask {
+		return nil
+	}
+	return &mask
+}
+
+// markArg возвращает метку для правил iptables; при неполной маске – в виде метка/маска
+func (nh *Helper) markArg(mark uint32) string {
+	mask := nh.markMask()
+	if mask == FullMarkMask {
+		return strconv.Itoa(int(mark))
+	}
+	return fmt.Sprintf("%#x/%#x", mark, mask)
+}
+
+// saveMarkArgs – CONNMARK --save-mark, затрагивающий только биты маски
+func (nh *Helper) saveMarkArgs() []string {
+	args := []string{"-j", "CONNMARK", "--save-mark"}
+	if mask := nh.markMask(); mask != FullMarkMask {
+		maskStr := fmt.Sprintf("%#x", mask)
+		args = append(args, "--nfmask", maskStr, "--ctmask", maskStr)
+	}
+	return args
+}
@@ -0,0 +1,118 @@
+package netfilterTools
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestDepositBits(t *testing.T) {
+	tests := []struct {
+		n, mask, want uint32
+	}{
+		{1, 0xff0000, 0x10000},
+		{3, 0xff0000, 0x30000},
+		{1, 0xf0f0, 0x10},
+		{0x1f, 0xf0f0, 0x10f0},
+		{0x100, 0xff, 0},
+	}
+	for _, tt := range tests {
+		if got := depositBits(tt.n, tt.mask); got != tt.want {
+			t.Errorf("depositBits(%#x, %#x) = %#x, want %#x", tt.n, tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestAllocationCollision(t *testing.T) {
+	usage := systemUsage{
+		rules: []netlink.Rule{
+			{Mark: 10, Table: 10},
+			{Mark: 20, Table: 21},
+		},
+		routeTables: map[int]struct{}{10: {}, 30: {}},
+	}
+	tests := []struct {
+		alloc     Allocation
+		mask      uint32
+		collision bool
+	}{
+		// Правило и маршруты, оставшиеся от предыдущего запуска
+		{Allocation{Mark: 10, Table: 10}, FullMarkMask, false},
+		{Allocation{Mark: 11, Table: 11}, FullMarkMask, false},
+		{Allocation{Mark: 20, Table: 22}, FullMarkMask, true},
+		{Allocation{Mark: 22, Table: 21}, FullMarkMask, true},
+		{Allocation{Mark: 31, Table: 30}, FullMarkMask, true},
+		{Allocation{Mark: 40, Table: 254}, FullMarkMask, true},
+		{Allocation{Mark: 0x10000, Table: 40}, 0xff0000, false},
+		{Allocation{Mark: 0x10001, Table: 40}, 0xff0000, true},
+	}
+	for _, tt := range tests {
+		if got := usage.collision(tt.alloc, tt.mask); (got != "") != tt.collision {
+			t.Errorf("collision(%+v, %#x) = %q, want collision %v", tt.alloc, tt.mask, got, tt.collision)
+		}
+	}
+}
+
+func TestAllocationsPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allocations.json")
+	allocations, err := LoadAllocations(path)
+	if err != nil {
+		t.Fatalf("LoadAllocations failed: %v", err)
+	}
+	for key, alloc := range map[string]Allocation{
+		"grp":      {Mark: 7, Table: 7},
+		"grp/nwg1": {Mark: 8, Table: 8},
+		"old":      {Mark: 9, Table: 9},
+	} {
+		if err := allocations.set(key, alloc); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	if err := allocations.Prune([]string{"grp"}); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+
+	loaded, err := LoadAllocations(path)
+	if err != nil {
+		t.Fatalf("LoadAllocations failed: %v", err)
+	}
+	if alloc, ok := loaded.get("grp/nwg1"); !ok || alloc.Mark != 8 || alloc.Table != 8 {
+		t.Errorf("member allocation = %+v, %v", alloc, ok)
+	}
+	if _, ok := loaded.get("old"); ok {
+		t.Error("allocation of removed group was not pruned")
+	}
+	marks, tables := loaded.reserved("grp")
+	if _, ok := marks[7]; ok {
+		t.Error("own mark reported as reserved")
+	}
+	if _, ok := tables[8]; !ok {
+		t.Error("member table is not reserved")
+	}
+}
+
+func TestAllocateExhausted(t *testing.T) {
+	// Свободных номеров ниже probeTable не осталось
+	usage := systemUsage{tables: map[int]struct{}{probeTable - 2: {}, probeTable - 1: {}}}
+	for _, mask := range []uint32{0, 0xff0000} {
+		allocations, err := LoadAllocations(filepath.Join(t.TempDir(), "allocations.json"))
+		if err != nil {
+			t.Fatalf("LoadAllocations failed: %v", err)
+		}
+		nh := &Helper{MarkMask: mask, Allocations: allocations}
+		if alloc, err := nh.allocateIn(usage, "grp", probeTable-2); err == nil {
+			t.Errorf("mask %#x: allocateIn() = %+v, want error", mask, alloc)
+		}
+		if alloc, ok := allocations.get("grp"); ok {
+			t.Errorf("mask %#x: exhausted allocation %+v was persisted", mask, alloc)
+		}
+
+		delete(usage.tables, probeTable-1)
+		alloc, err := nh.allocateIn(usage, "grp", probeTable-2)
+		if err != nil || alloc.Table != probeTable-1 {
+			t.Errorf("mask %#x: allocateIn() = %+v, %v, want table %d", mask, alloc, err, probeTable-1)
+		}
+		usage.tables[probeTable-1] = struct{}{}
+	}
+}
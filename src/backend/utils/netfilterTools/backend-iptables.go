@@ -0,0 +1,305 @@
+package netfilterTools
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"magitrickle/utils/iptables"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// iptablesBackend – классическая реализация: наборы ipset (hash:net) и правила iptables-restore
+type iptablesBackend struct {
+	nh *Helper
+}
+
+func (b *iptablesBackend) name() string {
+	return BackendIPTables
+}
+
+func (b *iptablesBackend) clean() error {
+	return b.nh.CleanIPTables()
+}
+
+func (b *iptablesBackend) close() error {
+	return nil
+}
+
+func ipsetFamilyName(name string, ipLen int) string {
+	if ipLen == net.IPv4len {
+		return name + "_4"
+	}
+	return name + "_6"
+}
+
+func (b *iptablesBackend) createSet(name string, opts IPSetOptions) error {
+	if err := createHashNet(name+"_4", unix.AF_INET, opts); err != nil {
+		return fmt.Errorf("failed to create ipset: %w", err)
+	}
+	if err := createHashNet(name+"_6", unix.AF_INET6, opts); err != nil {
+		return fmt.Errorf("failed to create ipset: %w", err)
+	}
+	return nil
+}
+
+// createHashNet создаёт набор hash:net. netlink.IpsetCreate не умеет задавать hashsize,
+// поэтому запрос собирается вручную.
+func createHashNet(setName string, family uint8, opts IPSetOptions) error {
+	req := nl.NewNetlinkRequest(nl.IPSET_CMD_CREATE|(unix.NFNL_SUBSYS_IPSET<<8), nl.GetIpsetFlags(nl.IPSET_CMD_CREATE)|unix.NLM_F_EXCL)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: uint8(unix.AF_NETLINK), Version: nl.NFNETLINK_V0})
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_PROTOCOL, nl.Uint8Attr(nl.IPSET_PROTOCOL)))
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setName)))
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_TYPENAME, nl.ZeroTerminated("hash:net")))
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_REVISION, nl.Uint8Attr(0)))
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_FAMILY, nl.Uint8Attr(family)))
+
+	data := nl.NewRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_HASHSIZE | nl.NLA_F_NET_BYTEORDER, Value: opts.HashSize})
+	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_MAXELEM | nl.NLA_F_NET_BYTEORDER, Value: opts.MaxElem})
+	data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: opts.Timeout})
+	req.AddData(data)
+
+	_, err := req.Execute(unix.NETLINK_NETFILTER, 0)
+	var errno unix.Errno
+	if errors.As(err, &errno) && errno >= nl.IPSET_ERR_PRIVATE {
+		return nl.IPSetError(uintptr(errno))
+	}
+	return err
+}
+
+func (b *iptablesBackend) destroySet(name string) error {
+	var errs []error
+	err := netlink.IpsetDestroy(name + "_4")
+	if err != nil && !os.IsNotExist(err) {
+		errs = append(errs, err)
+	}
+	err = netlink.IpsetDestroy(name + "_6")
+	if err != nil && !os.IsNotExist(err) {
+		errs = append(errs, err)
+	}
+	if errs != nil {
+		return fmt.Errorf("failed to destroy ipsets: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (b *iptablesBackend) addToSet(name string, ip []byte, cidr uint8, timeout IPSetTimeout) error {
+	if timeout == nil {
+		timeout = zeroTimeout
+	}
+
+	err := netlink.IpsetAdd(ipsetFamilyName(name, len(ip)), &netlink.IPSetEntry{
+		IP:      ip,
+		CIDR:    cidr,
+		Timeout: timeout,
+		Replace: true,
+	})
+	if errors.Is(err, nl.IPSetError(nl.IPSET_ERR_TYPE_SPECIFIC)) {
+		return fmt.Errorf("failed to add address: %w", ErrIPSetFull)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add address: %w", err)
+	}
+	return nil
+}
+
+func (b *iptablesBackend) delFromSet(name string, ip []byte, cidr uint8) error {
+	err := netlink.IpsetDel(ipsetFamilyName(name, len(ip)), &netlink.IPSetEntry{
+		IP:   ip,
+		CIDR: cidr,
+	})
+	if err != nil && !errors.Is(err, nl.IPSetError(nl.IPSET_ERR_EXIST)) {
+		return fmt.Errorf("failed to delete address: %w", err)
+	}
+	return nil
+}
+
+func (b *iptablesBackend) addToSetBatch(name string, entries []setEntry) error {
+	err := ipsetBatch(nl.IPSET_CMD_ADD, name, entries)
+	if errors.Is(err, nl.IPSetError(nl.IPSET_ERR_TYPE_SPECIFIC)) {
+		return fmt.Errorf("failed to add addresses: %w", ErrIPSetFull)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add addresses: %w", err)
+	}
+	return nil
+}
+
+func (b *iptablesBackend) delFromSetBatch(name string, entries []setEntry) error {
+	err := ipsetBatch(nl.IPSET_CMD_DEL, name, entries)
+	if err != nil {
+		return fmt.Errorf("failed to delete addresses: %w", err)
+	}
+	return nil
+}
+
+// ipsetBatch передаёт элементы одним сообщением netlink в контейнере IPSET_ATTR_ADT.
+// Без NLM_F_EXCL добавление заменяет существующие элементы, а удаление пропускает отсутствующие.
+// Ядро применяет элементы по порядку и останавливается на первой ошибке.
+func ipsetBatch(cmd int, name string, entries []setEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	req := nl.NewNetlinkRequest(cmd|(unix.NFNL_SUBSYS_IPSET<<8), nl.GetIpsetFlags(cmd))
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: uint8(unix.AF_NETLINK), Version: nl.NFNETLINK_V0})
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_PROTOCOL, nl.Uint8Attr(nl.IPSET_PROTOCOL)))
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(ipsetFamilyName(name, len(entries[0].IP)))))
+
+	adt := nl.NewRtAttr(nl.IPSET_ATTR_ADT|int(nl.NLA_F_NESTED), nil)
+	for _, entry := range entries {
+		data := adt.AddRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+		if cmd == nl.IPSET_CMD_ADD {
+			var timeout uint32
+			if entry.Timeout != nil {
+				timeout = *entry.Timeout
+			}
+			data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: timeout})
+		}
+		addrType := nl.IPSET_ATTR_IPADDR_IPV4
+		if len(entry.IP) == net.IPv6len {
+			addrType = nl.IPSET_ATTR_IPADDR_IPV6
+		}
+		ip := data.AddRtAttr(nl.IPSET_ATTR_IP|int(nl.NLA_F_NESTED), nil)
+		ip.AddRtAttr(addrType|int(nl.NLA_F_NET_BYTEORDER), entry.IP)
+		if entry.CIDR != 0 {
+			data.AddRtAttr(nl.IPSET_ATTR_CIDR, nl.Uint8Attr(entry.CIDR))
+		}
+	}
+	req.AddData(adt)
+
+	_, err := req.Execute(unix.NETLINK_NETFILTER, 0)
+	var errno unix.Errno
+	if errors.As(err, &errno) && errno >= nl.IPSET_ERR_PRIVATE {
+		return nl.IPSetError(uintptr(errno))
+	}
+	return err
+}
+
+func (b *iptablesBackend) listSet(name string, ipLen int) ([]setEntry, error) {
+	list, err := netlink.IpsetList(ipsetFamilyName(name, ipLen))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]setEntry, 0, len(list.Entries))
+	for _, entry := range list.Entries {
+		if len(entry.IP) != ipLen {
+			continue
+		}
+		var timeout IPSetTimeout
+		if entry.Timeout != nil && *entry.Timeout != 0 {
+			timeout = entry.Timeout
+		}
+		entries = append(entries, setEntry{IP: entry.IP, CIDR: hostCIDR(entry.IP, entry.CIDR), Timeout: timeout})
+	}
+	return entries, nil
+}
+
+func (b *iptablesBackend) setUsage(name string, _ IPSetOptions) ([]IPSetUsage, error) {
+	var usage []IPSetUsage
+	for _, ipLen := range []int{net.IPv4len, net.IPv6len} {
+		list, err := netlink.IpsetList(ipsetFamilyName(name, ipLen))
+		if err != nil {
+			return nil, err
+		}
+		family := 4
+		if ipLen == net.IPv6len {
+			family = 6
+		}
+		usage = append(usage, IPSetUsage{Family: family, Entries: list.NumEntries, MaxElem: list.MaxElements})
+	}
+	return usage, nil
+}
+
+// resizeSet копирует элементы во временный набор с новыми размерами и меняет наборы местами;
+// правила iptables ссылаются на набор по имени, поэтому продолжают работать
+func (b *iptablesBackend) resizeSet(name string, ipLen int, opts IPSetOptions) error {
+	setName := ipsetFamilyName(name, ipLen)
+	tmpName := setName + "_r"
+	family := uint8(unix.AF_INET)
+	if ipLen == net.IPv6len {
+		family = unix.AF_INET6
+	}
+
+	entries, err := b.listSet(name, ipLen)
+	if err != nil {
+		return fmt.Errorf("failed to list ipset: %w", err)
+	}
+	if err := netlink.IpsetDestroy(tmpName); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to destroy temporary ipset: %w", err)
+	}
+	if err := createHashNet(tmpName, family, opts); err != nil {
+		return fmt.Errorf("failed to create temporary ipset: %w", err)
+	}
+	defer func() { _ = netlink.IpsetDestroy(tmpName) }()
+
+	for _, entry := range entries {
+		timeout := entry.Timeout
+		if timeout == nil {
+			timeout = zeroTimeout
+		}
+		err := netlink.IpsetAdd(tmpName, &netlink.IPSetEntry{
+			IP:      entry.IP,
+			CIDR:    entry.CIDR,
+			Timeout: timeout,
+			Replace: true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to copy address: %w", err)
+		}
+	}
+
+	if err := netlink.IpsetSwap(setName, tmpName); err != nil {
+		return fmt.Errorf("failed to swap ipsets: %w", err)
+	}
+	return nil
+}
+
+func (b *iptablesBackend) insertLinkRules(r *IPSetToLink) error {
+	if err := r.insertIPTablesRules(b.nh.IPTables4); err != nil {
+		return err
+	}
+	return r.insertIPTablesRules(b.nh.IPTables6)
+}
+
+func (b *iptablesBackend) deleteLinkRules(r *IPSetToLink) error {
+	return errors.Join(
+		r.deleteIPTablesRules(b.nh.IPTables4),
+		r.deleteIPTablesRules(b.nh.IPTables6),
+	)
+}
+
+func (b *iptablesBackend) counters(r *IPSetToLink) ([]TrafficCounter, error) {
+	var counters []TrafficCounter
+	for _, ipt := range []*iptables.IPTables{b.nh.IPTables4, b.nh.IPTables6} {
+		if ipt == nil {
+			continue
+		}
+		counter, err := r.iptablesCounters(ipt)
+		if err != nil {
+			return nil, err
+		}
+		counters = append(counters, counter)
+	}
+	return counters, nil
+}
+
+func (b *iptablesBackend) insertPortRemap(r *PortRemap) error {
+	if err := r.insertIPTablesRules(b.nh.IPTables4); err != nil {
+		return err
+	}
+	return r.insertIPTablesRules(b.nh.IPTables6)
+}
+
+func (b *iptablesBackend) deletePortRemap(r *PortRemap) error {
+	return errors.Join(
+		r.deleteIPTablesRules(b.nh.IPTables4),
+		r.deleteIPTablesRules(b.nh.IPTables6),
+	)
+}
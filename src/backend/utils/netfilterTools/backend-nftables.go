@@ -0,0 +1,1459 @@
+package netfilterTools
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"magitrickle/utils/nftables"
+
+	"golang.org/x/sys/unix"
+)
+
+const nftTableName = "magitrickle"
+
+// Направления conntrack (IP_CT_DIR_ORIGINAL, IP_CT_DIR_REPLY)
+const (
+	ctDirOriginal = 0
+	ctDirReply    = 1
+)
+
+// Базовые цепочки таблицы; цепочки групп подключаются к ним переходами.
+// Accept в нашей таблице не отменяет drop в таблицах межсетевого экрана (fw4),
+// поэтому цепочка forward лишь повторяет поведение iptables-реализации.
+var nftBaseChains = []struct {
+	name string
+	hook nftables.Hook
+}{
+	{"forward", nftables.Hook{Type: "filter", Num: unix.NF_INET_FORWARD, Priority: 0}},
+	{"prerouting", nftables.Hook{Type: "filter", Num: unix.NF_INET_PRE_ROUTING, Priority: -150}},
+	// Счётчики групп – раньше prerouting, где tproxy завершает обход
+	{"accounting", nftables.Hook{Type: "filter", Num: unix.NF_INET_PRE_ROUTING, Priority: -151}},
+	{"output", nftables.Hook{Type: "route", Num: unix.NF_INET_LOCAL_OUT, Priority: -150}},
+	// Ограничение MSS трафика роутера – после перемаршрутизации по метке из output
+	{"mssclamp", nftables.Hook{Type: "filter", Num: unix.NF_INET_LOCAL_OUT, Priority: 0}},
+	{"postrouting", nftables.Hook{Type: "nat", Num: unix.NF_INET_POST_ROUTING, Priority: 100}},
+	{"dstnat", nftables.Hook{Type: "nat", Num: unix.NF_INET_PRE_ROUTING, Priority: -100}},
+}
+
+// nftablesBackend работает с собственной таблицей inet через netlink nf_tables.
+// Каждый набор представлен парой наборов на семейство: хеш адресов с таймаутами
+// (<name>_4, <name>_6) и набор-интервал подсетей (<name>_4n, <name>_6n).
+type nftablesBackend struct {
+	nh    *Helper
+	conn  *nftables.Conn
+	table nftables.Table
+
+	locker sync.Mutex
+	// jumps – цепочки групп, подключённые к базовым цепочкам, в порядке подключения
+	jumps map[string][]string
+	// chains – существующие цепочки групп
+	chains map[string]struct{}
+	// subnets – логическое содержимое наборов подсетей. Наборы-интервалы не допускают
+	// пересечений, поэтому в ядре хранится только объединение максимальных подсетей.
+	subnets map[string]map[netip.Prefix]struct{}
+}
+
+func newNFTablesBackend(nh *Helper) (*nftablesBackend, error) {
+	conn, err := nftables.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open nftables connection: %w", err)
+	}
+	return &nftablesBackend{
+		nh:      nh,
+		conn:    conn,
+		table:   nftables.Table{Family: unix.NFPROTO_INET, Name: nftTableName},
+		jumps:   make(map[string][]string),
+		chains:  make(map[string]struct{}),
+		subnets: make(map[string]map[netip.Prefix]struct{}),
+	}, nil
+}
+
+func (b *nftablesBackend) name() string {
+	return BackendNFTables
+}
+
+// clean пересоздаёт таблицу целиком
+func (b *nftablesBackend) clean() error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	batch := &nftables.Batch{}
+	// Создание перед удалением делает удаление безусловным
+	batch.AddTable(b.table)
+	batch.DelTable(b.table)
+	batch.AddTable(b.table)
+	for _, chain := range nftBaseChains {
+		batch.AddChain(b.table, chain.name, &chain.hook)
+	}
+	err := b.conn.Commit(batch)
+	if err != nil {
+		return fmt.Errorf("failed to recreate nftables table: %w", err)
+	}
+
+	b.jumps = make(map[string][]string)
+	b.chains = make(map[string]struct{})
+	b.subnets = make(map[string]map[netip.Prefix]struct{})
+	return nil
+}
+
+func (b *nftablesBackend) close() error {
+	return b.conn.Close()
+}
+
+func nftSetName(name string, ipLen int, subnets bool) string {
+	name = ipsetFamilyName(name, ipLen)
+	if subnets {
+		name += "n"
+	}
+	return name
+}
+
+// createSet создаёт наборы; наборы nftables без размера не ограничены ядром,
+// поэтому параметры размеров не используются
+func (b *nftablesBackend) createSet(name string, _ IPSetOptions) error {
+	batch := &nftables.Batch{}
+	for _, family := range []struct {
+		ipLen   int
+		keyType uint32
+	}{
+		{net.IPv4len, nftables.TypeIPv4Addr},
+		{net.IPv6len, nftables.TypeIPv6Addr},
+	} {
+		batch.AddSet(b.table, nftables.Set{
+			Name:    nftSetName(name, family.ipLen, false),
+			KeyType: family.keyType,
+			KeyLen:  uint32(family.ipLen),
+			Timeout: true,
+		})
+		batch.AddSet(b.table, nftables.Set{
+			Name:     nftSetName(name, family.ipLen, true),
+			KeyType:  family.keyType,
+			KeyLen:   uint32(family.ipLen),
+			Interval: true,
+		})
+	}
+	err := b.conn.Commit(batch)
+	if err != nil {
+		return fmt.Errorf("failed to create set: %w", err)
+	}
+	return nil
+}
+
+func (b *nftablesBackend) destroySet(name string) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	var errs []error
+	for _, ipLen := range []int{net.IPv4len, net.IPv6len} {
+		for _, subnets := range []bool{false, true} {
+			setName := nftSetName(name, ipLen, subnets)
+			delete(b.subnets, setName)
+
+			batch := &nftables.Batch{}
+			batch.DelSet(b.table, setName)
+			err := b.conn.Commit(batch)
+			if err != nil && !errors.Is(err, unix.ENOENT) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("failed to destroy sets: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (b *nftablesBackend) addToSet(name string, ip []byte, cidr uint8, timeout IPSetTimeout) error {
+	if isHost(ip, cidr) {
+		return b.addHost(nftSetName(name, len(ip), false), ip, timeout)
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return fmt.Errorf("failed to add address: invalid address %v", ip)
+	}
+	return b.addSubnet(nftSetName(name, len(ip), true), netip.PrefixFrom(addr, int(cidr)).Masked())
+}
+
+// addHost добавляет адрес или обновляет таймаут уже существующего
+func (b *nftablesBackend) addHost(setName string, ip []byte, timeout IPSetTimeout) error {
+	elem := nftables.Element{Key: ip}
+	if timeout != nil {
+		elem.Timeout = time.Duration(*timeout) * time.Second
+	}
+
+	batch := &nftables.Batch{}
+	batch.AddElements(b.table, setName, []nftables.Element{elem}, true)
+	err := b.conn.Commit(batch)
+	if errors.Is(err, unix.EEXIST) {
+		batch = &nftables.Batch{}
+		batch.DelElements(b.table, setName, []nftables.Element{{Key: ip}})
+		batch.AddElements(b.table, setName, []nftables.Element{elem}, false)
+		err = b.conn.Commit(batch)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add address: %w", err)
+	}
+	return nil
+}
+
+func (b *nftablesBackend) addSubnet(setName string, prefix netip.Prefix) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	mirror := b.subnets[setName]
+	if _, ok := mirror[prefix]; ok {
+		return nil
+	}
+	if coveringPrefix(mirror, prefix) {
+		mirror[prefix] = struct{}{}
+		return nil
+	}
+
+	batch := &nftables.Batch{}
+	for _, contained := range maximalPrefixes(mirror, prefix) {
+		batch.DelElements(b.table, setName, intervalElements(contained))
+	}
+	batch.AddElements(b.table, setName, intervalElements(prefix), false)
+	err := b.conn.Commit(batch)
+	if err != nil {
+		return fmt.Errorf("failed to add address: %w", err)
+	}
+
+	if mirror == nil {
+		mirror = make(map[netip.Prefix]struct{})
+		b.subnets[setName] = mirror
+	}
+	mirror[prefix] = struct{}{}
+	return nil
+}
+
+func (b *nftablesBackend) delFromSet(name string, ip []byte, cidr uint8) error {
+	if isHost(ip, cidr) {
+		batch := &nftables.Batch{}
+		batch.DelElements(b.table, nftSetName(name, len(ip), false), []nftables.Element{{Key: ip}})
+		err := b.conn.Commit(batch)
+		if err != nil && !errors.Is(err, unix.ENOENT) {
+			return fmt.Errorf("failed to delete address: %w", err)
+		}
+		return nil
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return fmt.Errorf("failed to delete address: invalid address %v", ip)
+	}
+	return b.delSubnet(nftSetName(name, len(ip), true), netip.PrefixFrom(addr, int(cidr)).Masked())
+}
+
+func (b *nftablesBackend) delSubnet(setName string, prefix netip.Prefix) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	mirror := b.subnets[setName]
+	if _, ok := mirror[prefix]; !ok {
+		return nil
+	}
+	delete(mirror, prefix)
+	if coveringPrefix(mirror, prefix) {
+		return nil
+	}
+
+	// Подсеть была в ядре: вместо неё становятся видны вложенные в неё подсети
+	batch := &nftables.Batch{}
+	batch.DelElements(b.table, setName, intervalElements(prefix))
+	for _, contained := range maximalPrefixes(mirror, prefix) {
+		batch.AddElements(b.table, setName, intervalElements(contained), false)
+	}
+	err := b.conn.Commit(batch)
+	if err != nil {
+		mirror[prefix] = struct{}{}
+		return fmt.Errorf("failed to delete address: %w", err)
+	}
+	return nil
+}
+
+// splitEntries делит элементы на адреса и подсети (маскированные префиксы)
+func splitEntries(entries []setEntry) (hosts []setEntry, prefixes []netip.Prefix, err error) {
+	for _, entry := range entries {
+		if isHost(entry.IP, entry.CIDR) {
+			hosts = append(hosts, entry)
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(entry.IP)
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid address %v", entry.IP)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, int(entry.CIDR)).Masked())
+	}
+	return hosts, prefixes, nil
+}
+
+func (b *nftablesBackend) addToSetBatch(name string, entries []setEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	hosts, prefixes, err := splitEntries(entries)
+	if err != nil {
+		return fmt.Errorf("failed to add addresses: %w", err)
+	}
+	ipLen := len(entries[0].IP)
+
+	var errs []error
+	if len(hosts) > 0 {
+		errs = append(errs, b.addHosts(nftSetName(name, ipLen, false), hosts))
+	}
+	if len(prefixes) > 0 {
+		errs = append(errs, b.addSubnets(nftSetName(name, ipLen, true), prefixes))
+	}
+	return errors.Join(errs...)
+}
+
+// addHosts добавляет адреса одной транзакцией. Существующие адреса удаляются и добавляются
+// заново в той же транзакции, чтобы обновить таймаут; если набор успел измениться,
+// адреса добавляются по одному.
+func (b *nftablesBackend) addHosts(setName string, hosts []setEntry) error {
+	elems := make([]nftables.Element, len(hosts))
+	for i, host := range hosts {
+		elems[i] = nftables.Element{Key: host.IP}
+		if host.Timeout != nil {
+			elems[i].Timeout = time.Duration(*host.Timeout) * time.Second
+		}
+	}
+
+	batch := &nftables.Batch{}
+	batch.AddElements(b.table, setName, elems, true)
+	err := b.conn.Commit(batch)
+	if errors.Is(err, unix.EEXIST) {
+		err = b.replaceHosts(setName, elems)
+	}
+	if errors.Is(err, unix.EEXIST) || errors.Is(err, unix.ENOENT) {
+		var errs []error
+		for _, host := range hosts {
+			errs = append(errs, b.addHost(setName, host.IP, host.Timeout))
+		}
+		return errors.Join(errs...)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add addresses: %w", err)
+	}
+	return nil
+}
+
+// replaceHosts добавляет адреса, предварительно удаляя уже существующие
+func (b *nftablesBackend) replaceHosts(setName string, elems []nftables.Element) error {
+	existing, err := b.conn.ListElements(b.table, setName)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]struct{}, len(existing))
+	for _, elem := range existing {
+		keys[string(elem.Key)] = struct{}{}
+	}
+
+	var present []nftables.Element
+	for _, elem := range elems {
+		if _, ok := keys[string(elem.Key)]; ok {
+			present = append(present, nftables.Element{Key: elem.Key})
+		}
+	}
+	batch := &nftables.Batch{}
+	if len(present) > 0 {
+		batch.DelElements(b.table, setName, present)
+	}
+	batch.AddElements(b.table, setName, elems, false)
+	return b.conn.Commit(batch)
+}
+
+// addSubnets добавляет подсети одной транзакцией, сохраняя набор интервалов без пересечений
+func (b *nftablesBackend) addSubnets(setName string, prefixes []netip.Prefix) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	current := b.subnets[setName]
+	// Внешние подсети идут раньше вложенных, поэтому вложенные в добавляемую подсеть
+	// можно искать только среди уже известных
+	known := sortedPrefixes(current)
+	prefixes = slices.Clone(prefixes)
+	slices.SortFunc(prefixes, comparePrefixes)
+
+	mirror := make(map[netip.Prefix]struct{}, len(current)+len(prefixes))
+	for prefix := range current {
+		mirror[prefix] = struct{}{}
+	}
+	batch := &nftables.Batch{}
+	for _, prefix := range prefixes {
+		if _, ok := mirror[prefix]; ok {
+			continue
+		}
+		if !coveringPrefix(mirror, prefix) {
+			for _, contained := range maximalSorted(known, prefix) {
+				batch.DelElements(b.table, setName, intervalElements(contained))
+			}
+			batch.AddElements(b.table, setName, intervalElements(prefix), false)
+		}
+		mirror[prefix] = struct{}{}
+	}
+
+	if batch.Len() > 0 {
+		if err := b.conn.Commit(batch); err != nil {
+			return fmt.Errorf("failed to add addresses: %w", err)
+		}
+	}
+	b.subnets[setName] = mirror
+	return nil
+}
+
+func (b *nftablesBackend) delFromSetBatch(name string, entries []setEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	hosts, prefixes, err := splitEntries(entries)
+	if err != nil {
+		return fmt.Errorf("failed to delete addresses: %w", err)
+	}
+	ipLen := len(entries[0].IP)
+
+	var errs []error
+	if len(hosts) > 0 {
+		errs = append(errs, b.delHosts(nftSetName(name, ipLen, false), hosts))
+	}
+	if len(prefixes) > 0 {
+		errs = append(errs, b.delSubnets(nftSetName(name, ipLen, true), prefixes))
+	}
+	return errors.Join(errs...)
+}
+
+// delHosts удаляет адреса одной транзакцией; если части адресов нет в наборе,
+// адреса удаляются по одному
+func (b *nftablesBackend) delHosts(setName string, hosts []setEntry) error {
+	elems := make([]nftables.Element, len(hosts))
+	for i, host := range hosts {
+		elems[i] = nftables.Element{Key: host.IP}
+	}
+
+	batch := &nftables.Batch{}
+	batch.DelElements(b.table, setName, elems)
+	err := b.conn.Commit(batch)
+	if errors.Is(err, unix.ENOENT) {
+		var errs []error
+		for _, elem := range elems {
+			batch = &nftables.Batch{}
+			batch.DelElements(b.table, setName, []nftables.Element{elem})
+			if err := b.conn.Commit(batch); err != nil && !errors.Is(err, unix.ENOENT) {
+				errs = append(errs, fmt.Errorf("failed to delete address: %w", err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete addresses: %w", err)
+	}
+	return nil
+}
+
+// delSubnets удаляет подсети одной транзакцией. Вместо удалённых подсетей, которые были
+// в ядре, становятся видны оставшиеся вложенные в них подсети.
+func (b *nftablesBackend) delSubnets(setName string, prefixes []netip.Prefix) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	current := b.subnets[setName]
+	mirror := make(map[netip.Prefix]struct{}, len(current))
+	for prefix := range current {
+		mirror[prefix] = struct{}{}
+	}
+	var visible []netip.Prefix
+	for _, prefix := range prefixes {
+		if _, ok := mirror[prefix]; !ok {
+			continue
+		}
+		delete(mirror, prefix)
+		// Подсети в ядре не пересекаются, поэтому видимость проверяется по исходному набору
+		if !coveringPrefix(current, prefix) {
+			visible = append(visible, prefix)
+		}
+	}
+	if len(visible) == 0 {
+		b.subnets[setName] = mirror
+		return nil
+	}
+
+	remaining := sortedPrefixes(mirror)
+	batch := &nftables.Batch{}
+	for _, prefix := range visible {
+		batch.DelElements(b.table, setName, intervalElements(prefix))
+		for _, contained := range maximalSorted(remaining, prefix) {
+			batch.AddElements(b.table, setName, intervalElements(contained), false)
+		}
+	}
+	if err := b.conn.Commit(batch); err != nil {
+		return fmt.Errorf("failed to delete addresses: %w", err)
+	}
+	b.subnets[setName] = mirror
+	return nil
+}
+
+// comparePrefixes упорядочивает подсети по адресу, а при равных адресах – внешние раньше вложенных
+func comparePrefixes(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
+
+// sortedPrefixes возвращает подсети prefixes в порядке comparePrefixes
+func sortedPrefixes(prefixes map[netip.Prefix]struct{}) []netip.Prefix {
+	out := make([]netip.Prefix, 0, len(prefixes))
+	for prefix := range prefixes {
+		out = append(out, prefix)
+	}
+	slices.SortFunc(out, comparePrefixes)
+	return out
+}
+
+// maximalSorted – maximalPrefixes для подсетей, упорядоченных comparePrefixes;
+// вложенные в outer подсети находятся двоичным поиском
+func maximalSorted(sorted []netip.Prefix, outer netip.Prefix) []netip.Prefix {
+	idx, _ := slices.BinarySearchFunc(sorted, outer, comparePrefixes)
+	var out []netip.Prefix
+	for _, prefix := range sorted[idx:] {
+		if !outer.Contains(prefix.Addr()) {
+			break
+		}
+		if prefix.Bits() <= outer.Bits() {
+			continue
+		}
+		if len(out) > 0 && out[len(out)-1].Contains(prefix.Addr()) {
+			continue
+		}
+		out = append(out, prefix)
+	}
+	return out
+}
+
+// coveringPrefix сообщает, есть ли среди prefixes подсеть, строго содержащая prefix
+func coveringPrefix(prefixes map[netip.Prefix]struct{}, prefix netip.Prefix) bool {
+	for bits := 0; bits < prefix.Bits(); bits++ {
+		if _, ok := prefixes[netip.PrefixFrom(prefix.Addr(), bits).Masked()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// maximalPrefixes возвращает подсети из prefixes, строго вложенные в outer
+// и не содержащиеся в других вложенных в outer подсетях
+func maximalPrefixes(prefixes map[netip.Prefix]struct{}, outer netip.Prefix) []netip.Prefix {
+	var inner []netip.Prefix
+	for prefix := range prefixes {
+		if prefix.Bits() > outer.Bits() && outer.Contains(prefix.Addr()) {
+			inner = append(inner, prefix)
+		}
+	}
+	slices.SortFunc(inner, func(a, b netip.Prefix) int {
+		return a.Bits() - b.Bits()
+	})
+
+	var out []netip.Prefix
+	for _, prefix := range inner {
+		if !slices.ContainsFunc(out, func(p netip.Prefix) bool { return p.Contains(prefix.Addr()) }) {
+			out = append(out, prefix)
+		}
+	}
+	return out
+}
+
+// intervalElements кодирует подсеть интервалом [начало, последний адрес + 1)
+func intervalElements(prefix netip.Prefix) []nftables.Element {
+	start := prefix.Addr().AsSlice()
+	end := slices.Clone(start)
+	hostBits := len(end)*8 - prefix.Bits()
+	for i := len(end) - 1; i >= 0 && hostBits > 0; i-- {
+		n := min(hostBits, 8)
+		end[i] |= byte(1<<n - 1)
+		hostBits -= n
+	}
+
+	elems := []nftables.Element{{Key: start}}
+	// Интервал до конца адресного пространства задаётся только началом
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			elems = append(elems, nftables.Element{Key: end, End: true})
+			break
+		}
+	}
+	return elems
+}
+
+func (b *nftablesBackend) listSet(name string, ipLen int) ([]setEntry, error) {
+	elems, err := b.conn.ListElements(b.table, nftSetName(name, ipLen, false))
+	if err != nil {
+		return nil, err
+	}
+	entries := nftHostEntries(elems, ipLen)
+
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	for prefix := range b.subnets[nftSetName(name, ipLen, true)] {
+		entries = append(entries, setEntry{IP: prefix.Addr().AsSlice(), CIDR: uint8(prefix.Bits())})
+	}
+	return entries, nil
+}
+
+// nftHostEntries переводит элементы набора узлов в записи с CIDR 0, как их добавляет DNS
+func nftHostEntries(elems []nftables.Element, ipLen int) []setEntry {
+	entries := make([]setEntry, 0, len(elems))
+	for _, elem := range elems {
+		if len(elem.Key) != ipLen {
+			continue
+		}
+		var timeout IPSetTimeout
+		if elem.Timeout != 0 {
+			timeout = func(i uint32) *uint32 { return &i }(uint32(elem.Expiration.Seconds()))
+		}
+		entries = append(entries, setEntry{IP: elem.Key, Timeout: timeout})
+	}
+	return entries
+}
+
+// setUsage возвращает число элементов наборов; ёмкость не ограничена
+func (b *nftablesBackend) setUsage(name string, _ IPSetOptions) ([]IPSetUsage, error) {
+	var usage []IPSetUsage
+	for _, ipLen := range []int{net.IPv4len, net.IPv6len} {
+		entries, err := b.listSet(name, ipLen)
+		if err != nil {
+			return nil, err
+		}
+		family := 4
+		if ipLen == net.IPv6len {
+			family = 6
+		}
+		usage = append(usage, IPSetUsage{Family: family, Entries: uint32(len(entries))})
+	}
+	return usage, nil
+}
+
+// resizeSet ничего не делает: наборы nftables не переполняются
+func (b *nftablesBackend) resizeSet(string, int, IPSetOptions) error {
+	return nil
+}
+
+/*
+	Правила
+*/
+
+func (b *nftablesBackend) insertLinkRules(r *IPSetToLink) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	if r.proxy() != "" {
+		return b.insertProxyRules(r)
+	}
+	if r.tag() != "" {
+		return b.insertTagRules(r)
+	}
+	if r.reject() {
+		return b.insertRejectRules(r)
+	}
+
+	forwardChain := r.chainName + "_FWD"
+	natChain := r.chainName + "_NAT"
+	outputChain := r.outputChainName()
+	mssChain := r.mssChainName()
+	markActions := r.nftMarkActions()
+
+	batch := &nftables.Batch{}
+	b.resetChain(batch, forwardChain)
+	b.resetChain(batch, r.chainName)
+	b.resetChain(batch, natChain)
+	b.resetChain(batch, outputChain)
+	b.resetChain(batch, mssChain)
+	if r.balancing() {
+		b.resetChain(batch, r.balanceChainName())
+		for _, rule := range r.nftBalanceRules() {
+			batch.AddRule(b.table, r.balanceChainName(), rule...)
+		}
+	} else {
+		b.deleteChains(batch, r.balanceChainName())
+	}
+
+	b.addCounterRules(batch, r)
+	batch.AddRule(b.table, r.chainName, nftCtReply(nftables.Return)...)
+
+	for _, family := range b.families() {
+		for _, subnets := range []bool{false, true} {
+			lookup := nftDstLookup(family, nftSetName(r.ipset.ipsetName, family, subnets))
+
+			for _, rule := range r.nftMSSClampRules(family, "FORWARD", lookup) {
+				batch.AddRule(b.table, forwardChain, rule...)
+			}
+			if r.opts.RouteOutput {
+				for _, rule := range r.nftMSSClampRules(family, "OUTPUT", lookup) {
+					batch.AddRule(b.table, mssChain, rule...)
+				}
+			}
+			for _, target := range r.targets() {
+				if target.ifaceName == Blackhole {
+					continue
+				}
+				for _, match := range r.nftTrafficMatches(family, "FORWARD") {
+					batch.AddRule(b.table, forwardChain, nftRule(match, lookup,
+						nftables.Meta{Key: unix.NFT_META_OIFNAME, Register: nftables.Reg1},
+						nftCmp(unix.NFT_CMP_EQ, nftables.IfName(target.ifaceName)),
+						nftables.Accept)...)
+				}
+			}
+
+			for _, match := range r.nftTrafficMatches(family, "PREROUTING") {
+				batch.AddRule(b.table, r.chainName, nftRule(match, lookup, markActions...)...)
+			}
+
+			natAction := r.nftNATAction(family)
+			for _, match := range r.nftTrafficMatches(family, "POSTROUTING") {
+				if natAction == nil {
+					break
+				}
+				batch.AddRule(b.table, natChain, nftRule(match, lookup, natAction...)...)
+			}
+
+			if !r.opts.RouteOutput {
+				continue
+			}
+			for _, match := range r.nftTrafficMatches(family, "OUTPUT") {
+				if natAction == nil || len(r.opts.SrcAddresses) == 0 {
+					continue
+				}
+				// Трафик роутера не попадает под селекторы источника, поэтому опознаётся по метке
+				for _, target := range r.targets() {
+					batch.AddRule(b.table, natChain, nftRule(match, lookup,
+						append(r.nh.nftMatchMark(target.mark, false), natAction...)...)...)
+				}
+			}
+		}
+	}
+
+	if r.opts.RouteOutput {
+		b.addOutputRules(batch, r, func(int) []nftables.Expr { return markActions })
+	}
+
+	b.link(batch, "forward", forwardChain)
+	b.link(batch, "prerouting", r.chainName)
+	b.link(batch, "postrouting", natChain)
+	if r.opts.RouteOutput {
+		b.link(batch, "output", outputChain)
+	} else {
+		b.unlink(batch, "output", outputChain)
+	}
+	if r.opts.RouteOutput && r.opts.MSSClamp != nil {
+		b.link(batch, "mssclamp", mssChain)
+	} else {
+		b.unlink(batch, "mssclamp", mssChain)
+	}
+
+	err := b.conn.Commit(batch)
+	if err != nil {
+		b.rollback(r.chainName, forwardChain, natChain, outputChain, mssChain, r.balanceChainName(), r.counterChainName())
+		return fmt.Errorf("failed to commit nftables rules: %w", err)
+	}
+	return nil
+}
+
+// addOutputRules – аналог insertOutputRules: маркировка трафика роутера действиями actions
+// с пропуском локального трафика, уже промаркированных сокетов, транспорта интерфейсов и исключений
+func (b *nftablesBackend) addOutputRules(batch *nftables.Batch, r *IPSetToLink, actions func(family int) []nftables.Expr) {
+	outputChain := r.outputChainName()
+	batch.AddRule(b.table, outputChain, nftCtReply(nftables.Return)...)
+	batch.AddRule(b.table, outputChain,
+		nftables.Meta{Key: unix.NFT_META_OIFNAME, Register: nftables.Reg1},
+		nftCmp(unix.NFT_CMP_EQ, nftables.IfName("lo")),
+		nftables.Return)
+	batch.AddRule(b.table, outputChain,
+		nftables.Meta{Key: unix.NFT_META_MARK, Register: nftables.Reg1},
+		nftCmp(unix.NFT_CMP_NEQ, nftables.NativeUint32(0)),
+		nftables.Return)
+
+	for _, family := range b.families() {
+		for _, bypass := range b.nh.OutputBypass {
+			if !bypass.Prefix.IsValid() || bypass.Prefix.Addr().Is4() != (family == net.IPv4len) {
+				continue
+			}
+			exprs := nftFamily(family)
+			exprs = append(exprs, nftPrefixMatch(family, false, bypass.Prefix)...)
+			if bypass.Protocol != "" {
+				exprs = append(exprs, nftProtoMatch(bypass.Protocol, "")...)
+				if bypass.Port != 0 {
+					exprs = append(exprs, nftProtoMatch("", strconv.Itoa(int(bypass.Port)))...)
+				}
+			}
+			batch.AddRule(b.table, outputChain, append(exprs, nftables.Return)...)
+		}
+		for _, prefix := range r.transportBypass() {
+			if prefix.Addr().Is4() != (family == net.IPv4len) {
+				continue
+			}
+			exprs := append(nftFamily(family), nftPrefixMatch(family, false, prefix)...)
+			batch.AddRule(b.table, outputChain, append(exprs, nftables.Return)...)
+		}
+	}
+
+	// Исключения должны проверяться раньше маркировки, поэтому правила добавляются вторым проходом
+	for _, family := range b.families() {
+		for _, subnets := range []bool{false, true} {
+			lookup := nftDstLookup(family, nftSetName(r.ipset.ipsetName, family, subnets))
+			for _, match := range r.nftTrafficMatches(family, "OUTPUT") {
+				batch.AddRule(b.table, outputChain, nftRule(match, lookup, actions(family)...)...)
+			}
+		}
+	}
+}
+
+// insertTagRules – аналог insertTagIPTablesRules: пометка трафика в prerouting и, для трафика роутера, в output
+func (b *nftablesBackend) insertTagRules(r *IPSetToLink) error {
+	outputChain := r.outputChainName()
+
+	batch := &nftables.Batch{}
+	b.resetChain(batch, r.chainName)
+	b.resetChain(batch, outputChain)
+	b.addCounterRules(batch, r)
+	batch.AddRule(b.table, r.chainName, nftCtReply(nftables.Return)...)
+	for _, family := range b.families() {
+		for _, subnets := range []bool{false, true} {
+			lookup := nftDstLookup(family, nftSetName(r.ipset.ipsetName, family, subnets))
+			for _, match := range r.nftTrafficMatches(family, "PREROUTING") {
+				batch.AddRule(b.table, r.chainName, nftRule(match, lookup, r.nftTagActions(family)...)...)
+			}
+		}
+	}
+	if r.opts.RouteOutput {
+		b.addOutputRules(batch, r, r.nftTagActions)
+	}
+
+	b.link(batch, "prerouting", r.chainName)
+	if r.opts.RouteOutput {
+		b.link(batch, "output", outputChain)
+	} else {
+		b.unlink(batch, "output", outputChain)
+	}
+
+	err := b.conn.Commit(batch)
+	if err != nil {
+		b.rollback(r.chainName, outputChain, r.counterChainName())
+		return fmt.Errorf("failed to commit nftables rules: %w", err)
+	}
+	return nil
+}
+
+// insertRejectRules – аналог insertRejectIPTablesRules: отклонение в forward и, для трафика роутера, в output
+func (b *nftablesBackend) insertRejectRules(r *IPSetToLink) error {
+	forwardChain := r.chainName + "_FWD"
+	outputChain := r.outputChainName()
+
+	batch := &nftables.Batch{}
+	b.resetChain(batch, forwardChain)
+	b.resetChain(batch, outputChain)
+	b.addCounterRules(batch, r)
+	for _, family := range b.families() {
+		for _, subnets := range []bool{false, true} {
+			lookup := nftDstLookup(family, nftSetName(r.ipset.ipsetName, family, subnets))
+			for _, rule := range r.nftRejectRules(family, "FORWARD", lookup) {
+				batch.AddRule(b.table, forwardChain, rule...)
+			}
+			if !r.opts.RouteOutput {
+				continue
+			}
+			for _, rule := range r.nftRejectRules(family, "OUTPUT", lookup) {
+				batch.AddRule(b.table, outputChain, rule...)
+			}
+		}
+	}
+
+	b.link(batch, "forward", forwardChain)
+	if r.opts.RouteOutput {
+		b.link(batch, "output", outputChain)
+	} else {
+		b.unlink(batch, "output", outputChain)
+	}
+
+	err := b.conn.Commit(batch)
+	if err != nil {
+		b.rollback(forwardChain, outputChain, r.counterChainName())
+		return fmt.Errorf("failed to commit nftables rules: %w", err)
+	}
+	return nil
+}
+
+// insertProxyRules – аналог insertProxyIPTablesRules: tproxy в prerouting или redirect в dstnat
+func (b *nftablesBackend) insertProxyRules(r *IPSetToLink) error {
+	chain := r.chainName
+	if r.proxy() == ProxyRedirect {
+		chain = r.redirectChainName()
+	}
+	port := []nftables.Expr{nftables.Immediate{Register: nftables.Reg1, Data: nftables.BigEndianUint16(r.opts.Proxy.Port)}}
+
+	batch := &nftables.Batch{}
+	b.resetChain(batch, chain)
+	b.addCounterRules(batch, r)
+	if r.proxy() == ProxyTProxy {
+		batch.AddRule(b.table, chain, nftCtReply(nftables.Return)...)
+	}
+	for _, family := range b.families() {
+		var action []nftables.Expr
+		if r.proxy() == ProxyRedirect {
+			action = append(port, nftables.Redir{PortRegister: nftables.Reg1})
+		} else {
+			nfproto := uint32(unix.NFPROTO_IPV4)
+			if family == net.IPv6len {
+				nfproto = unix.NFPROTO_IPV6
+			}
+			action = append(port, nftables.TProxy{Family: nfproto, PortRegister: nftables.Reg1})
+			action = append(action, r.nh.nftSetMark(r.mark, false)...)
+		}
+
+		for _, subnets := range []bool{false, true} {
+			lookup := nftDstLookup(family, nftSetName(r.ipset.ipsetName, family, subnets))
+			for _, match := range r.nftTrafficMatches(family, "PREROUTING") {
+				batch.AddRule(b.table, chain, nftRule(match, lookup, action...)...)
+			}
+		}
+	}
+	if r.proxy() == ProxyRedirect {
+		b.link(batch, "dstnat", chain)
+	} else {
+		b.link(batch, "prerouting", chain)
+	}
+
+	err := b.conn.Commit(batch)
+	if err != nil {
+		b.rollback(chain, r.counterChainName())
+		return fmt.Errorf("failed to commit nftables rules: %w", err)
+	}
+	return nil
+}
+
+// addCounterRules пересоздаёт цепочку счётчиков группы и подключает её к accounting
+func (b *nftablesBackend) addCounterRules(batch *nftables.Batch, r *IPSetToLink) {
+	b.resetChain(batch, r.counterChainName())
+	for _, family := range b.families() {
+		for _, subnets := range []bool{false, true} {
+			comments, rules := r.nftCounterRules(family, nftSetName(r.ipset.ipsetName, family, subnets))
+			for i, rule := range rules {
+				batch.AddRuleComment(b.table, r.counterChainName(), comments[i], rule...)
+			}
+		}
+	}
+	b.link(batch, "accounting", r.counterChainName())
+}
+
+// counters суммирует счётчики цепочки счётчиков группы по комментариям правил
+func (b *nftablesBackend) counters(r *IPSetToLink) ([]TrafficCounter, error) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	rules, err := b.conn.ListCounters(b.table, r.counterChainName())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read counters: %w", err)
+	}
+
+	var counters []TrafficCounter
+	for _, family := range b.families() {
+		counter := TrafficCounter{Family: nftFamilyVersion(family)}
+		tx := fmt.Sprintf("%s%d", counterTx, counter.Family)
+		rx := fmt.Sprintf("%s%d", counterRx, counter.Family)
+		for _, rule := range rules {
+			var traffic *Traffic
+			switch rule.Comment {
+			case tx:
+				traffic = &counter.Tx
+			case rx:
+				traffic = &counter.Rx
+			default:
+				continue
+			}
+			traffic.Packets += rule.Packets
+			traffic.Bytes += rule.Bytes
+		}
+		counters = append(counters, counter)
+	}
+	return counters, nil
+}
+
+func (b *nftablesBackend) deleteLinkRules(r *IPSetToLink) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	batch := &nftables.Batch{}
+	b.unlink(batch, "forward", r.chainName+"_FWD")
+	b.unlink(batch, "prerouting", r.chainName)
+	b.unlink(batch, "postrouting", r.chainName+"_NAT")
+	b.unlink(batch, "output", r.outputChainName())
+	b.unlink(batch, "dstnat", r.redirectChainName())
+	b.unlink(batch, "accounting", r.counterChainName())
+	b.unlink(batch, "mssclamp", r.mssChainName())
+	b.deleteChains(batch, r.chainName+"_FWD", r.chainName, r.chainName+"_NAT", r.outputChainName(), r.balanceChainName(), r.redirectChainName(), r.counterChainName(), r.mssChainName())
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	err := b.conn.Commit(batch)
+	if err != nil {
+		return fmt.Errorf("failed to commit nftables rules: %w", err)
+	}
+	return nil
+}
+
+func (b *nftablesBackend) insertPortRemap(r *PortRemap) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	batch := &nftables.Batch{}
+	b.resetChain(batch, r.chainName)
+	for _, addr := range r.addresses {
+		family := len(addr.IP)
+		if !b.familyEnabled(family) {
+			continue
+		}
+		prefix, ok := netip.AddrFromSlice(addr.IP)
+		if !ok {
+			continue
+		}
+		for _, protocol := range []string{"tcp", "udp"} {
+			exprs := nftFamily(family)
+			exprs = append(exprs, nftPrefixMatch(family, false, netip.PrefixFrom(prefix, prefix.BitLen()))...)
+			exprs = append(exprs, nftProtoMatch(protocol, strconv.Itoa(int(r.from)))...)
+			exprs = append(exprs,
+				nftables.Immediate{Register: nftables.Reg1, Data: nftables.BigEndianUint16(r.to)},
+				nftables.Redir{PortRegister: nftables.Reg1})
+			batch.AddRule(b.table, r.chainName, exprs...)
+		}
+	}
+	b.link(batch, "dstnat", r.chainName)
+
+	err := b.conn.Commit(batch)
+	if err != nil {
+		b.rollback(r.chainName)
+		return fmt.Errorf("failed to commit nftables rules: %w", err)
+	}
+	return nil
+}
+
+func (b *nftablesBackend) deletePortRemap(r *PortRemap) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	batch := &nftables.Batch{}
+	b.unlink(batch, "dstnat", r.chainName)
+	b.deleteChains(batch, r.chainName)
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	err := b.conn.Commit(batch)
+	if err != nil {
+		return fmt.Errorf("failed to commit nftables rules: %w", err)
+	}
+	return nil
+}
+
+// resetChain создаёт цепочку группы или очищает существующую
+func (b *nftablesBackend) resetChain(batch *nftables.Batch, chain string) {
+	batch.AddChain(b.table, chain, nil)
+	batch.FlushChain(b.table, chain)
+	b.chains[chain] = struct{}{}
+}
+
+func (b *nftablesBackend) deleteChains(batch *nftables.Batch, chains ...string) {
+	for _, chain := range chains {
+		if _, ok := b.chains[chain]; !ok {
+			continue
+		}
+		batch.FlushChain(b.table, chain)
+		batch.DelChain(b.table, chain)
+		delete(b.chains, chain)
+	}
+}
+
+// rollback забывает цепочки, созданные в отклонённом ядром пакете
+func (b *nftablesBackend) rollback(chains ...string) {
+	for _, chain := range chains {
+		delete(b.chains, chain)
+		for base, linked := range b.jumps {
+			b.jumps[base] = slices.DeleteFunc(linked, func(c string) bool { return c == chain })
+		}
+	}
+}
+
+// link подключает цепочку к базовой; переходы базовой цепочки пересоздаются целиком
+func (b *nftablesBackend) link(batch *nftables.Batch, base, chain string) {
+	if !slices.Contains(b.jumps[base], chain) {
+		b.jumps[base] = append(b.jumps[base], chain)
+	}
+	b.relink(batch, base)
+}
+
+func (b *nftablesBackend) unlink(batch *nftables.Batch, base, chain string) {
+	if !slices.Contains(b.jumps[base], chain) {
+		return
+	}
+	b.jumps[base] = slices.DeleteFunc(b.jumps[base], func(c string) bool { return c == chain })
+	b.relink(batch, base)
+}
+
+func (b *nftablesBackend) relink(batch *nftables.Batch, base string) {
+	batch.FlushChain(b.table, base)
+	for _, chain := range b.jumps[base] {
+		batch.AddRule(b.table, base, nftables.Jump(chain))
+	}
+}
+
+func (b *nftablesBackend) families() []int {
+	var families []int
+	for _, family := range []int{net.IPv4len, net.IPv6len} {
+		if b.familyEnabled(family) {
+			families = append(families, family)
+		}
+	}
+	return families
+}
+
+func (b *nftablesBackend) familyEnabled(family int) bool {
+	if family == net.IPv4len {
+		return !b.nh.DisableIPv4
+	}
+	return !b.nh.DisableIPv6
+}
+
+/*
+	Выражения
+*/
+
+// nftTrafficMatches – аналог trafficMatches для nf_tables: возвращает наборы выражений
+// квалификаторов группы для семейства family (длина адреса) в цепочке chain
+func (r *IPSetToLink) nftTrafficMatches(family int, chain string) [][]nftables.Expr {
+	return r.nftProtocolMatches(family, chain, r.protocols())
+}
+
+// nftProtocolMatches – nftTrafficMatches с заданным списком протоколов
+func (r *IPSetToLink) nftProtocolMatches(family int, chain string, protocols []string) [][]nftables.Expr {
+	matches := [][]nftables.Expr{nftFamily(family)}
+
+	if len(r.opts.SrcAddresses) > 0 && chain != "OUTPUT" {
+		var sources [][]nftables.Expr
+		for _, source := range r.opts.SrcAddresses {
+			prefix, ok := parseSourcePrefix(source)
+			if !ok || prefix.Addr().Is4() != (family == net.IPv4len) {
+				continue
+			}
+			sources = append(sources, nftPrefixMatch(family, true, prefix))
+		}
+		if len(sources) == 0 {
+			return nil
+		}
+		matches = crossExprs(matches, sources)
+	}
+
+	if chain == "FORWARD" || chain == "PREROUTING" {
+		if len(r.opts.SrcInterfaces) > 0 {
+			ifaces := make([][]nftables.Expr, len(r.opts.SrcInterfaces))
+			for i, iface := range r.opts.SrcInterfaces {
+				ifaces[i] = []nftables.Expr{
+					nftables.Meta{Key: unix.NFT_META_IIFNAME, Register: nftables.Reg1},
+					nftCmp(unix.NFT_CMP_EQ, nftables.IfName(iface)),
+				}
+			}
+			matches = crossExprs(matches, ifaces)
+		}
+
+		if len(r.opts.SrcMACs) > 0 {
+			var macs [][]nftables.Expr
+			for _, mac := range r.opts.SrcMACs {
+				hw, err := net.ParseMAC(mac)
+				if err != nil || len(hw) != 6 {
+					continue
+				}
+				macs = append(macs, []nftables.Expr{
+					nftables.Meta{Key: unix.NFT_META_IIFTYPE, Register: nftables.Reg1},
+					nftCmp(unix.NFT_CMP_EQ, nftables.NativeUint16(unix.ARPHRD_ETHER)),
+					nftables.Payload{Base: unix.NFT_PAYLOAD_LL_HEADER, Offset: 6, Len: 6, Register: nftables.Reg1},
+					nftCmp(unix.NFT_CMP_EQ, hw),
+				})
+			}
+			if len(macs) == 0 {
+				return nil
+			}
+			matches = crossExprs(matches, macs)
+		}
+	}
+
+	if len(protocols) > 0 {
+		var protoMatches [][]nftables.Expr
+		for _, protocol := range protocols {
+			if protocol == "icmp" && family == net.IPv6len {
+				protocol = "ipv6-icmp"
+			}
+			if protocol == "icmp" || protocol == "ipv6-icmp" || len(r.opts.DstPorts) == 0 {
+				protoMatches = append(protoMatches, nftProtoMatch(protocol, ""))
+				continue
+			}
+			for _, port := range r.opts.DstPorts {
+				protoMatches = append(protoMatches, nftProtoMatch(protocol, port))
+			}
+		}
+		matches = crossExprs(matches, protoMatches)
+	}
+
+	return matches
+}
+
+func crossExprs(base, values [][]nftables.Expr) [][]nftables.Expr {
+	out := make([][]nftables.Expr, 0, len(base)*len(values))
+	for _, b := range base {
+		for _, v := range values {
+			out = append(out, append(slices.Clone(b), v...))
+		}
+	}
+	return out
+}
+
+func nftRule(match, lookup []nftables.Expr, action ...nftables.Expr) []nftables.Expr {
+	out := make([]nftables.Expr, 0, len(match)+len(lookup)+len(action))
+	out = append(out, match...)
+	out = append(out, lookup...)
+	return append(out, action...)
+}
+
+func nftCmp(op uint32, data []byte) nftables.Cmp {
+	return nftables.Cmp{Op: op, Register: nftables.Reg1, Data: data}
+}
+
+func nftFamily(family int) []nftables.Expr {
+	nfproto := byte(unix.NFPROTO_IPV4)
+	if family == net.IPv6len {
+		nfproto = unix.NFPROTO_IPV6
+	}
+	return []nftables.Expr{
+		nftables.Meta{Key: unix.NFT_META_NFPROTO, Register: nftables.Reg1},
+		nftCmp(unix.NFT_CMP_EQ, []byte{nfproto}),
+	}
+}
+
+func nftCtReply(verdict nftables.Verdict) []nftables.Expr {
+	return append(nftCtDirection(ctDirReply), verdict)
+}
+
+// nftCtDirection проверяет направление пакета в соединении conntrack
+func nftCtDirection(dir byte) []nftables.Expr {
+	return []nftables.Expr{
+		nftables.Ct{Key: unix.NFT_CT_DIRECTION, Register: nftables.Reg1},
+		nftCmp(unix.NFT_CMP_EQ, []byte{dir}),
+	}
+}
+
+// nftAddrOffset возвращает смещение адреса источника или назначения в заголовке IP
+func nftAddrOffset(family int, src bool) uint32 {
+	switch {
+	case family == net.IPv4len && src:
+		return 12
+	case family == net.IPv4len:
+		return 16
+	case src:
+		return 8
+	default:
+		return 24
+	}
+}
+
+func nftDstLookup(family int, setName string) []nftables.Expr {
+	return []nftables.Expr{
+		nftables.Payload{Base: unix.NFT_PAYLOAD_NETWORK_HEADER, Offset: nftAddrOffset(family, false), Len: uint32(family), Register: nftables.Reg1},
+		nftables.Lookup{Set: setName, Register: nftables.Reg1},
+	}
+}
+
+func nftSrcLookup(family int, setName string) []nftables.Expr {
+	return []nftables.Expr{
+		nftables.Payload{Base: unix.NFT_PAYLOAD_NETWORK_HEADER, Offset: nftAddrOffset(family, true), Len: uint32(family), Register: nftables.Reg1},
+		nftables.Lookup{Set: setName, Register: nftables.Reg1},
+	}
+}
+
+func nftPrefixMatch(family int, src bool, prefix netip.Prefix) []nftables.Expr {
+	exprs := []nftables.Expr{
+		nftables.Payload{Base: unix.NFT_PAYLOAD_NETWORK_HEADER, Offset: nftAddrOffset(family, src), Len: uint32(family), Register: nftables.Reg1},
+	}
+	if prefix.Bits() < family*8 {
+		mask := net.CIDRMask(prefix.Bits(), family*8)
+		exprs = append(exprs, nftables.Bitwise{Register: nftables.Reg1, Mask: mask})
+	}
+	return append(exprs, nftCmp(unix.NFT_CMP_EQ, prefix.Masked().Addr().AsSlice()))
+}
+
+var nftProtocols = map[string]byte{
+	"icmp":      unix.IPPROTO_ICMP,
+	"ipv6-icmp": unix.IPPROTO_ICMPV6,
+	"tcp":       unix.IPPROTO_TCP,
+	"udp":       unix.IPPROTO_UDP,
+	"sctp":      unix.IPPROTO_SCTP,
+	"dccp":      unix.IPPROTO_DCCP,
+}
+
+// nftProtoMatch проверяет протокол и порт назначения ("443" или "8000-8080");
+// пустые значения не проверяются
+func nftProtoMatch(protocol, port string) []nftables.Expr {
+	var exprs []nftables.Expr
+	if protocol != "" {
+		exprs = append(exprs,
+			nftables.Meta{Key: unix.NFT_META_L4PROTO, Register: nftables.Reg1},
+			nftCmp(unix.NFT_CMP_EQ, []byte{nftProtocols[protocol]}))
+	}
+	if port == "" {
+		return exprs
+	}
+
+	exprs = append(exprs, nftables.Payload{Base: unix.NFT_PAYLOAD_TRANSPORT_HEADER, Offset: 2, Len: 2, Register: nftables.Reg1})
+	from, to, isRange := strings.Cut(port, "-")
+	fromPort, _ := strconv.ParseUint(from, 10, 16)
+	if !isRange {
+		return append(exprs, nftCmp(unix.NFT_CMP_EQ, nftables.BigEndianUint16(uint16(fromPort))))
+	}
+	toPort, _ := strconv.ParseUint(to, 10, 16)
+	return append(exprs,
+		nftCmp(unix.NFT_CMP_GTE, nftables.BigEndianUint16(uint16(fromPort))),
+		nftCmp(unix.NFT_CMP_LTE, nftables.BigEndianUint16(uint16(toPort))))
+}
+
+// nftSetMark ставит метку пакету и, если saveCt, сохраняет её в conntrack
+// (аналог MARK + CONNMARK --save-mark). При неполной маске биты вне неё не меняются.
+func (nh *Helper) nftSetMark(mark uint32, saveCt bool) []nftables.Expr {
+	return nftSetMaskedMark(mark, nh.markMask(), saveCt)
+}
+
+// nftSetMaskedMark – nftSetMark с явной маской
+func nftSetMaskedMark(mark, mask uint32, saveCt bool) []nftables.Expr {
+	if mask == FullMarkMask {
+		exprs := []nftables.Expr{
+			nftables.Immediate{Register: nftables.Reg1, Data: nftables.NativeUint32(mark)},
+			nftables.Meta{Key: unix.NFT_META_MARK, Register: nftables.Reg1, Set: true},
+		}
+		if saveCt {
+			exprs = append(exprs, nftables.Ct{Key: unix.NFT_CT_MARK, Register: nftables.Reg1, Set: true})
+		}
+		return exprs
+	}
+
+	update := nftables.Bitwise{Register: nftables.Reg1, Mask: nftables.NativeUint32(^mask), Xor: nftables.NativeUint32(mark)}
+	exprs := []nftables.Expr{
+		nftables.Meta{Key: unix.NFT_META_MARK, Register: nftables.Reg1},
+		update,
+		nftables.Meta{Key: unix.NFT_META_MARK, Register: nftables.Reg1, Set: true},
+	}
+	if saveCt {
+		exprs = append(exprs,
+			nftables.Ct{Key: unix.NFT_CT_MARK, Register: nftables.Reg1},
+			update,
+			nftables.Ct{Key: unix.NFT_CT_MARK, Register: nftables.Reg1, Set: true})
+	}
+	return exprs
+}
+
+// nftMatchMark сравнивает метку пакета (или conntrack, если ct) с mark в пределах маски
+func (nh *Helper) nftMatchMark(mark uint32, ct bool) []nftables.Expr {
+	var exprs []nftables.Expr
+	if ct {
+		exprs = append(exprs, nftables.Ct{Key: unix.NFT_CT_MARK, Register: nftables.Reg1})
+	} else {
+		exprs = append(exprs, nftables.Meta{Key: unix.NFT_META_MARK, Register: nftables.Reg1})
+	}
+	if mask := nh.markMask(); mask != FullMarkMask {
+		exprs = append(exprs, nftables.Bitwise{Register: nftables.Reg1, Mask: nftables.NativeUint32(mask)})
+	}
+	return append(exprs, nftCmp(unix.NFT_CMP_EQ, nftables.NativeUint32(mark)))
+}
+
+// nftMarkActions – аналог markActions: установка метки или переход в цепочку балансировки
+func (r *IPSetToLink) nftMarkActions() []nftables.Expr {
+	if r.balancing() {
+		return []nftables.Expr{nftables.Jump(r.balanceChainName())}
+	}
+	return r.nh.nftSetMark(r.mark, true)
+}
+
+// nftMSSClampRules – аналог mssClampRules: MSS по MTU маршрута (rt mtu) или не больше заданного
+func (r *IPSetToLink) nftMSSClampRules(family int, chain string, lookup []nftables.Expr) [][]nftables.Expr {
+	if r.opts.MSSClamp == nil {
+		return nil
+	}
+	protocols, ok := r.tcpProtocols()
+	if !ok {
+		return nil
+	}
+
+	mss := nftables.TCPOption{Kind: 2, Offset: 2, Len: 2, Register: nftables.Reg1}
+	setMSS := mss
+	setMSS.Set = true
+	action := []nftables.Expr{
+		// Только SYN без RST: tcp flags & (syn|rst) == syn
+		nftables.Payload{Base: unix.NFT_PAYLOAD_TRANSPORT_HEADER, Offset: 13, Len: 1, Register: nftables.Reg1},
+		nftables.Bitwise{Register: nftables.Reg1, Mask: []byte{0x06}},
+		nftCmp(unix.NFT_CMP_EQ, []byte{0x02}),
+	}
+	if size := r.opts.MSSClamp.Size; size != 0 {
+		action = append(action,
+			mss,
+			nftCmp(unix.NFT_CMP_GT, nftables.BigEndianUint16(size)),
+			nftables.Immediate{Register: nftables.Reg1, Data: nftables.BigEndianUint16(size)},
+			setMSS)
+	} else {
+		action = append(action, nftables.Rt{Key: unix.NFT_RT_TCPMSS, Register: nftables.Reg1}, setMSS)
+	}
+
+	var rules [][]nftables.Expr
+	for _, target := range r.targets() {
+		if target.ifaceName == Blackhole {
+			continue
+		}
+		oif := []nftables.Expr{
+			nftables.Meta{Key: unix.NFT_META_OIFNAME, Register: nftables.Reg1},
+			nftCmp(unix.NFT_CMP_EQ, nftables.IfName(target.ifaceName)),
+		}
+		for _, match := range r.nftProtocolMatches(family, chain, protocols) {
+			rules = append(rules, nftRule(match, lookup, append(oif, action...)...))
+		}
+	}
+	return rules
+}
+
+// nftBalanceRules – аналог balanceRules: восстановление метки соединения и выбор
+// интерфейса для нового соединения случайным числом из [0, сумма оставшихся весов)
+func (r *IPSetToLink) nftBalanceRules() [][]nftables.Expr {
+	active := r.activeTargets()
+
+	var rules [][]nftables.Expr
+	for _, target := range active {
+		rules = append(rules, append(r.nh.nftMatchMark(target.mark, true),
+			append(r.nh.nftSetMark(target.mark, true), nftables.Return)...))
+	}
+
+	var remaining uint32
+	for _, target := range active {
+		remaining += r.weight(target)
+	}
+	for _, target := range active[:len(active)-1] {
+		rules = append(rules, append([]nftables.Expr{
+			nftables.Numgen{Register: nftables.Reg1, Modulus: remaining},
+			nftables.Hton{Register: nftables.Reg1},
+			nftCmp(unix.NFT_CMP_LT, nftables.BigEndianUint32(r.weight(target))),
+		}, append(r.nh.nftSetMark(target.mark, true), nftables.Return)...))
+		remaining -= r.weight(target)
+	}
+	rules = append(rules, r.nh.nftSetMark(active[len(active)-1].mark, true))
+	return rules
+}
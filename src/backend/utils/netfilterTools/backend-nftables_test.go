@@ -0,0 +1,117 @@
+package netfilterTools
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"magitrickle/utils/nftables"
+)
+
+func TestIntervalElements(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []nftables.Element
+	}{
+		{"10.0.0.0/8", []nftables.Element{
+			{Key: []byte{10, 0, 0, 0}},
+			{Key: []byte{11, 0, 0, 0}, End: true},
+		}},
+		{"192.168.1.128/25", []nftables.Element{
+			{Key: []byte{192, 168, 1, 128}},
+			{Key: []byte{192, 168, 2, 0}, End: true},
+		}},
+		{"255.255.255.0/24", []nftables.Element{
+			{Key: []byte{255, 255, 255, 0}},
+		}},
+	}
+	for _, tt := range tests {
+		got := intervalElements(netip.MustParsePrefix(tt.prefix))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("intervalElements(%s) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestMaximalPrefixes(t *testing.T) {
+	prefixes := map[netip.Prefix]struct{}{}
+	for _, p := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.2.0.0/16", "11.0.0.0/16"} {
+		prefixes[netip.MustParsePrefix(p)] = struct{}{}
+	}
+
+	if !coveringPrefix(prefixes, netip.MustParsePrefix("10.1.2.0/24")) {
+		t.Error("10.1.2.0/24 must be covered")
+	}
+	if coveringPrefix(prefixes, netip.MustParsePrefix("10.0.0.0/8")) {
+		t.Error("10.0.0.0/8 must not be covered")
+	}
+
+	got := maximalPrefixes(prefixes, netip.MustParsePrefix("10.0.0.0/8"))
+	want := map[netip.Prefix]bool{
+		netip.MustParsePrefix("10.1.0.0/16"): true,
+		netip.MustParsePrefix("10.2.0.0/16"): true,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("maximalPrefixes() = %v, want %v", got, want)
+	}
+	for _, p := range got {
+		if !want[p] {
+			t.Errorf("unexpected prefix %s", p)
+		}
+	}
+}
+
+func TestMaximalSorted(t *testing.T) {
+	prefixes := map[netip.Prefix]struct{}{}
+	for _, p := range []string{"10.0.0.0/8", "10.0.0.0/16", "10.0.0.0/24", "10.1.0.0/16", "10.1.2.0/24", "10.2.0.0/16", "11.0.0.0/16", "9.0.0.0/8"} {
+		prefixes[netip.MustParsePrefix(p)] = struct{}{}
+	}
+	sorted := sortedPrefixes(prefixes)
+
+	for _, outer := range []string{"10.0.0.0/8", "10.0.0.0/16", "10.1.0.0/16", "0.0.0.0/0", "12.0.0.0/8"} {
+		prefix := netip.MustParsePrefix(outer)
+		got := maximalSorted(sorted, prefix)
+		want := maximalPrefixes(prefixes, prefix)
+		slices.SortFunc(want, comparePrefixes)
+		if !slices.Equal(got, want) {
+			t.Errorf("maximalSorted(%s) = %v, want %v", outer, got, want)
+		}
+	}
+}
+
+func TestNFTHostEntriesResync(t *testing.T) {
+	// Набор после синхронизации: узел из DNS с таймаутом и постоянный узел
+	elems := []nftables.Element{
+		{Key: []byte{10, 0, 0, 1}, Timeout: time.Minute, Expiration: 50 * time.Second},
+		{Key: []byte{10, 0, 0, 2}},
+		{Key: net.ParseIP("2001:db8::1")},
+	}
+	listed := make(map[IPv4Subnet]IPSetTimeout)
+	for _, entry := range nftHostEntries(elems, net.IPv4len) {
+		listed[IPv4Subnet{Address: [4]byte(entry.IP), CIDR: entry.CIDR}] = entry.Timeout
+	}
+
+	// Повторная синхронизация сравнивает список с адресами из DNS, записанными с CIDR 0
+	for _, host := range []IPv4Subnet{{Address: [4]byte{10, 0, 0, 1}}, {Address: [4]byte{10, 0, 0, 2}}} {
+		if _, ok := listed[host]; !ok {
+			t.Errorf("host %s is missing from listed entries %v", host, listed)
+		}
+	}
+	if len(listed) != 2 {
+		t.Errorf("listed %d entries, want 2", len(listed))
+	}
+	if timeout := listed[IPv4Subnet{Address: [4]byte{10, 0, 0, 1}}]; timeout == nil || *timeout != 50 {
+		t.Errorf("timeout = %v, want 50", timeout)
+	}
+
+	// Ядро сообщает узлы ipset с полной длиной префикса
+	if got := hostCIDR([]byte{10, 0, 0, 1}, 32); got != 0 {
+		t.Errorf("hostCIDR(/32) = %d, want 0", got)
+	}
+	if got := hostCIDR([]byte{10, 0, 0, 0}, 24); got != 24 {
+		t.Errorf("hostCIDR(/24) = %d, want 24", got)
+	}
+}
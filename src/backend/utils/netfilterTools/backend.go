@@ -0,0 +1,94 @@
+package netfilterTools
+
+import (
+	"os/exec"
+	"strings"
+
+	"magitrickle/utils/nftables"
+)
+
+const (
+	BackendAuto     = "auto"
+	BackendIPTables = "iptables"
+	BackendNFTables = "nftables"
+)
+
+// setEntry – элемент набора адресов; Timeout == nil означает бессрочный элемент
+type setEntry struct {
+	IP      []byte
+	CIDR    uint8
+	Timeout IPSetTimeout
+}
+
+// isHost сообщает, что запись адресует один узел (DNS добавляет адреса с CIDR 0)
+func isHost(ip []byte, cidr uint8) bool {
+	return cidr == 0 || int(cidr) == len(ip)*8
+}
+
+// hostCIDR возвращает CIDR записи в том виде, в каком её добавляет DNS: у узла – 0.
+// Ядро сообщает узлы с полной длиной префикса, и без приведения синхронизация
+// считала бы один и тот же адрес разными записями.
+func hostCIDR(ip []byte, cidr uint8) uint8 {
+	if isHost(ip, cidr) {
+		return 0
+	}
+	return cidr
+}
+
+// backend – реализация netfilter, через которую работают IPSet, IPSetToLink и PortRemap.
+// Наборы адресуются базовым именем; реализация сама разделяет их по семействам (_4/_6).
+type backend interface {
+	name() string
+	clean() error
+	close() error
+
+	createSet(name string, opts IPSetOptions) error
+	destroySet(name string) error
+	addToSet(name string, ip []byte, cidr uint8, timeout IPSetTimeout) error
+	delFromSet(name string, ip []byte, cidr uint8) error
+	// addToSetBatch добавляет элементы одного семейства одним запросом; существующие элементы заменяются
+	addToSetBatch(name string, entries []setEntry) error
+	// delFromSetBatch удаляет элементы одного семейства одним запросом; отсутствующие пропускаются
+	delFromSetBatch(name string, entries []setEntry) error
+	// listSet возвращает элементы набора семейства, определяемого длиной адреса ipLen
+	listSet(name string, ipLen int) ([]setEntry, error)
+	// setUsage возвращает заполненность наборов обоих семейств
+	setUsage(name string, opts IPSetOptions) ([]IPSetUsage, error)
+	// resizeSet пересоздаёт набор семейства ipLen с новыми размерами, сохраняя элементы
+	resizeSet(name string, ipLen int, opts IPSetOptions) error
+
+	insertLinkRules(r *IPSetToLink) error
+	deleteLinkRules(r *IPSetToLink) error
+	// counters возвращает счётчики трафика группы по включённым семействам
+	counters(r *IPSetToLink) ([]TrafficCounter, error)
+
+	insertPortRemap(r *PortRemap) error
+	deletePortRemap(r *PortRemap) error
+
+	// reconcile сверяет правила с желаемым состоянием и исправляет расхождения
+	reconcile() ([]string, error)
+	// probe проверяет возможности ядра, которые использует реализация
+	probe() []Capability
+}
+
+// DetectBackend выбирает реализацию netfilter: iptables, если доступен классический (legacy)
+// iptables-restore, иначе nftables, если его поддерживает ядро. Обёртка iptables-nft
+// используется только при отсутствии поддержки nf_tables через netlink.
+func DetectBackend() string {
+	if _, err := exec.LookPath("iptables-restore"); err == nil {
+		out, err := exec.Command("iptables-restore", "--version").CombinedOutput()
+		if err != nil || !strings.Contains(string(out), "nf_tables") {
+			return BackendIPTables
+		}
+	}
+
+	if conn, err := nftables.Open(); err == nil {
+		supported := conn.Supported()
+		_ = conn.Close()
+		if supported {
+			return BackendNFTables
+		}
+	}
+
+	return BackendIPTables
+}
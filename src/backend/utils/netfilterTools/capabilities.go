@@ -0,0 +1,367 @@
+package netfilterTools
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"magitrickle/utils/iptables"
+	"magitrickle/utils/nftables"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Проверка возможностей ядра: без xt_set, ip_set_hash_net, conntrack или policy routing
+	правила групп не применяются, а ошибка iptables-restore посреди запуска не говорит,
+	чего не хватает. Preflight проверяет каждую возможность отдельно, отключает семейство
+	адресов, в котором не работают обязательные возможности, и удаление соединений
+	conntrack; группы, которым не хватает возможностей, не включаются.
+*/
+
+// Проверяемые возможности
+const (
+	// CapIPTables – iptables-save и iptables-restore семейства
+	CapIPTables = "iptables"
+	// CapNFTables – таблицы nf_tables через netlink
+	CapNFTables = "nftables"
+	// CapIPSet – наборы hash:net (ip_set_hash_net) или наборы nf_tables
+	CapIPSet = "ipset"
+	// CapSetMatch – проверка адреса по набору (xt_set, nft_lookup)
+	CapSetMatch = "set_match"
+	// CapConntrack – состояние соединения в правилах (xt_conntrack, nft_ct)
+	CapConntrack = "conntrack"
+	// CapMark – метки пакетов и соединений (MARK, CONNMARK)
+	CapMark = "mark"
+	// CapPolicyRouting – ip rule по метке и отдельные таблицы маршрутизации
+	CapPolicyRouting = "policy_routing"
+	// CapNAT – трансляция адресов (MASQUERADE, SNAT, REDIRECT)
+	CapNAT = "nat"
+	// CapTProxy – прозрачный прокси (TPROXY)
+	CapTProxy = "tproxy"
+	// CapReject – отклонение с ответом отправителю (REJECT)
+	CapReject = "reject"
+	// CapConntrackNetlink – удаление соединений через netlink (nf_conntrack_netlink)
+	CapConntrackNetlink = "conntrack_netlink"
+)
+
+var ErrCapabilityMissing = errors.New("kernel capability is missing")
+
+// familyCapabilities – возможности, без которых в семействе не работает ни одна группа
+var familyCapabilities = []string{CapIPTables, CapNFTables, CapIPSet, CapSetMatch, CapConntrack, CapMark, CapPolicyRouting}
+
+// probeTable – таблица маршрутизации для проверки policy routing
+const probeTable = 0x7ffffffe
+
+// ipctnlMsgCtGetStats – запрос IPCTNL_MSG_CT_GET_STATS: общее число соединений conntrack
+const ipctnlMsgCtGetStats = 5
+
+// Capability – результат проверки возможности в семействе Family (4 или 6;
+// 0 – возможность не зависит от семейства)
+type Capability struct {
+	Name      string
+	Family    int
+	Available bool
+	Error     string
+}
+
+// Capabilities – результаты Preflight
+type Capabilities struct {
+	Backend string
+	List    []Capability
+	// Degraded – отключённые из-за отсутствующих возможностей семейства и функции
+	Degraded []string
+}
+
+// Missing возвращает недоступные возможности
+func (c Capabilities) Missing() []Capability {
+	var missing []Capability
+	for _, capability := range c.List {
+		if !capability.Available {
+			missing = append(missing, capability)
+		}
+	}
+	return missing
+}
+
+func newCapability(name string, family int, err error) Capability {
+	capability := Capability{Name: name, Family: family, Available: err == nil}
+	if err != nil {
+		capability.Error = err.Error()
+	}
+	return capability
+}
+
+// Preflight проверяет возможности ядра и отключает то, что без них не работает:
+// семейство адресов, в котором недоступна обязательная возможность (если другое
+// семейство работает), и удаление соединений conntrack. Вызывается до Clean.
+func (nh *Helper) Preflight() Capabilities {
+	caps := Capabilities{Backend: nh.Backend()}
+	caps.List = append(caps.List, nh.getBackend().probe()...)
+	for _, family := range nh.enabledFamilies() {
+		caps.List = append(caps.List, newCapability(CapPolicyRouting, family, probePolicyRouting(family)))
+	}
+	caps.List = append(caps.List, newCapability(CapConntrackNetlink, 0, probeConntrackNetlink()))
+
+	nh.degrade(&caps)
+	nh.capabilities = &caps
+	return caps
+}
+
+// degrade отключает семейство и функции, которым не хватает возможностей caps,
+// и записывает принятые меры в caps.Degraded
+func (nh *Helper) degrade(caps *Capabilities) {
+	broken := make(map[int][]string)
+	for _, capability := range caps.Missing() {
+		if capability.Family != 0 && slices.Contains(familyCapabilities, capability.Name) {
+			broken[capability.Family] = append(broken[capability.Family], capability.Name)
+		}
+	}
+	if families := nh.enabledFamilies(); len(families) == 2 && len(broken) == 1 {
+		for family, names := range broken {
+			nh.disableFamily(family)
+			caps.Degraded = append(caps.Degraded, fmt.Sprintf("ipv%d disabled: missing %s", family, strings.Join(names, ", ")))
+		}
+		nh.conntrack = NewConntrackFlusher(ConntrackFlushInterval, nh.DisableIPv4, nh.DisableIPv6, nh.MarkMask)
+	}
+
+	for _, capability := range caps.Missing() {
+		if capability.Name == CapConntrackNetlink {
+			nh.conntrack = nil
+			caps.Degraded = append(caps.Degraded, "conntrack flush disabled: existing connections keep their route until they expire")
+		}
+	}
+}
+
+// Capabilities возвращает результаты Preflight; nil, если проверка не выполнялась
+func (nh *Helper) Capabilities() *Capabilities {
+	return nh.capabilities
+}
+
+// Missing возвращает недоступные в работающих семействах возможности из names.
+// Без Preflight все возможности считаются доступными.
+func (nh *Helper) Missing(names ...string) []string {
+	if nh.capabilities == nil {
+		return nil
+	}
+	var missing []string
+	for _, capability := range nh.capabilities.Missing() {
+		if !slices.Contains(names, capability.Name) || slices.Contains(missing, capability.Name) {
+			continue
+		}
+		if capability.Family == 0 || slices.Contains(nh.enabledFamilies(), capability.Family) {
+			missing = append(missing, capability.Name)
+		}
+	}
+	return missing
+}
+
+// RequireCapabilities возвращает ErrCapabilityMissing, если недоступна одна из возможностей names
+func (nh *Helper) RequireCapabilities(names ...string) error {
+	if missing := nh.Missing(names...); len(missing) != 0 {
+		return fmt.Errorf("%w: %s", ErrCapabilityMissing, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// requiredCapabilities возвращает возможности, без которых правила группы не применятся
+func (r *IPSetToLink) requiredCapabilities() []string {
+	// conntrack нужен всем группам: счётчики трафика различают направление соединения
+	caps := []string{CapIPTables, CapNFTables, CapSetMatch, CapConntrack}
+	switch {
+	case r.reject():
+		caps = append(caps, CapReject)
+	case r.proxy() == ProxyRedirect:
+		caps = append(caps, CapNAT)
+	case r.proxy() == ProxyTProxy:
+		caps = append(caps, CapMark, CapPolicyRouting, CapTProxy)
+	case r.tag() == TagMark:
+		caps = append(caps, CapMark)
+	case r.tag() != "":
+	default:
+		caps = append(caps, CapMark, CapPolicyRouting)
+		if !r.opts.NAT.Disabled {
+			caps = append(caps, CapNAT)
+		}
+	}
+	return caps
+}
+
+func (nh *Helper) enabledFamilies() []int {
+	var families []int
+	if !nh.DisableIPv4 {
+		families = append(families, 4)
+	}
+	if !nh.DisableIPv6 {
+		families = append(families, 6)
+	}
+	return families
+}
+
+func (nh *Helper) disableFamily(family int) {
+	if family == 4 {
+		nh.DisableIPv4 = true
+		nh.IPTables4 = nil
+	} else {
+		nh.DisableIPv6 = true
+		nh.IPTables6 = nil
+	}
+}
+
+// probePolicyRouting добавляет и удаляет ip rule по метке и маршрут в отдельной таблице
+func probePolicyRouting(family int) error {
+	nlFamily, dst := nl.FAMILY_V4, &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+	if family == 6 {
+		nlFamily, dst = nl.FAMILY_V6, &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+	}
+
+	route := &netlink.Route{Dst: dst, Table: probeTable, Type: unix.RTN_BLACKHOLE, Family: nlFamily}
+	if err := netlink.RouteAdd(route); err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("failed to add route: %w", err)
+	}
+	defer func() { _ = netlink.RouteDel(route) }()
+
+	// Метка, которой не помечается реальный трафик: правило не влияет на маршрутизацию
+	rule := netlink.NewRule()
+	rule.Mark = 0xffffffff
+	rule.Mask = new(uint32)
+	*rule.Mask = 0xffffffff
+	rule.Table = probeTable
+	rule.Family = nlFamily
+	if err := netlink.RuleAdd(rule); err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("failed to add rule: %w", err)
+	}
+	if err := netlink.RuleDel(rule); err != nil {
+		return fmt.Errorf("failed to delete rule: %w", err)
+	}
+	return nil
+}
+
+// probeConntrackNetlink запрашивает число соединений через ctnetlink
+func probeConntrackNetlink() error {
+	// Без NLM_F_ACK ядро не отвечает на запрос статистики
+	req := nl.NewNetlinkRequest(ipctnlMsgCtGetStats|(unix.NFNL_SUBSYS_CTNETLINK<<8), unix.NLM_F_ACK)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: unix.AF_UNSPEC, Version: nl.NFNETLINK_V0})
+	_, err := req.Execute(unix.NETLINK_NETFILTER, 0)
+	return err
+}
+
+/*
+	iptables
+*/
+
+func (b *iptablesBackend) probe() []Capability {
+	var caps []Capability
+	for _, ipt := range []*iptables.IPTables{b.nh.IPTables4, b.nh.IPTables6} {
+		if ipt == nil {
+			continue
+		}
+		caps = append(caps, b.probeFamily(ipt)...)
+	}
+	return caps
+}
+
+func (b *iptablesBackend) probeFamily(ipt *iptables.IPTables) []Capability {
+	family, afFamily, setName := 4, uint8(unix.AF_INET), b.nh.IpsetPrefix+"probe_4"
+	if ipt.Proto() == iptables.ProtocolIPv6 {
+		family, afFamily, setName = 6, unix.AF_INET6, b.nh.IpsetPrefix+"probe_6"
+	}
+	chain := b.nh.ChainPrefix + "PROBE"
+
+	caps := []Capability{newCapability(CapIPTables, family, ipt.Available())}
+
+	if err := netlink.IpsetDestroy(setName); err != nil && !os.IsNotExist(err) {
+		caps = append(caps, newCapability(CapIPSet, family, err))
+	} else {
+		err := createHashNet(setName, afFamily, IPSetOptions{HashSize: 64, MaxElem: 64})
+		caps = append(caps, newCapability(CapIPSet, family, err))
+		if err == nil {
+			defer func() { _ = netlink.IpsetDestroy(setName) }()
+		}
+	}
+
+	probes := []struct {
+		name  string
+		table string
+		rules [][]string
+	}{
+		{CapSetMatch, "filter", [][]string{{"-m", "set", "--match-set", setName, "dst", "-j", "RETURN"}}},
+		{CapConntrack, "mangle", [][]string{{"-m", "conntrack", "--ctdir", "REPLY", "-j", "RETURN"}}},
+		{CapMark, "mangle", [][]string{
+			{"-j", "MARK", "--set-xmark", "0x1/0x1"},
+			{"-j", "CONNMARK", "--save-mark"},
+			{"-m", "mark", "--mark", "0x1/0x1", "-j", "RETURN"},
+		}},
+		{CapNAT, "nat", [][]string{{"-j", "MASQUERADE"}}},
+		{CapTProxy, "mangle", [][]string{{"-p", "tcp", "-j", "TPROXY", "--on-port", "1", "--tproxy-mark", "0x1/0x1"}}},
+		{CapReject, "filter", [][]string{{"-p", "tcp", "-j", "REJECT", "--reject-with", "tcp-reset"}}},
+	}
+	for _, probe := range probes {
+		caps = append(caps, newCapability(probe.name, family, ipt.Probe(probe.table, chain, probe.rules...)))
+	}
+	return caps
+}
+
+/*
+	nftables
+*/
+
+// nftProbeTable – временная таблица для проверки выражений
+var nftProbeTable = nftables.Table{Family: unix.NFPROTO_INET, Name: nftTableName + "_probe"}
+
+func (b *nftablesBackend) probe() []Capability {
+	const chain, setName = "probe", "probe"
+	lookup := nftDstLookup(net.IPv4len, setName)
+	hosts := nftables.Set{Name: setName, KeyType: nftables.TypeIPv4Addr, KeyLen: net.IPv4len, Timeout: true}
+
+	probes := []struct {
+		name string
+		add  func(batch *nftables.Batch)
+	}{
+		{CapNFTables, func(batch *nftables.Batch) {}},
+		{CapIPSet, func(batch *nftables.Batch) {
+			batch.AddSet(nftProbeTable, hosts)
+			batch.AddSet(nftProbeTable, nftables.Set{Name: setName + "n", KeyType: nftables.TypeIPv4Addr, KeyLen: net.IPv4len, Interval: true})
+		}},
+		{CapSetMatch, func(batch *nftables.Batch) {
+			batch.AddSet(nftProbeTable, hosts)
+			batch.AddRule(nftProbeTable, chain, append(lookup, nftables.Return)...)
+		}},
+		{CapConntrack, func(batch *nftables.Batch) {
+			batch.AddRule(nftProbeTable, chain, nftCtReply(nftables.Return)...)
+		}},
+		{CapMark, func(batch *nftables.Batch) {
+			batch.AddRule(nftProbeTable, chain, nftSetMaskedMark(1, 1, true)...)
+		}},
+		{CapNAT, func(batch *nftables.Batch) {
+			batch.AddRule(nftProbeTable, chain, nftables.Masq{})
+		}},
+		{CapTProxy, func(batch *nftables.Batch) {
+			batch.AddRule(nftProbeTable, chain,
+				nftables.Immediate{Register: nftables.Reg1, Data: []byte{0, 1}},
+				nftables.TProxy{Family: unix.NFPROTO_IPV4, PortRegister: nftables.Reg1})
+		}},
+		{CapReject, func(batch *nftables.Batch) {
+			batch.AddRule(nftProbeTable, chain, nftables.Reject{Type: unix.NFT_REJECT_TCP_RST})
+		}},
+	}
+
+	var caps []Capability
+	for _, probe := range probes {
+		// Таблица удаляется в той же транзакции: выражения проверяются при добавлении правила
+		batch := &nftables.Batch{}
+		batch.AddTable(nftProbeTable)
+		batch.DelTable(nftProbeTable)
+		batch.AddTable(nftProbeTable)
+		batch.AddChain(nftProbeTable, chain, nil)
+		probe.add(batch)
+		batch.DelTable(nftProbeTable)
+		caps = append(caps, newCapability(probe.name, 0, b.conn.Commit(batch)))
+	}
+	return caps
+}
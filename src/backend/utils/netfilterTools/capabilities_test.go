@@ -0,0 +1,83 @@
+//go:build testing
+
+package netfilterTools
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestRequireCapabilities проверяет, что группа без нужных возможностей не включается,
+// а возможности отключённого семейства не учитываются
+func TestRequireCapabilities(t *testing.T) {
+	nh, fake4, _ := newTestHelper(t)
+	if missing := nh.Missing(CapReject); missing != nil {
+		t.Errorf("Missing() without preflight = %v", missing)
+	}
+
+	nh.DisableIPv6 = true
+	nh.IPTables6 = nil
+	nh.capabilities = &Capabilities{
+		Backend: BackendIPTables,
+		List: []Capability{
+			{Name: CapSetMatch, Family: 4, Available: true},
+			{Name: CapReject, Family: 4, Error: "iptables-restore failed"},
+			{Name: CapTProxy, Family: 6, Error: "iptables-restore failed"},
+			{Name: CapConntrackNetlink, Error: "operation not supported"},
+		},
+	}
+	expected := []string{CapReject, CapConntrackNetlink}
+	if got := nh.Missing(CapSetMatch, CapReject, CapTProxy, CapConntrackNetlink); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Missing() = %v, want %v", got, expected)
+	}
+
+	link := nh.IPSetToLink("grp", Reject, nh.IPSet("grp", IPSetOptions{}), IPSetToLinkOptions{})
+	if err := link.Enable(); !errors.Is(err, ErrCapabilityMissing) {
+		t.Fatalf("Enable() err = %v, want ErrCapabilityMissing", err)
+	}
+	if got := fake4.GetRules("filter", "FORWARD"); len(got) != 0 {
+		t.Errorf("FORWARD rules = %v", got)
+	}
+	if got := link.requiredCapabilities(); !reflect.DeepEqual(got, []string{CapIPTables, CapNFTables, CapSetMatch, CapConntrack, CapReject}) {
+		t.Errorf("requiredCapabilities() = %v", got)
+	}
+}
+
+// TestDegrade проверяет отключение семейства без обязательных возможностей и удаления соединений
+func TestDegrade(t *testing.T) {
+	nh, _, _ := newTestHelper(t)
+	nh.conntrack = NewConntrackFlusher(ConntrackFlushInterval, false, false, 0)
+	caps := &Capabilities{List: []Capability{
+		{Name: CapMark, Family: 4, Available: true},
+		{Name: CapMark, Family: 6, Error: "iptables-restore failed"},
+		{Name: CapPolicyRouting, Family: 6, Error: "address family not supported by protocol"},
+		{Name: CapTProxy, Family: 4, Error: "iptables-restore failed"},
+	}}
+	nh.degrade(caps)
+	if !nh.DisableIPv6 || nh.IPTables6 != nil || nh.DisableIPv4 || nh.IPTables4 == nil {
+		t.Errorf("ipv6 is not disabled: DisableIPv4=%v DisableIPv6=%v", nh.DisableIPv4, nh.DisableIPv6)
+	}
+	if nh.conntrack == nil || !nh.conntrack.disableIPv6 {
+		t.Error("conntrack flusher must skip ipv6")
+	}
+	expected := []string{"ipv6 disabled: missing mark, policy_routing"}
+	if !reflect.DeepEqual(caps.Degraded, expected) {
+		t.Errorf("Degraded = %v, want %v", caps.Degraded, expected)
+	}
+
+	// Семейство не отключается, если без обязательных возможностей остались бы оба
+	nh, _, _ = newTestHelper(t)
+	caps = &Capabilities{List: []Capability{
+		{Name: CapSetMatch, Family: 4, Error: "iptables-restore failed"},
+		{Name: CapSetMatch, Family: 6, Error: "iptables-restore failed"},
+		{Name: CapConntrackNetlink, Error: "operation not supported"},
+	}}
+	nh.degrade(caps)
+	if nh.DisableIPv4 || nh.DisableIPv6 {
+		t.Error("both families must stay enabled")
+	}
+	if nh.conntrack != nil || len(caps.Degraded) != 1 {
+		t.Errorf("conntrack flush is not disabled: %v", caps.Degraded)
+	}
+}
@@ -0,0 +1,120 @@
+package netfilterTools
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Connection – запись conntrack: клиент Src обращается к Dst. Tx – трафик исходного
+// направления, Rx – обратного; без net.netfilter.nf_conntrack_acct=1 ядро их не считает.
+type Connection struct {
+	Family   int
+	Protocol string
+	Src      netip.AddrPort
+	Dst      netip.AddrPort
+	Tx       Traffic
+	Rx       Traffic
+	Mark     uint32
+}
+
+// Connections возвращает записи conntrack включённых семейств
+func (nh *Helper) Connections() ([]Connection, error) {
+	if err := nh.RequireCapabilities(CapConntrackNetlink); err != nil {
+		return nil, err
+	}
+
+	var connections []Connection
+	for _, family := range []struct {
+		family   netlink.InetFamily
+		disabled bool
+	}{
+		{unix.AF_INET, nh.DisableIPv4},
+		{unix.AF_INET6, nh.DisableIPv6},
+	} {
+		if family.disabled {
+			continue
+		}
+		// Прерванный дамп (таблица менялась во время чтения) всё равно полезен
+		flows, err := netlink.ConntrackTableList(netlink.ConntrackTable, family.family)
+		if err != nil && !errors.Is(err, netlink.ErrDumpInterrupted) {
+			return nil, fmt.Errorf("failed to list conntrack entries: %w", err)
+		}
+		for _, flow := range flows {
+			connections = append(connections, connectionFromFlow(flow))
+		}
+	}
+	return connections, nil
+}
+
+func connectionFromFlow(flow *netlink.ConntrackFlow) Connection {
+	connection := Connection{
+		Family:   4,
+		Protocol: protocolName(flow.Forward.Protocol, flow.FamilyType == unix.AF_INET6),
+		Tx:       Traffic{Packets: flow.Forward.Packets, Bytes: flow.Forward.Bytes},
+		Rx:       Traffic{Packets: flow.Reverse.Packets, Bytes: flow.Reverse.Bytes},
+		Mark:     flow.Mark,
+	}
+	if flow.FamilyType == unix.AF_INET6 {
+		connection.Family = 6
+	}
+	if src, ok := netip.AddrFromSlice(flow.Forward.SrcIP); ok {
+		connection.Src = netip.AddrPortFrom(src.Unmap(), flow.Forward.SrcPort)
+	}
+	if dst, ok := netip.AddrFromSlice(flow.Forward.DstIP); ok {
+		connection.Dst = netip.AddrPortFrom(dst.Unmap(), flow.Forward.DstPort)
+	}
+	return connection
+}
+
+// protocolName возвращает имя протокола, как в квалификаторах групп, или его номер
+func protocolName(protocol uint8, ipv6 bool) string {
+	for name, number := range nftProtocols {
+		if number != protocol {
+			continue
+		}
+		if (name == "icmp" && ipv6) || (name == "ipv6-icmp" && !ipv6) {
+			continue
+		}
+		return name
+	}
+	return strconv.Itoa(int(protocol))
+}
+
+// ConnMark – метка conntrack, которой группа помечает соединения через Interface;
+// соединение принадлежит группе, если его метка в пределах Mask равна Mark
+type ConnMark struct {
+	Interface string
+	Mark      uint32
+	Mask      uint32
+}
+
+func (m ConnMark) Match(mark uint32) bool {
+	return m.Mark != 0 && mark&m.Mask == m.Mark
+}
+
+// ConnMarks возвращает метки conntrack включённой группы. TPROXY метит только пакеты,
+// а REDIRECT, DSCP и отклонение меток не ставят – у таких групп меток нет.
+func (r *IPSetToLink) ConnMarks() []ConnMark {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if !r.enabled.Load() {
+		return nil
+	}
+	if r.tag() == TagMark {
+		return []ConnMark{{Mark: r.opts.Tag.Mark, Mask: r.opts.Tag.Mask}}
+	}
+	if !r.routed() || r.proxy() == ProxyTProxy {
+		return nil
+	}
+	var marks []ConnMark
+	for _, target := range r.targets() {
+		marks = append(marks, ConnMark{Interface: target.ifaceName, Mark: target.mark, Mask: r.nh.markMask()})
+	}
+	return marks
+}
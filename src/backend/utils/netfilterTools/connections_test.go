@@ -0,0 +1,76 @@
+//go:build testing
+
+package netfilterTools
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestConnectionFromFlow(t *testing.T) {
+	flow := &netlink.ConntrackFlow{
+		FamilyType: unix.AF_INET6,
+		Forward: netlink.IPTuple{
+			Protocol: 58,
+			SrcIP:    net.ParseIP("2001:db8::10"),
+			DstIP:    net.ParseIP("2001:db8::1"),
+			Packets:  3,
+			Bytes:    300,
+		},
+		Reverse: netlink.IPTuple{Packets: 2, Bytes: 200},
+		Mark:    7,
+	}
+	expected := Connection{
+		Family:   6,
+		Protocol: "ipv6-icmp",
+		Src:      netip.MustParseAddrPort("[2001:db8::10]:0"),
+		Dst:      netip.MustParseAddrPort("[2001:db8::1]:0"),
+		Tx:       Traffic{Packets: 3, Bytes: 300},
+		Rx:       Traffic{Packets: 2, Bytes: 200},
+		Mark:     7,
+	}
+	if got := connectionFromFlow(flow); !reflect.DeepEqual(got, expected) {
+		t.Errorf("connection mismatch.\nExpected: %+v\nGot: %+v", expected, got)
+	}
+
+	if got := protocolName(1, false); got != "icmp" {
+		t.Errorf("protocolName(1) = %q, want icmp", got)
+	}
+	if got := protocolName(47, false); got != "47" {
+		t.Errorf("protocolName(47) = %q, want 47", got)
+	}
+}
+
+func TestIPSetToLinkConnMarks(t *testing.T) {
+	nh, _, _ := newTestHelper(t)
+	nh.MarkMask = 0xff0000
+
+	link := nh.IPSetToLink("grp", "nwg0", nh.IPSet("grp", IPSetOptions{}), IPSetToLinkOptions{})
+	link.mark = 0x10000
+	if marks := link.ConnMarks(); marks != nil {
+		t.Errorf("disabled group marks = %v, want none", marks)
+	}
+	link.enabled.Store(true)
+	expected := []ConnMark{{Interface: "nwg0", Mark: 0x10000, Mask: 0xff0000}}
+	marks := link.ConnMarks()
+	if !reflect.DeepEqual(marks, expected) {
+		t.Fatalf("marks mismatch.\nExpected: %v\nGot: %v", expected, marks)
+	}
+	if !marks[0].Match(0x1010abc) || marks[0].Match(0x20000) || (ConnMark{Mask: 0xff0000}).Match(0) {
+		t.Errorf("unexpected mark match result")
+	}
+
+	tag := nh.IPSetToLink("tag", "mark:0x10/0xff", nh.IPSet("tag", IPSetOptions{}), IPSetToLinkOptions{
+		Tag: &TagTarget{Mode: TagMark, Mark: 0x10, Mask: 0xff},
+	})
+	tag.enabled.Store(true)
+	expected = []ConnMark{{Mark: 0x10, Mask: 0xff}}
+	if got := tag.ConnMarks(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("tag marks mismatch.\nExpected: %v\nGot: %v", expected, got)
+	}
+}
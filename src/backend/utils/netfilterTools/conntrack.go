@@ -0,0 +1,171 @@
+package netfilterTools
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// ConntrackFlushInterval – минимальный интервал между удалениями записей conntrack
+const ConntrackFlushInterval = 2 * time.Second
+
+// ConntrackFlusher удаляет записи conntrack, чтобы существующие соединения заново прошли
+// маркировку и маршрутизацию. Каждое удаление – полный обход таблицы conntrack, поэтому
+// запросы накапливаются и выполняются не чаще одного раза в interval.
+// Методы безопасно вызывать у nil.
+type ConntrackFlusher struct {
+	interval    time.Duration
+	disableIPv4 bool
+	disableIPv6 bool
+	markMask    uint32
+
+	locker  sync.Mutex
+	pending conntrackFilter
+	timer   *time.Timer
+	last    time.Time
+	closed  bool
+}
+
+func NewConntrackFlusher(interval time.Duration, disableIPv4, disableIPv6 bool, markMask uint32) *ConntrackFlusher {
+	return &ConntrackFlusher{
+		interval:    interval,
+		disableIPv4: disableIPv4,
+		disableIPv6: disableIPv6,
+		markMask:    markMask,
+	}
+}
+
+// FlushDestinations удаляет соединения к адресам из prefixes
+func (f *ConntrackFlusher) FlushDestinations(prefixes ...netip.Prefix) {
+	if f == nil || len(prefixes) == 0 {
+		return
+	}
+	f.locker.Lock()
+	defer f.locker.Unlock()
+
+	for _, prefix := range prefixes {
+		f.pending.addPrefix(prefix)
+	}
+	f.schedule()
+}
+
+// FlushMarks удаляет соединения, помеченные одной из меток marks
+func (f *ConntrackFlusher) FlushMarks(marks ...uint32) {
+	if f == nil || len(marks) == 0 {
+		return
+	}
+	f.locker.Lock()
+	defer f.locker.Unlock()
+
+	for _, mark := range marks {
+		f.pending.addMark(mark)
+	}
+	f.schedule()
+}
+
+// Close отменяет отложенное удаление
+func (f *ConntrackFlusher) Close() {
+	if f == nil {
+		return
+	}
+	f.locker.Lock()
+	defer f.locker.Unlock()
+
+	f.closed = true
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+}
+
+func (f *ConntrackFlusher) schedule() {
+	if f.closed || f.timer != nil {
+		return
+	}
+	f.timer = time.AfterFunc(max(time.Until(f.last.Add(f.interval)), 0), f.flush)
+}
+
+func (f *ConntrackFlusher) flush() {
+	f.locker.Lock()
+	filter := f.pending
+	filter.markMask = f.markMask
+	f.pending = conntrackFilter{}
+	f.timer = nil
+	f.last = time.Now()
+	f.locker.Unlock()
+
+	var families []netlink.InetFamily
+	if !f.disableIPv4 {
+		families = append(families, unix.AF_INET)
+	}
+	if !f.disableIPv6 {
+		families = append(families, unix.AF_INET6)
+	}
+
+	var deleted uint
+	for _, family := range families {
+		n, err := netlink.ConntrackDeleteFilters(netlink.ConntrackTable, family, &filter)
+		if err != nil {
+			log.Warn().Err(err).Int("family", int(family)).Msg("failed to flush conntrack entries")
+		}
+		deleted += n
+	}
+	log.Debug().Uint("flows", deleted).Msg("conntrack entries flushed")
+}
+
+// conntrackFilter выбирает соединения по адресу назначения исходного направления или по метке
+type conntrackFilter struct {
+	// prefixes сгруппированы по длине, чтобы проверка соединения не зависела от их числа
+	prefixes map[int]map[netip.Prefix]struct{}
+	marks    map[uint32]struct{}
+	// markMask – сравниваемые биты метки (0 – все)
+	markMask uint32
+}
+
+func (c *conntrackFilter) addPrefix(prefix netip.Prefix) {
+	if c.prefixes == nil {
+		c.prefixes = make(map[int]map[netip.Prefix]struct{})
+	}
+	prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked()
+	if c.prefixes[prefix.Bits()] == nil {
+		c.prefixes[prefix.Bits()] = make(map[netip.Prefix]struct{})
+	}
+	c.prefixes[prefix.Bits()][prefix] = struct{}{}
+}
+
+func (c *conntrackFilter) addMark(mark uint32) {
+	if c.marks == nil {
+		c.marks = make(map[uint32]struct{})
+	}
+	c.marks[mark] = struct{}{}
+}
+
+func (c *conntrackFilter) MatchConntrackFlow(flow *netlink.ConntrackFlow) bool {
+	mark := flow.Mark
+	if c.markMask != 0 {
+		mark &= c.markMask
+	}
+	if _, ok := c.marks[mark]; ok && mark != 0 {
+		return true
+	}
+	dst, ok := netip.AddrFromSlice(flow.Forward.DstIP)
+	if !ok {
+		return false
+	}
+	dst = dst.Unmap()
+	for bits, prefixes := range c.prefixes {
+		// Префикс другого семейства не совпадёт с ключом, даже если длина допустима
+		prefix, err := dst.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if _, ok := prefixes[prefix]; ok {
+			return true
+		}
+	}
+	return false
+}
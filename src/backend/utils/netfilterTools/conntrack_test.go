@@ -0,0 +1,51 @@
+package netfilterTools
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestConntrackFilter(t *testing.T) {
+	var filter conntrackFilter
+	filter.addPrefix(netip.MustParsePrefix("10.1.2.3/32"))
+	filter.addPrefix(netip.MustParsePrefix("192.168.5.7/24"))
+	filter.addPrefix(netip.MustParsePrefix("2001:db8::/32"))
+	filter.addMark(7)
+
+	flow := func(dst string, mark uint32) *netlink.ConntrackFlow {
+		return &netlink.ConntrackFlow{Forward: netlink.IPTuple{DstIP: net.ParseIP(dst)}, Mark: mark}
+	}
+	tests := []struct {
+		flow *netlink.ConntrackFlow
+		want bool
+	}{
+		{flow("10.1.2.3", 0), true},
+		{flow("10.1.2.4", 0), false},
+		{flow("192.168.5.200", 0), true},
+		{flow("192.168.6.1", 0), false},
+		{flow("2001:db8:1::1", 0), true},
+		{flow("2001:db9::1", 0), false},
+		// Адрес IPv6 не должен совпадать с IPv4-префиксом той же длины
+		{flow("a01:203::", 0), false},
+		{flow("8.8.8.8", 7), true},
+		{flow("8.8.8.8", 8), false},
+	}
+	for _, tt := range tests {
+		if got := filter.MatchConntrackFlow(tt.flow); got != tt.want {
+			t.Errorf("MatchConntrackFlow(%s, mark %d) = %v, want %v", tt.flow.Forward.DstIP, tt.flow.Mark, got, tt.want)
+		}
+	}
+
+	// Биты вне маски принадлежат прошивке и не участвуют в сравнении
+	masked := conntrackFilter{markMask: 0xff0000}
+	masked.addMark(0x10000)
+	if !masked.MatchConntrackFlow(flow("8.8.8.8", 0x100ab)) {
+		t.Error("MatchConntrackFlow ignored mark mask")
+	}
+	if masked.MatchConntrackFlow(flow("8.8.8.8", 0x200ab)) {
+		t.Error("MatchConntrackFlow matched foreign mark")
+	}
+}
@@ -0,0 +1,199 @@
+package netfilterTools
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+
+	"magitrickle/utils/iptables"
+
+	"github.com/rs/zerolog/log"
+)
+
+/*
+	Балансировка: каждый интерфейс получает свою метку и таблицу. Новое соединение
+	получает метку одного из интерфейсов случайно с учётом весов, метка сохраняется
+	в conntrack, и последующие пакеты соединения восстанавливают её (соединения «липкие»).
+*/
+
+// balancing сообщает, распределяет ли группа соединения между несколькими интерфейсами
+func (r *IPSetToLink) balancing() bool {
+	return len(r.members) > 0
+}
+
+func (r *IPSetToLink) balanceChainName() string {
+	return r.chainName + "_LB"
+}
+
+// targets возвращает основной интерфейс и участников балансировки
+func (r *IPSetToLink) targets() []*linkTarget {
+	return append([]*linkTarget{&r.linkTarget}, r.members...)
+}
+
+// activeTargets возвращает интерфейсы, получающие новые соединения;
+// если исключены все, используются все
+func (r *IPSetToLink) activeTargets() []*linkTarget {
+	var active []*linkTarget
+	for _, target := range r.targets() {
+		if _, ok := r.inactive[target.ifaceName]; !ok {
+			active = append(active, target)
+		}
+	}
+	if len(active) == 0 {
+		return r.targets()
+	}
+	return active
+}
+
+func (r *IPSetToLink) weight(target *linkTarget) uint32 {
+	if w := r.opts.Weights[target.ifaceName]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+// markActions возвращает действия iptables для трафика группы: установку метки
+// или, при балансировке, переход в цепочку выбора интерфейса
+func (r *IPSetToLink) markActions() [][]string {
+	if r.balancing() {
+		return [][]string{{"-j", r.balanceChainName()}}
+	}
+	return [][]string{
+		{"-j", "MARK", "--set-mark", r.nh.markArg(r.mark)},
+		r.nh.saveMarkArgs(), // Without this rule, routing on Keenetic routers did not work; DO NOT REMOVE!
+	}
+}
+
+// balanceRules строит правила цепочки выбора интерфейса. Вероятность выбора
+// i-го интерфейса – его вес, делённый на сумму весов ещё не рассмотренных интерфейсов.
+func (r *IPSetToLink) balanceRules() [][]string {
+	active := r.activeTargets()
+
+	var rules [][]string
+	for _, target := range active {
+		markStr := r.nh.markArg(target.mark)
+		rules = append(rules,
+			[]string{"-m", "connmark", "--mark", markStr, "-j", "MARK", "--set-mark", markStr},
+			[]string{"-m", "connmark", "--mark", markStr, "-j", "RETURN"},
+		)
+	}
+
+	var remaining uint32
+	for _, target := range active {
+		remaining += r.weight(target)
+	}
+	for _, target := range active[:len(active)-1] {
+		markStr := r.nh.markArg(target.mark)
+		probability := float64(r.weight(target)) / float64(remaining)
+		rules = append(rules,
+			[]string{"-m", "statistic", "--mode", "random", "--probability", strconv.FormatFloat(probability, 'f', 5, 64), "-j", "CONNMARK", "--set-mark", markStr},
+			[]string{"-m", "connmark", "--mark", markStr, "-j", "MARK", "--set-mark", markStr},
+			[]string{"-m", "connmark", "--mark", markStr, "-j", "RETURN"},
+		)
+		remaining -= r.weight(target)
+	}
+	markStr := r.nh.markArg(active[len(active)-1].mark)
+	rules = append(rules,
+		[]string{"-j", "CONNMARK", "--set-mark", markStr},
+		[]string{"-j", "MARK", "--set-mark", markStr},
+	)
+	return rules
+}
+
+func (r *IPSetToLink) insertBalanceRules(ipt *iptables.IPTables) error {
+	err := ipt.RegisterChainOverride("mangle", r.balanceChainName())
+	if err != nil {
+		return fmt.Errorf("failed to create chain: %w", err)
+	}
+	for _, iptablesArgs := range r.balanceRules() {
+		err = ipt.Append("mangle", r.balanceChainName(), iptablesArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to append rule: %w", err)
+		}
+	}
+	return nil
+}
+
+// enableMembers выделяет участникам балансировки метки и таблицы и создаёт их маршруты
+func (r *IPSetToLink) enableMembers() error {
+	for _, ifaceName := range r.opts.Balance {
+		member := &linkTarget{nh: r.nh, ifaceName: ifaceName}
+		if err := member.allocate(r.name+"/"+ifaceName, r.startIdx); err != nil {
+			return err
+		}
+		r.members = append(r.members, member)
+
+		// ip rule занимает метку, поэтому следующий участник получит другую
+		if err := member.insertIPRule(); err != nil {
+			return err
+		}
+		if err := member.insertIPRoute(); err != nil {
+			return err
+		}
+		log.Debug().
+			Str("iface", ifaceName).
+			Int("table", member.table).
+			Int("mark", int(member.mark)).
+			Msg("using ip table and mark for balance member")
+	}
+	return nil
+}
+
+func (r *IPSetToLink) disableMembers() error {
+	var errs []error
+	for _, member := range r.members {
+		errs = append(errs, member.deleteIPRoute())
+		errs = append(errs, member.deleteIPRule())
+	}
+	r.members = nil
+	return errors.Join(errs...)
+}
+
+// SetInactive исключает интерфейсы из выбора для новых соединений (например, по результатам
+// проверок работоспособности). Существующие соединения неактивных интерфейсов выбирают
+// интерфейс заново.
+func (r *IPSetToLink) SetInactive(ifaces []string) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	inactive := make(map[string]struct{}, len(ifaces))
+	for _, iface := range ifaces {
+		inactive[iface] = struct{}{}
+	}
+	if maps.Equal(inactive, r.inactive) {
+		return nil
+	}
+	r.inactive = inactive
+	if !r.enabled.Load() || !r.balancing() {
+		return nil
+	}
+	return r.nh.getBackend().insertLinkRules(r)
+}
+
+// ActiveInterfaces возвращает интерфейсы, получающие новые соединения
+func (r *IPSetToLink) ActiveInterfaces() []string {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	var out []string
+	for _, target := range r.activeTargets() {
+		out = append(out, target.ifaceName)
+	}
+	return out
+}
+
+// Marks возвращает метки интерфейсов группы
+func (r *IPSetToLink) Marks() map[string]uint32 {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	marks := make(map[string]uint32)
+	if !r.enabled.Load() || !r.routed() {
+		return marks
+	}
+	for _, target := range r.targets() {
+		marks[target.ifaceName] = target.mark
+	}
+	return marks
+}
@@ -0,0 +1,159 @@
+package netfilterTools
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"magitrickle/utils/iptables"
+	"magitrickle/utils/nftables"
+)
+
+/*
+	Учёт трафика: у каждой группы есть цепочка только со счётчиками, которая стоит
+	в mangle PREROUTING раньше цепочек групп (TPROXY завершает обход таблицы).
+	Исходящие пакеты клиентов считаются по адресу назначения в наборе группы,
+	ответы – по адресу источника. К ответам применяются только адреса клиентов:
+	входящий интерфейс, MAC и порты в ответе другие. Ответы прокси формирует
+	локальный сокет, поэтому для прокси-групп они не учитываются. Каждое правило
+	завершается RETURN, чтобы пакет, попавший под несколько правил, учитывался один раз.
+*/
+
+const (
+	counterTx = "tx"
+	counterRx = "rx"
+)
+
+// Traffic – число пакетов и байт
+type Traffic struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// TrafficCounter – счётчики группы в семействе Family (4 или 6):
+// Tx – пакеты клиентов к адресам группы, Rx – ответы на них
+type TrafficCounter struct {
+	Family int
+	Tx     Traffic
+	Rx     Traffic
+}
+
+func (r *IPSetToLink) counterChainName() string {
+	return r.chainName + "_CNT"
+}
+
+// counterRules возвращает правила iptables цепочки счётчиков
+func (r *IPSetToLink) counterRules(proto iptables.Protocol, ipsetName string) [][]string {
+	var rules [][]string
+	for _, match := range r.trafficMatches(proto, "PREROUTING") {
+		rules = append(rules, withMatch(match, "-m", "conntrack", "--ctdir", "ORIGINAL", "-m", "set", "--match-set", ipsetName, "dst", "-j", "RETURN"))
+	}
+	for _, prefix := range r.replyDestinations(proto == iptables.ProtocolIPv4) {
+		rule := []string{"-m", "conntrack", "--ctdir", "REPLY"}
+		if prefix.IsValid() {
+			rule = append(rule, "-d", prefix.String())
+		}
+		rules = append(rules, append(rule, "-m", "set", "--match-set", ipsetName, "src", "-j", "RETURN"))
+	}
+	return rules
+}
+
+// replyDestinations возвращает адреса клиентов группы семейства ipv4 как адреса назначения
+// ответов. Группа без адресов клиентов – один нулевой префикс (любой адрес), nil – ни одного.
+func (r *IPSetToLink) replyDestinations(ipv4 bool) []netip.Prefix {
+	if len(r.opts.SrcAddresses) == 0 {
+		return []netip.Prefix{{}}
+	}
+	var prefixes []netip.Prefix
+	for _, source := range r.opts.SrcAddresses {
+		prefix, ok := parseSourcePrefix(source)
+		if !ok || prefix.Addr().Is4() != ipv4 {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+func (r *IPSetToLink) insertCounterIPTablesRules(ipt *iptables.IPTables, ipsetName string) error {
+	err := ipt.RegisterChainOverride("mangle", r.counterChainName())
+	if err != nil {
+		return fmt.Errorf("failed to create chain: %w", err)
+	}
+	for _, iptablesArgs := range r.counterRules(ipt.Proto(), ipsetName) {
+		err = ipt.Append("mangle", r.counterChainName(), iptablesArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to append rule: %w", err)
+		}
+	}
+	err = ipt.Insert("mangle", "PREROUTING", 1, "-j", r.counterChainName())
+	if err != nil {
+		return fmt.Errorf("failed to insert rule to PREROUTING: %w", err)
+	}
+	return nil
+}
+
+// iptablesCounters суммирует счётчики цепочки счётчиков группы
+func (r *IPSetToLink) iptablesCounters(ipt *iptables.IPTables) (TrafficCounter, error) {
+	counter := TrafficCounter{Family: 4}
+	if ipt.Proto() == iptables.ProtocolIPv6 {
+		counter.Family = 6
+	}
+	rules, err := ipt.Counters("mangle", r.counterChainName())
+	if err != nil {
+		return counter, fmt.Errorf("failed to read counters: %w", err)
+	}
+	for _, rule := range rules {
+		traffic := &counter.Tx
+		if rule.Rule.Contains("--ctdir REPLY") {
+			traffic = &counter.Rx
+		}
+		traffic.Packets += rule.Packets
+		traffic.Bytes += rule.Bytes
+	}
+	return counter, nil
+}
+
+// nftCounterRules – аналог counterRules для семейства family и набора setName;
+// комментарий правила – направление и семейство ("tx4", "rx6")
+func (r *IPSetToLink) nftCounterRules(family int, setName string) (comments []string, rules [][]nftables.Expr) {
+	version := nftFamilyVersion(family)
+	count := []nftables.Expr{nftables.Counter{}, nftables.Return}
+
+	lookup := append(nftCtDirection(ctDirOriginal), nftDstLookup(family, setName)...)
+	for _, match := range r.nftTrafficMatches(family, "PREROUTING") {
+		comments = append(comments, fmt.Sprintf("%s%d", counterTx, version))
+		rules = append(rules, nftRule(match, lookup, count...))
+	}
+
+	lookup = nftSrcLookup(family, setName)
+	for _, prefix := range r.replyDestinations(family == net.IPv4len) {
+		match := append(nftFamily(family), nftCtDirection(ctDirReply)...)
+		if prefix.IsValid() {
+			match = append(match, nftPrefixMatch(family, false, prefix)...)
+		}
+		comments = append(comments, fmt.Sprintf("%s%d", counterRx, version))
+		rules = append(rules, nftRule(match, lookup, count...))
+	}
+	return comments, rules
+}
+
+// nftFamilyVersion переводит длину адреса в номер версии IP
+func nftFamilyVersion(family int) int {
+	if family == net.IPv4len {
+		return 4
+	}
+	return 6
+}
+
+// Counters возвращает счётчики трафика группы по семействам; у выключенной группы
+// счётчиков нет. Счётчики обнуляются, когда правила группы пересоздаются.
+func (r *IPSetToLink) Counters() ([]TrafficCounter, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if !r.enabled.Load() {
+		return nil, nil
+	}
+	return r.nh.getBackend().counters(r)
+}
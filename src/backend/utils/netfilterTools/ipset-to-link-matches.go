@@ -0,0 +1,135 @@
+package netfilterTools
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"magitrickle/utils/iptables"
+)
+
+// trafficMatches возвращает наборы аргументов iptables для квалификаторов группы в цепочке chain.
+// Значения внутри одного квалификатора объединяются по ИЛИ (отдельные правила),
+// разные квалификаторы – по И. Пустой набор означает весь трафик, пустой список – ни одного правила.
+// В POSTROUTING проверки входящего интерфейса и MAC недоступны и пропускаются,
+// а к трафику самого роутера (OUTPUT) селекторы источника не применяются.
+func (r *IPSetToLink) trafficMatches(proto iptables.Protocol, chain string) [][]string {
+	return r.protocolMatches(proto, chain, r.protocols())
+}
+
+// protocols возвращает протоколы группы; порты без протоколов означают tcp и udp
+func (r *IPSetToLink) protocols() []string {
+	if len(r.opts.Protocols) == 0 && len(r.opts.DstPorts) > 0 {
+		return []string{"tcp", "udp"}
+	}
+	return r.opts.Protocols
+}
+
+// tcpProtocols возвращает протоколы для правил, применимых только к TCP;
+// false – группа не пропускает TCP
+func (r *IPSetToLink) tcpProtocols() ([]string, bool) {
+	protocols := r.protocols()
+	if len(protocols) > 0 && !slices.Contains(protocols, "tcp") {
+		return nil, false
+	}
+	return []string{"tcp"}, true
+}
+
+// protocolMatches – trafficMatches с заданным списком протоколов
+func (r *IPSetToLink) protocolMatches(proto iptables.Protocol, chain string, protocols []string) [][]string {
+	matches := [][]string{nil}
+
+	if len(r.opts.SrcAddresses) > 0 && chain != "OUTPUT" {
+		sources := make([][]string, 0, len(r.opts.SrcAddresses))
+		for _, source := range r.opts.SrcAddresses {
+			prefix, ok := parseSourcePrefix(source)
+			if !ok || prefix.Addr().Is4() != (proto == iptables.ProtocolIPv4) {
+				continue
+			}
+			sources = append(sources, []string{"-s", prefix.String()})
+		}
+		if len(sources) == 0 {
+			return nil
+		}
+		matches = crossMatches(matches, sources)
+	}
+
+	if chain == "FORWARD" || chain == "PREROUTING" {
+		if len(r.opts.SrcInterfaces) > 0 {
+			ifaces := make([][]string, len(r.opts.SrcInterfaces))
+			for i, iface := range r.opts.SrcInterfaces {
+				ifaces[i] = []string{"-i", iface}
+			}
+			matches = crossMatches(matches, ifaces)
+		}
+
+		if len(r.opts.SrcMACs) > 0 {
+			macs := make([][]string, 0, len(r.opts.SrcMACs))
+			for _, mac := range r.opts.SrcMACs {
+				hw, err := net.ParseMAC(mac)
+				if err != nil {
+					continue
+				}
+				macs = append(macs, []string{"-m", "mac", "--mac-source", strings.ToUpper(hw.String())})
+			}
+			if len(macs) == 0 {
+				return nil
+			}
+			matches = crossMatches(matches, macs)
+		}
+	}
+
+	if len(protocols) > 0 {
+		var protoMatches [][]string
+		for _, protocol := range protocols {
+			if protocol == "icmp" {
+				if proto == iptables.ProtocolIPv6 {
+					protocol = "ipv6-icmp"
+				}
+				protoMatches = append(protoMatches, []string{"-p", protocol})
+				continue
+			}
+			if len(r.opts.DstPorts) == 0 {
+				protoMatches = append(protoMatches, []string{"-p", protocol})
+				continue
+			}
+			for _, port := range r.opts.DstPorts {
+				protoMatches = append(protoMatches, []string{"-p", protocol, "-m", protocol, "--dport", strings.ReplaceAll(port, "-", ":")})
+			}
+		}
+		matches = crossMatches(matches, protoMatches)
+	}
+
+	return matches
+}
+
+// crossMatches строит декартово произведение наборов аргументов
+func crossMatches(base, values [][]string) [][]string {
+	out := make([][]string, 0, len(base)*len(values))
+	for _, b := range base {
+		for _, v := range values {
+			out = append(out, withMatch(b, v...))
+		}
+	}
+	return out
+}
+
+func withMatch(match []string, args ...string) []string {
+	out := make([]string, 0, len(match)+len(args))
+	out = append(out, match...)
+	return append(out, args...)
+}
+
+// parseSourcePrefix разбирает адрес ("192.168.1.10") или подсеть ("192.168.1.0/24")
+func parseSourcePrefix(s string) (netip.Prefix, bool) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), true
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
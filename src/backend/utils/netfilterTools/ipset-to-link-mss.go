@@ -0,0 +1,54 @@
+package netfilterTools
+
+import (
+	"strconv"
+
+	"magitrickle/utils/iptables"
+)
+
+/*
+	Ограничение MSS: туннели (WireGuard, OpenVPN) имеют меньший MTU, и без ограничения
+	крупные сегменты TCP теряются там, где не проходит ICMP "fragmentation needed".
+	SYN-пакеты группы, уходящие через её интерфейсы, получают MSS по MTU маршрута
+	или заданное значение (только в сторону уменьшения). Трафик роутера (RouteOutput)
+	ограничивается в filter OUTPUT: там уже известен интерфейс после перемаршрутизации
+	по метке из mangle OUTPUT.
+*/
+
+// MSSClamp – ограничение MSS TCP-соединений группы; Size 0 – по MTU маршрута
+type MSSClamp struct {
+	Size uint16
+}
+
+// mssChainName – цепочка nftables, ограничивающая MSS трафика роутера
+func (r *IPSetToLink) mssChainName() string {
+	return r.chainName + "_MSS"
+}
+
+// mssClampRules возвращает правила цепочки chain (FORWARD или OUTPUT) таблицы filter,
+// ограничивающие MSS SYN-пакетов группы
+func (r *IPSetToLink) mssClampRules(proto iptables.Protocol, chain, ipsetName string) [][]string {
+	if r.opts.MSSClamp == nil {
+		return nil
+	}
+	protocols, ok := r.tcpProtocols()
+	if !ok {
+		return nil
+	}
+
+	action := []string{"-j", "TCPMSS", "--clamp-mss-to-pmtu"}
+	if size := r.opts.MSSClamp.Size; size != 0 {
+		action = []string{"-j", "TCPMSS", "--set-mss", strconv.Itoa(int(size))}
+	}
+
+	var rules [][]string
+	for _, target := range r.targets() {
+		if target.ifaceName == Blackhole {
+			continue
+		}
+		for _, match := range r.protocolMatches(proto, chain, protocols) {
+			rules = append(rules, withMatch(match, append([]string{"-o", target.ifaceName, "--tcp-flags", "SYN,RST", "SYN", "-m", "set", "--match-set", ipsetName, "dst"}, action...)...))
+		}
+	}
+	return rules
+}
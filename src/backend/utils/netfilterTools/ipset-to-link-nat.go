@@ -0,0 +1,64 @@
+package netfilterTools
+
+import (
+	"net"
+	"net/netip"
+
+	"magitrickle/utils/iptables"
+	"magitrickle/utils/nftables"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Трансляция адреса источника: по умолчанию трафик группы маскарадится адресом
+	исходящего интерфейса. Для туннелей, на другой стороне которых известна локальная
+	сеть, и для IPv6 с делегированным префиксом трансляцию можно отключить
+	или заменить подстановкой фиксированного адреса.
+*/
+
+// NAT – трансляция адреса источника трафика группы; нулевое значение – маскарадинг
+type NAT struct {
+	// Disabled – не транслировать адрес источника
+	Disabled bool
+	// SNAT4, SNAT6 – фиксированные адреса источника; семейство без адреса маскарадится
+	SNAT4 netip.Addr
+	SNAT6 netip.Addr
+}
+
+// snatAddr возвращает фиксированный адрес источника для семейства
+func (n NAT) snatAddr(ipv4 bool) netip.Addr {
+	if ipv4 {
+		return n.SNAT4
+	}
+	return n.SNAT6
+}
+
+// natAction возвращает действие iptables nat POSTROUTING; nil – без трансляции
+func (r *IPSetToLink) natAction(proto iptables.Protocol) []string {
+	if r.opts.NAT.Disabled {
+		return nil
+	}
+	if addr := r.opts.NAT.snatAddr(proto == iptables.ProtocolIPv4); addr.IsValid() {
+		return []string{"-j", "SNAT", "--to-source", addr.String()}
+	}
+	return []string{"-j", "MASQUERADE"}
+}
+
+// nftNATAction возвращает действие nat-цепочки nftables; nil – без трансляции
+func (r *IPSetToLink) nftNATAction(family int) []nftables.Expr {
+	if r.opts.NAT.Disabled {
+		return nil
+	}
+	if addr := r.opts.NAT.snatAddr(family == net.IPv4len); addr.IsValid() {
+		nfproto := uint32(unix.NFPROTO_IPV4)
+		if family == net.IPv6len {
+			nfproto = unix.NFPROTO_IPV6
+		}
+		return []nftables.Expr{
+			nftables.Immediate{Register: nftables.Reg1, Data: addr.AsSlice()},
+			nftables.SNAT{Family: nfproto, AddrRegister: nftables.Reg1},
+		}
+	}
+	return []nftables.Expr{nftables.Masq{}}
+}
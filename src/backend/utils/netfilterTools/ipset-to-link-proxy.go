@@ -0,0 +1,129 @@
+package netfilterTools
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"magitrickle/utils/iptables"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Прозрачный прокси: вместо маршрутизации через интерфейс трафик группы передаётся
+	локальному прокси (xray, sing-box, redsocks). TPROXY сохраняет адрес назначения
+	и помечает пакет; метка направляет его в таблицу с маршрутом local через lo.
+	REDIRECT подменяет адрес назначения в nat и не использует метку.
+*/
+
+// Режимы передачи трафика прокси
+const (
+	ProxyTProxy   = "tproxy"
+	ProxyRedirect = "redirect"
+)
+
+// ProxyTarget – локальный прокси, которому передаётся трафик группы
+type ProxyTarget struct {
+	Mode string
+	Port uint16
+}
+
+// proxy возвращает режим прокси группы или пустую строку
+func (r *IPSetToLink) proxy() string {
+	if r.opts.Proxy == nil {
+		return ""
+	}
+	return r.opts.Proxy.Mode
+}
+
+func (r *IPSetToLink) redirectChainName() string {
+	return r.chainName + "_RDR"
+}
+
+func (r *IPSetToLink) insertProxyIPTablesRules(ipt *iptables.IPTables, ipsetName string) error {
+	port := strconv.Itoa(int(r.opts.Proxy.Port))
+
+	if r.proxy() == ProxyRedirect {
+		err := ipt.RegisterChainOverride("nat", r.redirectChainName())
+		if err != nil {
+			return fmt.Errorf("failed to create chain: %w", err)
+		}
+		for _, match := range r.trafficMatches(ipt.Proto(), "PREROUTING") {
+			err = ipt.Append("nat", r.redirectChainName(), withMatch(match, "-m", "set", "--match-set", ipsetName, "dst", "-j", "REDIRECT", "--to-ports", port)...)
+			if err != nil {
+				return fmt.Errorf("failed to append rule: %w", err)
+			}
+		}
+		err = ipt.Append("nat", "PREROUTING", "-j", r.redirectChainName())
+		if err != nil {
+			return fmt.Errorf("failed to append rule to PREROUTING: %w", err)
+		}
+	} else {
+		err := ipt.RegisterChainOverride("mangle", r.chainName)
+		if err != nil {
+			return fmt.Errorf("failed to create chain: %w", err)
+		}
+		mangleRules := [][]string{
+			{"-m", "conntrack", "--ctdir", "REPLY", "-j", "RETURN"},
+		}
+		for _, match := range r.trafficMatches(ipt.Proto(), "PREROUTING") {
+			mangleRules = append(mangleRules, withMatch(match, "-m", "set", "--match-set", ipsetName, "dst", "-j", "TPROXY", "--on-port", port, "--tproxy-mark", r.nh.markArg(r.mark)))
+		}
+		for _, iptablesArgs := range mangleRules {
+			err = ipt.Append("mangle", r.chainName, iptablesArgs...)
+			if err != nil {
+				return fmt.Errorf("failed to append rule: %w", err)
+			}
+		}
+		err = ipt.Append("mangle", "PREROUTING", "-j", r.chainName)
+		if err != nil {
+			return fmt.Errorf("failed to append rule to PREROUTING: %w", err)
+		}
+	}
+
+	err := ipt.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit iptables rules: %w", err)
+	}
+	return nil
+}
+
+// insertLocalRoute добавляет в таблицу цели маршрут local по умолчанию через lo,
+// чтобы помеченные TPROXY пакеты доставлялись локальному сокету прокси
+func (r *linkTarget) insertLocalRoute() error {
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		return fmt.Errorf("error while getting loopback interface: %w", err)
+	}
+
+	for _, family := range []struct {
+		family   int
+		ipLen    int
+		disabled bool
+		route    **netlink.Route
+	}{
+		{nl.FAMILY_V4, net.IPv4len, r.nh.DisableIPv4, &r.ip4Route[0]},
+		{nl.FAMILY_V6, net.IPv6len, r.nh.DisableIPv6, &r.ip6Route[0]},
+	} {
+		if family.disabled {
+			continue
+		}
+		route := &netlink.Route{
+			LinkIndex: lo.Attrs().Index,
+			Dst:       &net.IPNet{IP: make(net.IP, family.ipLen), Mask: make(net.IPMask, family.ipLen)},
+			Table:     r.table,
+			Type:      unix.RTN_LOCAL,
+			Scope:     netlink.SCOPE_HOST,
+			Family:    family.family,
+		}
+		if err := netlink.RouteAdd(route); err != nil && !errors.Is(err, unix.EEXIST) {
+			return fmt.Errorf("error while adding local route: %w", err)
+		}
+		*family.route = route
+	}
+	return nil
+}
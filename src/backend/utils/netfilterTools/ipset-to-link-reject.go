@@ -0,0 +1,109 @@
+package netfilterTools
+
+import (
+	"fmt"
+	"slices"
+
+	"magitrickle/utils/iptables"
+	"magitrickle/utils/nftables"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Отклонение трафика: в отличие от blackhole, где пакеты молча теряются маршрутом
+	RTN_BLACKHOLE и клиент ждёт таймаута, группа отвечает на TCP сбросом соединения,
+	а на остальные протоколы – ICMP port unreachable. Правила стоят в filter FORWARD
+	(и OUTPUT для трафика роутера); метка, таблица, ip rule и маршруты группе не выделяются.
+*/
+
+// Reject – псевдоинтерфейс, отклоняющий трафик группы
+const Reject = "reject"
+
+// reject сообщает, что группа отклоняет свой трафик
+func (r *IPSetToLink) reject() bool {
+	return r.ifaceName == Reject
+}
+
+// rejectProtocols делит протоколы группы: TCP отклоняется сбросом соединения (tcp),
+// остальные протоколы others – через ICMP (rest); пустой others при rest означает
+// любой протокол, правило для которого стоит после правила TCP
+func (r *IPSetToLink) rejectProtocols() (tcp bool, others []string, rest bool) {
+	protocols := r.protocols()
+	if len(protocols) == 0 {
+		return true, nil, true
+	}
+	others = slices.DeleteFunc(slices.Clone(protocols), func(p string) bool { return p == "tcp" })
+	return slices.Contains(protocols, "tcp"), others, len(others) > 0
+}
+
+// rejectRules возвращает правила iptables, отклоняющие трафик группы в цепочке chain
+func (r *IPSetToLink) rejectRules(proto iptables.Protocol, chain, ipsetName string) [][]string {
+	unreachable := "icmp-port-unreachable"
+	if proto == iptables.ProtocolIPv6 {
+		unreachable = "icmp6-port-unreachable"
+	}
+	lookup := []string{"-m", "set", "--match-set", ipsetName, "dst"}
+
+	var rules [][]string
+	tcp, others, rest := r.rejectProtocols()
+	if tcp {
+		for _, match := range r.protocolMatches(proto, chain, []string{"tcp"}) {
+			rules = append(rules, withMatch(match, append(lookup, "-j", "REJECT", "--reject-with", "tcp-reset")...))
+		}
+	}
+	if rest {
+		for _, match := range r.protocolMatches(proto, chain, others) {
+			rules = append(rules, withMatch(match, append(lookup, "-j", "REJECT", "--reject-with", unreachable)...))
+		}
+	}
+	return rules
+}
+
+func (r *IPSetToLink) insertRejectIPTablesRules(ipt *iptables.IPTables, ipsetName string) error {
+	chains := []struct{ name, hook string }{{r.chainName, "FORWARD"}}
+	if r.opts.RouteOutput {
+		chains = append(chains, struct{ name, hook string }{r.outputChainName(), "OUTPUT"})
+	}
+
+	for _, chain := range chains {
+		err := ipt.RegisterChainOverride("filter", chain.name)
+		if err != nil {
+			return fmt.Errorf("failed to create chain: %w", err)
+		}
+		for _, iptablesArgs := range r.rejectRules(ipt.Proto(), chain.hook, ipsetName) {
+			err = ipt.Append("filter", chain.name, iptablesArgs...)
+			if err != nil {
+				return fmt.Errorf("failed to append rule: %w", err)
+			}
+		}
+		// Переход ставится первым, чтобы трафик не пропустили разрешающие правила межсетевого экрана
+		err = ipt.Insert("filter", chain.hook, 1, "-j", chain.name)
+		if err != nil {
+			return fmt.Errorf("failed to insert rule to %s: %w", chain.hook, err)
+		}
+	}
+
+	err := ipt.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit iptables rules: %w", err)
+	}
+	return nil
+}
+
+// nftRejectRules – аналог rejectRules для семейства family
+func (r *IPSetToLink) nftRejectRules(family int, chain string, lookup []nftables.Expr) [][]nftables.Expr {
+	var rules [][]nftables.Expr
+	tcp, others, rest := r.rejectProtocols()
+	if tcp {
+		for _, match := range r.nftProtocolMatches(family, chain, []string{"tcp"}) {
+			rules = append(rules, nftRule(match, lookup, nftables.Reject{Type: unix.NFT_REJECT_TCP_RST}))
+		}
+	}
+	if rest {
+		for _, match := range r.nftProtocolMatches(family, chain, others) {
+			rules = append(rules, nftRule(match, lookup, nftables.Reject{Type: unix.NFT_REJECT_ICMPX_UNREACH, Code: unix.NFT_REJECT_ICMPX_PORT_UNREACH}))
+		}
+	}
+	return rules
+}
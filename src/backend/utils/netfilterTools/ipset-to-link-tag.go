@@ -0,0 +1,147 @@
+package netfilterTools
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"magitrickle/utils/iptables"
+	"magitrickle/utils/nftables"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Пометка трафика: группа не меняет маршрутизацию, а только помечает свой трафик
+	для внешнего QoS/SQM (cake, fq_codel, DSCP у провайдера). Метка ставится в пределах
+	маски и сохраняется в conntrack, чтобы её можно было восстановить для ответов
+	(act_ctinfo, connmark); DSCP переписывается в заголовке пакета.
+	Метка, таблица, ip rule и маршруты группе не выделяются.
+*/
+
+// Режимы пометки трафика
+const (
+	TagMark = "mark"
+	TagDSCP = "dscp"
+)
+
+var ErrTagMarkConflict = errors.New("tag mark overlaps fwmark mask")
+
+// TagTarget – пометка трафика группы вместо маршрутизации
+type TagTarget struct {
+	Mode string
+	// Mark, Mask – метка и изменяемые ею биты (mark)
+	Mark uint32
+	Mask uint32
+	// DSCP – класс DSCP (dscp)
+	DSCP uint8
+}
+
+// tag возвращает режим пометки группы или пустую строку
+func (r *IPSetToLink) tag() string {
+	if r.opts.Tag == nil {
+		return ""
+	}
+	return r.opts.Tag.Mode
+}
+
+// routed сообщает, выделяются ли группе метка и таблица маршрутизации
+// (их не используют redirect, пометка и отклонение трафика)
+func (r *IPSetToLink) routed() bool {
+	return r.proxy() != ProxyRedirect && r.tag() == "" && !r.reject()
+}
+
+// checkTagMark проверяет, что метка группы не затирает биты меток маршрутизации
+func (r *IPSetToLink) checkTagMark() error {
+	if r.tag() != TagMark {
+		return nil
+	}
+	mask := r.nh.markMask()
+	if mask == FullMarkMask {
+		log.Warn().
+			Str("group", r.name).
+			Msg("fwmark mask is not restricted, routing groups overwrite tag marks of the same traffic")
+		return nil
+	}
+	if r.opts.Tag.Mask&mask != 0 {
+		return fmt.Errorf("%w: tag mask %#x, fwmark mask %#x", ErrTagMarkConflict, r.opts.Tag.Mask, mask)
+	}
+	return nil
+}
+
+// tagActions возвращает действия iptables, помечающие трафик группы
+func (r *IPSetToLink) tagActions() [][]string {
+	if r.tag() == TagDSCP {
+		return [][]string{{"-j", "DSCP", "--set-dscp", fmt.Sprintf("%#x", r.opts.Tag.DSCP)}}
+	}
+	mask := fmt.Sprintf("%#x", r.opts.Tag.Mask)
+	return [][]string{
+		{"-j", "MARK", "--set-mark", fmt.Sprintf("%#x/%s", r.opts.Tag.Mark, mask)},
+		{"-j", "CONNMARK", "--save-mark", "--nfmask", mask, "--ctmask", mask},
+	}
+}
+
+func (r *IPSetToLink) insertTagIPTablesRules(ipt *iptables.IPTables, ipsetName string) error {
+	err := ipt.RegisterChainOverride("mangle", r.chainName)
+	if err != nil {
+		return fmt.Errorf("failed to create chain: %w", err)
+	}
+
+	mangleRules := [][]string{
+		{"-m", "conntrack", "--ctdir", "REPLY", "-j", "RETURN"},
+	}
+	for _, match := range r.trafficMatches(ipt.Proto(), "PREROUTING") {
+		for _, action := range r.tagActions() {
+			mangleRules = append(mangleRules, withMatch(match, append([]string{"-m", "set", "--match-set", ipsetName, "dst"}, action...)...))
+		}
+	}
+	for _, iptablesArgs := range mangleRules {
+		err = ipt.Append("mangle", r.chainName, iptablesArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to append rule: %w", err)
+		}
+	}
+
+	err = ipt.Append("mangle", "PREROUTING", "-j", r.chainName)
+	if err != nil {
+		return fmt.Errorf("failed to append rule to PREROUTING: %w", err)
+	}
+
+	if r.opts.RouteOutput {
+		err = r.insertOutputRules(ipt, ipsetName)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ipt.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit iptables rules: %w", err)
+	}
+	return nil
+}
+
+// nftTagActions – аналог tagActions для семейства family
+func (r *IPSetToLink) nftTagActions(family int) []nftables.Expr {
+	if r.tag() == TagMark {
+		return nftSetMaskedMark(r.opts.Tag.Mark, r.opts.Tag.Mask, true)
+	}
+
+	dscp := r.opts.Tag.DSCP
+	if family == net.IPv4len {
+		// DSCP – старшие 6 бит второго байта заголовка IPv4; контрольная сумма пересчитывается
+		return []nftables.Expr{
+			nftables.Payload{Base: unix.NFT_PAYLOAD_NETWORK_HEADER, Offset: 1, Len: 1, Register: nftables.Reg1},
+			nftables.Bitwise{Register: nftables.Reg1, Mask: []byte{0x03}, Xor: []byte{dscp << 2}},
+			nftables.Payload{Base: unix.NFT_PAYLOAD_NETWORK_HEADER, Offset: 1, Len: 1, Register: nftables.Reg1,
+				Set: true, CsumType: unix.NFT_PAYLOAD_CSUM_INET, CsumOffset: 10},
+		}
+	}
+	// В IPv6 класс трафика начинается с пятого бита заголовка, DSCP – его старшие 6 бит
+	return []nftables.Expr{
+		nftables.Payload{Base: unix.NFT_PAYLOAD_NETWORK_HEADER, Offset: 0, Len: 2, Register: nftables.Reg1},
+		nftables.Bitwise{Register: nftables.Reg1, Mask: []byte{0xf0, 0x3f}, Xor: []byte{dscp >> 2, dscp << 6}},
+		nftables.Payload{Base: unix.NFT_PAYLOAD_NETWORK_HEADER, Offset: 0, Len: 2, Register: nftables.Reg1, Set: true},
+	}
+}
@@ -18,21 +18,76 @@ import (
 
 const Blackhole = "blackhole"
 
-type IPSetToLink struct {
-	enabled atomic.Bool
-	locker  sync.Mutex
+// IPSetToLinkOptions – дополнительные условия, ограничивающие маркируемый трафик
+type IPSetToLinkOptions struct {
+	// Protocols – протоколы (tcp, udp, sctp, dccp, icmp); пусто – любой протокол
+	Protocols []string
+	// DstPorts – порты назначения ("443" или "8000-8080"); без Protocols означают tcp и udp
+	DstPorts []string
+	// SrcAddresses – адреса и подсети клиентов; адреса другого семейства пропускаются
+	SrcAddresses []string
+	// SrcMACs – MAC-адреса клиентов
+	SrcMACs []string
+	// SrcInterfaces – входящие интерфейсы (поддерживается суффикс "+")
+	SrcInterfaces []string
+	// RouteOutput – маршрутизировать также трафик самого роутера (mangle OUTPUT)
+	RouteOutput bool
+	// Balance – дополнительные интерфейсы, между которыми вместе с основным
+	// распределяются новые соединения
+	Balance []string
+	// Weights – веса интерфейсов при балансировке; по умолчанию 1
+	Weights map[string]uint32
+	// Gateways – шлюзы основного интерфейса (не более одного на семейство);
+	// для семейства без шлюза используется шлюз интерфейса, если он есть
+	Gateways []string
+	// Onlink – шлюз доступен напрямую через интерфейс, даже если не входит в его подсети
+	Onlink bool
+	// Proxy – передавать трафик локальному прокси вместо интерфейса;
+	// без Protocols используются tcp и udp
+	Proxy *ProxyTarget
+	// MSSClamp – ограничивать MSS TCP-соединений, уходящих через интерфейсы группы
+	MSSClamp *MSSClamp
+	// NAT – трансляция адреса источника; по умолчанию маскарадинг
+	NAT NAT
+	// Tag – только помечать трафик, не меняя маршрутизацию
+	Tag *TagTarget
+}
 
-	chainName string
-	ifaceName string
-	startIdx  uint32
-	ipset     *IPSet
+// linkTarget направляет трафик с меткой mark в таблицу table с маршрутом через интерфейс
+type linkTarget struct {
 	nh        *Helper
+	ifaceName string
 	mark      uint32
 	table     int
 	ip4Rule   *netlink.Rule
 	ip6Rule   *netlink.Rule
 	ip4Route  [2]*netlink.Route
 	ip6Route  [2]*netlink.Route
+	// collision – почему закреплённые метка и таблица были заменены
+	collision string
+
+	// Шлюзы применяются, только пока трафик идёт через gatewayIface
+	gatewayIface string
+	gateways     []net.IP
+	onlink       bool
+}
+
+type IPSetToLink struct {
+	enabled atomic.Bool
+	locker  sync.Mutex
+
+	// Основной интерфейс группы
+	linkTarget
+
+	name      string
+	chainName string
+	startIdx  uint32
+	ipset     *IPSet
+	opts      IPSetToLinkOptions
+	// members – дополнительные интерфейсы балансировки со своими метками и таблицами
+	members []*linkTarget
+	// inactive – интерфейсы, временно исключённые из балансировки
+	inactive map[string]struct{}
 }
 
 func (r *IPSetToLink) insertIPTablesRules(ipt *iptables.IPTables) error {
@@ -47,19 +102,47 @@ func (r *IPSetToLink) insertIPTablesRules(ipt *iptables.IPTables) error {
 		ipsetName += "_6"
 	}
 
+	// Цепочку счётчиков применяет Commit правил группы
+	err := r.insertCounterIPTablesRules(ipt, ipsetName)
+	if err != nil {
+		return err
+	}
+
+	if r.proxy() != "" {
+		return r.insertProxyIPTablesRules(ipt, ipsetName)
+	}
+	if r.tag() != "" {
+		return r.insertTagIPTablesRules(ipt, ipsetName)
+	}
+	if r.reject() {
+		return r.insertRejectIPTablesRules(ipt, ipsetName)
+	}
+
 	/*
 		Filter Forward
 	*/
 
-	err := ipt.RegisterChainOverride("filter", r.chainName)
+	err = ipt.RegisterChainOverride("filter", r.chainName)
 	if err != nil {
 		return fmt.Errorf("failed to create chain: %w", err)
 	}
 
-	if r.ifaceName != Blackhole {
-		err = ipt.Append("filter", r.chainName, "-o", r.ifaceName, "-m", "set", "--match-set", ipsetName, "dst", "-j", "ACCEPT")
+	for _, iptablesArgs := range r.mssClampRules(ipt.Proto(), "FORWARD", ipsetName) {
+		err = ipt.Append("filter", r.chainName, iptablesArgs...)
 		if err != nil {
-			return fmt.Errorf("failed to fix protect for IPv4: %w", err)
+			return fmt.Errorf("failed to append rule: %w", err)
+		}
+	}
+
+	for _, target := range r.targets() {
+		if target.ifaceName == Blackhole {
+			continue
+		}
+		for _, match := range r.trafficMatches(ipt.Proto(), "FORWARD") {
+			err = ipt.Append("filter", r.chainName, withMatch(match, "-o", target.ifaceName, "-m", "set", "--match-set", ipsetName, "dst", "-j", "ACCEPT")...)
+			if err != nil {
+				return fmt.Errorf("failed to fix protect for IPv4: %w", err)
+			}
 		}
 	}
 
@@ -77,12 +160,22 @@ func (r *IPSetToLink) insertIPTablesRules(ipt *iptables.IPTables) error {
 		return fmt.Errorf("failed to create chain: %w", err)
 	}
 
-	markStr := strconv.Itoa(int(r.mark))
-	for _, iptablesArgs := range [][]string{
+	if r.balancing() {
+		err = r.insertBalanceRules(ipt)
+		if err != nil {
+			return err
+		}
+	}
+
+	mangleRules := [][]string{
 		{"-m", "conntrack", "--ctdir", "REPLY", "-j", "RETURN"},
-		{"-m", "set", "--match-set", ipsetName, "dst", "-j", "MARK", "--set-mark", markStr},
-		{"-m", "set", "--match-set", ipsetName, "dst", "-j", "CONNMARK", "--save-mark"}, // Without this rule, routing on Keenetic routers did not work; DO NOT REMOVE!
-	} {
+	}
+	for _, match := range r.trafficMatches(ipt.Proto(), "PREROUTING") {
+		for _, action := range r.markActions() {
+			mangleRules = append(mangleRules, withMatch(match, append([]string{"-m", "set", "--match-set", ipsetName, "dst"}, action...)...))
+		}
+	}
+	for _, iptablesArgs := range mangleRules {
 		err = ipt.Append("mangle", r.chainName, iptablesArgs...)
 		if err != nil {
 			return fmt.Errorf("failed to append rule: %w", err)
@@ -94,6 +187,38 @@ func (r *IPSetToLink) insertIPTablesRules(ipt *iptables.IPTables) error {
 		return fmt.Errorf("failed to append rule to PREROUTING: %w", err)
 	}
 
+	/*
+		Mangle Output
+	*/
+
+	if r.opts.RouteOutput {
+		err = r.insertOutputRules(ipt, ipsetName)
+		if err != nil {
+			return err
+		}
+	}
+
+	/*
+		Filter Output
+	*/
+
+	if clampRules := r.mssClampRules(ipt.Proto(), "OUTPUT", ipsetName); r.opts.RouteOutput && len(clampRules) != 0 {
+		err = ipt.RegisterChainOverride("filter", r.outputChainName())
+		if err != nil {
+			return fmt.Errorf("failed to create chain: %w", err)
+		}
+		for _, iptablesArgs := range clampRules {
+			err = ipt.Append("filter", r.outputChainName(), iptablesArgs...)
+			if err != nil {
+				return fmt.Errorf("failed to append rule: %w", err)
+			}
+		}
+		err = ipt.Append("filter", "OUTPUT", "-j", r.outputChainName())
+		if err != nil {
+			return fmt.Errorf("failed to append rule to OUTPUT: %w", err)
+		}
+	}
+
 	/*
 		NAT Postrouting
 	*/
@@ -103,9 +228,26 @@ func (r *IPSetToLink) insertIPTablesRules(ipt *iptables.IPTables) error {
 		return fmt.Errorf("failed to create chain: %w", err)
 	}
 
-	err = ipt.Append("nat", r.chainName, "-m", "set", "--match-set", ipsetName, "dst", "-j", "MASQUERADE")
-	if err != nil {
-		return fmt.Errorf("failed to create rule: %w", err)
+	natAction := r.natAction(ipt.Proto())
+	for _, match := range r.trafficMatches(ipt.Proto(), "POSTROUTING") {
+		if natAction == nil {
+			break
+		}
+		err = ipt.Append("nat", r.chainName, withMatch(match, append([]string{"-m", "set", "--match-set", ipsetName, "dst"}, natAction...)...)...)
+		if err != nil {
+			return fmt.Errorf("failed to create rule: %w", err)
+		}
+	}
+	if natAction != nil && r.opts.RouteOutput && len(r.opts.SrcAddresses) > 0 {
+		// Трафик роутера не попадает под селекторы источника, поэтому опознаётся по метке
+		for _, match := range r.trafficMatches(ipt.Proto(), "OUTPUT") {
+			for _, target := range r.targets() {
+				err = ipt.Append("nat", r.chainName, withMatch(match, append([]string{"-m", "mark", "--mark", r.nh.markArg(target.mark), "-m", "set", "--match-set", ipsetName, "dst"}, natAction...)...)...)
+				if err != nil {
+					return fmt.Errorf("failed to create rule: %w", err)
+				}
+			}
+		}
 	}
 
 	err = ipt.Append("nat", "POSTROUTING", "-j", r.chainName)
@@ -120,6 +262,62 @@ func (r *IPSetToLink) insertIPTablesRules(ipt *iptables.IPTables) error {
 	return nil
 }
 
+func (r *IPSetToLink) outputChainName() string {
+	return r.chainName + "_OUT"
+}
+
+// insertOutputRules маркирует трафик самого роутера. Чтобы не создать петлю,
+// пропускаются локальный трафик, уже промаркированные сокеты (VPN-клиенты с fwmark),
+// транспорт интерфейсов группы (link-endpoints.go) и исключения из Helper.OutputBypass.
+func (r *IPSetToLink) insertOutputRules(ipt *iptables.IPTables, ipsetName string) error {
+	chainName := r.outputChainName()
+	err := ipt.RegisterChainOverride("mangle", chainName)
+	if err != nil {
+		return fmt.Errorf("failed to create chain: %w", err)
+	}
+
+	outputRules := [][]string{
+		{"-m", "conntrack", "--ctdir", "REPLY", "-j", "RETURN"},
+		{"-o", "lo", "-j", "RETURN"},
+		{"-m", "mark", "!", "--mark", "0", "-j", "RETURN"},
+	}
+	for _, bypass := range r.nh.OutputBypass {
+		if !bypass.Prefix.IsValid() || bypass.Prefix.Addr().Is4() != (ipt.Proto() == iptables.ProtocolIPv4) {
+			continue
+		}
+		rule := []string{"-d", bypass.Prefix.String()}
+		if bypass.Protocol != "" {
+			rule = append(rule, "-p", bypass.Protocol)
+			if bypass.Port != 0 {
+				rule = append(rule, "-m", bypass.Protocol, "--dport", strconv.Itoa(int(bypass.Port)))
+			}
+		}
+		outputRules = append(outputRules, append(rule, "-j", "RETURN"))
+	}
+	for _, prefix := range r.transportBypass() {
+		if prefix.Addr().Is4() == (ipt.Proto() == iptables.ProtocolIPv4) {
+			outputRules = append(outputRules, []string{"-d", prefix.String(), "-j", "RETURN"})
+		}
+	}
+	for _, match := range r.trafficMatches(ipt.Proto(), "OUTPUT") {
+		for _, action := range r.markActions() {
+			outputRules = append(outputRules, withMatch(match, append([]string{"-m", "set", "--match-set", ipsetName, "dst"}, action...)...))
+		}
+	}
+	for _, iptablesArgs := range outputRules {
+		err = ipt.Append("mangle", chainName, iptablesArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to append rule: %w", err)
+		}
+	}
+
+	err = ipt.Append("mangle", "OUTPUT", "-j", chainName)
+	if err != nil {
+		return fmt.Errorf("failed to append rule to OUTPUT: %w", err)
+	}
+	return nil
+}
+
 func (r *IPSetToLink) deleteIPTablesRules(ipt *iptables.IPTables) error {
 	if ipt == nil {
 		return nil
@@ -154,6 +352,49 @@ func (r *IPSetToLink) deleteIPTablesRules(ipt *iptables.IPTables) error {
 		errs = append(errs, fmt.Errorf("failed to unlinking chain: %w", err))
 	}
 
+	/*
+		Mangle Output
+	*/
+
+	err = ipt.RegisterChainDelete("mangle", r.outputChainName())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete chain: %w", err))
+	}
+
+	err = ipt.Delete("mangle", "OUTPUT", "-j", r.outputChainName())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to unlinking chain: %w", err))
+	}
+
+	err = ipt.RegisterChainDelete("filter", r.outputChainName())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete chain: %w", err))
+	}
+
+	err = ipt.Delete("filter", "OUTPUT", "-j", r.outputChainName())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to unlinking chain: %w", err))
+	}
+
+	err = ipt.RegisterChainDelete("mangle", r.balanceChainName())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete chain: %w", err))
+	}
+
+	/*
+		Mangle Prerouting (счётчики)
+	*/
+
+	err = ipt.RegisterChainDelete("mangle", r.counterChainName())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete chain: %w", err))
+	}
+
+	err = ipt.Delete("mangle", "PREROUTING", "-j", r.counterChainName())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to unlinking chain: %w", err))
+	}
+
 	/*
 		NAT Postrouting
 	*/
@@ -168,6 +409,20 @@ func (r *IPSetToLink) deleteIPTablesRules(ipt *iptables.IPTables) error {
 		errs = append(errs, fmt.Errorf("failed to unlinking chain: %w", err))
 	}
 
+	/*
+		NAT Prerouting
+	*/
+
+	err = ipt.RegisterChainDelete("nat", r.redirectChainName())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete chain: %w", err))
+	}
+
+	err = ipt.Delete("nat", "PREROUTING", "-j", r.redirectChainName())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to unlinking chain: %w", err))
+	}
+
 	err = ipt.Commit()
 	if err != nil {
 		errs = append(errs, fmt.Errorf("failed to commit iptables rules: %w", err))
@@ -175,10 +430,11 @@ func (r *IPSetToLink) deleteIPTablesRules(ipt *iptables.IPTables) error {
 	return errors.Join(errs...)
 }
 
-func (r *IPSetToLink) insertIPRule() error {
-	if r.nh.IPTables4 != nil {
+func (r *linkTarget) insertIPRule() error {
+	if !r.nh.DisableIPv4 {
 		rule := netlink.NewRule()
 		rule.Mark = r.mark
+		rule.Mask = r.nh.ruleMask()
 		rule.Table = r.table
 		rule.Family = nl.FAMILY_V4
 		_ = netlink.RuleDel(rule)
@@ -189,9 +445,10 @@ func (r *IPSetToLink) insertIPRule() error {
 		r.ip4Rule = rule
 	}
 
-	if r.nh.IPTables6 != nil {
+	if !r.nh.DisableIPv6 {
 		rule := netlink.NewRule()
 		rule.Mark = r.mark
+		rule.Mask = r.nh.ruleMask()
 		rule.Table = r.table
 		rule.Family = nl.FAMILY_V6
 		_ = netlink.RuleDel(rule)
@@ -205,7 +462,7 @@ func (r *IPSetToLink) insertIPRule() error {
 	return nil
 }
 
-func (r *IPSetToLink) deleteIPRule() error {
+func (r *linkTarget) deleteIPRule() error {
 	var errs []error
 
 	if r.ip4Rule != nil {
@@ -227,8 +484,8 @@ func (r *IPSetToLink) deleteIPRule() error {
 	return errors.Join(errs...)
 }
 
-func (r *IPSetToLink) insertIPRoute() error {
-	if r.nh.IPTables4 != nil {
+func (r *linkTarget) insertIPRoute() error {
+	if !r.nh.DisableIPv4 {
 		route := &netlink.Route{
 			Priority: 20,
 			Dst:      &net.IPNet{IP: []byte{0, 0, 0, 0}, Mask: []byte{0, 0, 0, 0}},
@@ -242,7 +499,7 @@ func (r *IPSetToLink) insertIPRoute() error {
 		r.ip4Route[0] = route
 	}
 
-	if r.nh.IPTables6 != nil {
+	if !r.nh.DisableIPv6 {
 		route := &netlink.Route{
 			Priority: 20,
 			Dst:      &net.IPNet{IP: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, Mask: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
@@ -274,18 +531,18 @@ func (r *IPSetToLink) insertIPRoute() error {
 	}
 
 	var errs []error
-	if r.nh.IPTables4 != nil {
+	if !r.nh.DisableIPv4 {
 		r.ip4Route[1], err = r.updateIfaceRoute(iface, nl.FAMILY_V4, r.ip4Route[1])
 		errs = append(errs, err)
 	}
-	if r.nh.IPTables6 != nil {
+	if !r.nh.DisableIPv6 {
 		r.ip6Route[1], err = r.updateIfaceRoute(iface, nl.FAMILY_V6, r.ip6Route[1])
 		errs = append(errs, err)
 	}
 	return errors.Join(errs...)
 }
 
-func (r *IPSetToLink) updateIfaceRoute(iface netlink.Link, family int, current *netlink.Route) (*netlink.Route, error) {
+func (r *linkTarget) updateIfaceRoute(iface netlink.Link, family int, current *netlink.Route) (*netlink.Route, error) {
 	ipLen := net.IPv4len
 	if family == nl.FAMILY_V6 {
 		ipLen = net.IPv6len
@@ -299,7 +556,12 @@ func (r *IPSetToLink) updateIfaceRoute(iface netlink.Link, family int, current *
 		Dst:       &net.IPNet{IP: make(net.IP, ipLen), Mask: make(net.IPMask, ipLen)},
 	}
 
-	if iface.Attrs().Flags&net.FlagPointToPoint == 0 {
+	if gateway := r.gateway(family); gateway != nil {
+		route.Gw = gateway
+		if r.onlink {
+			route.Flags = int(netlink.FLAG_ONLINK)
+		}
+	} else if iface.Attrs().Flags&net.FlagPointToPoint == 0 {
 		gateway, err := getGwFromIface(iface, family)
 		if err != nil {
 			log.Warn().Str("iface", r.ifaceName).Err(err).Int("family", family).Msg("gateway not found")
@@ -310,11 +572,13 @@ func (r *IPSetToLink) updateIfaceRoute(iface netlink.Link, family int, current *
 
 	deleted := false
 	if current != nil {
-		if route.Gw != nil && route.Gw.Equal(current.Gw) {
+		// Интерфейс мог быть пересоздан с новым индексом, пока событие удаления не дошло
+		sameLink := current.LinkIndex == route.LinkIndex
+		if sameLink && route.Gw != nil && route.Gw.Equal(current.Gw) {
 			return current, nil
 		}
-		if route.Gw != nil {
-			if err := netlink.RouteDel(current); err != nil && !errors.Is(err, unix.ESRCH) {
+		if route.Gw != nil || !sameLink {
+			if err := netlink.RouteDel(current); err != nil && !errors.Is(err, unix.ESRCH) && !errors.Is(err, unix.ENODEV) {
 				return current, fmt.Errorf("error deleting iface route: %w", err)
 			}
 			deleted = true
@@ -329,6 +593,14 @@ func (r *IPSetToLink) updateIfaceRoute(iface netlink.Link, family int, current *
 			}
 			return current, nil
 		}
+		if errors.Is(err, unix.ENETUNREACH) && r.gateway(family) != nil {
+			// Адрес интерфейса может появиться позже; маршрут будет добавлен при его изменении
+			log.Warn().Str("iface", r.ifaceName).Str("gateway", route.Gw.String()).Msg("gateway is not in interface subnets, skipping route (use onlink)")
+			if deleted {
+				return nil, nil
+			}
+			return current, nil
+		}
 		if !errors.Is(err, unix.EEXIST) {
 			return nil, fmt.Errorf("error adding iface route: %w", err)
 		}
@@ -336,6 +608,19 @@ func (r *IPSetToLink) updateIfaceRoute(iface netlink.Link, family int, current *
 	return route, nil
 }
 
+// gateway возвращает заданный шлюз семейства family или nil
+func (r *linkTarget) gateway(family int) net.IP {
+	if r.ifaceName != r.gatewayIface {
+		return nil
+	}
+	for _, gateway := range r.gateways {
+		if (gateway.To4() != nil) == (family == nl.FAMILY_V4) {
+			return gateway
+		}
+	}
+	return nil
+}
+
 func getGwFromIface(iface netlink.Link, family int) (net.IP, error) {
 	routes, err := netlink.RouteListFiltered(family, &netlink.Route{
 		LinkIndex: iface.Attrs().Index,
@@ -351,7 +636,7 @@ func getGwFromIface(iface netlink.Link, family int) (net.IP, error) {
 	return nil, fmt.Errorf("no gateway found for interface %s", iface.Attrs().Name)
 }
 
-func (r *IPSetToLink) deleteIPRoute() error {
+func (r *linkTarget) deleteIPRoute() error {
 	errs := make([]error, 0)
 
 	for i := 1; i >= 0; i-- {
@@ -379,37 +664,22 @@ func (r *IPSetToLink) deleteIPRoute() error {
 	return errors.Join(errs...)
 }
 
-func (r *IPSetToLink) getUnusedMarkAndTable() (idx uint32, err error) {
-	// Find unused mark and table
-	markMap := make(map[uint32]struct{})
-	tableMap := map[int]struct{}{0: {}, 253: {}, 254: {}, 255: {}}
-
-	rules, err := netlink.RuleList(nl.FAMILY_ALL)
-	if err != nil {
-		return 0, fmt.Errorf("error while getting rules: %w", err)
-	}
-	for _, rule := range rules {
-		markMap[rule.Mark] = struct{}{}
-		tableMap[rule.Table] = struct{}{}
-	}
-
-	routes, err := netlink.RouteListFiltered(nl.FAMILY_ALL, &netlink.Route{}, netlink.RT_FILTER_TABLE)
+// allocate выделяет цели метку и таблицу, закреплённые за ключом key
+func (r *linkTarget) allocate(key string, startIdx uint32) error {
+	alloc, err := r.nh.allocate(key, startIdx)
 	if err != nil {
-		return 0, fmt.Errorf("error while getting routes: %w", err)
-	}
-	for _, route := range routes {
-		tableMap[route.Table] = struct{}{}
+		return err
 	}
-
-	for idx = r.startIdx; idx < 0x7ffffffe; idx++ {
-		_, tableExists := tableMap[int(idx)]
-		_, markExists := markMap[idx]
-		if !tableExists && !markExists {
-			break
-		}
+	if alloc.Collision != "" {
+		log.Warn().
+			Str("key", key).
+			Str("collision", alloc.Collision).
+			Int("table", alloc.Table).
+			Int("mark", int(alloc.Mark)).
+			Msg("persisted ip table and mark are taken, allocated new ones")
 	}
-
-	return idx, nil
+	r.mark, r.table, r.collision = alloc.Mark, alloc.Table, alloc.Collision
+	return nil
 }
 
 func (r *IPSetToLink) enable() error {
@@ -417,29 +687,44 @@ func (r *IPSetToLink) enable() error {
 		return nil
 	}
 
-	var err error
-	idx, err := r.getUnusedMarkAndTable()
+	if err := r.nh.RequireCapabilities(r.requiredCapabilities()...); err != nil {
+		return err
+	}
+
+	// REDIRECT меняет адрес назначения, пометка не меняет маршрутизацию, а отклонённый
+	// трафик не маршрутизируется, поэтому метка и таблица не нужны
+	if !r.routed() {
+		if err := r.checkTagMark(); err != nil {
+			return err
+		}
+		return r.nh.getBackend().insertLinkRules(r)
+	}
+
+	err := r.allocate(r.name, r.startIdx)
 	if err != nil {
 		return err
 	}
-	r.mark, r.table = idx, int(idx)
 
 	err = r.insertIPRule()
 	if err != nil {
 		return err
 	}
 
-	err = r.insertIPRoute()
+	if r.proxy() == ProxyTProxy {
+		err = r.insertLocalRoute()
+	} else {
+		err = r.insertIPRoute()
+	}
 	if err != nil {
 		return err
 	}
 
-	err = r.insertIPTablesRules(r.nh.IPTables4)
+	err = r.enableMembers()
 	if err != nil {
 		return err
 	}
 
-	err = r.insertIPTablesRules(r.nh.IPTables6)
+	err = r.nh.getBackend().insertLinkRules(r)
 	if err != nil {
 		return err
 	}
@@ -454,7 +739,7 @@ func (r *IPSetToLink) Enable() error {
 	err := r.enable()
 	if err != nil {
 		r.disable()
-	} else {
+	} else if r.routed() {
 		log.Debug().
 			Int("table", r.table).
 			Int("mark", int(r.mark)).
@@ -473,8 +758,8 @@ func (r *IPSetToLink) disable() error {
 	var errs []error
 	errs = append(errs, r.deleteIPRoute())
 	errs = append(errs, r.deleteIPRule())
-	errs = append(errs, r.deleteIPTablesRules(r.nh.IPTables4))
-	errs = append(errs, r.deleteIPTablesRules(r.nh.IPTables6))
+	errs = append(errs, r.disableMembers())
+	errs = append(errs, r.nh.getBackend().deleteLinkRules(r))
 	return errors.Join(errs...)
 }
 
@@ -496,29 +781,159 @@ func (r *IPSetToLink) ClearIfDisabled() error {
 	var errs []error
 	errs = append(errs, r.deleteIPRoute())
 	errs = append(errs, r.deleteIPRule())
-	errs = append(errs, r.deleteIPTablesRules(r.nh.IPTables4))
-	errs = append(errs, r.deleteIPTablesRules(r.nh.IPTables6))
+	errs = append(errs, r.nh.getBackend().deleteLinkRules(r))
 	return errors.Join(errs...)
 }
 
-func (r *IPSetToLink) LinkUpHook(event netlink.LinkUpdate) error {
+// Interface возвращает интерфейс, через который сейчас маршрутизируется трафик
+func (r *IPSetToLink) Interface() string {
 	r.locker.Lock()
 	defer r.locker.Unlock()
 
-	if !r.enabled.Load() || event.Link.Attrs().Name != r.ifaceName {
+	return r.ifaceName
+}
+
+// Allocations возвращает метки и таблицы интерфейсов группы; пусто, пока группа выключена
+// или не использует метку (redirect, пометка трафика)
+func (r *IPSetToLink) Allocations() []Allocation {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if !r.enabled.Load() || !r.routed() {
+		return nil
+	}
+	var out []Allocation
+	for _, target := range r.targets() {
+		out = append(out, Allocation{
+			Interface: target.ifaceName,
+			Mark:      target.mark,
+			Table:     target.table,
+			Collision: target.collision,
+		})
+	}
+	return out
+}
+
+// SetInterface переключает маршрутизацию на другой интерфейс. Меняется только маршрут
+// в таблице группы и её собственные цепочки; метка, таблица и ip rule сохраняются.
+func (r *IPSetToLink) SetInterface(ifaceName string) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if r.ifaceName == ifaceName {
+		return nil
+	}
+	r.ifaceName = ifaceName
+	if !r.enabled.Load() {
 		return nil
 	}
 
 	var errs []error
+	for _, route := range []**netlink.Route{&r.ip4Route[1], &r.ip6Route[1]} {
+		if *route == nil {
+			continue
+		}
+		err := netlink.RouteDel(*route)
+		if err != nil && !errors.Is(err, unix.ESRCH) {
+			errs = append(errs, fmt.Errorf("error while deleting route: %w", err))
+		}
+		*route = nil
+	}
 	errs = append(errs, r.insertIPRoute())
+	errs = append(errs, r.nh.getBackend().insertLinkRules(r))
 	return errors.Join(errs...)
 }
 
+// LinkUpHook добавляет маршруты через включённый интерфейс группы. Возвращает интерфейсы,
+// у которых маршрута не было: они появились впервые или вернулись после отключения.
+func (r *IPSetToLink) LinkUpHook(event netlink.LinkUpdate) ([]string, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if !r.enabled.Load() || r.proxy() != "" || !r.routed() {
+		return nil, nil
+	}
+
+	var restored []string
+	var errs []error
+	for _, target := range r.targets() {
+		if event.Link.Attrs().Name != target.ifaceName {
+			continue
+		}
+		hadRoute := target.hasIfaceRoute()
+		errs = append(errs, target.insertIPRoute())
+		if !hadRoute && target.hasIfaceRoute() {
+			restored = append(restored, target.ifaceName)
+		}
+	}
+	return restored, errors.Join(errs...)
+}
+
+// LinkDownHook удаляет маршруты через выключенный или удалённый интерфейс: трафик
+// группы остаётся на blackhole-маршруте таблицы, а LinkUpHook добавит маршрут заново,
+// в том числе с новым индексом интерфейса. Возвращает интерфейсы, маршруты которых удалены.
+func (r *IPSetToLink) LinkDownHook(event netlink.LinkUpdate) ([]string, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if !r.enabled.Load() || r.proxy() != "" || !r.routed() {
+		return nil, nil
+	}
+
+	var dropped []string
+	var errs []error
+	for _, target := range r.targets() {
+		ok, err := target.dropIfaceRoute(event.Link.Attrs().Name, event.Link.Attrs().Index)
+		if ok {
+			dropped = append(dropped, target.ifaceName)
+		}
+		errs = append(errs, err)
+	}
+	return dropped, errors.Join(errs...)
+}
+
+// hasIfaceRoute сообщает, есть ли у цели маршрут через интерфейс
+func (r *linkTarget) hasIfaceRoute() bool {
+	return r.ip4Route[1] != nil || r.ip6Route[1] != nil
+}
+
+// dropIfaceRoute удаляет маршруты через интерфейс name (или с индексом index, если
+// интерфейс переименован); blackhole-маршрут таблицы остаётся. Для удалённого
+// интерфейса ядро уже удалило маршруты, поэтому их отсутствие не считается ошибкой.
+func (r *linkTarget) dropIfaceRoute(name string, index int) (bool, error) {
+	var dropped bool
+	var errs []error
+	for _, route := range []**netlink.Route{&r.ip4Route[1], &r.ip6Route[1]} {
+		if *route == nil || (r.ifaceName != name && (*route).LinkIndex != index) {
+			continue
+		}
+		err := netlink.RouteDel(*route)
+		if err != nil && !errors.Is(err, unix.ESRCH) && !errors.Is(err, unix.ENODEV) {
+			errs = append(errs, fmt.Errorf("error while deleting route: %w", err))
+		}
+		*route = nil
+		dropped = true
+	}
+	return dropped, errors.Join(errs...)
+}
+
 func (r *IPSetToLink) AddrChangeHook(event netlink.AddrUpdate) error {
 	r.locker.Lock()
 	defer r.locker.Unlock()
 
-	if !r.enabled.Load() || r.ifaceName == Blackhole {
+	if !r.enabled.Load() || r.proxy() != "" || !r.routed() {
+		return nil
+	}
+
+	var errs []error
+	for _, target := range r.targets() {
+		errs = append(errs, target.addrChangeHook(event))
+	}
+	return errors.Join(errs...)
+}
+
+func (r *linkTarget) addrChangeHook(event netlink.AddrUpdate) error {
+	if r.ifaceName == Blackhole {
 		return nil
 	}
 
@@ -531,23 +946,39 @@ func (r *IPSetToLink) AddrChangeHook(event netlink.AddrUpdate) error {
 	}
 
 	var errs []error
-	if r.nh.IPTables4 != nil {
+	if !r.nh.DisableIPv4 {
 		r.ip4Route[1], err = r.updateIfaceRoute(iface, nl.FAMILY_V4, r.ip4Route[1])
 		errs = append(errs, err)
 	}
-	if r.nh.IPTables6 != nil {
+	if !r.nh.DisableIPv6 {
 		r.ip6Route[1], err = r.updateIfaceRoute(iface, nl.FAMILY_V6, r.ip6Route[1])
 		errs = append(errs, err)
 	}
 	return errors.Join(errs...)
 }
 
-func (nh *Helper) IPSetToLink(name string, ifaceName string, ipset *IPSet) *IPSetToLink {
+func (nh *Helper) IPSetToLink(name string, ifaceName string, ipset *IPSet, opts IPSetToLinkOptions) *IPSetToLink {
+	var gateways []net.IP
+	for _, gateway := range opts.Gateways {
+		if ip := net.ParseIP(gateway); ip != nil {
+			gateways = append(gateways, ip)
+		}
+	}
+	if opts.Proxy != nil && len(opts.Protocols) == 0 {
+		opts.Protocols = []string{"tcp", "udp"}
+	}
 	return &IPSetToLink{
-		nh:        nh,
+		linkTarget: linkTarget{
+			nh:           nh,
+			ifaceName:    ifaceName,
+			gatewayIface: ifaceName,
+			gateways:     gateways,
+			onlink:       opts.Onlink,
+		},
+		name:      name,
 		chainName: nh.ChainPrefix + name,
-		ifaceName: ifaceName,
 		ipset:     ipset,
+		opts:      opts,
 		startIdx:  nh.StartIdx,
 	}
 }
@@ -0,0 +1,623 @@
+//go:build testing
+
+package netfilterTools
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"magitrickle/utils/iptables"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// testChains – встроенные цепочки, к которым группы подключают свои правила
+var testChains = [][2]string{
+	{"filter", "FORWARD"},
+	{"filter", "OUTPUT"},
+	{"mangle", "PREROUTING"},
+	{"mangle", "OUTPUT"},
+	{"nat", "PREROUTING"},
+	{"nat", "POSTROUTING"},
+}
+
+func registerTestChains(t *testing.T, ipt *iptables.IPTables) {
+	t.Helper()
+	for _, chain := range testChains {
+		if err := ipt.RegisterChainPatch(chain[0], chain[1]); err != nil {
+			t.Fatalf("RegisterChainPatch failed: %v", err)
+		}
+	}
+}
+
+func newTestHelper(t *testing.T) (*Helper, *iptables.FakeIPTables, *iptables.FakeIPTables) {
+	t.Helper()
+	fake4 := iptables.NewFakeIPTables(iptables.ProtocolIPv4)
+	fake6 := iptables.NewFakeIPTables(iptables.ProtocolIPv6)
+	nh := &Helper{
+		ChainPrefix: "MT_",
+		IpsetPrefix: "mt_",
+		IPTables4:   iptables.NewIPTables(fake4),
+		IPTables6:   iptables.NewIPTables(fake6),
+	}
+	registerTestChains(t, nh.IPTables4)
+	registerTestChains(t, nh.IPTables6)
+	return nh, fake4, fake6
+}
+
+// testLink – группа grp с меткой 7 на помощнике из newTestHelper
+type testLink struct {
+	t            *testing.T
+	nh           *Helper
+	link         *IPSetToLink
+	fake4, fake6 *iptables.FakeIPTables
+}
+
+func newTestLink(t *testing.T, ifaceName string, opts IPSetToLinkOptions) *testLink {
+	t.Helper()
+	nh, fake4, fake6 := newTestHelper(t)
+	link := nh.IPSetToLink("grp", ifaceName, nh.IPSet("grp", IPSetOptions{}), opts)
+	link.mark = 7
+	return &testLink{t: t, nh: nh, link: link, fake4: fake4, fake6: fake6}
+}
+
+func (l *testLink) insert(ipt *iptables.IPTables) {
+	l.t.Helper()
+	if err := l.link.insertIPTablesRules(ipt); err != nil {
+		l.t.Fatalf("insertIPTablesRules failed: %v", err)
+	}
+}
+
+func (l *testLink) insert4() {
+	l.t.Helper()
+	l.insert(l.nh.IPTables4)
+}
+
+func (l *testLink) insert6() {
+	l.t.Helper()
+	l.insert(l.nh.IPTables6)
+}
+
+func (l *testLink) delete4() {
+	l.t.Helper()
+	if err := l.link.deleteIPTablesRules(l.nh.IPTables4); err != nil {
+		l.t.Fatalf("deleteIPTablesRules failed: %v", err)
+	}
+}
+
+// expectRules сравнивает правила цепочки с ожидаемыми
+func expectRules(t *testing.T, fake *iptables.FakeIPTables, table, chain string, expected [][]string) {
+	t.Helper()
+	if got := fake.GetRules(table, chain); !reflect.DeepEqual(got, expected) {
+		t.Errorf("%s %s rules mismatch.\nExpected: %v\nGot: %v", table, chain, expected, got)
+	}
+}
+
+// replyReturn – первое правило цепочки маркировки: ответы не маркируются повторно
+var replyReturn = []string{"-m", "conntrack", "--ctdir", "REPLY", "-j", "RETURN"}
+
+func TestIPSetToLinkRulesWithoutQualifiers(t *testing.T) {
+	l := newTestLink(t, "nwg0", IPSetToLinkOptions{})
+	l.insert4()
+
+	expectRules(t, l.fake4, "mangle", "MT_grp", [][]string{
+		replyReturn,
+		{"-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "MARK", "--set-mark", "7"},
+		{"-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "CONNMARK", "--save-mark"},
+	})
+}
+
+func TestIPSetToLinkRulesWithProtocolAndPorts(t *testing.T) {
+	l := newTestLink(t, "nwg0", IPSetToLinkOptions{
+		DstPorts: []string{"443", "8000-8080"},
+	})
+	l.insert4()
+
+	mark := func(proto, port string) []string {
+		return []string{"-p", proto, "-m", proto, "--dport", port, "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "MARK", "--set-mark", "7"}
+	}
+	save := func(proto, port string) []string {
+		return []string{"-p", proto, "-m", proto, "--dport", port, "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "CONNMARK", "--save-mark"}
+	}
+	expectRules(t, l.fake4, "mangle", "MT_grp", [][]string{
+		replyReturn,
+		mark("tcp", "443"), save("tcp", "443"),
+		mark("tcp", "8000:8080"), save("tcp", "8000:8080"),
+		mark("udp", "443"), save("udp", "443"),
+		mark("udp", "8000:8080"), save("udp", "8000:8080"),
+	})
+	if got := l.fake4.GetRules("nat", "MT_grp"); len(got) != 4 {
+		t.Errorf("expected 4 nat rules, got %v", got)
+	}
+
+	l.link.opts = IPSetToLinkOptions{Protocols: []string{"icmp"}}
+	l.insert6()
+	expectRules(t, l.fake6, "mangle", "MT_grp", [][]string{
+		replyReturn,
+		{"-p", "ipv6-icmp", "-m", "set", "--match-set", "mt_grp_6", "dst", "-j", "MARK", "--set-mark", "7"},
+		{"-p", "ipv6-icmp", "-m", "set", "--match-set", "mt_grp_6", "dst", "-j", "CONNMARK", "--save-mark"},
+	})
+}
+
+func TestIPSetToLinkRulesWithSources(t *testing.T) {
+	l := newTestLink(t, "nwg0", IPSetToLinkOptions{
+		SrcAddresses:  []string{"192.168.1.10", "10.0.0.0/8"},
+		SrcMACs:       []string{"aa:bb:cc:dd:ee:ff"},
+		SrcInterfaces: []string{"br0"},
+	})
+	l.insert4()
+
+	mark := func(src string) []string {
+		return []string{"-s", src, "-i", "br0", "-m", "mac", "--mac-source", "AA:BB:CC:DD:EE:FF", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "MARK", "--set-mark", "7"}
+	}
+	save := func(src string) []string {
+		return []string{"-s", src, "-i", "br0", "-m", "mac", "--mac-source", "AA:BB:CC:DD:EE:FF", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "CONNMARK", "--save-mark"}
+	}
+	expectRules(t, l.fake4, "mangle", "MT_grp", [][]string{
+		replyReturn,
+		mark("192.168.1.10/32"), save("192.168.1.10/32"),
+		mark("10.0.0.0/8"), save("10.0.0.0/8"),
+	})
+	for _, rule := range l.fake4.GetRules("nat", "MT_grp") {
+		for _, arg := range rule {
+			if arg == "-i" || arg == "mac" {
+				t.Errorf("nat rule must not match input interface or mac: %v", rule)
+			}
+		}
+	}
+
+	// IPv6-трафик группы с одними IPv4-источниками не маршрутизируется
+	l.insert6()
+	expectRules(t, l.fake6, "mangle", "MT_grp", [][]string{replyReturn})
+}
+
+func TestIPSetToLinkOutputRules(t *testing.T) {
+	l := newTestLink(t, "nwg0", IPSetToLinkOptions{
+		SrcAddresses: []string{"192.168.1.10"},
+		RouteOutput:  true,
+	})
+	l.nh.OutputBypass = []OutputBypass{
+		{Prefix: netip.MustParsePrefix("127.0.0.1/32"), Protocol: "udp", Port: 53},
+		{Prefix: netip.MustParsePrefix("203.0.113.7/32")},
+		{Prefix: netip.MustParsePrefix("2001:db8::7/128")},
+	}
+	l.insert4()
+
+	expectRules(t, l.fake4, "mangle", "MT_grp_OUT", [][]string{
+		replyReturn,
+		{"-o", "lo", "-j", "RETURN"},
+		{"-m", "mark", "!", "--mark", "0", "-j", "RETURN"},
+		{"-d", "127.0.0.1/32", "-p", "udp", "-m", "udp", "--dport", "53", "-j", "RETURN"},
+		{"-d", "203.0.113.7/32", "-j", "RETURN"},
+		{"-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "MARK", "--set-mark", "7"},
+		{"-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "CONNMARK", "--save-mark"},
+	})
+	expectRules(t, l.fake4, "mangle", "OUTPUT", [][]string{{"-j", "MT_grp_OUT"}})
+	expectRules(t, l.fake4, "nat", "MT_grp", [][]string{
+		{"-s", "192.168.1.10/32", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "MASQUERADE"},
+		{"-m", "mark", "--mark", "7", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "MASQUERADE"},
+	})
+
+	l.insert6()
+	if got := l.fake6.GetRules("mangle", "MT_grp_OUT"); len(got) != 6 || !reflect.DeepEqual(got[3], []string{"-d", "2001:db8::7/128", "-j", "RETURN"}) {
+		t.Errorf("unexpected ipv6 output rules: %v", got)
+	}
+
+	l.delete4()
+	if got := l.fake4.GetRules("mangle", "OUTPUT"); len(got) != 0 {
+		t.Errorf("OUTPUT jump was not removed: %v", got)
+	}
+}
+
+func TestIPSetToLinkOutputTransportBypass(t *testing.T) {
+	l := newTestLink(t, "nwg0", IPSetToLinkOptions{
+		RouteOutput: true,
+		Balance:     []string{"nwg1", "ppp0"},
+	})
+	endpoints := map[string][]netip.Addr{
+		"nwg0": {netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("2001:db8::1")},
+		"nwg1": {netip.MustParseAddr("198.51.100.2")},
+	}
+	l.nh.linkEndpoints = func(ifaceName string) ([]netip.Addr, error) {
+		if addrs, ok := endpoints[ifaceName]; ok {
+			return addrs, nil
+		}
+		return nil, errors.New("link not found")
+	}
+	l.link.members = []*linkTarget{
+		{nh: l.nh, ifaceName: "nwg1", mark: 8, table: 8},
+		{nh: l.nh, ifaceName: "ppp0", mark: 9, table: 9},
+	}
+	l.insert4()
+
+	// Транспорт каждого интерфейса группы пропускается до маркировки
+	expectRules(t, l.fake4, "mangle", "MT_grp_OUT", [][]string{
+		replyReturn,
+		{"-o", "lo", "-j", "RETURN"},
+		{"-m", "mark", "!", "--mark", "0", "-j", "RETURN"},
+		{"-d", "198.51.100.1/32", "-j", "RETURN"},
+		{"-d", "198.51.100.2/32", "-j", "RETURN"},
+		{"-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "MT_grp_LB"},
+	})
+}
+
+func TestIPSetToLinkMSSClampRules(t *testing.T) {
+	l := newTestLink(t, "nwg0", IPSetToLinkOptions{
+		Protocols: []string{"tcp", "udp"},
+		DstPorts:  []string{"443"},
+		MSSClamp:  &MSSClamp{},
+	})
+	l.insert4()
+
+	expectRules(t, l.fake4, "filter", "MT_grp", [][]string{
+		{"-p", "tcp", "-m", "tcp", "--dport", "443", "-o", "nwg0", "--tcp-flags", "SYN,RST", "SYN", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "TCPMSS", "--clamp-mss-to-pmtu"},
+		{"-p", "tcp", "-m", "tcp", "--dport", "443", "-o", "nwg0", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "ACCEPT"},
+		{"-p", "udp", "-m", "udp", "--dport", "443", "-o", "nwg0", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "ACCEPT"},
+	})
+
+	l.link.opts.MSSClamp.Size = 1360
+	l.insert6()
+	got := l.fake6.GetRules("filter", "MT_grp")
+	want := []string{"-p", "tcp", "-m", "tcp", "--dport", "443", "-o", "nwg0", "--tcp-flags", "SYN,RST", "SYN", "-m", "set", "--match-set", "mt_grp_6", "dst", "-j", "TCPMSS", "--set-mss", "1360"}
+	if len(got) == 0 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("ipv6 clamp rule mismatch.\nExpected: %v\nGot: %v", want, got)
+	}
+
+	// Без TCP ограничивать нечего
+	l.link.opts.Protocols = []string{"udp"}
+	if rules := l.link.mssClampRules(iptables.ProtocolIPv4, "FORWARD", "mt_grp_4"); len(rules) != 0 {
+		t.Errorf("unexpected clamp rules for udp group: %v", rules)
+	}
+}
+
+func TestIPSetToLinkOutputMSSClampRules(t *testing.T) {
+	l := newTestLink(t, "nwg0", IPSetToLinkOptions{
+		Protocols:   []string{"tcp"},
+		MSSClamp:    &MSSClamp{},
+		RouteOutput: true,
+	})
+	l.insert4()
+
+	// Трафик роутера ограничивается после перемаршрутизации в туннель
+	expectRules(t, l.fake4, "filter", "MT_grp_OUT", [][]string{
+		{"-p", "tcp", "-o", "nwg0", "--tcp-flags", "SYN,RST", "SYN", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "TCPMSS", "--clamp-mss-to-pmtu"},
+	})
+	if got := l.fake4.GetRules("filter", "OUTPUT"); !slices.ContainsFunc(got, func(rule []string) bool {
+		return reflect.DeepEqual(rule, []string{"-j", "MT_grp_OUT"})
+	}) {
+		t.Errorf("filter OUTPUT has no jump to MT_grp_OUT: %v", got)
+	}
+
+	// Без RouteOutput трафик роутера группой не маршрутизируется
+	l = newTestLink(t, "nwg0", IPSetToLinkOptions{
+		Protocols: []string{"tcp"},
+		MSSClamp:  &MSSClamp{},
+	})
+	l.insert4()
+	if got := l.fake4.GetRules("filter", "MT_grp_OUT"); len(got) != 0 {
+		t.Errorf("unexpected filter output rules without RouteOutput: %v", got)
+	}
+}
+
+func TestIPSetToLinkNATRules(t *testing.T) {
+	l := newTestLink(t, "nwg0", IPSetToLinkOptions{
+		NAT: NAT{SNAT4: netip.MustParseAddr("192.0.2.1")},
+	})
+	l.insert4()
+	l.insert6()
+
+	expectRules(t, l.fake4, "nat", "MT_grp", [][]string{{"-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "SNAT", "--to-source", "192.0.2.1"}})
+	// Семейство без адреса маскарадится
+	expectRules(t, l.fake6, "nat", "MT_grp", [][]string{{"-m", "set", "--match-set", "mt_grp_6", "dst", "-j", "MASQUERADE"}})
+
+	l.link.opts.NAT = NAT{Disabled: true}
+	l.insert4()
+	if got := l.fake4.GetRules("nat", "MT_grp"); len(got) != 0 {
+		t.Errorf("unexpected nat rules without translation: %v", got)
+	}
+	if got := l.fake4.GetRules("mangle", "MT_grp"); len(got) == 0 {
+		t.Errorf("marking rules must be kept without translation")
+	}
+}
+
+func TestIPSetToLinkMarkMaskRules(t *testing.T) {
+	l := newTestLink(t, "nwg0", IPSetToLinkOptions{})
+	l.nh.MarkMask = 0xff0000
+	l.link.mark = 0x10000
+	l.insert4()
+
+	expectRules(t, l.fake4, "mangle", "MT_grp", [][]string{
+		replyReturn,
+		{"-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "MARK", "--set-mark", "0x10000/0xff0000"},
+		{"-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "CONNMARK", "--save-mark", "--nfmask", "0xff0000", "--ctmask", "0xff0000"},
+	})
+}
+
+func TestIPSetToLinkBalanceRules(t *testing.T) {
+	l := newTestLink(t, "nwg0", IPSetToLinkOptions{
+		Balance: []string{"nwg1", "nwg2"},
+		Weights: map[string]uint32{"nwg0": 2},
+	})
+	l.link.members = []*linkTarget{
+		{nh: l.nh, ifaceName: "nwg1", mark: 8, table: 8},
+		{nh: l.nh, ifaceName: "nwg2", mark: 9, table: 9},
+	}
+	l.insert4()
+
+	expectRules(t, l.fake4, "mangle", "MT_grp", [][]string{
+		replyReturn,
+		{"-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "MT_grp_LB"},
+	})
+
+	sticky := func(mark string) [][]string {
+		return [][]string{
+			{"-m", "connmark", "--mark", mark, "-j", "MARK", "--set-mark", mark},
+			{"-m", "connmark", "--mark", mark, "-j", "RETURN"},
+		}
+	}
+	var expectedLB [][]string
+	expectedLB = append(expectedLB, sticky("7")...)
+	expectedLB = append(expectedLB, sticky("8")...)
+	expectedLB = append(expectedLB, sticky("9")...)
+	expectedLB = append(expectedLB, []string{"-m", "statistic", "--mode", "random", "--probability", "0.50000", "-j", "CONNMARK", "--set-mark", "7"})
+	expectedLB = append(expectedLB, sticky("7")...)
+	expectedLB = append(expectedLB, []string{"-m", "statistic", "--mode", "random", "--probability", "0.50000", "-j", "CONNMARK", "--set-mark", "8"})
+	expectedLB = append(expectedLB, sticky("8")...)
+	expectedLB = append(expectedLB,
+		[]string{"-j", "CONNMARK", "--set-mark", "9"},
+		[]string{"-j", "MARK", "--set-mark", "9"},
+	)
+	expectRules(t, l.fake4, "mangle", "MT_grp_LB", expectedLB)
+
+	// Неактивный интерфейс не получает новых соединений
+	l.link.inactive = map[string]struct{}{"nwg1": {}}
+	rules := l.link.balanceRules()
+	last := rules[len(rules)-1]
+	if !reflect.DeepEqual(last, []string{"-j", "MARK", "--set-mark", "9"}) {
+		t.Errorf("unexpected last rule %v", last)
+	}
+	for _, rule := range rules {
+		for _, arg := range rule {
+			if arg == "8" {
+				t.Fatalf("inactive interface mark in rule %v", rule)
+			}
+		}
+	}
+}
+
+func TestIPSetToLinkGateway(t *testing.T) {
+	nh, _, _ := newTestHelper(t)
+	link := nh.IPSetToLink("grp", "br0", nh.IPSet("grp", IPSetOptions{}), IPSetToLinkOptions{
+		Gateways: []string{"192.168.1.2", "fe80::2"},
+	})
+
+	if gw := link.gateway(nl.FAMILY_V4); !gw.Equal(net.ParseIP("192.168.1.2")) {
+		t.Errorf("ipv4 gateway = %v", gw)
+	}
+	if gw := link.gateway(nl.FAMILY_V6); !gw.Equal(net.ParseIP("fe80::2")) {
+		t.Errorf("ipv6 gateway = %v", gw)
+	}
+
+	// Шлюз относится к основному интерфейсу и не используется после переключения
+	link.ifaceName = "nwg0"
+	if gw := link.gateway(nl.FAMILY_V4); gw != nil {
+		t.Errorf("gateway after switch = %v, want none", gw)
+	}
+}
+
+func TestIPSetToLinkProxyRules(t *testing.T) {
+	l := newTestLink(t, "tproxy:12345", IPSetToLinkOptions{
+		Proxy: &ProxyTarget{Mode: ProxyTProxy, Port: 12345},
+	})
+	l.insert4()
+
+	expectRules(t, l.fake4, "mangle", "MT_grp", [][]string{
+		replyReturn,
+		{"-p", "tcp", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "TPROXY", "--on-port", "12345", "--tproxy-mark", "7"},
+		{"-p", "udp", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "TPROXY", "--on-port", "12345", "--tproxy-mark", "7"},
+	})
+	if l.fake4.ChainExists("filter", "MT_grp") || l.fake4.ChainExists("nat", "MT_grp") {
+		t.Error("tproxy group must not create forward or nat chains")
+	}
+
+	l = newTestLink(t, "redirect:12345", IPSetToLinkOptions{
+		Proxy:    &ProxyTarget{Mode: ProxyRedirect, Port: 12345},
+		DstPorts: []string{"80"},
+	})
+	l.insert4()
+
+	expectRules(t, l.fake4, "nat", "MT_grp_RDR", [][]string{
+		{"-p", "tcp", "-m", "tcp", "--dport", "80", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "REDIRECT", "--to-ports", "12345"},
+		{"-p", "udp", "-m", "udp", "--dport", "80", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "REDIRECT", "--to-ports", "12345"},
+	})
+	expectRules(t, l.fake4, "nat", "PREROUTING", [][]string{{"-j", "MT_grp_RDR"}})
+	if l.fake4.ChainExists("mangle", "MT_grp") {
+		t.Error("redirect group must not mark traffic")
+	}
+
+	l.delete4()
+	if l.fake4.ChainExists("nat", "MT_grp_RDR") {
+		t.Error("redirect chain must be deleted")
+	}
+}
+
+func TestIPSetToLinkTagRules(t *testing.T) {
+	l := newTestLink(t, "mark:0x10/0xff", IPSetToLinkOptions{
+		Protocols: []string{"udp"},
+		Tag:       &TagTarget{Mode: TagMark, Mark: 0x10, Mask: 0xff},
+	})
+	l.nh.MarkMask = 0xff0000
+	l.insert4()
+
+	expectRules(t, l.fake4, "mangle", "MT_grp", [][]string{
+		replyReturn,
+		{"-p", "udp", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "MARK", "--set-mark", "0x10/0xff"},
+		{"-p", "udp", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "CONNMARK", "--save-mark", "--nfmask", "0xff", "--ctmask", "0xff"},
+	})
+	if l.fake4.ChainExists("filter", "MT_grp") || l.fake4.ChainExists("nat", "MT_grp") {
+		t.Error("tag group must not create forward or nat chains")
+	}
+	if l.link.routed() || l.link.routing() != nil {
+		t.Error("tag group must not use routing")
+	}
+	if err := l.link.checkTagMark(); err != nil {
+		t.Errorf("checkTagMark() = %v, want nil", err)
+	}
+	l.link.opts.Tag.Mask = 0x1ff0000
+	if err := l.link.checkTagMark(); !errors.Is(err, ErrTagMarkConflict) {
+		t.Errorf("checkTagMark() = %v, want ErrTagMarkConflict", err)
+	}
+
+	l.link.opts.Tag = &TagTarget{Mode: TagDSCP, DSCP: 46}
+	if got := l.link.tagActions(); !reflect.DeepEqual(got, [][]string{{"-j", "DSCP", "--set-dscp", "0x2e"}}) {
+		t.Errorf("dscp actions = %v", got)
+	}
+}
+
+func TestIPSetToLinkRejectRules(t *testing.T) {
+	l := newTestLink(t, Reject, IPSetToLinkOptions{
+		RouteOutput: true,
+	})
+	l.insert4()
+	l.insert6()
+
+	expected := [][]string{
+		{"-p", "tcp", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "REJECT", "--reject-with", "tcp-reset"},
+		{"-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "REJECT", "--reject-with", "icmp-port-unreachable"},
+	}
+	expectRules(t, l.fake4, "filter", "MT_grp", expected)
+	expectRules(t, l.fake4, "filter", "MT_grp_OUT", expected)
+	if got := l.fake6.GetRules("filter", "MT_grp"); len(got) != 2 || got[1][len(got[1])-1] != "icmp6-port-unreachable" {
+		t.Errorf("ipv6 filter rules = %v", got)
+	}
+	expectRules(t, l.fake4, "filter", "FORWARD", [][]string{{"-j", "MT_grp"}})
+	if l.fake4.ChainExists("mangle", "MT_grp") || l.fake4.ChainExists("nat", "MT_grp") {
+		t.Error("reject group must not create mangle or nat chains")
+	}
+	if l.link.routed() || l.link.routing() != nil {
+		t.Error("reject group must not use routing")
+	}
+
+	l.link.opts.Protocols = []string{"udp", "icmp"}
+	expected = [][]string{
+		{"-p", "udp", "-m", "set", "--match-set", "mt_grp_6", "dst", "-j", "REJECT", "--reject-with", "icmp6-port-unreachable"},
+		{"-p", "ipv6-icmp", "-m", "set", "--match-set", "mt_grp_6", "dst", "-j", "REJECT", "--reject-with", "icmp6-port-unreachable"},
+	}
+	if got := l.link.rejectRules(iptables.ProtocolIPv6, "FORWARD", "mt_grp_6"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("rejectRules() = %v, want %v", got, expected)
+	}
+
+	l.delete4()
+	if l.fake4.ChainExists("filter", "MT_grp_OUT") || len(l.fake4.GetRules("filter", "OUTPUT")) != 0 {
+		t.Error("output chain must be removed")
+	}
+}
+
+// newTestPlan возвращает помощника DryRun для IPv4 поверх newTestHelper
+func newTestPlan(t *testing.T, setup func(nh *Helper)) (*Helper, *Helper, *iptables.FakeIPTables) {
+	t.Helper()
+	nh, fake4, _ := newTestHelper(t)
+	nh.DisableIPv6, nh.IPTables6 = true, nil
+	if setup != nil {
+		setup(nh)
+	}
+	plan := nh.DryRun()
+	registerTestChains(t, plan.IPTables4)
+	return nh, plan, fake4
+}
+
+// planScript строит правила группы на помощнике плана и возвращает скрипт iptables-restore
+func planScript(t *testing.T, plan *Helper, link *IPSetToLink) string {
+	t.Helper()
+	if err := plan.getBackend().insertLinkRules(link); err != nil {
+		t.Fatalf("insertLinkRules failed: %v", err)
+	}
+	script, err := plan.IPTables4.Script()
+	if err != nil {
+		t.Fatalf("Script failed: %v", err)
+	}
+	return string(script)
+}
+
+func TestIPSetToLinkDryRun(t *testing.T) {
+	nh, plan, fake4 := newTestPlan(t, nil)
+	link := plan.IPSetToLink("grp", "nwg0", plan.IPSet("grp", IPSetOptions{}), IPSetToLinkOptions{})
+	link.mark, link.table = 7, 7
+
+	if script := planScript(t, plan, link); !strings.Contains(script, "-A MT_grp -m set --match-set mt_grp_4 dst -j MARK --set-mark 7") {
+		t.Errorf("script does not contain group rules:\n%s", script)
+	}
+	if fake4.ChainExists("mangle", "MT_grp") {
+		t.Error("dry run must not change iptables")
+	}
+	if _, err := nh.IPTables4.Script(); err == nil {
+		t.Error("Script must fail for iptables that is not a dry run")
+	}
+
+	expected := []string{
+		"rule -4 fwmark 0x7 lookup 7",
+		"route -4 blackhole default metric 20 table 7",
+		"route -4 default dev nwg0 metric 10 table 7",
+	}
+	if got := link.routing(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("routing mismatch.\nExpected: %v\nGot: %v", expected, got)
+	}
+}
+
+func TestIPSetToLinkDryRunOutputTransportBypass(t *testing.T) {
+	_, plan, _ := newTestPlan(t, func(nh *Helper) {
+		nh.linkEndpoints = func(string) ([]netip.Addr, error) {
+			return []netip.Addr{netip.MustParseAddr("198.51.100.1")}, nil
+		}
+	})
+	link := plan.IPSetToLink("grp", "nwg0", plan.IPSet("grp", IPSetOptions{}), IPSetToLinkOptions{RouteOutput: true})
+	link.mark, link.table = 7, 7
+
+	// План пропускает транспорт интерфейса так же, как применение
+	if script := planScript(t, plan, link); !strings.Contains(script, "-A MT_grp_OUT -d 198.51.100.1/32 -j RETURN") {
+		t.Errorf("script does not contain transport bypass:\n%s", script)
+	}
+}
+
+func TestIPSetToLinkCounterRules(t *testing.T) {
+	l := newTestLink(t, "tproxy:12345", IPSetToLinkOptions{
+		Proxy:        &ProxyTarget{Mode: ProxyTProxy, Port: 12345},
+		Protocols:    []string{"tcp"},
+		SrcAddresses: []string{"192.168.1.10"},
+	})
+	l.insert4()
+	l.insert6()
+	tx := []string{"-s", "192.168.1.10/32", "-p", "tcp", "-m", "conntrack", "--ctdir", "ORIGINAL", "-m", "set", "--match-set", "mt_grp_4", "dst", "-j", "RETURN"}
+	rx := []string{"-m", "conntrack", "--ctdir", "REPLY", "-d", "192.168.1.10/32", "-m", "set", "--match-set", "mt_grp_4", "src", "-j", "RETURN"}
+	expectRules(t, l.fake4, "mangle", "MT_grp_CNT", [][]string{tx, rx})
+	// Счётчики стоят раньше цепочки с TPROXY, который завершает обход таблицы
+	expectRules(t, l.fake4, "mangle", "PREROUTING", [][]string{{"-j", "MT_grp_CNT"}, {"-j", "MT_grp"}})
+	if got := l.fake6.GetRules("mangle", "MT_grp_CNT"); len(got) != 0 {
+		t.Errorf("ipv6 group without ipv6 sources must not count traffic, got %v", got)
+	}
+
+	l.fake4.SetCounter("mangle", "MT_grp_CNT", tx, iptables.Counter{Packets: 10, Bytes: 1000})
+	l.fake4.SetCounter("mangle", "MT_grp_CNT", rx, iptables.Counter{Packets: 20, Bytes: 20000})
+	l.link.enabled.Store(true)
+	counters, err := l.link.Counters()
+	if err != nil {
+		t.Fatalf("Counters failed: %v", err)
+	}
+	expectedCounters := []TrafficCounter{
+		{Family: 4, Tx: Traffic{Packets: 10, Bytes: 1000}, Rx: Traffic{Packets: 20, Bytes: 20000}},
+		{Family: 6},
+	}
+	if !reflect.DeepEqual(counters, expectedCounters) {
+		t.Errorf("counters mismatch.\nExpected: %+v\nGot: %+v", expectedCounters, counters)
+	}
+
+	l.delete4()
+	if l.fake4.ChainExists("mangle", "MT_grp_CNT") {
+		t.Error("counter chain must be deleted")
+	}
+}
@@ -4,13 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"os"
+	"net/netip"
 	"sync"
 	"sync/atomic"
 
-	"github.com/vishvananda/netlink"
-	"github.com/vishvananda/netlink/nl"
-	"golang.org/x/sys/unix"
+	"github.com/rs/zerolog/log"
 )
 
 type IPv4Subnet struct {
@@ -26,6 +24,15 @@ func (subnet IPv4Subnet) String() string {
 	}
 }
 
+// Prefix возвращает подсеть как префикс; CIDR 0 означает один адрес
+func (subnet IPv4Subnet) Prefix() netip.Prefix {
+	bits := int(subnet.CIDR)
+	if bits == 0 {
+		bits = 32
+	}
+	return netip.PrefixFrom(netip.AddrFrom4(subnet.Address), bits)
+}
+
 type IPv6Subnet struct {
 	Address [16]byte
 	CIDR    uint8
@@ -39,15 +46,78 @@ func (subnet IPv6Subnet) String() string {
 	}
 }
 
+// Prefix возвращает подсеть как префикс; CIDR 0 означает один адрес
+func (subnet IPv6Subnet) Prefix() netip.Prefix {
+	bits := int(subnet.CIDR)
+	if bits == 0 {
+		bits = 128
+	}
+	return netip.PrefixFrom(netip.AddrFrom16(subnet.Address), bits)
+}
+
 type IPSetTimeout *uint32
 
 var zeroTimeout = IPSetTimeout(new(uint32))
 
+// Размеры наборов по умолчанию (совпадают со значениями ipset)
+const (
+	DefaultIPSetMaxElem  = 65536
+	DefaultIPSetHashSize = 1024
+	DefaultIPSetTimeout  = 300
+	// MaxIPSetMaxElem – предел автоматического увеличения набора
+	MaxIPSetMaxElem = 1 << 24
+	// IPSetUsageThreshold – доля заполнения, после которой набор увеличивается вдвое
+	IPSetUsageThreshold = 0.9
+	// IPSetBatchSize – число элементов в одном запросе при массовом изменении набора
+	IPSetBatchSize = 512
+)
+
+var ErrIPSetFull = errors.New("ipset is full")
+
+// IPSetOptions – размеры набора; нулевые значения означают значения по умолчанию
+type IPSetOptions struct {
+	MaxElem  uint32
+	HashSize uint32
+	// Timeout – время жизни элемента по умолчанию в секундах
+	Timeout uint32
+}
+
+func (o IPSetOptions) withDefaults() IPSetOptions {
+	if o.MaxElem == 0 {
+		o.MaxElem = DefaultIPSetMaxElem
+	}
+	if o.HashSize == 0 {
+		o.HashSize = DefaultIPSetHashSize
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DefaultIPSetTimeout
+	}
+	return o
+}
+
+// IPSetUsage – заполненность набора одного семейства
+type IPSetUsage struct {
+	// Family – 4 или 6
+	Family  int
+	Entries uint32
+	// MaxElem – ёмкость набора; 0 – набор не ограничен (nftables)
+	MaxElem uint32
+	// Resized – набор был увеличен при последней проверке
+	Resized bool
+}
+
+// NearFull сообщает, что набор заполнен не меньше чем на IPSetUsageThreshold
+func (u IPSetUsage) NearFull() bool {
+	return u.MaxElem > 0 && float64(u.Entries) >= float64(u.MaxElem)*IPSetUsageThreshold
+}
+
 type IPSet struct {
 	enabled atomic.Bool
 	locker  sync.Mutex
 
 	ipsetName string
+	opts      IPSetOptions
+	nh        *Helper
 }
 
 func (r *IPSet) AddIPv4Subnet(subnet IPv4Subnet, timeout IPSetTimeout) error {
@@ -58,46 +128,88 @@ func (r *IPSet) AddIPv4Subnet(subnet IPv4Subnet, timeout IPSetTimeout) error {
 		return nil
 	}
 
-	if timeout == nil {
-		timeout = zeroTimeout
+	return r.add(subnet.Address[:], subnet.CIDR, timeout)
+}
+
+func (r *IPSet) AddIPv6Subnet(subnet IPv6Subnet, timeout IPSetTimeout) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if !r.enabled.Load() {
+		return nil
 	}
 
-	err := netlink.IpsetAdd(r.ipsetName+"_4", &netlink.IPSetEntry{
-		IP:      subnet.Address[:],
-		CIDR:    subnet.CIDR,
-		Timeout: timeout,
-		Replace: true,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to add address: %w", err)
+	return r.add(subnet.Address[:], subnet.CIDR, timeout)
+}
+
+// add добавляет элемент; если набор переполнен, увеличивает его и повторяет попытку
+func (r *IPSet) add(ip []byte, cidr uint8, timeout IPSetTimeout) error {
+	err := r.nh.getBackend().addToSet(r.ipsetName, ip, cidr, timeout)
+	if !errors.Is(err, ErrIPSetFull) {
+		return err
+	}
+	if growErr := r.grow(len(ip)); growErr != nil {
+		return errors.Join(err, growErr)
+	}
+	return r.nh.getBackend().addToSet(r.ipsetName, ip, cidr, timeout)
+}
+
+// grow вдвое увеличивает ёмкость набора семейства, определяемого длиной адреса ipLen
+func (r *IPSet) grow(ipLen int) error {
+	opts := r.opts.withDefaults()
+	maxElem := min(uint64(opts.MaxElem)*2, MaxIPSetMaxElem)
+	if maxElem <= uint64(opts.MaxElem) {
+		return fmt.Errorf("%w: %s reached the limit of %d elements", ErrIPSetFull, r.ipsetName, opts.MaxElem)
 	}
+	opts.MaxElem = uint32(maxElem)
 
+	if err := r.nh.getBackend().resizeSet(r.ipsetName, ipLen, opts); err != nil {
+		return fmt.Errorf("failed to resize ipset: %w", err)
+	}
+	r.opts.MaxElem = opts.MaxElem
+	log.Warn().
+		Str("ipset", ipsetFamilyName(r.ipsetName, ipLen)).
+		Uint32("maxelem", opts.MaxElem).
+		Msg("ipset resized")
 	return nil
 }
 
-func (r *IPSet) AddIPv6Subnet(subnet IPv6Subnet, timeout IPSetTimeout) error {
+// CheckUsage проверяет заполненность наборов и увеличивает почти заполненные
+func (r *IPSet) CheckUsage() ([]IPSetUsage, error) {
 	r.locker.Lock()
 	defer r.locker.Unlock()
 
 	if !r.enabled.Load() {
-		return nil
-	}
-
-	if timeout == nil {
-		timeout = zeroTimeout
+		return nil, nil
 	}
 
-	err := netlink.IpsetAdd(r.ipsetName+"_6", &netlink.IPSetEntry{
-		IP:      subnet.Address[:],
-		CIDR:    subnet.CIDR,
-		Timeout: timeout,
-		Replace: true,
-	})
+	usage, err := r.nh.getBackend().setUsage(r.ipsetName, r.opts.withDefaults())
 	if err != nil {
-		return fmt.Errorf("failed to add address: %w", err)
+		return nil, fmt.Errorf("failed to get ipset usage: %w", err)
 	}
 
-	return nil
+	var errs []error
+	for i, u := range usage {
+		if !u.NearFull() {
+			continue
+		}
+		ipLen := net.IPv4len
+		if u.Family == 6 {
+			ipLen = net.IPv6len
+		}
+		log.Error().
+			Str("ipset", ipsetFamilyName(r.ipsetName, ipLen)).
+			Uint32("entries", u.Entries).
+			Uint32("maxelem", u.MaxElem).
+			Msg("ipset is nearly full")
+		if err := r.grow(ipLen); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		usage[i].MaxElem = r.opts.MaxElem
+		usage[i].Resized = true
+	}
+	return usage, errors.Join(errs...)
 }
 
 func (r *IPSet) DelIPv4Subnet(subnet IPv4Subnet) error {
@@ -108,18 +220,78 @@ func (r *IPSet) DelIPv4Subnet(subnet IPv4Subnet) error {
 		return nil
 	}
 
-	err := netlink.IpsetDel(r.ipsetName+"_4", &netlink.IPSetEntry{
-		IP:   subnet.Address[:],
-		CIDR: subnet.CIDR,
-	})
-	if err != nil && !errors.Is(err, nl.IPSetError(nl.IPSET_ERR_EXIST)) {
-		return fmt.Errorf("failed to delete address: %w", err)
+	return r.nh.getBackend().delFromSet(r.ipsetName, subnet.Address[:], subnet.CIDR)
+}
+
+func (r *IPSet) DelIPv6Subnet(subnet IPv6Subnet) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if !r.enabled.Load() {
+		return nil
 	}
 
-	return nil
+	return r.nh.getBackend().delFromSet(r.ipsetName, subnet.Address[:], subnet.CIDR)
 }
 
-func (r *IPSet) DelIPv6Subnet(subnet IPv6Subnet) error {
+// BulkProgress получает число уже обработанных элементов
+type BulkProgress func(done int)
+
+// AddIPv4Subnets добавляет подсети пачками по IPSetBatchSize. Блокировка набора
+// удерживается только на время одной пачки, поэтому одиночные изменения не ждут
+// окончания загрузки.
+func (r *IPSet) AddIPv4Subnets(subnets map[IPv4Subnet]IPSetTimeout, progress BulkProgress) error {
+	entries := make([]setEntry, 0, len(subnets))
+	for subnet, timeout := range subnets {
+		entries = append(entries, setEntry{IP: subnet.Address[:], CIDR: subnet.CIDR, Timeout: timeout})
+	}
+	return r.bulk(entries, true, progress)
+}
+
+// AddIPv6Subnets – AddIPv4Subnets для IPv6
+func (r *IPSet) AddIPv6Subnets(subnets map[IPv6Subnet]IPSetTimeout, progress BulkProgress) error {
+	entries := make([]setEntry, 0, len(subnets))
+	for subnet, timeout := range subnets {
+		entries = append(entries, setEntry{IP: subnet.Address[:], CIDR: subnet.CIDR, Timeout: timeout})
+	}
+	return r.bulk(entries, true, progress)
+}
+
+// DelIPv4Subnets удаляет подсети пачками по IPSetBatchSize
+func (r *IPSet) DelIPv4Subnets(subnets []IPv4Subnet, progress BulkProgress) error {
+	entries := make([]setEntry, len(subnets))
+	for i, subnet := range subnets {
+		entries[i] = setEntry{IP: subnet.Address[:], CIDR: subnet.CIDR}
+	}
+	return r.bulk(entries, false, progress)
+}
+
+// DelIPv6Subnets удаляет подсети пачками по IPSetBatchSize
+func (r *IPSet) DelIPv6Subnets(subnets []IPv6Subnet, progress BulkProgress) error {
+	entries := make([]setEntry, len(subnets))
+	for i, subnet := range subnets {
+		entries[i] = setEntry{IP: subnet.Address[:], CIDR: subnet.CIDR}
+	}
+	return r.bulk(entries, false, progress)
+}
+
+func (r *IPSet) bulk(entries []setEntry, add bool, progress BulkProgress) error {
+	var errs []error
+	for start := 0; start < len(entries); start += IPSetBatchSize {
+		batch := entries[start:min(start+IPSetBatchSize, len(entries))]
+		if err := r.applyBatch(batch, add); err != nil {
+			errs = append(errs, err)
+		}
+		if progress != nil {
+			progress(start + len(batch))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyBatch применяет пачку одним запросом. Если запрос не удался, элементы
+// применяются по одному, чтобы ошибка одного элемента не мешала остальным.
+func (r *IPSet) applyBatch(batch []setEntry, add bool) error {
 	r.locker.Lock()
 	defer r.locker.Unlock()
 
@@ -127,15 +299,35 @@ func (r *IPSet) DelIPv6Subnet(subnet IPv6Subnet) error {
 		return nil
 	}
 
-	err := netlink.IpsetDel(r.ipsetName+"_6", &netlink.IPSetEntry{
-		IP:   subnet.Address[:],
-		CIDR: subnet.CIDR,
-	})
-	if err != nil && !errors.Is(err, nl.IPSetError(nl.IPSET_ERR_EXIST)) {
-		return fmt.Errorf("failed to delete address: %w", err)
+	backend := r.nh.getBackend()
+	var err error
+	if add {
+		err = backend.addToSetBatch(r.ipsetName, batch)
+		if errors.Is(err, ErrIPSetFull) {
+			if growErr := r.grow(len(batch[0].IP)); growErr != nil {
+				return errors.Join(err, growErr)
+			}
+			err = backend.addToSetBatch(r.ipsetName, batch)
+		}
+	} else {
+		err = backend.delFromSetBatch(r.ipsetName, batch)
+	}
+	if err == nil {
+		return nil
 	}
 
-	return nil
+	var errs []error
+	for _, entry := range batch {
+		if add {
+			err = r.add(entry.IP, entry.CIDR, entry.Timeout)
+		} else {
+			err = backend.delFromSet(r.ipsetName, entry.IP, entry.CIDR)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s/%d: %w", net.IP(entry.IP), entry.CIDR, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func (r *IPSet) ListIPv4Subnets() (map[IPv4Subnet]IPSetTimeout, error) {
@@ -146,25 +338,17 @@ func (r *IPSet) ListIPv4Subnets() (map[IPv4Subnet]IPSetTimeout, error) {
 		return nil, nil
 	}
 
-	addresses := make(map[IPv4Subnet]IPSetTimeout)
-
-	list, err := netlink.IpsetList(r.ipsetName + "_4")
+	entries, err := r.nh.getBackend().listSet(r.ipsetName, net.IPv4len)
 	if err != nil {
 		return nil, err
 	}
-	for _, entry := range list.Entries {
-		if len(entry.IP) != net.IPv4len {
-			continue
-		}
-		subnet := IPv4Subnet{
+
+	addresses := make(map[IPv4Subnet]IPSetTimeout, len(entries))
+	for _, entry := range entries {
+		addresses[IPv4Subnet{
 			Address: [4]byte(entry.IP),
 			CIDR:    entry.CIDR,
-		}
-		if entry.Timeout != nil && *entry.Timeout == 0 {
-			addresses[subnet] = nil
-		} else {
-			addresses[subnet] = entry.Timeout
-		}
+		}] = entry.Timeout
 	}
 
 	return addresses, nil
@@ -178,77 +362,37 @@ func (r *IPSet) ListIPv6Subnets() (map[IPv6Subnet]IPSetTimeout, error) {
 		return nil, nil
 	}
 
-	addresses := make(map[IPv6Subnet]IPSetTimeout)
-
-	list, err := netlink.IpsetList(r.ipsetName + "_6")
+	entries, err := r.nh.getBackend().listSet(r.ipsetName, net.IPv6len)
 	if err != nil {
 		return nil, err
 	}
-	for _, entry := range list.Entries {
-		if len(entry.IP) != net.IPv6len {
-			continue
-		}
-		subnet := IPv6Subnet{
+
+	addresses := make(map[IPv6Subnet]IPSetTimeout, len(entries))
+	for _, entry := range entries {
+		addresses[IPv6Subnet{
 			Address: [16]byte(entry.IP),
 			CIDR:    entry.CIDR,
-		}
-		if entry.Timeout != nil && *entry.Timeout == 0 {
-			addresses[subnet] = nil
-		} else {
-			addresses[subnet] = entry.Timeout
-		}
+		}] = entry.Timeout
 	}
 
 	return addresses, nil
 }
 
-func (r *IPSet) ipsetCreate() error {
-	err := netlink.IpsetCreate(r.ipsetName+"_4", "hash:net", netlink.IpsetCreateOptions{
-		Timeout: func(i uint32) *uint32 { return &i }(300),
-		Family:  unix.AF_INET,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create ipset: %w", err)
-	}
-
-	err = netlink.IpsetCreate(r.ipsetName+"_6", "hash:net", netlink.IpsetCreateOptions{
-		Timeout: func(i uint32) *uint32 { return &i }(300),
-		Family:  unix.AF_INET6,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create ipset: %w", err)
-	}
-
-	return nil
-}
-
-func (r *IPSet) ipsetDestroy() error {
-	var errs []error
-	err := netlink.IpsetDestroy(r.ipsetName + "_4")
-	if err != nil && !os.IsNotExist(err) {
-		errs = append(errs, err)
-	}
-	err = netlink.IpsetDestroy(r.ipsetName + "_6")
-	if err != nil && !os.IsNotExist(err) {
-		errs = append(errs, err)
-	}
-	if errs != nil {
-		return fmt.Errorf("failed to destroy ipsets: %w", errors.Join(errs...))
-	}
-	return nil
-}
-
 func (r *IPSet) enable() error {
 	if !r.enabled.CompareAndSwap(false, true) {
 		return nil
 	}
 
-	err := r.ipsetDestroy()
+	if err := r.nh.RequireCapabilities(CapIPSet); err != nil {
+		return err
+	}
+
+	err := r.nh.getBackend().destroySet(r.ipsetName)
 	if err != nil {
 		return err
 	}
 
-	err = r.ipsetCreate()
+	err = r.nh.getBackend().createSet(r.ipsetName, r.opts.withDefaults())
 	if err != nil {
 		return err
 	}
@@ -274,7 +418,7 @@ func (r *IPSet) disable() error {
 	}
 	defer r.enabled.Store(false)
 
-	return r.ipsetDestroy()
+	return r.nh.getBackend().destroySet(r.ipsetName)
 }
 
 func (r *IPSet) Disable() error {
@@ -284,8 +428,10 @@ func (r *IPSet) Disable() error {
 	return r.disable()
 }
 
-func (nh *Helper) IPSet(name string) *IPSet {
+func (nh *Helper) IPSet(name string, opts IPSetOptions) *IPSet {
 	return &IPSet{
 		ipsetName: nh.IpsetPrefix + name,
+		opts:      opts,
+		nh:        nh,
 	}
 }
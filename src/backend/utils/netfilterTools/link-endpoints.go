@@ -0,0 +1,171 @@
+package netfilterTools
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Транспорт интерфейсов: пакеты, которыми туннель сам ходит к удалённой стороне,
+	не должны маркироваться группами этого интерфейса, иначе туннель уйдёт сам в себя.
+	Адрес удалённой стороны ядро знает для туннелей с явным remote (GRE, IPIP, SIT,
+	VTI, VXLAN, Geneve) и для пиров WireGuard в ядре. Транспорт OpenVPN, WireGuard
+	в пространстве пользователя и прочих tun-интерфейсов ядру не известен – его
+	адреса нужно перечислить в netfilter.outputBypass (или задать сокету fwmark).
+*/
+
+const (
+	wgCmdGetDevice   = 0
+	wgDeviceAIfName  = 2
+	wgDeviceAPeers   = 8
+	wgPeerAEndpoint  = 4
+	wgGenlVersion    = 1
+	wgGenlFamilyName = "wireguard"
+)
+
+// linkEndpoints возвращает адреса удалённой стороны транспорта интерфейса ifaceName;
+// пустой список – транспорт ядру не известен
+func linkEndpoints(ifaceName string) ([]netip.Addr, error) {
+	link, err := netlink.LinkByName(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	var remote net.IP
+	switch l := link.(type) {
+	case *netlink.Wireguard:
+		return wireguardEndpoints(ifaceName)
+	case *netlink.Gretun:
+		remote = l.Remote
+	case *netlink.Gretap:
+		remote = l.Remote
+	case *netlink.Iptun:
+		remote = l.Remote
+	case *netlink.Ip6tnl:
+		remote = l.Remote
+	case *netlink.Sittun:
+		remote = l.Remote
+	case *netlink.Vti:
+		remote = l.Remote
+	case *netlink.Geneve:
+		remote = l.Remote
+	case *netlink.Vxlan:
+		// Групповой адрес VXLAN – не удалённая сторона, а рассылка
+		if !l.Group.IsMulticast() {
+			remote = l.Group
+		}
+	}
+	addr, ok := netip.AddrFromSlice(remote)
+	if !ok || addr.Unmap().IsUnspecified() {
+		return nil, nil
+	}
+	return []netip.Addr{addr.Unmap()}, nil
+}
+
+// wireguardEndpoints возвращает адреса пиров WireGuard в ядре через generic netlink
+func wireguardEndpoints(ifaceName string) ([]netip.Addr, error) {
+	family, err := netlink.GenlFamilyGet(wgGenlFamilyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wireguard netlink family: %w", err)
+	}
+
+	req := nl.NewNetlinkRequest(int(family.ID), unix.NLM_F_DUMP)
+	req.AddData(&nl.Genlmsg{Command: wgCmdGetDevice, Version: wgGenlVersion})
+	req.AddData(nl.NewRtAttr(wgDeviceAIfName, nl.ZeroTerminated(ifaceName)))
+	msgs, err := req.Execute(unix.NETLINK_GENERIC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wireguard device: %w", err)
+	}
+	return parseWireguardEndpoints(msgs)
+}
+
+// parseWireguardEndpoints разбирает ответ WG_CMD_GET_DEVICE; пиры устройства
+// с большим числом пиров приходят несколькими сообщениями
+func parseWireguardEndpoints(msgs [][]byte) ([]netip.Addr, error) {
+	var endpoints []netip.Addr
+	for _, msg := range msgs {
+		if len(msg) < nl.SizeofGenlmsg {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(msg[nl.SizeofGenlmsg:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse wireguard device: %w", err)
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type&nl.NLA_TYPE_MASK != wgDeviceAPeers {
+				continue
+			}
+			peers, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse wireguard peers: %w", err)
+			}
+			for _, peer := range peers {
+				peerAttrs, err := nl.ParseRouteAttr(peer.Value)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse wireguard peer: %w", err)
+				}
+				for _, peerAttr := range peerAttrs {
+					if peerAttr.Attr.Type&nl.NLA_TYPE_MASK != wgPeerAEndpoint {
+						continue
+					}
+					if addr, ok := parseSockaddr(peerAttr.Value); ok {
+						endpoints = append(endpoints, addr)
+					}
+				}
+			}
+		}
+	}
+	return endpoints, nil
+}
+
+// parseSockaddr извлекает адрес из sockaddr_in или sockaddr_in6
+func parseSockaddr(b []byte) (netip.Addr, bool) {
+	if len(b) < 2 {
+		return netip.Addr{}, false
+	}
+	switch binary.NativeEndian.Uint16(b) {
+	case unix.AF_INET:
+		if len(b) < 8 {
+			return netip.Addr{}, false
+		}
+		return netip.AddrFrom4([4]byte(b[4:8])), true
+	case unix.AF_INET6:
+		if len(b) < 24 {
+			return netip.Addr{}, false
+		}
+		return netip.AddrFrom16([16]byte(b[8:24])).Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// transportBypass возвращает адреса транспорта интерфейсов группы, которые не маркируются
+// в OUTPUT. Адреса читаются при применении правил: после смены адреса пира WireGuard
+// они обновятся при следующем пересоздании правил группы.
+func (r *IPSetToLink) transportBypass() []netip.Prefix {
+	if r.nh.linkEndpoints == nil {
+		return nil
+	}
+	var prefixes []netip.Prefix
+	for _, target := range r.targets() {
+		endpoints, err := r.nh.linkEndpoints(target.ifaceName)
+		if err != nil {
+			log.Debug().Err(err).Str("iface", target.ifaceName).Msg("failed to get interface transport endpoints")
+			continue
+		}
+		for _, addr := range endpoints {
+			prefix := netip.PrefixFrom(addr, addr.BitLen())
+			if !slices.Contains(prefixes, prefix) {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	return prefixes
+}
@@ -0,0 +1,43 @@
+package netfilterTools
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseWireguardEndpoints(t *testing.T) {
+	sockaddr4 := make([]byte, 16)
+	binary.NativeEndian.PutUint16(sockaddr4, unix.AF_INET)
+	binary.BigEndian.PutUint16(sockaddr4[2:], 51820)
+	copy(sockaddr4[4:], []byte{198, 51, 100, 1})
+
+	sockaddr6 := make([]byte, 28)
+	binary.NativeEndian.PutUint16(sockaddr6, unix.AF_INET6)
+	addr6 := netip.MustParseAddr("2001:db8::1").As16()
+	copy(sockaddr6[8:], addr6[:])
+
+	peers := nl.NewRtAttr(wgDeviceAPeers|unix.NLA_F_NESTED, nil)
+	for i, sockaddr := range [][]byte{sockaddr4, sockaddr6, nil} {
+		peer := peers.AddRtAttr(i|unix.NLA_F_NESTED, nil)
+		peer.AddRtAttr(1, make([]byte, 32))
+		if sockaddr != nil {
+			peer.AddRtAttr(wgPeerAEndpoint, sockaddr)
+		}
+	}
+	msg := append([]byte{wgCmdGetDevice, wgGenlVersion, 0, 0}, nl.NewRtAttr(wgDeviceAIfName, nl.ZeroTerminated("wg0")).Serialize()...)
+	msg = append(msg, peers.Serialize()...)
+
+	got, err := parseWireguardEndpoints([][]byte{msg})
+	if err != nil {
+		t.Fatalf("parseWireguardEndpoints failed: %v", err)
+	}
+	expected := []netip.Addr{netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("2001:db8::1")}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("endpoints mismatch.\nExpected: %v\nGot: %v", expected, got)
+	}
+}
@@ -1,6 +1,10 @@
 package netfilterTools
 
 import (
+	"errors"
+	"fmt"
+	"net/netip"
+
 	"magitrickle/utils/iptables"
 )
 
@@ -10,25 +14,103 @@ type Helper struct {
 	IPTables4   *iptables.IPTables
 	IPTables6   *iptables.IPTables
 
+	DisableIPv4 bool
+	DisableIPv6 bool
+
 	StartIdx uint32
-}
+	// MarkMask – биты метки пакета, которые использует приложение (0 – все);
+	// остальные биты остаются прошивке
+	MarkMask uint32
+	// Allocations – закреплённые за группами метки и таблицы; nil – без закрепления
+	Allocations *Allocations
 
-func New(chainPrefix, ipsetPrefix string, disableIPv4, disableIPv6 bool, startIdx uint32) (*Helper, error) {
-	var ipt4, ipt6 *iptables.IPTables
+	// OutputBypass – трафик роутера, который никогда не маркируется в mangle OUTPUT
+	// (upstream DNS-прокси, транспорт VPN-туннелей); защищает от петель маршрутизации
+	OutputBypass []OutputBypass
 
-	if !disableIPv4 {
-		ipt4 = iptables.NewIPTables(iptables.NewRealIPTables())
-	}
+	// linkEndpoints возвращает адреса транспорта интерфейса, которые группы этого
+	// интерфейса пропускают в OUTPUT автоматически; nil – не определяются
+	linkEndpoints func(ifaceName string) ([]netip.Addr, error)
 
-	if !disableIPv6 {
-		ipt6 = iptables.NewIPTables(iptables.NewRealIP6Tables())
-	}
+	backend   backend
+	conntrack *ConntrackFlusher
+	// capabilities – результаты Preflight; nil, если проверка не выполнялась
+	capabilities *Capabilities
+}
+
+// OutputBypass описывает исключение из маршрутизации трафика самого роутера.
+// Пустые Protocol и Port означают любой трафик до Prefix.
+type OutputBypass struct {
+	Prefix   netip.Prefix
+	Protocol string
+	Port     uint16
+}
 
-	return &Helper{
+func New(backendName, chainPrefix, ipsetPrefix string, disableIPv4, disableIPv6 bool, startIdx, markMask uint32) (*Helper, error) {
+	nh := &Helper{
 		ChainPrefix: chainPrefix,
 		IpsetPrefix: ipsetPrefix,
-		IPTables4:   ipt4,
-		IPTables6:   ipt6,
+		DisableIPv4: disableIPv4,
+		DisableIPv6: disableIPv6,
 		StartIdx:    startIdx,
-	}, nil
+		MarkMask:    markMask,
+		conntrack:   NewConntrackFlusher(ConntrackFlushInterval, disableIPv4, disableIPv6, markMask),
+
+		linkEndpoints: linkEndpoints,
+	}
+
+	if backendName == "" || backendName == BackendAuto {
+		backendName = DetectBackend()
+	}
+
+	switch backendName {
+	case BackendIPTables:
+		if !disableIPv4 {
+			nh.IPTables4 = iptables.NewIPTables(iptables.NewRealIPTables())
+		}
+		if !disableIPv6 {
+			nh.IPTables6 = iptables.NewIPTables(iptables.NewRealIP6Tables())
+		}
+		nh.backend = &iptablesBackend{nh: nh}
+	case BackendNFTables:
+		b, err := newNFTablesBackend(nh)
+		if err != nil {
+			return nil, err
+		}
+		nh.backend = b
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, backendName)
+	}
+
+	return nh, nil
+}
+
+var ErrUnknownBackend = errors.New("unknown netfilter backend")
+
+// Backend возвращает имя используемой реализации netfilter
+func (nh *Helper) Backend() string {
+	return nh.getBackend().name()
+}
+
+// Clean удаляет правила, оставшиеся от предыдущего запуска
+func (nh *Helper) Clean() error {
+	return nh.getBackend().clean()
+}
+
+// Close освобождает ресурсы реализации netfilter
+func (nh *Helper) Close() error {
+	nh.conntrack.Close()
+	return nh.getBackend().close()
+}
+
+// Conntrack возвращает общий для всех групп механизм удаления записей conntrack
+func (nh *Helper) Conntrack() *ConntrackFlusher {
+	return nh.conntrack
+}
+
+func (nh *Helper) getBackend() backend {
+	if nh.backend == nil {
+		nh.backend = &iptablesBackend{nh: nh}
+	}
+	return nh.backend
 }
@@ -0,0 +1,143 @@
+package netfilterTools
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+/*
+	План: помощник из DryRun строит правила групп так же, как при включении, но ничего
+	не меняет в системе. Правила iptables сравниваются с текущими и превращаются в скрипт
+	iptables-restore, метки и таблицы выделяются в копии закреплений, а правила и маршруты
+	маршрутизации описываются в виде аргументов ip rule и ip route.
+*/
+
+// DryRun возвращает помощника для построения плана. С nftables правила netfilter
+// в план не попадают: у помощника нет IPTables.
+func (nh *Helper) DryRun() *Helper {
+	plan := &Helper{
+		ChainPrefix:  nh.ChainPrefix,
+		IpsetPrefix:  nh.IpsetPrefix,
+		DisableIPv4:  nh.DisableIPv4,
+		DisableIPv6:  nh.DisableIPv6,
+		StartIdx:     nh.StartIdx,
+		MarkMask:     nh.MarkMask,
+		Allocations:  nh.Allocations.snapshot(),
+		OutputBypass: nh.OutputBypass,
+
+		linkEndpoints: nh.linkEndpoints,
+	}
+	if nh.IPTables4 != nil {
+		plan.IPTables4 = nh.IPTables4.DryRun()
+	}
+	if nh.IPTables6 != nil {
+		plan.IPTables6 = nh.IPTables6.DryRun()
+	}
+	plan.backend = &iptablesBackend{nh: plan}
+	return plan
+}
+
+// snapshot возвращает копию закреплений, которая не сохраняется в файл
+func (a *Allocations) snapshot() *Allocations {
+	out := &Allocations{items: make(map[string]Allocation)}
+	if a == nil {
+		return out
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+
+	for key, alloc := range a.items {
+		out.items[key] = alloc
+	}
+	return out
+}
+
+// PlanEnable строит правила группы, как Enable, и возвращает её правила и маршруты
+// маршрутизации. Только для помощника из DryRun.
+func (r *IPSetToLink) PlanEnable() ([]string, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if r.routed() {
+		if err := r.allocate(r.name, r.startIdx); err != nil {
+			return nil, err
+		}
+		for _, ifaceName := range r.opts.Balance {
+			member := &linkTarget{nh: r.nh, ifaceName: ifaceName}
+			if err := member.allocate(r.name+"/"+ifaceName, r.startIdx); err != nil {
+				return nil, err
+			}
+			r.members = append(r.members, member)
+		}
+	}
+	if err := r.nh.getBackend().insertLinkRules(r); err != nil {
+		return nil, err
+	}
+	return r.routing(), nil
+}
+
+// PlanDisable строит удаление правил группы, как Disable. Только для помощника из DryRun.
+func (r *IPSetToLink) PlanDisable() error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	return r.nh.getBackend().deleteLinkRules(r)
+}
+
+// Routing возвращает правила и маршруты маршрутизации включённой группы
+func (r *IPSetToLink) Routing() []string {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if !r.enabled.Load() {
+		return nil
+	}
+	return r.routing()
+}
+
+// routing описывает правила и маршруты целей группы в виде аргументов ip rule и ip route
+// (маршрут через шлюз интерфейса указывается без шлюза)
+func (r *IPSetToLink) routing() []string {
+	if !r.routed() {
+		return nil
+	}
+
+	var out []string
+	for _, target := range r.targets() {
+		mark := fmt.Sprintf("%#x", target.mark)
+		if mask := r.nh.markMask(); mask != FullMarkMask {
+			mark += fmt.Sprintf("/%#x", mask)
+		}
+		for _, family := range []struct {
+			flag     string
+			family   int
+			disabled bool
+		}{
+			{"-4", nl.FAMILY_V4, r.nh.DisableIPv4},
+			{"-6", nl.FAMILY_V6, r.nh.DisableIPv6},
+		} {
+			if family.disabled {
+				continue
+			}
+			out = append(out, fmt.Sprintf("rule %s fwmark %s lookup %d", family.flag, mark, target.table))
+			if r.proxy() == ProxyTProxy {
+				out = append(out, fmt.Sprintf("route %s local default dev lo table %d", family.flag, target.table))
+				continue
+			}
+			out = append(out, fmt.Sprintf("route %s blackhole default metric 20 table %d", family.flag, target.table))
+			if target.ifaceName == Blackhole {
+				continue
+			}
+			route := fmt.Sprintf("route %s default", family.flag)
+			if gateway := target.gateway(family.family); gateway != nil {
+				route += " via " + gateway.String()
+				if target.onlink {
+					route += " onlink"
+				}
+			}
+			out = append(out, fmt.Sprintf("%s dev %s metric 10 table %d", route, target.ifaceName, target.table))
+		}
+	}
+	return out
+}
@@ -90,17 +90,11 @@ func (r *PortRemap) enable() error {
 		return nil
 	}
 
-	err := r.insertIPTablesRules(r.nh.IPTables4)
-	if err != nil {
-		return err
-	}
-
-	err = r.insertIPTablesRules(r.nh.IPTables6)
-	if err != nil {
+	if err := r.nh.RequireCapabilities(CapIPTables, CapNFTables, CapNAT); err != nil {
 		return err
 	}
 
-	return nil
+	return r.nh.getBackend().insertPortRemap(r)
 }
 
 func (r *PortRemap) Enable() error {
@@ -121,10 +115,7 @@ func (r *PortRemap) disable() error {
 	}
 	defer r.enabled.Store(false)
 
-	var errs []error
-	errs = append(errs, r.deleteIPTablesRules(r.nh.IPTables4))
-	errs = append(errs, r.deleteIPTablesRules(r.nh.IPTables6))
-	return errors.Join(errs...)
+	return r.nh.getBackend().deletePortRemap(r)
 }
 
 func (r *PortRemap) Disable() error {
@@ -0,0 +1,236 @@
+package netfilterTools
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+
+	"magitrickle/utils/iptables"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+/*
+	Сверка: прошивка (Keenetic NDM, перезапуск fw4) или сторонние скрипты могут удалить
+	цепочки, наборы и правила маршрутизации. Сверка сравнивает их с желаемым состоянием,
+	которое уже хранится в помощнике, и восстанавливает пропавшее. Каждая функция
+	возвращает описания исправленных объектов.
+*/
+
+// ErrRulesLost – правила netfilter утрачены так, что восстановить их может только повторное
+// включение групп (например, таблица nftables удалена вместе с наборами)
+var ErrRulesLost = errors.New("netfilter rules lost")
+
+// ReconcileNetfilter сверяет цепочки и переходы с зарегистрированными и исправляет расхождения.
+// При ErrRulesLost возвращаются утраченные объекты, а исправлять их должен вызывающий.
+func (nh *Helper) ReconcileNetfilter() ([]string, error) {
+	return nh.getBackend().reconcile()
+}
+
+func (b *iptablesBackend) reconcile() ([]string, error) {
+	var repaired []string
+	var errs []error
+	for _, ipt := range []*iptables.IPTables{b.nh.IPTables4, b.nh.IPTables6} {
+		if ipt == nil {
+			continue
+		}
+		name := "iptables"
+		if ipt.Proto() == iptables.ProtocolIPv6 {
+			name = "ip6tables"
+		}
+
+		drifted, err := ipt.Drift()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to check %s rules: %w", name, err))
+			continue
+		}
+		if len(drifted) == 0 {
+			continue
+		}
+		if err := ipt.Commit(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to commit %s rules: %w", name, err))
+			continue
+		}
+		for _, chain := range drifted {
+			repaired = append(repaired, name+" "+chain)
+		}
+	}
+	return repaired, errors.Join(errs...)
+}
+
+// reconcile проверяет наличие базовых цепочек и цепочек групп. Содержимое цепочек
+// строится из IPSetToLink, поэтому пропавшие цепочки восстанавливает вызывающий.
+func (b *nftablesBackend) reconcile() ([]string, error) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	chains, err := b.conn.ListChains(b.table)
+	if err != nil {
+		return nil, err
+	}
+
+	var lost []string
+	for _, chain := range nftBaseChains {
+		if !slices.Contains(chains, chain.name) {
+			lost = append(lost, "nftables chain "+chain.name)
+		}
+	}
+	for chain := range b.chains {
+		if !slices.Contains(chains, chain) {
+			lost = append(lost, "nftables chain "+chain)
+		}
+	}
+	if len(lost) == 0 {
+		return nil, nil
+	}
+	slices.Sort(lost)
+	return lost, ErrRulesLost
+}
+
+// Reconcile пересоздаёт пропавшие наборы включённого IPSet. true – наборы пересозданы
+// пустыми, и их нужно заполнить заново.
+func (r *IPSet) Reconcile() (bool, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if !r.enabled.Load() {
+		return false, nil
+	}
+
+	missing := false
+	for _, ipLen := range []int{net.IPv4len, net.IPv6len} {
+		_, err := r.nh.getBackend().listSet(r.ipsetName, ipLen)
+		if errors.Is(err, os.ErrNotExist) {
+			missing = true
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to check ipset: %w", err)
+		}
+	}
+	if !missing {
+		return false, nil
+	}
+
+	if err := r.nh.getBackend().destroySet(r.ipsetName); err != nil {
+		return false, err
+	}
+	if err := r.nh.getBackend().createSet(r.ipsetName, r.opts.withDefaults()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Name возвращает имя набора (без суффикса семейства)
+func (r *IPSet) Name() string {
+	return r.ipsetName
+}
+
+// Reconcile восстанавливает пропавшие ip rule и маршруты включённой группы
+func (r *IPSetToLink) Reconcile() ([]string, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	if !r.enabled.Load() || !r.routed() {
+		return nil, nil
+	}
+
+	var repaired []string
+	var errs []error
+	for _, target := range r.targets() {
+		fixed, err := target.reconcileRouting()
+		repaired = append(repaired, fixed...)
+		errs = append(errs, err)
+	}
+	return repaired, errors.Join(errs...)
+}
+
+func (r *linkTarget) reconcileRouting() ([]string, error) {
+	var repaired []string
+	var errs []error
+
+	for _, rule := range []*netlink.Rule{r.ip4Rule, r.ip6Rule} {
+		if rule == nil {
+			continue
+		}
+		rules, err := netlink.RuleListFiltered(rule.Family, rule, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while getting rules: %w", err))
+			continue
+		}
+		if slices.ContainsFunc(rules, func(existing netlink.Rule) bool { return existing.Mark == rule.Mark }) {
+			continue
+		}
+		if err := netlink.RuleAdd(rule); err != nil {
+			errs = append(errs, fmt.Errorf("error while mapping marked packages to table: %w", err))
+			continue
+		}
+		repaired = append(repaired, fmt.Sprintf("ip rule %s fwmark %#x lookup %d", familyFlag(rule.Family), rule.Mark, rule.Table))
+	}
+
+	for _, route := range []*netlink.Route{r.ip4Route[0], r.ip4Route[1], r.ip6Route[0], r.ip6Route[1]} {
+		if route == nil {
+			continue
+		}
+		routes, err := netlink.RouteListFiltered(route.Family, &netlink.Route{Table: route.Table}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while getting routes: %w", err))
+			continue
+		}
+		if slices.ContainsFunc(routes, func(existing netlink.Route) bool { return sameRoute(existing, *route) }) {
+			continue
+		}
+		// Маршрут через интерфейс ядро удаляет вместе с интерфейсом; его вернёт LinkUpHook
+		if route.Type == 0 && route.LinkIndex != 0 {
+			link, err := netlink.LinkByIndex(route.LinkIndex)
+			if err != nil || link.Attrs().Flags&net.FlagUp == 0 {
+				continue
+			}
+		}
+		if err := netlink.RouteAdd(route); err != nil && !errors.Is(err, unix.EEXIST) {
+			errs = append(errs, fmt.Errorf("error while adding route: %w", err))
+			continue
+		}
+		repaired = append(repaired, fmt.Sprintf("ip route %s %s table %d", familyFlag(route.Family), routeKind(route), route.Table))
+	}
+
+	return repaired, errors.Join(errs...)
+}
+
+// sameRoute сравнивает маршрут по умолчанию из таблицы группы с добавленным приложением.
+// Интерфейс сравнивается только у маршрутов через интерфейс: blackhole IPv6 ядро
+// показывает с интерфейсом lo.
+func sameRoute(existing, route netlink.Route) bool {
+	routeType := route.Type
+	if routeType == 0 {
+		routeType = unix.RTN_UNICAST
+	}
+	return existing.Type == routeType &&
+		(routeType != unix.RTN_UNICAST || existing.LinkIndex == route.LinkIndex) &&
+		existing.Priority == route.Priority &&
+		(existing.Dst == nil || existing.Dst.IP.IsUnspecified())
+}
+
+func routeKind(route *netlink.Route) string {
+	switch route.Type {
+	case unix.RTN_BLACKHOLE:
+		return "blackhole default"
+	case unix.RTN_LOCAL:
+		return "local default"
+	}
+	if route.Gw != nil {
+		return fmt.Sprintf("default via %s ifindex %d", route.Gw, route.LinkIndex)
+	}
+	return fmt.Sprintf("default ifindex %d", route.LinkIndex)
+}
+
+func familyFlag(family int) string {
+	if family == nl.FAMILY_V6 {
+		return "-6"
+	}
+	return "-4"
+}
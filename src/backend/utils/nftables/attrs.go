@@ -0,0 +1,75 @@
+package nftables
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+var errMalformedAttr = errors.New("malformed netlink attribute")
+
+// attrs – построитель атрибутов netlink (TLV с выравниванием на 4 байта)
+type attrs []byte
+
+func align(n int) int {
+	return (n + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+}
+
+func (a attrs) bytes(typ uint16, data []byte) attrs {
+	var hdr [unix.SizeofNlAttr]byte
+	binary.NativeEndian.PutUint16(hdr[0:2], uint16(unix.SizeofNlAttr+len(data)))
+	binary.NativeEndian.PutUint16(hdr[2:4], typ)
+	a = append(a, hdr[:]...)
+	a = append(a, data...)
+	for len(a)%unix.NLA_ALIGNTO != 0 {
+		a = append(a, 0)
+	}
+	return a
+}
+
+func (a attrs) str(typ uint16, s string) attrs {
+	return a.bytes(typ, append([]byte(s), 0))
+}
+
+func (a attrs) u8(typ uint16, v uint8) attrs {
+	return a.bytes(typ, []byte{v})
+}
+
+func (a attrs) be32(typ uint16, v uint32) attrs {
+	return a.bytes(typ, binary.BigEndian.AppendUint32(nil, v))
+}
+
+func (a attrs) be64(typ uint16, v uint64) attrs {
+	return a.bytes(typ, binary.BigEndian.AppendUint64(nil, v))
+}
+
+func (a attrs) nest(typ uint16, inner attrs) attrs {
+	return a.bytes(typ|unix.NLA_F_NESTED, inner)
+}
+
+// parseAttrs разбирает плоский список атрибутов; вложенные атрибуты разбираются повторным вызовом
+func parseAttrs(b []byte) (map[uint16][]byte, error) {
+	out := make(map[uint16][]byte)
+	err := walkAttrs(b, func(typ uint16, data []byte) {
+		out[typ] = data
+	})
+	return out, err
+}
+
+// walkAttrs обходит атрибуты по порядку (нужно для списков с повторяющимся типом)
+func walkAttrs(b []byte, fn func(typ uint16, data []byte)) error {
+	for len(b) >= unix.SizeofNlAttr {
+		length := int(binary.NativeEndian.Uint16(b[0:2]))
+		typ := binary.NativeEndian.Uint16(b[2:4]) &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+		if length < unix.SizeofNlAttr || length > len(b) {
+			return errMalformedAttr
+		}
+		fn(typ, b[unix.SizeofNlAttr:length])
+		if align(length) >= len(b) {
+			return nil
+		}
+		b = b[align(length):]
+	}
+	return nil
+}
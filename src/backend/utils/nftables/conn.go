@@ -0,0 +1,223 @@
+package nftables
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const sizeofNfgenmsg = 4
+
+// message – одно сообщение nf_tables внутри пакета (batch)
+type message struct {
+	typ    uint16
+	flags  uint16
+	family uint8
+	data   attrs
+}
+
+// Conn – netlink-сокет подсистемы nf_tables. Изменения отправляются пакетами (batch),
+// которые ядро применяет атомарно: либо все сообщения, либо ни одного.
+type Conn struct {
+	locker sync.Mutex
+	fd     int
+	seq    uint32
+}
+
+func Open() (*Conn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind netlink socket: %w", err)
+	}
+	// Ответы на большие пакеты и дампы наборов могут не поместиться в буфер по умолчанию
+	_ = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, 4<<20)
+	// Если ядро не подтвердит часть сообщений, не зависаем навсегда
+	_ = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 5})
+	return &Conn{fd: fd}, nil
+}
+
+func (c *Conn) Close() error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	if c.fd < 0 {
+		return nil
+	}
+	err := unix.Close(c.fd)
+	c.fd = -1
+	return err
+}
+
+func (c *Conn) nextSeq() uint32 {
+	c.seq++
+	return c.seq
+}
+
+func appendMessage(buf []byte, typ, flags uint16, seq uint32, family uint8, resID uint16, data []byte) []byte {
+	length := unix.SizeofNlMsghdr + sizeofNfgenmsg + len(data)
+	hdr := make([]byte, unix.SizeofNlMsghdr+sizeofNfgenmsg)
+	binary.NativeEndian.PutUint32(hdr[0:4], uint32(length))
+	binary.NativeEndian.PutUint16(hdr[4:6], typ)
+	binary.NativeEndian.PutUint16(hdr[6:8], flags)
+	binary.NativeEndian.PutUint32(hdr[8:12], seq)
+	hdr[16] = family
+	hdr[17] = unix.NFNETLINK_V0
+	binary.BigEndian.PutUint16(hdr[18:20], resID)
+	buf = append(buf, hdr...)
+	buf = append(buf, data...)
+	for len(buf)%unix.NLMSG_ALIGNTO != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+func msgType(typ uint16) uint16 {
+	return unix.NFNL_SUBSYS_NFTABLES<<8 | typ
+}
+
+// exec отправляет сообщения одним пакетом и дожидается подтверждения каждого из них
+func (c *Conn) exec(msgs []message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	if c.fd < 0 {
+		return errors.New("connection is closed")
+	}
+
+	var buf []byte
+	beginSeq := c.nextSeq()
+	buf = appendMessage(buf, unix.NFNL_MSG_BATCH_BEGIN, unix.NLM_F_REQUEST, beginSeq, unix.AF_UNSPEC, unix.NFNL_SUBSYS_NFTABLES, nil)
+	pending := make(map[uint32]int, len(msgs))
+	for idx, msg := range msgs {
+		seq := c.nextSeq()
+		pending[seq] = idx
+		buf = appendMessage(buf, msgType(msg.typ), unix.NLM_F_REQUEST|unix.NLM_F_ACK|msg.flags, seq, msg.family, 0, msg.data)
+	}
+	buf = appendMessage(buf, unix.NFNL_MSG_BATCH_END, unix.NLM_F_REQUEST, c.nextSeq(), unix.AF_UNSPEC, unix.NFNL_SUBSYS_NFTABLES, nil)
+
+	if err := unix.Sendto(c.fd, buf, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to send netlink batch: %w", err)
+	}
+
+	// При ошибке ядро откатывает весь пакет, но подтверждения по остальным сообщениям всё равно приходят
+	var firstErr error
+	for len(pending) > 0 {
+		replies, err := c.receive()
+		if err != nil {
+			return err
+		}
+		for _, reply := range replies {
+			if reply.typ != unix.NLMSG_ERROR {
+				continue
+			}
+			if reply.seq == beginSeq && reply.errno != 0 {
+				// Пакет отвергнут целиком (например, nf_tables не поддерживается ядром)
+				return fmt.Errorf("netlink batch rejected: %w", reply.errno)
+			}
+			idx, ok := pending[reply.seq]
+			if !ok {
+				continue
+			}
+			delete(pending, reply.seq)
+			if reply.errno != 0 && firstErr == nil {
+				firstErr = fmt.Errorf("message %d (type %d): %w", idx, msgs[idx].typ, reply.errno)
+			}
+		}
+	}
+	return firstErr
+}
+
+// dump выполняет запрос с NLM_F_DUMP и возвращает полезную нагрузку всех ответов (без nfgenmsg)
+func (c *Conn) dump(typ uint16, family uint8, data attrs) ([][]byte, error) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	if c.fd < 0 {
+		return nil, errors.New("connection is closed")
+	}
+
+	seq := c.nextSeq()
+	buf := appendMessage(nil, msgType(typ), unix.NLM_F_REQUEST|unix.NLM_F_DUMP, seq, family, 0, data)
+	if err := unix.Sendto(c.fd, buf, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("failed to send netlink request: %w", err)
+	}
+
+	var out [][]byte
+	for {
+		replies, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		for _, reply := range replies {
+			if reply.seq != seq {
+				continue
+			}
+			switch reply.typ {
+			case unix.NLMSG_DONE:
+				return out, nil
+			case unix.NLMSG_ERROR:
+				if reply.errno != 0 {
+					return nil, reply.errno
+				}
+				return out, nil
+			default:
+				if len(reply.data) >= sizeofNfgenmsg {
+					out = append(out, reply.data[sizeofNfgenmsg:])
+				}
+			}
+		}
+	}
+}
+
+type reply struct {
+	typ   uint16
+	seq   uint32
+	errno unix.Errno
+	data  []byte
+}
+
+func (c *Conn) receive() ([]reply, error) {
+	buf := make([]byte, 1<<16)
+	n, _, err := unix.Recvfrom(c.fd, buf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive netlink reply: %w", err)
+	}
+	buf = buf[:n]
+
+	var out []reply
+	for len(buf) >= unix.SizeofNlMsghdr {
+		length := int(binary.NativeEndian.Uint32(buf[0:4]))
+		if length < unix.SizeofNlMsghdr || length > len(buf) {
+			return nil, errors.New("malformed netlink message")
+		}
+		r := reply{
+			typ:  binary.NativeEndian.Uint16(buf[4:6]),
+			seq:  binary.NativeEndian.Uint32(buf[8:12]),
+			data: buf[unix.SizeofNlMsghdr:length],
+		}
+		if r.typ == unix.NLMSG_ERROR && len(r.data) >= 4 {
+			if code := int32(binary.NativeEndian.Uint32(r.data[0:4])); code < 0 {
+				r.errno = unix.Errno(-code)
+			}
+		}
+		out = append(out, r)
+
+		next := (length + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+		if next >= len(buf) {
+			break
+		}
+		buf = buf[next:]
+	}
+	return out, nil
+}
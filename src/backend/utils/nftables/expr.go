@@ -0,0 +1,374 @@
+package nftables
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/sys/unix"
+)
+
+// Регистры: NFT_REG_1 вмещает до 16 байт (IPv6-адрес целиком)
+const (
+	RegVerdict = unix.NFT_REG_VERDICT
+	Reg1       = unix.NFT_REG_1
+	Reg2       = unix.NFT_REG_2
+)
+
+// Expr – выражение правила nf_tables
+type Expr interface {
+	exprName() string
+	exprData() attrs
+}
+
+func marshalExprs(exprs []Expr) attrs {
+	var list attrs
+	for _, e := range exprs {
+		list = list.nest(unix.NFTA_LIST_ELEM, attrs(nil).
+			str(unix.NFTA_EXPR_NAME, e.exprName()).
+			nest(unix.NFTA_EXPR_DATA, e.exprData()))
+	}
+	return list
+}
+
+// Meta загружает метаданные пакета в регистр или, при Set, записывает их из регистра
+type Meta struct {
+	Key      uint32
+	Register uint32
+	Set      bool
+}
+
+func (e Meta) exprName() string { return "meta" }
+func (e Meta) exprData() attrs {
+	a := attrs(nil).be32(unix.NFTA_META_KEY, e.Key)
+	if e.Set {
+		return a.be32(unix.NFTA_META_SREG, e.Register)
+	}
+	return a.be32(unix.NFTA_META_DREG, e.Register)
+}
+
+// Ct загружает поле conntrack в регистр или, при Set, записывает его из регистра
+type Ct struct {
+	Key      uint32
+	Register uint32
+	Set      bool
+}
+
+func (e Ct) exprName() string { return "ct" }
+func (e Ct) exprData() attrs {
+	a := attrs(nil).be32(unix.NFTA_CT_KEY, e.Key)
+	if e.Set {
+		return a.be32(unix.NFTA_CT_SREG, e.Register)
+	}
+	return a.be32(unix.NFTA_CT_DREG, e.Register)
+}
+
+// Payload загружает Len байт заголовка Base со смещения Offset или, при Set, записывает их
+// из регистра; CsumType и CsumOffset задают пересчёт контрольной суммы заголовка при записи
+type Payload struct {
+	Base       uint32
+	Offset     uint32
+	Len        uint32
+	Register   uint32
+	Set        bool
+	CsumType   uint32
+	CsumOffset uint32
+}
+
+func (e Payload) exprName() string { return "payload" }
+func (e Payload) exprData() attrs {
+	a := attrs(nil).
+		be32(unix.NFTA_PAYLOAD_BASE, e.Base).
+		be32(unix.NFTA_PAYLOAD_OFFSET, e.Offset).
+		be32(unix.NFTA_PAYLOAD_LEN, e.Len)
+	if !e.Set {
+		return a.be32(unix.NFTA_PAYLOAD_DREG, e.Register)
+	}
+	a = a.be32(unix.NFTA_PAYLOAD_SREG, e.Register)
+	if e.CsumType != unix.NFT_PAYLOAD_CSUM_NONE {
+		a = a.
+			be32(unix.NFTA_PAYLOAD_CSUM_TYPE, e.CsumType).
+			be32(unix.NFTA_PAYLOAD_CSUM_OFFSET, e.CsumOffset)
+	}
+	return a
+}
+
+// Cmp сравнивает регистр со значением; при несовпадении правило прекращается
+type Cmp struct {
+	Op       uint32
+	Register uint32
+	Data     []byte
+}
+
+func (e Cmp) exprName() string { return "cmp" }
+func (e Cmp) exprData() attrs {
+	return attrs(nil).
+		be32(unix.NFTA_CMP_SREG, e.Register).
+		be32(unix.NFTA_CMP_OP, e.Op).
+		nest(unix.NFTA_CMP_DATA, attrs(nil).bytes(unix.NFTA_DATA_VALUE, e.Data))
+}
+
+// Bitwise вычисляет (reg & Mask) ^ Xor
+type Bitwise struct {
+	Register uint32
+	Mask     []byte
+	Xor      []byte
+}
+
+func (e Bitwise) exprName() string { return "bitwise" }
+func (e Bitwise) exprData() attrs {
+	xor := e.Xor
+	if xor == nil {
+		xor = make([]byte, len(e.Mask))
+	}
+	return attrs(nil).
+		be32(unix.NFTA_BITWISE_SREG, e.Register).
+		be32(unix.NFTA_BITWISE_DREG, e.Register).
+		be32(unix.NFTA_BITWISE_LEN, uint32(len(e.Mask))).
+		nest(unix.NFTA_BITWISE_MASK, attrs(nil).bytes(unix.NFTA_DATA_VALUE, e.Mask)).
+		nest(unix.NFTA_BITWISE_XOR, attrs(nil).bytes(unix.NFTA_DATA_VALUE, xor))
+}
+
+// Lookup проверяет наличие значения регистра в именованном наборе
+type Lookup struct {
+	Set      string
+	Register uint32
+	Invert   bool
+}
+
+func (e Lookup) exprName() string { return "lookup" }
+func (e Lookup) exprData() attrs {
+	a := attrs(nil).
+		str(unix.NFTA_LOOKUP_SET, e.Set).
+		be32(unix.NFTA_LOOKUP_SREG, e.Register)
+	if e.Invert {
+		a = a.be32(unix.NFTA_LOOKUP_FLAGS, unix.NFT_LOOKUP_F_INV)
+	}
+	return a
+}
+
+// Immediate записывает значение в регистр
+type Immediate struct {
+	Register uint32
+	Data     []byte
+}
+
+func (e Immediate) exprName() string { return "immediate" }
+func (e Immediate) exprData() attrs {
+	return attrs(nil).
+		be32(unix.NFTA_IMMEDIATE_DREG, e.Register).
+		nest(unix.NFTA_IMMEDIATE_DATA, attrs(nil).bytes(unix.NFTA_DATA_VALUE, e.Data))
+}
+
+// Verdict завершает правило вердиктом (accept, drop, return, jump)
+type Verdict struct {
+	Code  int32
+	Chain string
+}
+
+func (e Verdict) exprName() string { return "immediate" }
+func (e Verdict) exprData() attrs {
+	verdict := attrs(nil).be32(unix.NFTA_VERDICT_CODE, uint32(e.Code))
+	if e.Chain != "" {
+		verdict = verdict.str(unix.NFTA_VERDICT_CHAIN, e.Chain)
+	}
+	return attrs(nil).
+		be32(unix.NFTA_IMMEDIATE_DREG, RegVerdict).
+		nest(unix.NFTA_IMMEDIATE_DATA, attrs(nil).nest(unix.NFTA_DATA_VERDICT, verdict))
+}
+
+// Коды вердиктов netfilter (linux/netfilter.h)
+const (
+	nfDrop   = 0
+	nfAccept = 1
+)
+
+var (
+	Accept = Verdict{Code: nfAccept}
+	Drop   = Verdict{Code: nfDrop}
+	Return = Verdict{Code: unix.NFT_RETURN}
+)
+
+// Jump переходит в цепочку chain с возвратом
+func Jump(chain string) Verdict {
+	return Verdict{Code: unix.NFT_JUMP, Chain: chain}
+}
+
+// Numgen записывает в регистр случайное число из [0, Modulus) в порядке байт хоста
+type Numgen struct {
+	Register uint32
+	Modulus  uint32
+}
+
+func (e Numgen) exprName() string { return "numgen" }
+func (e Numgen) exprData() attrs {
+	return attrs(nil).
+		be32(unix.NFTA_NG_DREG, e.Register).
+		be32(unix.NFTA_NG_MODULUS, e.Modulus).
+		be32(unix.NFTA_NG_TYPE, unix.NFT_NG_RANDOM)
+}
+
+// Hton переводит 32-битное значение регистра в сетевой порядок байт;
+// нужно перед сравнениями больше/меньше, которые сравнивают байты как big-endian
+type Hton struct {
+	Register uint32
+}
+
+func (e Hton) exprName() string { return "byteorder" }
+func (e Hton) exprData() attrs {
+	return attrs(nil).
+		be32(unix.NFTA_BYTEORDER_SREG, e.Register).
+		be32(unix.NFTA_BYTEORDER_DREG, e.Register).
+		be32(unix.NFTA_BYTEORDER_OP, unix.NFT_BYTEORDER_HTON).
+		be32(unix.NFTA_BYTEORDER_LEN, 4).
+		be32(unix.NFTA_BYTEORDER_SIZE, 4)
+}
+
+// BigEndianUint32 кодирует значение в сетевом порядке байт
+func BigEndianUint32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// Masq – маскарадинг (только в цепочках типа nat)
+type Masq struct{}
+
+func (e Masq) exprName() string { return "masq" }
+func (e Masq) exprData() attrs  { return nil }
+
+// SNAT подменяет адрес источника адресом из регистра AddrRegister (только в цепочках типа nat);
+// Family – NFPROTO_IPV4 или NFPROTO_IPV6
+type SNAT struct {
+	Family       uint32
+	AddrRegister uint32
+}
+
+func (e SNAT) exprName() string { return "nat" }
+func (e SNAT) exprData() attrs {
+	return attrs(nil).
+		be32(unix.NFTA_NAT_TYPE, unix.NFT_NAT_SNAT).
+		be32(unix.NFTA_NAT_FAMILY, e.Family).
+		be32(unix.NFTA_NAT_REG_ADDR_MIN, e.AddrRegister).
+		be32(unix.NFTA_NAT_REG_ADDR_MAX, e.AddrRegister)
+}
+
+// Redir перенаправляет пакет на локальный порт из регистра PortRegister
+type Redir struct {
+	PortRegister uint32
+}
+
+func (e Redir) exprName() string { return "redir" }
+func (e Redir) exprData() attrs {
+	return attrs(nil).
+		be32(unix.NFTA_REDIR_REG_PROTO_MIN, e.PortRegister).
+		be32(unix.NFTA_REDIR_REG_PROTO_MAX, e.PortRegister)
+}
+
+// Атрибуты выражения tproxy (linux/netfilter/nf_tables.h, в x/sys/unix отсутствуют)
+const (
+	nftaTProxyFamily  = 1
+	nftaTProxyRegPort = 3
+)
+
+// TProxy передаёт пакет прозрачному прокси на локальный порт из регистра PortRegister
+// (только в цепочках prerouting); Family – NFPROTO_IPV4 или NFPROTO_IPV6
+type TProxy struct {
+	Family       uint32
+	PortRegister uint32
+}
+
+func (e TProxy) exprName() string { return "tproxy" }
+func (e TProxy) exprData() attrs {
+	return attrs(nil).
+		be32(nftaTProxyFamily, e.Family).
+		be32(nftaTProxyRegPort, e.PortRegister)
+}
+
+// Reject отбрасывает пакет с ответом отправителю: Type – NFT_REJECT_TCP_RST или
+// NFT_REJECT_ICMPX_UNREACH с кодом Code (NFT_REJECT_ICMPX_*), одинаковым для IPv4 и IPv6
+type Reject struct {
+	Type uint32
+	Code uint8
+}
+
+func (e Reject) exprName() string { return "reject" }
+func (e Reject) exprData() attrs {
+	return attrs(nil).
+		be32(unix.NFTA_REJECT_TYPE, e.Type).
+		u8(unix.NFTA_REJECT_ICMP_CODE, e.Code)
+}
+
+// Rt загружает в регистр данные маршрута пакета (например, NFT_RT_TCPMSS – MSS по MTU маршрута)
+type Rt struct {
+	Key      uint32
+	Register uint32
+}
+
+func (e Rt) exprName() string { return "rt" }
+func (e Rt) exprData() attrs {
+	return attrs(nil).
+		be32(unix.NFTA_RT_KEY, e.Key).
+		be32(unix.NFTA_RT_DREG, e.Register)
+}
+
+// TCPOption загружает Len байт опции TCP Kind со смещения Offset или, при Set, записывает их из регистра
+type TCPOption struct {
+	Kind     uint8
+	Offset   uint32
+	Len      uint32
+	Register uint32
+	Set      bool
+}
+
+func (e TCPOption) exprName() string { return "exthdr" }
+func (e TCPOption) exprData() attrs {
+	a := attrs(nil).
+		u8(unix.NFTA_EXTHDR_TYPE, e.Kind).
+		be32(unix.NFTA_EXTHDR_OFFSET, e.Offset).
+		be32(unix.NFTA_EXTHDR_LEN, e.Len).
+		be32(unix.NFTA_EXTHDR_OP, unix.NFT_EXTHDR_OP_TCPOPT)
+	if e.Set {
+		return a.be32(unix.NFTA_EXTHDR_SREG, e.Register)
+	}
+	return a.be32(unix.NFTA_EXTHDR_DREG, e.Register)
+}
+
+// Counter считает пакеты и байты, прошедшие правило
+type Counter struct{}
+
+func (e Counter) exprName() string { return "counter" }
+func (e Counter) exprData() attrs {
+	return attrs(nil).
+		be64(unix.NFTA_COUNTER_PACKETS, 0).
+		be64(unix.NFTA_COUNTER_BYTES, 0)
+}
+
+// NativeUint32 кодирует значение в порядке байт хоста (метки пакетов и conntrack)
+func NativeUint32(v uint32) []byte {
+	return binary.NativeEndian.AppendUint32(nil, v)
+}
+
+// NativeUint16 кодирует значение в порядке байт хоста (тип интерфейса)
+func NativeUint16(v uint16) []byte {
+	return binary.NativeEndian.AppendUint16(nil, v)
+}
+
+// BigEndianUint16 кодирует значение в сетевом порядке байт (порты)
+func BigEndianUint16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+// IfName кодирует имя интерфейса для сравнения с meta iifname/oifname.
+// Имя с суффиксом "+" сравнивается только по префиксу.
+func IfName(name string) []byte {
+	if prefix, ok := cutWildcard(name); ok {
+		return []byte(prefix)
+	}
+	b := make([]byte, unix.IFNAMSIZ)
+	copy(b, name)
+	return b
+}
+
+func cutWildcard(name string) (string, bool) {
+	if len(name) > 0 && name[len(name)-1] == '+' {
+		return name[:len(name)-1], true
+	}
+	return name, false
+}
@@ -0,0 +1,377 @@
+package nftables
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Table – таблица nf_tables; все объекты (цепочки, наборы, правила) принадлежат таблице
+type Table struct {
+	Family uint8
+	Name   string
+}
+
+// Hook описывает подключение базовой цепочки к хуку netfilter
+type Hook struct {
+	Type     string // filter, nat, route
+	Num      uint32 // NF_INET_PRE_ROUTING и т.д.
+	Priority int32
+}
+
+// Set – именованный набор ключей (адресов)
+type Set struct {
+	Name     string
+	KeyType  uint32
+	KeyLen   uint32
+	Interval bool
+	Timeout  bool
+}
+
+// Типы данных ключей nft (nftables/include/datatype.h)
+const (
+	TypeIPv4Addr = 7
+	TypeIPv6Addr = 8
+)
+
+// Element – элемент набора. В наборах-интервалах диапазон [start, end) задаётся
+// двумя элементами: началом и концом с флагом End.
+type Element struct {
+	Key []byte
+	End bool
+	// Timeout – время жизни при добавлении; 0 – бессрочно
+	Timeout time.Duration
+	// Expiration – оставшееся время жизни (заполняется при чтении)
+	Expiration time.Duration
+}
+
+// Batch накапливает изменения для атомарного применения через Conn.Commit
+type Batch struct {
+	msgs  []message
+	setID uint32
+}
+
+func (b *Batch) Len() int {
+	return len(b.msgs)
+}
+
+func (b *Batch) add(typ, flags uint16, family uint8, data attrs) {
+	b.msgs = append(b.msgs, message{typ: typ, flags: flags, family: family, data: data})
+}
+
+func (b *Batch) AddTable(t Table) {
+	b.add(unix.NFT_MSG_NEWTABLE, unix.NLM_F_CREATE, t.Family, attrs(nil).
+		str(unix.NFTA_TABLE_NAME, t.Name))
+}
+
+func (b *Batch) DelTable(t Table) {
+	b.add(unix.NFT_MSG_DELTABLE, 0, t.Family, attrs(nil).
+		str(unix.NFTA_TABLE_NAME, t.Name))
+}
+
+// AddChain создаёт цепочку; с hook – базовую цепочку с политикой accept
+func (b *Batch) AddChain(t Table, chain string, hook *Hook) {
+	a := attrs(nil).
+		str(unix.NFTA_CHAIN_TABLE, t.Name).
+		str(unix.NFTA_CHAIN_NAME, chain)
+	if hook != nil {
+		a = a.nest(unix.NFTA_CHAIN_HOOK, attrs(nil).
+			be32(unix.NFTA_HOOK_HOOKNUM, hook.Num).
+			be32(unix.NFTA_HOOK_PRIORITY, uint32(hook.Priority))).
+			be32(unix.NFTA_CHAIN_POLICY, nfAccept).
+			str(unix.NFTA_CHAIN_TYPE, hook.Type)
+	}
+	b.add(unix.NFT_MSG_NEWCHAIN, unix.NLM_F_CREATE, t.Family, a)
+}
+
+// FlushChain удаляет все правила цепочки
+func (b *Batch) FlushChain(t Table, chain string) {
+	b.add(unix.NFT_MSG_DELRULE, 0, t.Family, attrs(nil).
+		str(unix.NFTA_RULE_TABLE, t.Name).
+		str(unix.NFTA_RULE_CHAIN, chain))
+}
+
+func (b *Batch) DelChain(t Table, chain string) {
+	b.add(unix.NFT_MSG_DELCHAIN, 0, t.Family, attrs(nil).
+		str(unix.NFTA_CHAIN_TABLE, t.Name).
+		str(unix.NFTA_CHAIN_NAME, chain))
+}
+
+// AddRule добавляет правило в конец цепочки
+func (b *Batch) AddRule(t Table, chain string, exprs ...Expr) {
+	b.add(unix.NFT_MSG_NEWRULE, unix.NLM_F_CREATE|unix.NLM_F_APPEND, t.Family, attrs(nil).
+		str(unix.NFTA_RULE_TABLE, t.Name).
+		str(unix.NFTA_RULE_CHAIN, chain).
+		nest(unix.NFTA_RULE_EXPRESSIONS, marshalExprs(exprs)))
+}
+
+// AddRuleComment добавляет в конец цепочки правило с комментарием; комментарий хранится
+// в userdata в формате утилиты nft и возвращается ListCounters
+func (b *Batch) AddRuleComment(t Table, chain, comment string, exprs ...Expr) {
+	b.add(unix.NFT_MSG_NEWRULE, unix.NLM_F_CREATE|unix.NLM_F_APPEND, t.Family, attrs(nil).
+		str(unix.NFTA_RULE_TABLE, t.Name).
+		str(unix.NFTA_RULE_CHAIN, chain).
+		nest(unix.NFTA_RULE_EXPRESSIONS, marshalExprs(exprs)).
+		bytes(unix.NFTA_RULE_USERDATA, ruleComment(comment)))
+}
+
+// udataRuleComment – тип комментария правила в userdata (NFTNL_UDATA_RULE_COMMENT)
+const udataRuleComment = 0
+
+// ruleComment кодирует комментарий записью userdata: тип, длина и строка с завершающим нулём
+func ruleComment(comment string) []byte {
+	value := append([]byte(comment), 0)
+	return append([]byte{udataRuleComment, byte(len(value))}, value...)
+}
+
+// parseRuleComment возвращает комментарий из userdata правила; нет комментария – пустая строка
+func parseRuleComment(b []byte) string {
+	for len(b) >= 2 {
+		typ, length := b[0], int(b[1])
+		if 2+length > len(b) {
+			return ""
+		}
+		if typ == udataRuleComment {
+			return cstr(b[2 : 2+length])
+		}
+		b = b[2+length:]
+	}
+	return ""
+}
+
+func (b *Batch) AddSet(t Table, set Set) {
+	var flags uint32
+	if set.Interval {
+		flags |= unix.NFT_SET_INTERVAL
+	}
+	if set.Timeout {
+		flags |= unix.NFT_SET_TIMEOUT
+	}
+	// Идентификатор обязателен и должен быть уникален в пределах пакета
+	b.setID++
+	b.add(unix.NFT_MSG_NEWSET, unix.NLM_F_CREATE, t.Family, attrs(nil).
+		str(unix.NFTA_SET_TABLE, t.Name).
+		str(unix.NFTA_SET_NAME, set.Name).
+		be32(unix.NFTA_SET_FLAGS, flags).
+		be32(unix.NFTA_SET_KEY_TYPE, set.KeyType).
+		be32(unix.NFTA_SET_KEY_LEN, set.KeyLen).
+		be32(unix.NFTA_SET_ID, b.setID))
+}
+
+func (b *Batch) DelSet(t Table, set string) {
+	b.add(unix.NFT_MSG_DELSET, 0, t.Family, attrs(nil).
+		str(unix.NFTA_SET_TABLE, t.Name).
+		str(unix.NFTA_SET_NAME, set))
+}
+
+// AddElements добавляет элементы; при exclusive уже существующий элемент приводит к EEXIST
+func (b *Batch) AddElements(t Table, set string, elems []Element, exclusive bool) {
+	flags := uint16(unix.NLM_F_CREATE)
+	if exclusive {
+		flags |= unix.NLM_F_EXCL
+	}
+	b.add(unix.NFT_MSG_NEWSETELEM, flags, t.Family, marshalElements(t, set, elems))
+}
+
+func (b *Batch) DelElements(t Table, set string, elems []Element) {
+	b.add(unix.NFT_MSG_DELSETELEM, 0, t.Family, marshalElements(t, set, elems))
+}
+
+// FlushSet удаляет все элементы набора
+func (b *Batch) FlushSet(t Table, set string) {
+	b.add(unix.NFT_MSG_DELSETELEM, 0, t.Family, attrs(nil).
+		str(unix.NFTA_SET_ELEM_LIST_TABLE, t.Name).
+		str(unix.NFTA_SET_ELEM_LIST_SET, set))
+}
+
+func marshalElements(t Table, set string, elems []Element) attrs {
+	var list attrs
+	for _, elem := range elems {
+		a := attrs(nil).nest(unix.NFTA_SET_ELEM_KEY, attrs(nil).bytes(unix.NFTA_DATA_VALUE, elem.Key))
+		if elem.End {
+			a = a.be32(unix.NFTA_SET_ELEM_FLAGS, unix.NFT_SET_ELEM_INTERVAL_END)
+		}
+		if elem.Timeout > 0 {
+			a = a.be64(unix.NFTA_SET_ELEM_TIMEOUT, uint64(elem.Timeout.Milliseconds()))
+		}
+		list = list.nest(unix.NFTA_LIST_ELEM, a)
+	}
+	return attrs(nil).
+		str(unix.NFTA_SET_ELEM_LIST_TABLE, t.Name).
+		str(unix.NFTA_SET_ELEM_LIST_SET, set).
+		nest(unix.NFTA_SET_ELEM_LIST_ELEMENTS, list)
+}
+
+// Commit атомарно применяет накопленные изменения
+func (c *Conn) Commit(b *Batch) error {
+	return c.exec(b.msgs)
+}
+
+// ListElements возвращает элементы набора
+func (c *Conn) ListElements(t Table, set string) ([]Element, error) {
+	payloads, err := c.dump(unix.NFT_MSG_GETSETELEM, t.Family, attrs(nil).
+		str(unix.NFTA_SET_ELEM_LIST_TABLE, t.Name).
+		str(unix.NFTA_SET_ELEM_LIST_SET, set))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list set elements: %w", err)
+	}
+
+	var out []Element
+	for _, payload := range payloads {
+		top, err := parseAttrs(payload)
+		if err != nil {
+			return nil, err
+		}
+		err = walkAttrs(top[unix.NFTA_SET_ELEM_LIST_ELEMENTS], func(typ uint16, data []byte) {
+			if typ != unix.NFTA_LIST_ELEM {
+				return
+			}
+			elem, parseErr := parseElement(data)
+			if parseErr != nil {
+				err = parseErr
+				return
+			}
+			out = append(out, elem)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// ListChains возвращает имена цепочек таблицы; таблицы нет – пустой список
+func (c *Conn) ListChains(t Table) ([]string, error) {
+	payloads, err := c.dump(unix.NFT_MSG_GETCHAIN, t.Family, attrs(nil).
+		str(unix.NFTA_CHAIN_TABLE, t.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chains: %w", err)
+	}
+
+	var out []string
+	for _, payload := range payloads {
+		a, err := parseAttrs(payload)
+		if err != nil {
+			return nil, err
+		}
+		// Старые ядра не фильтруют дамп по таблице
+		if cstr(a[unix.NFTA_CHAIN_TABLE]) != t.Name {
+			continue
+		}
+		out = append(out, cstr(a[unix.NFTA_CHAIN_NAME]))
+	}
+	return out, nil
+}
+
+// RuleCounter – счётчики правила и его комментарий
+type RuleCounter struct {
+	Comment string
+	Packets uint64
+	Bytes   uint64
+}
+
+// ListCounters возвращает счётчики правил цепочки, содержащих выражение counter, в порядке правил
+func (c *Conn) ListCounters(t Table, chain string) ([]RuleCounter, error) {
+	payloads, err := c.dump(unix.NFT_MSG_GETRULE, t.Family, attrs(nil).
+		str(unix.NFTA_RULE_TABLE, t.Name).
+		str(unix.NFTA_RULE_CHAIN, chain))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	var out []RuleCounter
+	for _, payload := range payloads {
+		a, err := parseAttrs(payload)
+		if err != nil {
+			return nil, err
+		}
+		// Старые ядра не фильтруют дамп по таблице и цепочке
+		if cstr(a[unix.NFTA_RULE_TABLE]) != t.Name || cstr(a[unix.NFTA_RULE_CHAIN]) != chain {
+			continue
+		}
+		counter, ok, err := parseRuleCounter(a[unix.NFTA_RULE_EXPRESSIONS])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		counter.Comment = parseRuleComment(a[unix.NFTA_RULE_USERDATA])
+		out = append(out, counter)
+	}
+	return out, nil
+}
+
+// parseRuleCounter находит выражение counter среди выражений правила
+func parseRuleCounter(exprs []byte) (counter RuleCounter, found bool, err error) {
+	walkErr := walkAttrs(exprs, func(typ uint16, data []byte) {
+		if typ != unix.NFTA_LIST_ELEM || found || err != nil {
+			return
+		}
+		expr, parseErr := parseAttrs(data)
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+		if cstr(expr[unix.NFTA_EXPR_NAME]) != "counter" {
+			return
+		}
+		values, parseErr := parseAttrs(expr[unix.NFTA_EXPR_DATA])
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+		if packets := values[unix.NFTA_COUNTER_PACKETS]; len(packets) == 8 {
+			counter.Packets = binary.BigEndian.Uint64(packets)
+		}
+		if bytesCount := values[unix.NFTA_COUNTER_BYTES]; len(bytesCount) == 8 {
+			counter.Bytes = binary.BigEndian.Uint64(bytesCount)
+		}
+		found = true
+	})
+	if err == nil {
+		err = walkErr
+	}
+	return counter, found, err
+}
+
+// cstr переводит строковый атрибут netlink (с завершающим нулём) в строку
+func cstr(b []byte) string {
+	return string(bytes.TrimRight(b, "\x00"))
+}
+
+func parseElement(b []byte) (Element, error) {
+	var elem Element
+	a, err := parseAttrs(b)
+	if err != nil {
+		return elem, err
+	}
+	key, err := parseAttrs(a[unix.NFTA_SET_ELEM_KEY])
+	if err != nil {
+		return elem, err
+	}
+	if key[unix.NFTA_DATA_VALUE] == nil {
+		return elem, errors.New("set element without key")
+	}
+	elem.Key = append([]byte(nil), key[unix.NFTA_DATA_VALUE]...)
+	if flags := a[unix.NFTA_SET_ELEM_FLAGS]; len(flags) == 4 {
+		elem.End = binary.BigEndian.Uint32(flags)&unix.NFT_SET_ELEM_INTERVAL_END != 0
+	}
+	if timeout := a[unix.NFTA_SET_ELEM_TIMEOUT]; len(timeout) == 8 {
+		elem.Timeout = time.Duration(binary.BigEndian.Uint64(timeout)) * time.Millisecond
+	}
+	if expiration := a[unix.NFTA_SET_ELEM_EXPIRATION]; len(expiration) == 8 {
+		elem.Expiration = time.Duration(binary.BigEndian.Uint64(expiration)) * time.Millisecond
+	}
+	return elem, nil
+}
+
+// Supported проверяет, что ядро поддерживает nf_tables
+func (c *Conn) Supported() bool {
+	_, err := c.dump(unix.NFT_MSG_GETTABLE, unix.NFPROTO_UNSPEC, nil)
+	return err == nil
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"net"
+	"net/netip"
+	"slices"
 	"sync"
 	"time"
 )
@@ -37,8 +39,10 @@ func (r *Records) AddAlias(domainName, alias string, ttl uint32) {
 	r.locker.Lock()
 	defer r.locker.Unlock()
 
-	deadline := time.Now().Add(time.Duration(ttl) * time.Second)
+	r.addAlias(domainName, alias, time.Now().Add(time.Duration(ttl)*time.Second))
+}
 
+func (r *Records) addAlias(domainName, alias string, deadline time.Time) {
 	// Удаляем старый reverse alias если был
 	if oldAlias, ok := r.aliases[domainName]; ok {
 		r.removeReverseAlias(oldAlias.Alias, domainName)
@@ -72,8 +76,10 @@ func (r *Records) AddAddress(domainName string, addr net.IP, ttl uint32) {
 	r.locker.Lock()
 	defer r.locker.Unlock()
 
-	deadline := time.Now().Add(time.Duration(ttl) * time.Second)
+	r.addAddress(domainName, addr, time.Now().Add(time.Duration(ttl)*time.Second))
+}
 
+func (r *Records) addAddress(domainName string, addr net.IP, deadline time.Time) {
 	addresses := r.addresses[domainName]
 	for _, aRecord := range addresses {
 		if bytes.Equal(aRecord.Address, addr) {
@@ -93,6 +99,10 @@ func (r *Records) GetAliases(domainName string) []string {
 	r.locker.RLock()
 	defer r.locker.RUnlock()
 
+	return r.getAliases(domainName)
+}
+
+func (r *Records) getAliases(domainName string) []string {
 	result := []string{domainName}
 	queue := []string{domainName}
 	seen := make(map[string]struct{})
@@ -151,6 +161,37 @@ func (r *Records) GetAddresses(domainName string) []*Address {
 	}
 }
 
+// AddressDomains возвращает для каждого действующего адреса домены, которые в него
+// разрешаются: владельцев записи и ссылающиеся на них (прямо или транзитивно) домены
+func (r *Records) AddressDomains() map[netip.Addr][]string {
+	r.locker.RLock()
+	defer r.locker.RUnlock()
+
+	now := time.Now()
+	result := make(map[netip.Addr][]string)
+	for domainName, addresses := range r.addresses {
+		var names []string
+		for _, address := range addresses {
+			if now.After(address.Deadline) {
+				continue
+			}
+			addr, ok := netip.AddrFromSlice(address.Address)
+			if !ok {
+				continue
+			}
+			if names == nil {
+				names = r.getAliases(domainName)
+			}
+			result[addr.Unmap()] = append(result[addr.Unmap()], names...)
+		}
+	}
+	for addr, names := range result {
+		slices.Sort(names)
+		result[addr] = slices.Compact(names)
+	}
+	return result
+}
+
 func (r *Records) ListKnownDomains() []string {
 	r.locker.RLock()
 	defer r.locker.RUnlock()
@@ -2,6 +2,8 @@ package recordsCache
 
 import (
 	"bytes"
+	"net"
+	"net/netip"
 	"slices"
 	"testing"
 	"time"
@@ -316,3 +318,22 @@ func TestGetAliasesUnknownDomain(t *testing.T) {
 		t.Fatal("unknown domain should return only itself")
 	}
 }
+
+func TestAddressDomains(t *testing.T) {
+	r := New()
+	r.AddAddress("cdn.example.net", []byte{1, 2, 3, 4}, 60)
+	r.AddAddress("other.example.org", net.IPv4(1, 2, 3, 4), 60)
+	r.AddAddress("expired.example.org", []byte{5, 6, 7, 8}, 0)
+	r.AddAlias("www.example.com", "cdn.example.net", 60)
+	r.AddAlias("example.com", "www.example.com", 60)
+	time.Sleep(10 * time.Millisecond)
+
+	domains := r.AddressDomains()
+	expected := []string{"cdn.example.net", "example.com", "other.example.org", "www.example.com"}
+	if got := domains[netip.MustParseAddr("1.2.3.4")]; !slices.Equal(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if _, ok := domains[netip.MustParseAddr("5.6.7.8")]; ok {
+		t.Fatal("expired address must be skipped")
+	}
+}
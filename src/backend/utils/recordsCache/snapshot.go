@@ -0,0 +1,123 @@
+package recordsCache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const snapshotVersion = 1
+
+var ErrStaleSnapshot = errors.New("stale snapshot")
+
+// snapshot – сохраняемое на диск содержимое кэша. Сроки хранятся абсолютными,
+// чтобы после перезапуска записи жили ровно оставшееся время.
+type snapshot struct {
+	Version   int               `json:"version"`
+	SavedAt   time.Time         `json:"savedAt"`
+	Addresses []snapshotAddress `json:"addresses"`
+	Aliases   []snapshotAlias   `json:"aliases"`
+}
+
+type snapshotAddress struct {
+	Domain   string    `json:"domain"`
+	Address  net.IP    `json:"address"`
+	Deadline time.Time `json:"deadline"`
+}
+
+type snapshotAlias struct {
+	Domain   string    `json:"domain"`
+	Alias    string    `json:"alias"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// Save атомарно записывает неистёкшие записи в файл path
+func (r *Records) Save(path string) error {
+	r.locker.RLock()
+	now := time.Now()
+	snap := snapshot{Version: snapshotVersion, SavedAt: now}
+	for domain, addresses := range r.addresses {
+		for _, addr := range addresses {
+			if now.After(addr.Deadline) {
+				continue
+			}
+			snap.Addresses = append(snap.Addresses, snapshotAddress{Domain: domain, Address: addr.Address, Deadline: addr.Deadline})
+		}
+	}
+	for domain, alias := range r.aliases {
+		if now.After(alias.Deadline) {
+			continue
+		}
+		snap.Aliases = append(snap.Aliases, snapshotAlias{Domain: domain, Alias: alias.Alias, Deadline: alias.Deadline})
+	}
+	r.locker.RUnlock()
+
+	out, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create snapshot folder: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0600); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// Load восстанавливает записи из файла path с оставшимся сроком жизни и возвращает их число.
+// Истёкшие записи пропускаются. Снимок другой версии или сделанный «в будущем»
+// (часы роутера ещё не синхронизированы или ушли назад) отбрасывается целиком
+// с ErrStaleSnapshot: оставшийся срок записей по нему вычислить нельзя.
+func (r *Records) Load(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	now := time.Now()
+	if snap.Version != snapshotVersion {
+		return 0, fmt.Errorf("%w: unsupported version %d", ErrStaleSnapshot, snap.Version)
+	}
+	if snap.SavedAt.After(now) {
+		return 0, fmt.Errorf("%w: saved at %s, clock is %s", ErrStaleSnapshot, snap.SavedAt.Format(time.RFC3339), now.Format(time.RFC3339))
+	}
+
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	restored := 0
+	for _, addr := range snap.Addresses {
+		if !now.Before(addr.Deadline) || addr.Domain == "" {
+			continue
+		}
+		ip := addr.Address
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		} else if len(ip) != net.IPv6len {
+			continue
+		}
+		r.addAddress(addr.Domain, ip, addr.Deadline)
+		restored++
+	}
+	for _, alias := range snap.Aliases {
+		if !now.Before(alias.Deadline) || alias.Domain == "" || alias.Domain == alias.Alias {
+			continue
+		}
+		r.addAlias(alias.Domain, alias.Alias, alias.Deadline)
+		restored++
+	}
+	return restored, nil
+}
@@ -0,0 +1,90 @@
+package recordsCache
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.json")
+
+	r := New()
+	r.AddAddress("example.com", []byte{1, 2, 3, 4}, 60)
+	r.AddAddress("example.com", net.ParseIP("2001:db8::1"), 60)
+	r.AddAlias("www.example.com", "example.com", 60)
+	r.addAddress("expired.com", []byte{5, 6, 7, 8}, time.Now().Add(-time.Second))
+	if err := r.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	restored := New()
+	n, err := restored.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("restored %d records, want 3", n)
+	}
+
+	addresses := restored.GetAddresses("www.example.com")
+	if len(addresses) != 2 {
+		t.Fatalf("got %d addresses, want 2", len(addresses))
+	}
+	if len(addresses[0].Address) != net.IPv4len && len(addresses[1].Address) != net.IPv4len {
+		t.Error("ipv4 address must be restored in 4-byte form")
+	}
+	// Срок жизни не продлевается перезапуском
+	if remaining := time.Until(addresses[0].Deadline); remaining > 60*time.Second || remaining < 50*time.Second {
+		t.Errorf("unexpected remaining ttl %v", remaining)
+	}
+	if restored.GetAddresses("expired.com") != nil {
+		t.Error("expired record restored")
+	}
+}
+
+func TestSnapshotStale(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, snap snapshot) string {
+		data, err := json.Marshal(snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	record := []snapshotAddress{{Domain: "example.com", Address: net.IP{1, 2, 3, 4}, Deadline: time.Now().Add(time.Hour)}}
+
+	future := write("future.json", snapshot{Version: snapshotVersion, SavedAt: time.Now().Add(time.Hour), Addresses: record})
+	if _, err := New().Load(future); !errors.Is(err, ErrStaleSnapshot) {
+		t.Errorf("future snapshot: error = %v, want ErrStaleSnapshot", err)
+	}
+
+	version := write("version.json", snapshot{Version: snapshotVersion + 1, SavedAt: time.Now(), Addresses: record})
+	if _, err := New().Load(version); !errors.Is(err, ErrStaleSnapshot) {
+		t.Errorf("unknown version: error = %v, want ErrStaleSnapshot", err)
+	}
+
+	broken := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(broken, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r := New()
+	if _, err := r.Load(broken); err == nil {
+		t.Error("broken snapshot must fail to load")
+	}
+	if len(r.ListKnownDomains()) != 0 {
+		t.Error("records restored from broken snapshot")
+	}
+
+	if _, err := New().Load(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing snapshot: error = %v, want os.ErrNotExist", err)
+	}
+}
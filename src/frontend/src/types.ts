@@ -8,6 +8,7 @@ import {
   optional,
   parse,
   pipe,
+  record,
   regex,
   string,
   type InferOutput,
@@ -42,12 +43,45 @@ export const RuleSchema = object({
 });
 export type Rule = InferOutput<typeof RuleSchema>;
 
+export const HealthCheckSchema = object({
+  type: string(),
+  target: optional(string()),
+  interval: optional(number()),
+  timeout: optional(number()),
+  failThreshold: optional(number()),
+  riseThreshold: optional(number()),
+});
+export type HealthCheck = InferOutput<typeof HealthCheckSchema>;
+
+export const IPSetSizingSchema = object({
+  maxElem: optional(number()),
+  hashSize: optional(number()),
+  timeout: optional(number()),
+});
+export type IPSetSizing = InferOutput<typeof IPSetSizingSchema>;
+
 export const GroupSchema = object({
   id: fallback(pipe(string(), length(8), regex(/^[0-9a-f]{8}/)), randomId()),
   name: fallback(string(), ""),
   color: fallback(optional(string()), "#ffffff"),
   interface: string(),
   enable: fallback(boolean(), true),
+  protocols: optional(array(string())),
+  dstPorts: optional(array(string())),
+  srcAddresses: optional(array(string())),
+  srcMacs: optional(array(string())),
+  srcInterfaces: optional(array(string())),
+  routeOutput: optional(boolean()),
+  failoverInterfaces: optional(array(string())),
+  healthCheck: optional(HealthCheckSchema),
+  mode: optional(string()),
+  interfaceWeights: optional(record(string(), number())),
+  gateways: optional(array(string())),
+  onlink: optional(boolean()),
+  disableConntrackFlush: optional(boolean()),
+  ipset: optional(IPSetSizingSchema),
+  mssClamp: optional(string()),
+  nat: optional(string()),
   rules: array(RuleSchema),
 });
 export type Group = InferOutput<typeof GroupSchema>;
@@ -84,6 +118,7 @@ export const RULE_TYPES = [
   { value: "domain", label: "Domain" },
   { value: "subnet", label: "IPv4 subnet" },
   { value: "subnet6", label: "IPv6 subnet" },
+  { value: "asn", label: "ASN" },
 ];
 
 export type Interfaces = {
@@ -6,6 +6,7 @@ export type SortDirection = "asc" | "desc";
 const TYPE_PRIORITY: Record<string, number> = {
   subnet: 10, // IPv4 subnets
   subnet6: 11, // IPv6 subnets
+  asn: 12, // Autonomous systems
   wildcard: 20,
   domain: 30,
   namespace: 31,
@@ -42,6 +42,11 @@ export function isValidSubnet6(pattern: string): boolean {
   return isValidIPv6(parts[0]);
 }
 
+export function isValidASN(pattern: string): boolean {
+  const matches = /^(?:AS)?(\d{1,10})$/i.exec(pattern);
+  return !!matches && Number(matches[1]) <= 4294967295;
+}
+
 export function isValidRegex(pattern: string): boolean {
   try {
     new RegExp(pattern);
@@ -61,4 +66,5 @@ export const VALIDATOP_MAP: Record<string, (pattern: string) => boolean> = {
   namespace: isValidNamespace,
   subnet: isValidSubnet,
   subnet6: isValidSubnet6,
+  asn: isValidASN,
 };
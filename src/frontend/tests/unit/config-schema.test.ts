@@ -0,0 +1,36 @@
+import assert from "node:assert";
+import { describe, it } from "node:test";
+
+import { parseConfig } from "../../src/types";
+
+describe("parseConfig", () => {
+  it("keeps group routing options on import", () => {
+    const group = {
+      id: "0a1b2c3d",
+      name: "Routing",
+      color: "#ffffff",
+      interface: "nwg0",
+      enable: true,
+      protocols: ["tcp"],
+      dstPorts: ["443"],
+      srcAddresses: ["192.168.1.10"],
+      srcMacs: ["aa:bb:cc:dd:ee:ff"],
+      srcInterfaces: ["br0"],
+      routeOutput: true,
+      failoverInterfaces: ["nwg1"],
+      healthCheck: { type: "icmp", target: "1.1.1.1", interval: 10 },
+      mode: "balance",
+      interfaceWeights: { nwg0: 2, nwg1: 1 },
+      gateways: ["192.168.1.2"],
+      onlink: true,
+      disableConntrackFlush: true,
+      ipset: { maxElem: 262144 },
+      mssClamp: "pmtu",
+      nat: "snat:192.0.2.1",
+      rules: [],
+    };
+
+    const { groups } = parseConfig(JSON.stringify({ groups: [group] }));
+    assert.deepStrictEqual(groups[0], group);
+  });
+});